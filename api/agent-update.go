@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+)
+
+// MaxUpdateSize bounds the size of a downloaded agent binary.
+const MaxUpdateSize = 256 << 20
+
+// updateDownloadTimeout bounds a whole binary download, which takes longer than an API call.
+const updateDownloadTimeout = 10 * time.Minute
+
+// UpdateInfo describes the release the backend offers this agent.
+type UpdateInfo struct {
+	Available bool   `json:"available"`
+	Version   string `json:"version,omitempty"`
+	URL       string `json:"url,omitempty"` // absolute, or relative to the API base
+	// Manifest is the release's update.Manifest (version, os, arch and the
+	// binary's sha256), and Signature the release key's ed25519 signature
+	// (base64url) over its exact bytes.
+	Manifest  json.RawMessage `json:"manifest,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+}
+
+// CheckUpdate asks the backend whether a newer agent than current is available
+// for this OS and architecture.
+func (c *Client) CheckUpdate(ctx context.Context, current string) (*UpdateInfo, error) {
+	q := url.Values{
+		"version": {current},
+		"os":      {runtime.GOOS},
+		"arch":    {runtime.GOARCH},
+	}
+	var info UpdateInfo
+	if err := c.do(ctx, http.MethodGet, c.endpoint("/update?"+q.Encode()), nil, &info, true); err != nil {
+		return nil, fmt.Errorf("check update: %w", err)
+	}
+	if info.Available && (info.Version == "" || info.URL == "" || len(info.Manifest) == 0 || info.Signature == "") {
+		return nil, fmt.Errorf("check update: response is missing version, url, manifest or signature")
+	}
+	return &info, nil
+}
+
+// DownloadUpdate fetches the binary info points to. It is not verified here;
+// see update.Apply.
+func (c *Client) DownloadUpdate(ctx context.Context, info *UpdateInfo) ([]byte, error) {
+	base, err := url.Parse(c.base() + "/")
+	if err != nil {
+		return nil, fmt.Errorf("download update: %w", err)
+	}
+	ref, err := url.Parse(info.URL)
+	if err != nil {
+		return nil, fmt.Errorf("download update: parse url: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, updateDownloadTimeout)
+	defer cancel()
+
+	target := base.ResolveReference(ref)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("download update: %w", err)
+	}
+	// extra_headers (e.g. a gateway token) are only for the backend, not a CDN.
+	if target.Host == base.Host {
+		c.setHeaders(req)
+	} else {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	requestID, err := newRequestID()
+	if err != nil {
+		return nil, fmt.Errorf("download update: %w", err)
+	}
+	req.Header.Set("X-Request-Id", requestID)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download update: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download update: status=%d", resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, MaxUpdateSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("download update: %w", err)
+	}
+	if len(b) > MaxUpdateSize {
+		return nil, fmt.Errorf("download update: binary is larger than %d bytes", MaxUpdateSize)
+	}
+	return b, nil
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// failingReader returns data, then err.
+type failingReader struct {
+	data *strings.Reader
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data.Len() > 0 {
+		return r.data.Read(p)
+	}
+	return 0, r.err
+}
+
+func gzipped(s string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.String()
+}
+
+func TestReadResponseBody(t *testing.T) {
+	errDropped := errors.New("connection dropped")
+	tests := []struct {
+		name     string
+		body     io.Reader
+		encoding string
+		want     string
+		wantErr  error // nil for none; errAny for any
+	}{
+		{name: "plain", body: strings.NewReader(`{"ok":true}`), want: `{"ok":true}`},
+		{name: "gzip", body: strings.NewReader(gzipped(`{"ok":true}`)), encoding: "gzip", want: `{"ok":true}`},
+		{name: "at the limit", body: strings.NewReader(strings.Repeat("x", MaxResponseSize)), want: strings.Repeat("x", MaxResponseSize)},
+		{name: "oversized", body: strings.NewReader(strings.Repeat("x", MaxResponseSize+1)), wantErr: errAny},
+		{name: "oversized once decoded", body: strings.NewReader(gzipped(strings.Repeat("x", MaxResponseSize+1))), encoding: "gzip", wantErr: errAny},
+		{name: "read error", body: &failingReader{data: strings.NewReader(`{"ok":`), err: errDropped}, wantErr: errDropped},
+		{name: "bad gzip", body: strings.NewReader("not gzip"), encoding: "gzip", wantErr: errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(tt.body)}
+			if tt.encoding != "" {
+				resp.Header.Set("Content-Encoding", tt.encoding)
+			}
+			got, err := readResponseBody(resp)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("err = %v", err)
+			case tt.wantErr != nil && err == nil:
+				t.Fatal("no error")
+			case tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && string(got) != tt.want {
+				t.Errorf("body is %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+// errAny stands for any error in TestReadResponseBody.
+var errAny = errors.New("any error")
+
+// TestReadErrorNotRetried checks that a 200 whose body is cut short fails
+// without being sent again.
+func TestReadErrorNotRetried(t *testing.T) {
+	resetShared(t)
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte(`{"ok":`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, nil, nil)
+	err := c.do(context.Background(), http.MethodGet, "/x", nil, nil, false)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want a cut short body", err)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
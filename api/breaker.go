@@ -0,0 +1,127 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/metrics"
+)
+
+const (
+	breakerThreshold = 5
+	breakerCooldown  = 5 * time.Minute
+)
+
+// ErrCircuitOpen is returned without contacting the backend while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("API circuit open")
+
+// Circuit states, as returned by CircuitState and reported to metrics.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// The breaker is shared by every Client in the process, like the clock skew
+// tracking: clients are cheap and built per call, the backend's health isn't.
+//
+// After breakerThreshold consecutive failures (no response, 429 or 5xx) it
+// opens and calls fail fast with ErrCircuitOpen. Once breakerCooldown has
+// passed it half-opens and lets a single probe through: success closes it,
+// failure opens it for another cooldown. When a whole fleet sees the backend
+// go down, this keeps them from hammering it on every tick.
+var (
+	breakerMu      sync.Mutex
+	breakerState   = circuitClosed
+	breakerFails   int
+	breakerUntil   time.Time // while open, when the next probe is allowed
+	breakerProbing bool
+)
+
+// breakerAllow reports whether a request may be sent now, claiming the probe
+// if the breaker is due to half-open.
+func breakerAllow() error {
+	breakerMu.Lock()
+	defer breakerMu.Unlock()
+
+	switch breakerState {
+	case circuitOpen:
+		if time.Now().Before(breakerUntil) {
+			return ErrCircuitOpen
+		}
+		setBreakerState(circuitHalfOpen)
+		breakerProbing = true
+	case circuitHalfOpen:
+		if breakerProbing {
+			return ErrCircuitOpen
+		}
+		breakerProbing = true
+	}
+	return nil
+}
+
+// breakerRecord records the outcome of a request that breakerAllow let through.
+func breakerRecord(failed bool) {
+	breakerMu.Lock()
+	defer breakerMu.Unlock()
+
+	breakerProbing = false
+	if !failed {
+		if breakerState != circuitClosed {
+			log.Printf("API circuit closed: backend is responding again")
+		}
+		breakerFails = 0
+		setBreakerState(circuitClosed)
+		return
+	}
+
+	breakerFails++
+	if breakerState == circuitHalfOpen || breakerFails >= breakerThreshold {
+		if breakerState == circuitClosed {
+			log.Printf("API circuit open after %d consecutive failures; pausing API calls for %s", breakerFails, breakerCooldown)
+		}
+		breakerUntil = time.Now().Add(breakerCooldown)
+		setBreakerState(circuitOpen)
+	}
+}
+
+// breakerAbandon releases a claimed probe without recording an outcome, e.g.
+// when the caller gave up before the backend answered.
+func breakerAbandon() {
+	breakerMu.Lock()
+	defer breakerMu.Unlock()
+	breakerProbing = false
+}
+
+func setBreakerState(s string) {
+	breakerState = s
+	metrics.SetAPICircuit(s)
+}
+
+// CircuitState returns the breaker's state and, while open, when it will next
+// let a probe through.
+func CircuitState() (string, time.Time) {
+	breakerMu.Lock()
+	defer breakerMu.Unlock()
+	if breakerState == circuitOpen {
+		return breakerState, breakerUntil
+	}
+	return breakerState, time.Time{}
+}
+
+// CircuitOpen reports whether API calls would currently fail with ErrCircuitOpen,
+// so periodic work can skip quietly instead of logging an error.
+func CircuitOpen() bool {
+	breakerMu.Lock()
+	defer breakerMu.Unlock()
+	switch breakerState {
+	case circuitOpen:
+		return time.Now().Before(breakerUntil)
+	case circuitHalfOpen:
+		return breakerProbing
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	// Steps: "ok" and "fail" are requests the breaker lets through with that
+	// outcome, "abandon" one given up on, "refused" one it turns away, and
+	// "cooldown" lets the cooldown pass.
+	type step struct {
+		do    string
+		state string // afterwards
+	}
+	fails := func(n int, state string) []step {
+		steps := make([]step, n)
+		for i := range steps {
+			steps[i] = step{"fail", circuitClosed}
+		}
+		steps[n-1].state = state
+		return steps
+	}
+	open := fails(breakerThreshold, circuitOpen)
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"stays closed below threshold", fails(breakerThreshold-1, circuitClosed)},
+		{"success resets the count", append(append(fails(breakerThreshold-1, circuitClosed), step{"ok", circuitClosed}), fails(breakerThreshold-1, circuitClosed)...)},
+		{"opens at threshold", append(open, step{"refused", circuitOpen})},
+		{"half-opens after cooldown", append(open, step{"cooldown", circuitOpen}, step{"ok", circuitClosed}, step{"ok", circuitClosed})},
+		{"failed probe reopens", append(open, step{"cooldown", circuitOpen}, step{"fail", circuitOpen}, step{"refused", circuitOpen})},
+		{"one probe at a time", append(open, step{"cooldown", circuitOpen}, step{"probe", circuitHalfOpen}, step{"refused", circuitHalfOpen})},
+		{"abandoned probe released", append(open, step{"cooldown", circuitOpen}, step{"probe", circuitHalfOpen}, step{"abandon", circuitHalfOpen}, step{"ok", circuitClosed})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			for i, s := range tt.steps {
+				switch s.do {
+				case "ok", "fail":
+					if err := breakerAllow(); err != nil {
+						t.Fatalf("step %d (%s): refused: %v", i+1, s.do, err)
+					}
+					breakerRecord(s.do == "fail")
+				case "probe":
+					if err := breakerAllow(); err != nil {
+						t.Fatalf("step %d (probe): refused: %v", i+1, err)
+					}
+				case "abandon":
+					breakerAbandon()
+				case "refused":
+					if err := breakerAllow(); !errors.Is(err, ErrCircuitOpen) {
+						t.Fatalf("step %d: allowed (err = %v), want ErrCircuitOpen", i+1, err)
+					}
+					if !CircuitOpen() {
+						t.Errorf("step %d: CircuitOpen() = false while refusing", i+1)
+					}
+				case "cooldown":
+					breakerMu.Lock()
+					breakerUntil = time.Now().Add(-time.Second)
+					breakerMu.Unlock()
+					if CircuitOpen() {
+						t.Errorf("step %d: CircuitOpen() = true after the cooldown", i+1)
+					}
+				}
+				if got, _ := CircuitState(); got != s.state {
+					t.Fatalf("step %d (%s): state %s, want %s", i+1, s.do, got, s.state)
+				}
+			}
+		})
+	}
+}
+
+// TestBreakerClient checks that the client stops contacting a failing backend.
+func TestBreakerClient(t *testing.T) {
+	resetShared(t)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, nil, nil)
+	for i := range breakerThreshold + 2 {
+		// POSTs aren't retried on 5xx, so each call is one request.
+		err := c.do(context.Background(), http.MethodPost, "/x", nil, nil, false)
+		if want := i >= breakerThreshold; errors.Is(err, ErrCircuitOpen) != want {
+			t.Fatalf("call %d: err = %v, want circuit open %t", i+1, err, want)
+		}
+	}
+	if got := requests.Load(); got != breakerThreshold {
+		t.Errorf("%d requests reached the backend, want %d", got, breakerThreshold)
+	}
+	if state, until := CircuitState(); state != circuitOpen || time.Until(until) <= 0 {
+		t.Errorf("CircuitState() = %s, %s; want open until a time in the future", state, until)
+	}
+}
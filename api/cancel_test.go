@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+func TestCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(ctx context.Context, c *Client) error
+	}{
+		{"fetch desired state", func(ctx context.Context, c *Client) error {
+			_, err := c.FetchDesiredState(ctx)
+			return err
+		}},
+		{"report events", func(ctx context.Context, c *Client) error {
+			return c.ReportEvent(ctx, Event{Type: EventReconcileSucceeded})
+		}},
+		{"ping", func(ctx context.Context, c *Client) error {
+			_, err := c.Ping(ctx)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			// Doesn't answer until the test is over.
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+			}))
+			defer srv.Close()
+			defer close(release)
+
+			_, priv, _ := ed25519.GenerateKey(rand.Reader)
+			c := NewClient(srv.URL, nil, &auth.KeySigner{AgentID: "agent-1", Key: priv})
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			start := time.Now()
+			err := tt.call(ctx, c)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("err = %v, want context.Canceled", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("returned after %s", elapsed)
+			}
+		})
+	}
+}
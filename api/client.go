@@ -0,0 +1,435 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/metrics"
+)
+
+const (
+	DefaultTimeout = 30 * time.Second
+	// DefaultAPIPrefix is the path under apiBase that agent endpoints are mounted at.
+	DefaultAPIPrefix = "/api/agent/v1"
+	// MaxResponseSize bounds the (decoded) body of an API response, so a
+	// broken or hostile server can't exhaust the agent's memory.
+	MaxResponseSize = 32 << 20
+)
+
+// Signer signs an outgoing request in place (see auth.SignRequest).
+type Signer interface {
+	SignRequest(req *http.Request) error
+}
+
+// Client talks to the CertKit backend.
+// All API calls should go through a Client so they share transport settings and signing.
+type Client struct {
+	apiBase    string
+	fallbacks  []string // see SetFallbacks
+	apiPrefix  string
+	httpClient *http.Client
+	signer     Signer
+	timeout    time.Duration // per request, so every attempt gets a fresh deadline
+	userAgent  string
+
+	// serverKeyID/serverKey, if set, must sign every successful response to a signed request.
+	serverKeyID string
+	serverKey   ed25519.PublicKey
+
+	extraHeaders map[string]string // see SetExtraHeaders
+
+	pollHint time.Duration // see NextPollAfter
+}
+
+// NewClient returns a Client for apiBase. signer may be nil, in which case
+// requests that require a signature will fail.
+func NewClient(apiBase string, httpClient *http.Client, signer Signer) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &Client{
+		apiBase:    strings.TrimRight(apiBase, "/"),
+		apiPrefix:  DefaultAPIPrefix,
+		httpClient: httpClient,
+		signer:     signer,
+		timeout:    DefaultTimeout,
+		userAgent:  UserAgent("dev"),
+	}
+}
+
+// UserAgent returns the User-Agent sent by an agent of the given version.
+func UserAgent(version string) string {
+	return fmt.Sprintf("certkit-agent/%s (%s/%s)", version, runtime.GOOS, runtime.GOARCH)
+}
+
+// SetUserAgent overrides the User-Agent header sent with every request.
+func (c *Client) SetUserAgent(userAgent string) {
+	c.userAgent = userAgent
+}
+
+// SetAPIPrefix sets the path agent endpoints are mounted at, e.g. when the
+// backend sits behind a reverse proxy under a subpath. "" restores the
+// default and "/" mounts them directly under apiBase.
+func (c *Client) SetAPIPrefix(prefix string) {
+	if prefix == "" {
+		prefix = DefaultAPIPrefix
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+	c.apiPrefix = prefix
+}
+
+// endpoint returns the path of the agent endpoint name (e.g. "/desired-state").
+func (c *Client) endpoint(name string) string {
+	return c.apiPrefix + name
+}
+
+// SetTimeout sets the deadline applied to each request. Zero or less restores the default.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	c.timeout = timeout
+}
+
+// SetExtraHeaders adds headers to every request, e.g. a gateway token for an
+// auth proxy in front of the backend. They're set before signing, so they can
+// be listed in auth.signed_headers.
+func (c *Client) SetExtraHeaders(headers map[string]string) {
+	c.extraHeaders = maps.Clone(headers)
+}
+
+// setHeaders sets the headers common to every request to the backend.
+func (c *Client) setHeaders(req *http.Request) {
+	for name, value := range c.extraHeaders {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+}
+
+// SetServerKey requires successful responses to signed requests to be signed by
+// the backend key keyID (see auth.VerifyResponse).
+func (c *Client) SetServerKey(keyID string, pub ed25519.PublicKey) {
+	c.serverKeyID = keyID
+	c.serverKey = pub
+}
+
+// WipeKey overwrites the client's in-memory signing key (see
+// auth.KeySigner.Wipe); requests it signs afterwards don't verify. Only the client's
+// decoded copy is wiped: the encoded key in the config it was built from is
+// an immutable string, and stays in memory until the garbage collector
+// reuses it.
+func (c *Client) WipeKey() {
+	if s, ok := c.signer.(*auth.KeySigner); ok {
+		s.Wipe()
+	}
+}
+
+// Options are client settings a command supplies on top of the config,
+// typically from its flags.
+type Options struct {
+	// Timeout, if positive, overrides request_timeout (--timeout).
+	Timeout time.Duration
+	// AllowInsecureHTTP lets the API bases use plain http
+	// (--insecure-allow-http; see config.RequireHTTPS).
+	AllowInsecureHTTP bool
+}
+
+// NewClientFromConfig builds a Client from the agent config and opts, signing
+// with the configured keypair once the agent has an AgentID. API bases must
+// be https (see config.RequireHTTPS).
+func NewClientFromConfig(cfg *config.Config, opts Options) (*Client, error) {
+	if err := config.RequireHTTPS("api_base", cfg.ApiBase, opts.AllowInsecureHTTP); err != nil {
+		return nil, err
+	}
+	for _, fallback := range cfg.APIBaseFallbacks {
+		if err := config.RequireHTTPS("api_base_fallbacks", fallback, opts.AllowInsecureHTTP); err != nil {
+			return nil, err
+		}
+	}
+	httpClient, err := NewHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer Signer
+	if cfg.Agent != nil && cfg.Agent.AgentID != "" && cfg.Auth != nil && (cfg.Auth.KeyPair != nil || cfg.Auth.PKCS11 != nil) {
+		key, err := cfg.Auth.SigningKey()
+		if err != nil {
+			return nil, err
+		}
+		signer = &auth.KeySigner{
+			AgentID:       cfg.Agent.AgentID,
+			Key:           key,
+			SignedHeaders: cfg.Auth.SignedHeaders,
+		}
+	}
+
+	client := NewClient(cfg.ApiBase, httpClient, signer)
+	client.SetFallbacks(cfg.APIBaseFallbacks)
+	client.SetAPIPrefix(cfg.APIPrefix)
+	client.SetExtraHeaders(cfg.ExtraHeaders)
+	if cfg.Version.Version != "" {
+		client.SetUserAgent(UserAgent(cfg.Version.Version))
+	}
+
+	if cfg.Agent != nil && cfg.Agent.ServerPublicKey != "" {
+		serverKey, err := auth.DecodePublicKey(cfg.Agent.ServerPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("decode server_public_key: %w", err)
+		}
+		client.SetServerKey(cfg.Agent.ServerKeyID, serverKey)
+	}
+
+	if cfg.RequestTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.RequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("parse request_timeout: %w", err)
+		}
+		client.SetTimeout(timeout)
+	}
+	if opts.Timeout > 0 {
+		client.SetTimeout(opts.Timeout)
+	}
+
+	return client, nil
+}
+
+// NewHTTPClient returns an http.Client for API calls on the shared transport
+// for cfg's connection settings (see sharedTransport).
+func NewHTTPClient(cfg *config.Config) (*http.Client, error) {
+	transport, err := sharedTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// No client-wide Timeout: deadlines are set per request by Client.do.
+	return &http.Client{
+		Transport: transport,
+	}, nil
+}
+
+// do sends a JSON request to path and decodes a JSON response into out (if non-nil).
+// When signed is true the request is signed with the client's Signer.
+// Rate-limited requests, and idempotent ones that hit server errors, are
+// retried with backoff (see retryable and retryDelay), unless that trips the
+// circuit breaker (see breakerAllow). If an API base
+// still fails, or can't be reached at all, the next one is tried (see
+// SetFallbacks and failover), and the one that answers is used from then on.
+// Cancelling ctx aborts the request in flight and any wait between retries.
+func (c *Client) do(ctx context.Context, method, path string, in any, out any, signed bool) error {
+	var requestBody []byte
+	if in != nil {
+		var err error
+		requestBody, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("marshal json: %w", err)
+		}
+	}
+
+	var respBody []byte
+	var err error
+	bases := c.bases()
+	for i, base := range bases {
+		respBody, err = c.retry(ctx, base, method, path, requestBody, signed)
+		if err == nil {
+			setActiveBase(base)
+			break
+		}
+		if i+1 >= len(bases) || !failover(ctx, method, err) {
+			return err
+		}
+		log.Printf("%s %s: %s failed: %v; failing over to %s", method, path, base, err, bases[i+1])
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// retry sends a request to base, retrying as described at do.
+func (c *Client) retry(ctx context.Context, base, method, path string, requestBody []byte, signed bool) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		respBody, err := c.attempt(ctx, base, method, path, requestBody, signed)
+		if err == nil || !retryable(method, err) || attempt+1 >= maxAttempts || CircuitOpen() {
+			return respBody, err
+		}
+		delay := retryDelay(attempt, RetryAfter(err))
+		log.Printf("%s %s: %v; retrying in %s", method, path, err, delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%s %s: %w (last error: %w)", method, path, ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends a single request, with its own deadline and a fresh signature.
+// It fails fast with ErrCircuitOpen while the circuit breaker is open.
+func (c *Client) attempt(ctx context.Context, base, method, path string, requestBody []byte, signed bool) ([]byte, error) {
+	if err := breakerAllow(); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	respBody, resp, err := c.send(ctx, base, method, path, requestBody, signed)
+	switch {
+	case resp != nil:
+		breakerRecord(resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500)
+	case err != nil && ctx.Err() == nil && !errors.Is(err, errNotSent):
+		breakerRecord(true)
+	default:
+		breakerAbandon()
+	}
+	return respBody, err
+}
+
+// errNotSent marks failures that happened before the request left this host.
+var errNotSent = errors.New("request not sent")
+
+// send performs attempt's request. resp is returned (with its body already
+// read and closed) whenever the backend answered.
+func (c *Client) send(ctx context.Context, base, method, path string, requestBody []byte, signed bool) ([]byte, *http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var body io.Reader
+	if requestBody != nil {
+		body = bytes.NewReader(requestBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, base+path, body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("new request: %w (%w)", err, errNotSent)
+	}
+	if requestBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// Accept-Encoding is left to the transport: it sends "gzip" and decodes the
+	// response transparently, which it stops doing once the header is set here.
+	// Request bodies go out uncompressed, so the signed body hash is of exactly
+	// what's sent.
+	req.Header.Set("Accept", "application/json")
+	c.setHeaders(req)
+	// A fresh id per attempt, included in errors so a failure can be found in backend logs.
+	requestID, err := newRequestID()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s: %w (%w)", method, path, err, errNotSent)
+	}
+	req.Header.Set("X-Request-Id", requestID)
+
+	if signed {
+		if c.signer == nil {
+			return nil, nil, fmt.Errorf("%s %s: request must be signed but no signer is configured (%w)", method, path, errNotSent)
+		}
+		if err := c.signer.SignRequest(req); err != nil {
+			return nil, nil, fmt.Errorf("sign request: %w (%w)", err, errNotSent)
+		}
+	}
+
+	logRequest(req, c.extraHeaders)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		metrics.APIRequest("error")
+		return nil, nil, fmt.Errorf("http do (request %s): %w", requestID, err)
+	}
+	defer resp.Body.Close()
+
+	metrics.APIRequest(strconv.Itoa(resp.StatusCode))
+	recordClockSkew(resp)
+
+	respBody, err := readResponseBody(resp)
+
+	logResponse(req, resp, respBody)
+
+	// A body cut short (e.g. the connection dropped) is reported as such,
+	// rather than as whatever decoding the partial body would fail with. A
+	// failed status is still wrapped too, so it's retried as usual. A 200 cut
+	// short isn't retried, nor failed over: the backend handled the request,
+	// which for a POST may have taken effect, so it's left to the next poll.
+	if err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, resp, fmt.Errorf("%s %s failed (request %s): %w; read response: %w", method, path, requestID, newStatusError(resp, respBody), err)
+		}
+		return nil, resp, fmt.Errorf("%s %s: read response (request %s): %w", method, path, requestID, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := newStatusError(resp, respBody)
+		if skew, _, ok := ClockSkew(); ok && statusErr.StatusCode == http.StatusUnauthorized && skew.Abs() > auth.DefaultMaxAge {
+			return nil, resp, fmt.Errorf("%s %s failed (request %s): %w (local clock is off by %s; fix NTP)", method, path, requestID, statusErr, skew)
+		}
+		return nil, resp, fmt.Errorf("%s %s failed (request %s): %w", method, path, requestID, statusErr)
+	}
+
+	if signed && c.serverKey != nil {
+		if err := auth.VerifyResponse(resp, respBody, c.serverKeyID, c.serverKey, time.Now(), auth.DefaultMaxAge); err != nil {
+			return nil, resp, fmt.Errorf("%s %s: verify response (request %s): %w", method, path, requestID, err)
+		}
+	}
+
+	return respBody, resp, nil
+}
+
+// readResponseBody reads resp's body, decoding it if it's still gzipped: the
+// transport only does that itself for responses to its own Accept-Encoding,
+// not for a server (or proxy) that compresses unasked. The server signs the
+// decoded body. Bodies over MaxResponseSize are an error.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	var body io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decode gzip response: %w", err)
+		}
+		defer zr.Close()
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		body = zr
+	}
+
+	b, err := io.ReadAll(io.LimitReader(body, MaxResponseSize+1))
+	if err != nil {
+		return b, err
+	}
+	if len(b) > MaxResponseSize {
+		return nil, fmt.Errorf("response body is larger than %d bytes", MaxResponseSize)
+	}
+	return b, nil
+}
+
+// newRequestID returns a random (version 4) UUID. Response signatures are
+// bound to it, so a failing random source is an error rather than an id that
+// might repeat.
+func newRequestID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate request id: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+var (
+	skewMu     sync.Mutex
+	lastSkew   time.Duration
+	lastSkewAt time.Time
+	skewWarned bool
+)
+
+// recordClockSkew compares the server's Date header to the local clock.
+// If they're far enough apart that signatures will be rejected, it logs a
+// warning (once, until the skew recovers) pointing at NTP.
+func recordClockSkew(resp *http.Response) {
+	serverDate, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	// Date has one-second resolution, so ignore sub-second differences.
+	skew := time.Since(serverDate).Truncate(time.Second)
+
+	skewMu.Lock()
+	defer skewMu.Unlock()
+
+	lastSkew = skew
+	lastSkewAt = time.Now()
+
+	if skew.Abs() > auth.DefaultMaxAge {
+		if !skewWarned {
+			log.Printf("⚠️  WARNING: local clock is off by %s compared to the CertKit server (%s). "+
+				"Signed requests will be rejected until the clock is fixed; check NTP/timesyncd.",
+				skew, serverDate.UTC().Format(time.RFC1123))
+			skewWarned = true
+		}
+		return
+	}
+	skewWarned = false
+}
+
+// ClockSkew returns the most recently observed difference between the local
+// clock and the server's (positive means local is ahead). ok is false if no
+// response with a Date header has been seen yet.
+func ClockSkew() (skew time.Duration, observedAt time.Time, ok bool) {
+	skewMu.Lock()
+	defer skewMu.Unlock()
+	return lastSkew, lastSkewAt, !lastSkewAt.IsZero()
+}
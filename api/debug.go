@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Keys whose values are never logged, even in debug mode.
+var sensitiveKeys = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"secret_key":    true,
+	"private_key":   true,
+	"key":           true,
+}
+
+var authSigPattern = regexp.MustCompile(`sig="([^"]{0,8})[^"]*"`)
+
+func debugEnabled() bool {
+	return slog.Default().Enabled(context.Background(), slog.LevelDebug)
+}
+
+// logRequest logs req, with the values of the secret headers (e.g.
+// extra_headers, which hold proxy credentials) masked.
+func logRequest(req *http.Request, secret map[string]string) {
+	if !debugEnabled() {
+		return
+	}
+	slog.Debug("api request", "method", req.Method, "url", req.URL.String(), "headers", redactHeaders(req.Header, secret))
+}
+
+func logResponse(req *http.Request, resp *http.Response, body []byte) {
+	if !debugEnabled() {
+		return
+	}
+	slog.Debug("api response", "method", req.Method, "url", req.URL.String(), "request_id", req.Header.Get("X-Request-Id"), "status", resp.StatusCode, "body", redactBody(body))
+}
+
+// redactHeaders flattens headers for logging, truncating the Authorization
+// signature and masking the headers named in secret.
+func redactHeaders(h http.Header, secret map[string]string) string {
+	masked := map[string]bool{}
+	for name := range secret {
+		masked[http.CanonicalHeaderKey(name)] = true
+	}
+	var parts []string
+	for name, values := range h {
+		v := strings.Join(values, ",")
+		switch {
+		case masked[http.CanonicalHeaderKey(name)]:
+			v = "[REDACTED]"
+		case name == "Authorization":
+			v = authSigPattern.ReplaceAllString(v, `sig="$1..."`)
+		}
+		parts = append(parts, name+": "+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "; ")
+}
+
+// redactBody replaces the values of sensitive JSON keys. Non-JSON bodies are
+// logged only by length, since we can't tell what's in them.
+func redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "[non-JSON body omitted]"
+	}
+	b, _ := json.Marshal(redactValue(v))
+	return string(b)
+}
+
+func redactValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if sensitiveKeys[strings.ToLower(k)] {
+				t[k] = "[REDACTED]"
+				continue
+			}
+			t[k] = redactValue(child)
+		}
+	case []any:
+		for i := range t {
+			t[i] = redactValue(t[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Deregister tells the backend this agent is being decommissioned so it is
+// marked inactive. The request is signed, identifying the agent by its AgentID.
+func (c *Client) Deregister(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodPost, c.endpoint("/deregister"), nil, nil, true); err != nil {
+		return fmt.Errorf("deregister: %w", err)
+	}
+
+	return nil
+}
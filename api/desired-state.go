@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+// SignedDesiredState is the desired-state response with a detached signature:
+// Signature is ed25519 (base64url) by the server key KeyID over the exact
+// bytes of Payload.
+type SignedDesiredState struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature,omitempty"`
+	KeyID     string          `json:"key_id,omitempty"`
+}
+
+// FetchDesiredState returns the raw desired state the backend has for this agent.
+// If the client has a server key (see SetServerKey), the payload must carry a
+// valid detached signature by it; unsigned or badly signed payloads are rejected.
+// The payload's next_poll_after, if any, is then available from c.NextPollAfter.
+func (c *Client) FetchDesiredState(ctx context.Context) (json.RawMessage, error) {
+	var raw json.RawMessage
+	if err := c.do(ctx, http.MethodGet, c.endpoint("/desired-state"), nil, &raw, true); err != nil {
+		return nil, fmt.Errorf("fetch desired state: %w", err)
+	}
+
+	var signed SignedDesiredState
+	if err := json.Unmarshal(raw, &signed); err != nil || len(signed.Payload) == 0 {
+		// A bare desired state, from a backend that doesn't sign.
+		signed = SignedDesiredState{Payload: raw}
+	}
+
+	if c.serverKey != nil {
+		if err := verifyDesiredState(&signed, c.serverKeyID, c.serverKey); err != nil {
+			return nil, fmt.Errorf("fetch desired state: %w", err)
+		}
+	}
+
+	c.recordPollHint(signed.Payload)
+	return signed.Payload, nil
+}
+
+// ErrLongPollUnsupported means the backend has no desired-state long-poll endpoint.
+var ErrLongPollUnsupported = errors.New("desired state long-poll not supported")
+
+// DesiredStateChange is the answer to a long-poll: Changed is false if the
+// wait elapsed with the desired state still at the version asked about.
+type DesiredStateChange struct {
+	Changed bool   `json:"changed"`
+	Version string `json:"version,omitempty"`
+}
+
+// WaitForChange asks the backend to hold the request open until its desired
+// state differs from version, or wait elapses. Cancelling ctx abandons it.
+func (c *Client) WaitForChange(ctx context.Context, version string, wait time.Duration) (*DesiredStateChange, error) {
+	q := url.Values{
+		"version": {version},
+		"timeout": {strconv.Itoa(int(wait.Seconds()))},
+	}
+
+	// The server may hold the request for up to wait, on top of the usual deadline.
+	held := *c
+	held.timeout = c.timeout + wait
+
+	var change DesiredStateChange
+	err := held.do(ctx, http.MethodGet, c.endpoint("/desired-state/wait?"+q.Encode()), nil, &change, true)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			return nil, fmt.Errorf("%w (status=%d)", ErrLongPollUnsupported, statusErr.StatusCode)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("wait for desired state change: %w", err)
+	}
+	if change.Changed && change.Version == "" {
+		return nil, fmt.Errorf("wait for desired state change: response has no version")
+	}
+	return &change, nil
+}
+
+func verifyDesiredState(signed *SignedDesiredState, keyID string, pub ed25519.PublicKey) error {
+	if signed.Signature == "" {
+		return fmt.Errorf("%w: desired state is not signed", auth.ErrInvalidSignature)
+	}
+	if signed.KeyID != keyID {
+		return fmt.Errorf("%w: desired state signed by unknown key %q", auth.ErrInvalidSignature, signed.KeyID)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: bad desired state signature encoding", auth.ErrInvalidSignature)
+	}
+	if !ed25519.Verify(pub, signed.Payload, sig) {
+		return fmt.Errorf("%w: desired state signature does not verify", auth.ErrInvalidSignature)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+func TestFetchDesiredStateSignature(t *testing.T) {
+	serverPub, serverPriv, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	payload := []byte(`{"version":"1","certificates":[]}`)
+	sign := func(priv ed25519.PrivateKey, data []byte) string {
+		return base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, data))
+	}
+	envelope := func(sig, keyID string) []byte {
+		b, _ := json.Marshal(SignedDesiredState{Payload: payload, Signature: sig, KeyID: keyID})
+		return b
+	}
+
+	tests := []struct {
+		name      string
+		body      []byte
+		serverKey bool // whether the client has the server key from enrollment
+		wantErr   bool
+	}{
+		{name: "valid", body: envelope(sign(serverPriv, payload), "srv-1"), serverKey: true},
+		{name: "missing signature", body: envelope("", "srv-1"), serverKey: true, wantErr: true},
+		{name: "bare payload", body: payload, serverKey: true, wantErr: true},
+		{name: "unknown key id", body: envelope(sign(serverPriv, payload), "srv-2"), serverKey: true, wantErr: true},
+		{name: "signed by another key", body: envelope(sign(otherPriv, payload), "srv-1"), serverKey: true, wantErr: true},
+		{name: "signature of other payload", body: envelope(sign(serverPriv, []byte(`{"version":"2"}`)), "srv-1"), serverKey: true, wantErr: true},
+		{name: "bad encoding", body: envelope("!!!", "srv-1"), serverKey: true, wantErr: true},
+		// Without a server key there's nothing to check against.
+		{name: "no server key, bare payload", body: payload},
+		{name: "no server key, envelope", body: envelope(sign(otherPriv, payload), "srv-1")},
+	}
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The response itself is always properly signed, so only the
+				// payload signature is under test.
+				if err := auth.SignResponse(w.Header(), r, http.StatusOK, tt.body, "srv-1", serverPriv, time.Now()); err != nil {
+					t.Errorf("sign response: %v", err)
+				}
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL, nil, &auth.KeySigner{AgentID: "agent-1", Key: priv})
+			if tt.serverKey {
+				c.SetServerKey("srv-1", serverPub)
+			}
+			got, err := c.FetchDesiredState(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, auth.ErrInvalidSignature) {
+					t.Errorf("err = %v, want auth.ErrInvalidSignature", err)
+				}
+				return
+			}
+			if string(got) != string(payload) {
+				t.Errorf("payload = %s, want %s", got, payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAlreadyEnrolled = errors.New("agent already enrolled")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrServer          = errors.New("server error")
+)
+
+// StatusError is returned for any non-200 API response. It wraps one of the
+// sentinel errors above when the status maps to one, so callers can use errors.Is.
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+	RetryAfter time.Duration // from the Retry-After header, if any
+	kind       error
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("status=%d body=%s", e.StatusCode, strings.TrimSpace(string(e.Body)))
+	if e.kind != nil {
+		return e.kind.Error() + ": " + msg
+	}
+	return msg
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.kind
+}
+
+func newStatusError(resp *http.Response, body []byte) *StatusError {
+	e := &StatusError{
+		StatusCode: resp.StatusCode,
+		Body:       body,
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		e.kind = ErrAlreadyEnrolled
+	case resp.StatusCode == http.StatusUnauthorized:
+		e.kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.kind = ErrRateLimited
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode >= 500:
+		e.kind = ErrServer
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+
+	return e
+}
+
+// parseRetryAfter parses a Retry-After header in either of its forms:
+// delay-seconds ("120") or an HTTP-date ("Wed, 21 Oct 2015 07:28:00 GMT").
+// It returns zero if the header is missing, invalid, or in the past.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// RetryAfter returns how long the server asked us to wait before retrying, if err carries it.
+func RetryAfter(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		status         int
+		retryAfter     string
+		want           error // nil: no sentinel
+		wantRetryAfter time.Duration
+	}{
+		{http.StatusConflict, "", ErrAlreadyEnrolled, 0},
+		{http.StatusUnauthorized, "", ErrUnauthorized, 0},
+		{http.StatusTooManyRequests, "", ErrRateLimited, 0},
+		{http.StatusTooManyRequests, "120", ErrRateLimited, 2 * time.Minute},
+		{http.StatusInternalServerError, "", ErrServer, 0},
+		{http.StatusBadGateway, "", ErrServer, 0},
+		{http.StatusServiceUnavailable, "30", ErrServer, 30 * time.Second},
+		{http.StatusBadRequest, "", nil, 0},
+		{http.StatusForbidden, "", nil, 0},
+		{http.StatusNotFound, "", nil, 0},
+	}
+	sentinels := []error{ErrAlreadyEnrolled, ErrUnauthorized, ErrRateLimited, ErrServer}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			var err error = newStatusError(resp, []byte(`{"error":"nope"}`))
+
+			for _, s := range sentinels {
+				if got := errors.Is(err, s); got != (s == tt.want) {
+					t.Errorf("errors.Is(err, %v) = %t", s, got)
+				}
+			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status || string(statusErr.Body) != `{"error":"nope"}` {
+				t.Errorf("StatusError = %+v, want status %d with the body", statusErr, tt.status)
+			}
+			if got := RetryAfter(err); got != tt.wantRetryAfter {
+				t.Errorf("RetryAfter = %s, want %s", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+// TestStatusErrorFromClient checks that the sentinels survive the client's
+// own wrapping, for statuses it doesn't retry.
+func TestStatusErrorFromClient(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusConflict, ErrAlreadyEnrolled},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusInternalServerError, ErrServer}, // a POST isn't retried
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			resetShared(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			_, err := NewClient(srv.URL, nil, nil).InstallAgent(context.Background(), InstallRequest{})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration // checked to the second, for dates
+	}{
+		{"empty", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"padded", " 5 ", 5 * time.Second},
+		{"negative", "-5", 0},
+		{"garbage", "soon", 0},
+		{"date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), time.Hour},
+		{"past date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRetryAfter(tt.value)
+			if diff := got - tt.want; diff < -time.Second || diff > time.Second {
+				t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// The API base that last answered is shared by every Client in the process,
+// like the circuit breaker, so each new Client starts where the last one
+// left off instead of waiting out a dead primary again.
+var (
+	activeMu   sync.Mutex
+	activeBase string
+)
+
+// SetFallbacks sets API bases to fail over to, in order, when apiBase can't
+// be reached or keeps answering with server errors.
+func (c *Client) SetFallbacks(apiBases []string) {
+	c.fallbacks = nil
+	for _, base := range apiBases {
+		if base = strings.TrimRight(base, "/"); base != "" && base != c.apiBase {
+			c.fallbacks = append(c.fallbacks, base)
+		}
+	}
+}
+
+// bases returns the API bases to try, in order: the one that last answered,
+// then the rest in configured order.
+func (c *Client) bases() []string {
+	all := append([]string{c.apiBase}, c.fallbacks...)
+	active := currentBase()
+	for i, base := range all {
+		if base == active && i > 0 {
+			return append(append([]string{base}, all[:i]...), all[i+1:]...)
+		}
+	}
+	return all
+}
+
+// base returns the API base requests are currently sent to.
+func (c *Client) base() string {
+	return c.bases()[0]
+}
+
+func currentBase() string {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	return activeBase
+}
+
+func setActiveBase(base string) {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	activeBase = base
+}
+
+// failover reports whether a request with method that failed with err is
+// worth trying against the next API base: the backend was unreachable or kept
+// failing, as opposed to rejecting the request or the caller giving up. A
+// request that isn't idempotent (enrollment, a CSR, ...) only fails over when
+// it provably never reached a backend (see unprocessed), since one that timed
+// out may still have been acted on.
+func failover(ctx context.Context, method string, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	if !idempotent(method) {
+		return unprocessed(err)
+	}
+	var urlErr *url.Error
+	return retryable(method, err) || errors.As(err, &urlErr)
+}
+
+// unprocessed reports whether a request that failed with err never reached
+// the backend's handlers: it couldn't connect, the TLS handshake failed, or it
+// was turned away as rate limited.
+func unprocessed(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, ErrRateLimited) || isTLSError(err)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// deadURL returns a URL nothing listens on, so connecting to it fails.
+func deadURL(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "http://" + addr
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		primary      int // status the primary answers with; 0: unreachable
+		wantFallback bool
+	}{
+		{"GET, primary unreachable", http.MethodGet, 0, true},
+		{"POST, primary unreachable", http.MethodPost, 0, true},
+		{"GET, primary failing", http.MethodGet, http.StatusInternalServerError, true},
+		// The primary may have acted on it.
+		{"POST, primary failing", http.MethodPost, http.StatusInternalServerError, false},
+		{"POST, primary rate limiting", http.MethodPost, http.StatusTooManyRequests, true},
+		{"GET, primary rejects", http.MethodGet, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			primary := deadURL(t)
+			if tt.primary != 0 {
+				srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.primary)
+				}))
+				defer srv.Close()
+				primary = srv.URL
+			}
+			var fallbackHits atomic.Int32
+			fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fallbackHits.Add(1)
+				w.Write([]byte(`{}`))
+			}))
+			defer fallback.Close()
+
+			c := NewClient(primary, nil, nil)
+			c.SetFallbacks([]string{fallback.URL})
+			err := c.do(context.Background(), tt.method, "/x", map[string]string{}, nil, false)
+			if got := fallbackHits.Load() > 0; got != tt.wantFallback {
+				t.Fatalf("fallback used = %v, want %v (err %v)", got, tt.wantFallback, err)
+			}
+			if tt.wantFallback && err != nil {
+				t.Fatalf("do: %v", err)
+			}
+		})
+	}
+}
+
+func TestPingFallback(t *testing.T) {
+	resetShared(t)
+	fallback := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer fallback.Close()
+
+	c := NewClient(deadURL(t), nil, nil)
+	c.SetFallbacks([]string{fallback.URL})
+	result, err := c.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if result.APIBase != fallback.URL {
+		t.Fatalf("APIBase = %q, want %q", result.APIBase, fallback.URL)
+	}
+
+	c = NewClient(deadURL(t), nil, nil)
+	c.SetFallbacks([]string{deadURL(t)})
+	if _, err := c.Ping(context.Background()); err == nil {
+		t.Fatal("Ping succeeded with every base unreachable")
+	}
+}
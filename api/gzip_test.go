@@ -0,0 +1,51 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGzipResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		httpClient *http.Client
+		wantAsked  bool // the request carried Accept-Encoding: gzip
+	}{
+		// The shared transport asks for gzip and decodes the response itself.
+		{name: "transport decoded", wantAsked: true},
+		// A transport that doesn't ask leaves it to readResponseBody.
+		{name: "unsolicited", httpClient: &http.Client{Transport: &http.Transport{DisableCompression: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			var asked atomic.Bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				asked.Store(r.Header.Get("Accept-Encoding") == "gzip")
+				w.Header().Set("Content-Encoding", "gzip")
+				zw := gzip.NewWriter(w)
+				zw.Write([]byte(`{"message":"hello"}`))
+				zw.Close()
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL, tt.httpClient, nil)
+			var out struct {
+				Message string `json:"message"`
+			}
+			if err := c.do(context.Background(), http.MethodGet, "/x", nil, &out, false); err != nil {
+				t.Fatal(err)
+			}
+			if out.Message != "hello" {
+				t.Errorf("message = %q, want hello", out.Message)
+			}
+			if got := asked.Load(); got != tt.wantAsked {
+				t.Errorf("asked for gzip = %v, want %v", got, tt.wantAsked)
+			}
+		})
+	}
+}
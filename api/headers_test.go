@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+func TestExtraHeaders(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	var mu sync.Mutex
+	got := map[string]string{} // path -> X-Gateway-Token
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			// The header is bound into the signature, so this also proves it
+			// was set before signing.
+			lookup := func(string) (ed25519.PublicKey, error) { return pub, nil }
+			if _, err := auth.VerifyRequest(r, lookup, time.Now(), auth.VerifyOptions{MaxAge: auth.DefaultMaxAge}); err != nil {
+				t.Errorf("%s: verify: %v", r.URL.Path, err)
+			}
+		}
+		mu.Lock()
+		got[r.URL.Path] = r.Header.Get("X-Gateway-Token")
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	signer := &auth.KeySigner{AgentID: "agent-1", Key: priv, SignedHeaders: []string{"X-Gateway-Token"}}
+	c := NewClient(srv.URL, nil, signer)
+	c.SetExtraHeaders(map[string]string{"x-gateway-token": "s3cret"})
+
+	if err := c.do(context.Background(), http.MethodPost, c.endpoint("/events"), map[string]string{}, nil, true); err != nil {
+		t.Fatalf("signed request: %v", err)
+	}
+	if _, err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	for _, path := range []string{DefaultAPIPrefix + "/events", "/"} {
+		if got[path] != "s3cret" {
+			t.Errorf("%s: X-Gateway-Token = %q, want s3cret", path, got[path])
+		}
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", `AgentSig keyId="a", alg="ed25519", sig="abcdefghijklmnop", signed="method"`)
+	h.Set("CF-Access-Client-Secret", "topsecret")
+	h.Set("User-Agent", "certkit-agent/dev")
+
+	out := redactHeaders(h, map[string]string{"cf-access-client-secret": "topsecret"})
+	if strings.Contains(out, "topsecret") {
+		t.Errorf("extra header value logged: %s", out)
+	}
+	if strings.Contains(out, "ijklmnop") {
+		t.Errorf("signature logged in full: %s", out)
+	}
+	if !strings.Contains(out, "User-Agent: certkit-agent/dev") {
+		t.Errorf("ordinary header missing: %s", out)
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	platform := " (" + runtime.GOOS + "/" + runtime.GOARCH + ")"
+
+	tests := []struct {
+		name      string
+		newClient func(apiBase string) (*Client, error)
+		wantUA    string
+	}{
+		{"default", func(apiBase string) (*Client, error) { return NewClient(apiBase, nil, nil), nil }, "certkit-agent/dev" + platform},
+		{"from config", func(apiBase string) (*Client, error) {
+			return NewClientFromConfig(&config.Config{ApiBase: apiBase, Version: config.VersionInfo{Version: "1.2.3"}}, Options{AllowInsecureHTTP: true})
+		}, "certkit-agent/1.2.3" + platform},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			var mu sync.Mutex
+			var userAgents, requestIDs []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				userAgents = append(userAgents, r.Header.Get("User-Agent"))
+				requestIDs = append(requestIDs, r.Header.Get("X-Request-Id"))
+				// Fail the first attempt, so there's a retry.
+				if len(requestIDs) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusBadRequest)
+			}))
+			defer srv.Close()
+
+			c, err := tt.newClient(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.do(context.Background(), http.MethodGet, "/x", nil, nil, false)
+			if err == nil {
+				t.Fatal("do succeeded")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(requestIDs) != 2 {
+				t.Fatalf("%d attempts, want 2", len(requestIDs))
+			}
+			for i := range requestIDs {
+				if userAgents[i] != tt.wantUA {
+					t.Errorf("attempt %d: User-Agent = %q, want %q", i+1, userAgents[i], tt.wantUA)
+				}
+				if !uuid.MatchString(requestIDs[i]) {
+					t.Errorf("attempt %d: X-Request-Id = %q, want a v4 UUID", i+1, requestIDs[i])
+				}
+			}
+			if requestIDs[0] == requestIDs[1] {
+				t.Errorf("retry reused X-Request-Id %s", requestIDs[0])
+			}
+			// The error names the attempt that failed, to find it in backend logs.
+			if !strings.Contains(err.Error(), requestIDs[1]) {
+				t.Errorf("err = %v, want it to include request id %s", err, requestIDs[1])
+			}
+		})
+	}
+}
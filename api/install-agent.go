@@ -1,83 +1,87 @@
 package api
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"time"
+	"runtime"
 
+	"github.com/certkit-io/certkit-agent-alpha/auth"
 	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
 )
 
 type InstallRequest struct {
 	PublicKey string `json:"public_key"`
 	Hostname  string `json:"hostname"`
 	Version   string `json:"version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	Kernel    string `json:"kernel"`
+	// Labels are the config's key/value labels, for grouping agents.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type InstallResponse struct {
 	AgentId string `json:"agent_id"`
+	// KeyID is the id the backend assigned to our public key.
+	KeyID string `json:"key_id,omitempty"`
+	// ServerKeyID and ServerPublicKey (base64url ed25519) identify the key the
+	// backend signs responses with, if it does.
+	ServerKeyID     string `json:"server_key_id,omitempty"`
+	ServerPublicKey string `json:"server_public_key,omitempty"`
 }
 
-func InstallAgent() (*InstallResponse, error) {
-
-	hostname, _ := os.Hostname()
-	payload := InstallRequest{
-		PublicKey: config.CurrentConfig.Auth.KeyPair.PublicKey,
-		Hostname:  hostname,
-		Version:   config.CurrentConfig.Version.Version,
+// AgentCreds returns the credentials to persist for this enrollment.
+func (r *InstallResponse) AgentCreds() *config.AgentCreds {
+	return &config.AgentCreds{
+		AgentID:         r.AgentId,
+		KeyID:           r.KeyID,
+		ServerKeyID:     r.ServerKeyID,
+		ServerPublicKey: r.ServerPublicKey,
 	}
+}
 
-	// Marshal payload to JSON
-	requestBody, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("marshal json: %w", err)
+// NewInstallRequest builds the registration payload for this host.
+func NewInstallRequest(cfg *config.Config) InstallRequest {
+	return InstallRequest{
+		PublicKey: cfg.Auth.KeyPair.PublicKey,
+		Hostname:  cfg.ReportedHostname(),
+		Version:   cfg.Version.Version,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Kernel:    utils.KernelVersion(),
+		Labels:    cfg.Labels,
 	}
+}
 
-	// Build request with raw bytes
-	req, err := http.NewRequest(
-		http.MethodPost,
-		config.CurrentConfig.ApiBase+"/api/agent/v1/register-agent",
-		bytes.NewReader(requestBody),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("new request: %w", err)
-	}
-
-	// Required for JSON
-	req.Header.Set("Content-Type", "application/json")
-
-	// (Optional) Set a timeout at the client level
-	client := &http.Client{
-		Timeout: 15 * time.Second,
+// InstallAgent registers this agent's public key with the backend. Cancelling
+// ctx (e.g. on shutdown) aborts the registration.
+func (c *Client) InstallAgent(ctx context.Context, payload InstallRequest) (*InstallResponse, error) {
+	var installResp InstallResponse
+	err := c.do(ctx, http.MethodPost, c.endpoint("/register-agent"), payload, &installResp, false)
+
+	// A 409 means this public key is already registered; if the backend tells us
+	// which agent it belongs to, that's as good as a fresh enrollment, and its
+	// server key is checked like one.
+	var statusErr *StatusError
+	if errors.Is(err, ErrAlreadyEnrolled) && errors.As(err, &statusErr) {
+		if json.Unmarshal(statusErr.Body, &installResp) == nil && installResp.AgentId != "" {
+			err = nil
+		}
 	}
-
-	//privKey, _ := auth.DecodePrivateKey(config.CurrentConfig.Auth.KeyPair.PrivateKey)
-
-	// err = auth.SignRequest(req, "Eric", privKey, time.Now())
-	// if err != nil {
-	// 	panic(err)
-	// }
-
-	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("http do: %w", err)
+		return nil, fmt.Errorf("install agent: %w", err)
 	}
-
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("install failed: status=%d body=%s", resp.StatusCode, body)
+	if (installResp.ServerKeyID == "") != (installResp.ServerPublicKey == "") {
+		return nil, fmt.Errorf("install agent: server_key_id and server_public_key must be sent together")
 	}
-
-	var installResp InstallResponse
-	if err := json.Unmarshal(body, &installResp); err != nil {
-		return nil, fmt.Errorf("decode install response: %w", err)
+	if installResp.ServerPublicKey != "" {
+		if _, err := auth.DecodePublicKey(installResp.ServerPublicKey); err != nil {
+			return nil, fmt.Errorf("install agent: invalid server_public_key: %w", err)
+		}
 	}
 
 	return &installResp, nil
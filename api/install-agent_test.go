@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+func TestInstallRequestJSON(t *testing.T) {
+	resetShared(t)
+
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Write([]byte(`{"agent_id":"agent-1"}`))
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		Hostname: "web-1",
+		Auth:     &config.AuthCreds{KeyPair: &auth.KeyPair{PublicKey: "pub"}},
+		Version:  config.VersionInfo{Version: "1.2.3"},
+	}
+	if _, err := NewClient(srv.URL, nil, nil).InstallAgent(context.Background(), NewInstallRequest(cfg)); err != nil {
+		t.Fatalf("InstallAgent: %v", err)
+	}
+
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"public_key", "pub"},
+		{"hostname", "web-1"},
+		{"version", "1.2.3"},
+		{"os", runtime.GOOS},
+		{"arch", runtime.GOARCH},
+		// Sent even where it can't be read, as "".
+		{"kernel", utils.KernelVersion()},
+	}
+	for _, tt := range tests {
+		got, ok := body[tt.field]
+		if !ok {
+			t.Errorf("%s missing from request %v", tt.field, body)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %q", tt.field, got, tt.want)
+		}
+	}
+}
+
+// TestInstallResponseServerKey round-trips the server key from enrollment
+// through the saved config into a client that verifies signed responses.
+func TestInstallResponseServerKey(t *testing.T) {
+	serverPub, serverPriv, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	encoded := base64.RawURLEncoding.EncodeToString(serverPub)
+
+	tests := []struct {
+		name       string
+		install    string             // register-agent response
+		status     int                // of the register-agent response, 0 for 200
+		signWith   ed25519.PrivateKey // for other responses, nil for unsigned
+		wantErr    bool               // from InstallAgent
+		wantKeyID  string
+		wantVerify bool // whether a signed request's response is accepted
+	}{
+		{
+			name:       "server key",
+			install:    `{"agent_id":"agent-1","key_id":"k1","server_key_id":"srv-1","server_public_key":"` + encoded + `"}`,
+			signWith:   serverPriv,
+			wantKeyID:  "srv-1",
+			wantVerify: true,
+		},
+		{
+			name:      "signed by another key",
+			install:   `{"agent_id":"agent-1","key_id":"k1","server_key_id":"srv-1","server_public_key":"` + encoded + `"}`,
+			signWith:  otherPriv,
+			wantKeyID: "srv-1",
+		},
+		{
+			name:      "unsigned response",
+			install:   `{"agent_id":"agent-1","key_id":"k1","server_key_id":"srv-1","server_public_key":"` + encoded + `"}`,
+			wantKeyID: "srv-1",
+		},
+		{
+			name:       "no server key",
+			install:    `{"agent_id":"agent-1","key_id":"k1"}`,
+			wantVerify: true,
+		},
+		{
+			name:    "key id without key",
+			install: `{"agent_id":"agent-1","server_key_id":"srv-1"}`,
+			wantErr: true,
+		},
+		{
+			name:    "bad public key",
+			install: `{"agent_id":"agent-1","server_key_id":"srv-1","server_public_key":"not-a-key"}`,
+			wantErr: true,
+		},
+		{
+			name:       "already enrolled",
+			install:    `{"agent_id":"agent-1","key_id":"k1","server_key_id":"srv-1","server_public_key":"` + encoded + `"}`,
+			status:     http.StatusConflict,
+			signWith:   serverPriv,
+			wantKeyID:  "srv-1",
+			wantVerify: true,
+		},
+		{
+			name:    "already enrolled, key id without key",
+			install: `{"agent_id":"agent-1","server_key_id":"srv-1"}`,
+			status:  http.StatusConflict,
+			wantErr: true,
+		},
+		{
+			name:    "already enrolled, bad public key",
+			install: `{"agent_id":"agent-1","server_key_id":"srv-1","server_public_key":"not-a-key"}`,
+			status:  http.StatusConflict,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == DefaultAPIPrefix+"/register-agent" {
+					if tt.status != 0 {
+						w.WriteHeader(tt.status)
+					}
+					w.Write([]byte(tt.install))
+					return
+				}
+				body := []byte(`{}`)
+				if tt.signWith != nil {
+					if err := auth.SignResponse(w.Header(), r, http.StatusOK, body, "srv-1", tt.signWith, time.Now()); err != nil {
+						t.Errorf("sign response: %v", err)
+					}
+				}
+				w.Write(body)
+			}))
+			defer srv.Close()
+
+			resp, err := NewClient(srv.URL, nil, nil).InstallAgent(context.Background(), InstallRequest{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InstallAgent: err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			kp, err := auth.CreateNewKeyPair()
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "config.json")
+			saved := &config.Config{ApiBase: srv.URL, Agent: resp.AgentCreds(), Auth: &config.AuthCreds{KeyPair: kp}}
+			if err := config.SaveConfig(saved, path); err != nil {
+				t.Fatalf("save config: %v", err)
+			}
+			cfg, err := config.ReadConfig(path, config.Options{})
+			if err != nil {
+				t.Fatalf("read config: %v", err)
+			}
+			if cfg.Agent.ServerKeyID != tt.wantKeyID {
+				t.Errorf("server_key_id = %q, want %q", cfg.Agent.ServerKeyID, tt.wantKeyID)
+			}
+
+			client, err := NewClientFromConfig(&cfg, Options{AllowInsecureHTTP: true})
+			if err != nil {
+				t.Fatalf("NewClientFromConfig: %v", err)
+			}
+			if err := client.ReportEvent(context.Background(), Event{Type: EventReconcileSucceeded}); (err == nil) != tt.wantVerify {
+				t.Errorf("ReportEvent: err = %v, want success %t", err, tt.wantVerify)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Envelope types for offline (air-gapped) enrollment. The operator carries the
+// request to the backend out of band and brings the response back.
+const (
+	OfflineRequestType  = "certkit-agent-enrollment-request"
+	OfflineResponseType = "certkit-agent-enrollment-response"
+	OfflineVersion      = 1
+)
+
+// OfflineEnrollmentRequest wraps an InstallRequest. Payload holds the exact
+// InstallRequest JSON that Signature (ed25519, base64url) covers, proving the
+// request came from the holder of the private key for payload.public_key.
+type OfflineEnrollmentRequest struct {
+	Type      string          `json:"type"`
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"`
+}
+
+// OfflineEnrollmentResponse is what the backend issues for an offline request.
+type OfflineEnrollmentResponse struct {
+	Type         string `json:"type"`
+	Version      int    `json:"version"`
+	PublicKey    string `json:"public_key"`
+	AgentId      string `json:"agent_id"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// See InstallResponse.
+	KeyID           string `json:"key_id,omitempty"`
+	ServerKeyID     string `json:"server_key_id,omitempty"`
+	ServerPublicKey string `json:"server_public_key,omitempty"`
+}
+
+// NewOfflineEnrollmentRequest signs payload with key into a request envelope.
+func NewOfflineEnrollmentRequest(payload InstallRequest, key crypto.Signer, now time.Time) (*OfflineEnrollmentRequest, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal json: %w", err)
+	}
+	sig, err := key.Sign(rand.Reader, b, crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("sign enrollment request: %w", err)
+	}
+	return &OfflineEnrollmentRequest{
+		Type:      OfflineRequestType,
+		Version:   OfflineVersion,
+		CreatedAt: now.UTC(),
+		Payload:   b,
+		Signature: base64.RawURLEncoding.EncodeToString(sig),
+	}, nil
+}
+
+// ParseOfflineEnrollmentResponse decodes and sanity-checks a response envelope
+// against the public key this agent enrolled with.
+func ParseOfflineEnrollmentResponse(b []byte, publicKey string) (*OfflineEnrollmentResponse, error) {
+	var resp OfflineEnrollmentResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("decode enrollment response: %w", err)
+	}
+	if resp.Type != OfflineResponseType {
+		return nil, fmt.Errorf("not an enrollment response (type %q)", resp.Type)
+	}
+	if resp.Version != OfflineVersion {
+		return nil, fmt.Errorf("unsupported enrollment response version %d", resp.Version)
+	}
+	if resp.AgentId == "" {
+		return nil, fmt.Errorf("enrollment response has no agent_id")
+	}
+	if resp.PublicKey != publicKey {
+		return nil, fmt.Errorf("enrollment response is for a different public key (%s)", resp.PublicKey)
+	}
+	return &resp, nil
+}
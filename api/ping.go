@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrTLS marks a Ping failure caused by the TLS handshake (untrusted CA,
+// hostname mismatch, plain HTTP on a TLS port, ...) rather than the network.
+var ErrTLS = errors.New("tls error")
+
+// PingResult describes a successful Ping.
+type PingResult struct {
+	APIBase    string // the one that answered
+	StatusCode int
+	TLS        *tls.ConnectionState // nil for plain http
+	ServerDate time.Time            // from the Date header; zero if absent
+}
+
+// Ping checks that an API base is reachable, trying the fallbacks in the same
+// order requests do. Any HTTP response counts as reachable; only
+// transport-level failures are returned.
+func (c *Client) Ping(ctx context.Context) (*PingResult, error) {
+	bases := c.bases()
+	var errs []error
+	for _, base := range bases {
+		result, err := c.ping(ctx, base)
+		if err == nil {
+			return result, nil
+		}
+		if len(bases) == 1 {
+			return nil, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", base, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (c *Client) ping(ctx context.Context, base string) (*PingResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/", nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	c.setHeaders(req)
+	requestID, err := newRequestID()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Request-Id", requestID)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if isTLSError(err) {
+			return nil, fmt.Errorf("%w: %w", ErrTLS, err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	recordClockSkew(resp)
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	result := &PingResult{
+		APIBase:    base,
+		StatusCode: resp.StatusCode,
+		TLS:        resp.TLS,
+	}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		result.ServerDate = date
+	}
+	return result, nil
+}
+
+func isTLSError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+	var verification *tls.CertificateVerificationError
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &invalid) ||
+		errors.As(err, &recordHeader) ||
+		errors.As(err, &verification)
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// recordPollHint notes the next_poll_after (seconds) a desired state payload
+// carries, or its absence. It's read from the payload so that, like the rest
+// of the desired state, it's covered by the backend's signature.
+func (c *Client) recordPollHint(payload json.RawMessage) {
+	var hint struct {
+		NextPollAfter float64 `json:"next_poll_after"`
+	}
+	json.Unmarshal(payload, &hint)
+	c.pollHint = time.Duration(hint.NextPollAfter * float64(time.Second))
+}
+
+// NextPollAfter returns how long the backend asked the agent to wait before
+// fetching the desired state again, in the last one c fetched. Zero means it
+// didn't say and the agent's own interval applies.
+func (c *Client) NextPollAfter() time.Duration {
+	return c.pollHint
+}
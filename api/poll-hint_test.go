@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+func TestNextPollAfter(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want time.Duration
+	}{
+		{"absent", `{"certificates":[]}`, 0},
+		{"seconds", `{"next_poll_after":120}`, 2 * time.Minute},
+		{"fractional", `{"next_poll_after":2.5}`, 2500 * time.Millisecond},
+		{"signed payload", `{"payload":{"next_poll_after":60}}`, time.Minute},
+		{"wrong type", `{"next_poll_after":"soon"}`, 0},
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer := &auth.KeySigner{AgentID: "agent-1", Key: priv}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL, nil, signer)
+			// A hint from an earlier fetch mustn't outlive a payload without one.
+			c.pollHint = time.Hour
+			if _, err := c.FetchDesiredState(context.Background()); err != nil {
+				t.Fatalf("FetchDesiredState: %v", err)
+			}
+			if got := c.NextPollAfter(); got != tt.want {
+				t.Errorf("NextPollAfter = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+func TestAPIPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string // path appended to the server URL for api_base
+		prefix string
+		want   string // path of the register-agent request
+	}{
+		{name: "default", want: "/api/agent/v1/register-agent"},
+		{name: "custom", prefix: "/certkit/api/agent/v2", want: "/certkit/api/agent/v2/register-agent"},
+		{name: "slashes trimmed", prefix: "certkit/v2/", want: "/certkit/v2/register-agent"},
+		{name: "root", prefix: "/", want: "/register-agent"},
+		{name: "api_base with a path", base: "/backend/", prefix: "/v2", want: "/backend/v2/register-agent"},
+		{name: "api_base with a path, default prefix", base: "/backend", want: "/backend/api/agent/v1/register-agent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			var got string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.URL.Path
+				w.Write([]byte(`{"agent_id":"agent-1"}`))
+			}))
+			defer srv.Close()
+
+			c, err := NewClientFromConfig(&config.Config{ApiBase: srv.URL + tt.base, APIPrefix: tt.prefix}, Options{AllowInsecureHTTP: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.InstallAgent(context.Background(), InstallRequest{}); err != nil {
+				t.Fatalf("InstallAgent: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("requested %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+func TestProxyURL(t *testing.T) {
+	type seen struct {
+		url, proxyAuth string
+	}
+	var got *seen
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = &seen{url: r.URL.String(), proxyAuth: r.Header.Get("Proxy-Authorization")}
+	}))
+	defer proxy.Close()
+	withUser, _ := url.Parse(proxy.URL)
+	withUser.User = url.UserPassword("agent", "s3cret")
+
+	tests := []struct {
+		name     string
+		proxyURL string
+		wantErr  bool
+		wantAuth string
+	}{
+		{name: "proxy_url", proxyURL: proxy.URL},
+		{name: "credentials", proxyURL: withUser.String(), wantAuth: "Basic " + base64.StdEncoding.EncodeToString([]byte("agent:s3cret"))},
+		{name: "no scheme", proxyURL: "proxy.example:3128", wantErr: true},
+		{name: "unparseable", proxyURL: "http://%zz", wantErr: true},
+	}
+
+	// proxy_url takes precedence over the environment.
+	t.Setenv("HTTP_PROXY", "http://127.0.0.1:1")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			tr, err := newTransport(&config.Config{ProxyURL: tt.proxyURL})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTransport: err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer tr.CloseIdleConnections()
+
+			resp, err := (&http.Client{Transport: tr}).Get("http://backend.invalid/ping")
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			resp.Body.Close()
+			if got == nil {
+				t.Fatal("request didn't go through the proxy")
+			}
+			if got.url != "http://backend.invalid/ping" {
+				t.Errorf("proxy saw %q, want the absolute backend URL", got.url)
+			}
+			if got.proxyAuth != tt.wantAuth {
+				t.Errorf("Proxy-Authorization = %q, want %q", got.proxyAuth, tt.wantAuth)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*RefreshTokenResponse, error) {
+	payload := RefreshTokenRequest{
+		RefreshToken: refreshToken,
+	}
+
+	var refreshResp RefreshTokenResponse
+	if err := c.do(ctx, http.MethodPost, c.endpoint("/refresh-token"), payload, &refreshResp, true); err != nil {
+		return nil, fmt.Errorf("refresh token: %w", err)
+	}
+
+	return &refreshResp, nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Event types reported to the backend.
+const (
+	EventEnrolled           = "enrolled"
+	EventReconcileSucceeded = "reconcile_succeeded"
+	EventReconcileFailed    = "reconcile_failed"
+	EventCertDeployed       = "cert_deployed"
+	EventReloadFailed       = "reload_failed"
+	EventDeploySkipped      = "deploy_skipped"
+	EventVerifyFailed       = "verify_failed"
+	EventUpdated            = "updated"
+	EventUpdateFailed       = "update_failed"
+)
+
+// Event is a status event for the backend's per-agent audit trail.
+type Event struct {
+	Type          string    `json:"type"`
+	Time          time.Time `json:"time"`
+	Error         string    `json:"error,omitempty"`
+	CertificateID string    `json:"certificate_id,omitempty"`
+	TargetID      string    `json:"target_id,omitempty"`
+	Fingerprint   string    `json:"fingerprint,omitempty"` // SHA-256 of the leaf certificate
+	NotAfter      time.Time `json:"not_after,omitzero"`    // of the leaf certificate
+	Reload        string    `json:"reload,omitempty"`
+	Version       string    `json:"version,omitempty"` // of the agent, for update events
+}
+
+type ReportEventsRequest struct {
+	Events []Event `json:"events"`
+}
+
+// ReportEvents sends a batch of events.
+func (c *Client) ReportEvents(ctx context.Context, events []Event) error {
+	payload := ReportEventsRequest{
+		Events: events,
+	}
+
+	if err := c.do(ctx, http.MethodPost, c.endpoint("/events"), payload, nil, true); err != nil {
+		return fmt.Errorf("report events: %w", err)
+	}
+
+	return nil
+}
+
+// ReportEvent sends a single event.
+func (c *Client) ReportEvent(ctx context.Context, event Event) error {
+	return c.ReportEvents(ctx, []Event{event})
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/certkit-io/certkit-agent-alpha/inventory"
+)
+
+// ReportInventory sends the certificates and services found on this host.
+func (c *Client) ReportInventory(ctx context.Context, inv *inventory.Inventory) error {
+	if err := c.do(ctx, http.MethodPost, c.endpoint("/inventory"), inv, nil, true); err != nil {
+		return fmt.Errorf("report inventory: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	maxAttempts   = 3
+	baseRetry     = 1 * time.Second
+	maxRetryDelay = 5 * time.Minute
+)
+
+// retryable reports whether a request with method that failed with err is
+// worth retrying: it was rate limited, or hit a server error and is idempotent.
+// A server error doesn't say whether the backend acted on the request, so a
+// POST (enrollment, a CSR, ...) could be applied twice; 429 means it wasn't.
+func retryable(method string, err error) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	return errors.Is(err, ErrServer) && idempotent(method)
+}
+
+// idempotent reports whether sending a request with method twice has the same
+// effect as sending it once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retry number attempt+1.
+//
+// A server-provided Retry-After is honored (capped at maxRetryDelay) with up to
+// 10% extra jitter; otherwise it's exponential backoff with full jitter. Either
+// way a fleet of agents told to back off at once won't all come back at once.
+func retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if retryAfter > maxRetryDelay {
+			retryAfter = maxRetryDelay
+		}
+		return retryAfter + rand.N(retryAfter/10+1)
+	}
+
+	backoff := baseRetry << attempt
+	if backoff <= 0 || backoff > maxRetryDelay {
+		backoff = maxRetryDelay
+	}
+	return rand.N(backoff) + 1
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resetShared puts the process-wide client state (circuit breaker, active API
+// base) back to how a fresh process starts.
+func resetShared(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		breakerMu.Lock()
+		breakerState, breakerFails, breakerProbing = circuitClosed, 0, false
+		breakerMu.Unlock()
+		setActiveBase("")
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		status       int
+		wantAttempts int32
+	}{
+		{"GET server error", http.MethodGet, http.StatusServiceUnavailable, maxAttempts},
+		{"GET rate limited", http.MethodGet, http.StatusTooManyRequests, maxAttempts},
+		{"GET client error", http.MethodGet, http.StatusBadRequest, 1},
+		// The backend may have acted on a POST that got a 5xx.
+		{"POST server error", http.MethodPost, http.StatusInternalServerError, 1},
+		{"POST bad gateway", http.MethodPost, http.StatusBadGateway, 1},
+		// 429 means it didn't.
+		{"POST rate limited", http.MethodPost, http.StatusTooManyRequests, maxAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL, nil, nil)
+			if err := c.do(context.Background(), tt.method, "/x", nil, nil, false); err == nil {
+				t.Fatal("do succeeded")
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Fatalf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 0; attempt < 12; attempt++ {
+		d := retryDelay(attempt, 0)
+		limit := baseRetry << attempt
+		if limit > maxRetryDelay {
+			limit = maxRetryDelay
+		}
+		if d <= 0 || d > limit {
+			t.Errorf("retryDelay(%d, 0) = %s, want (0, %s]", attempt, d, limit)
+		}
+	}
+
+	tests := []struct {
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{10 * time.Second, 10 * time.Second, 11 * time.Second},
+		{time.Hour, maxRetryDelay, maxRetryDelay + maxRetryDelay/10},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if d := retryDelay(0, tt.retryAfter); d < tt.min || d > tt.max {
+				t.Errorf("retryDelay(0, %s) = %s, want [%s, %s]", tt.retryAfter, d, tt.min, tt.max)
+			}
+		}
+	}
+}
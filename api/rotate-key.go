@@ -0,0 +1,25 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type RotateKeyRequest struct {
+	PublicKey string `json:"public_key"`
+}
+
+// RotateKey registers a new public key for this agent. The request is signed
+// with the current key; the backend only switches keys once this succeeds.
+func (c *Client) RotateKey(ctx context.Context, newPublicKey string) error {
+	payload := RotateKeyRequest{
+		PublicKey: newPublicKey,
+	}
+
+	if err := c.do(ctx, http.MethodPost, c.endpoint("/rotate-key"), payload, nil, true); err != nil {
+		return fmt.Errorf("rotate key: %w", err)
+	}
+
+	return nil
+}
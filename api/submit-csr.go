@@ -0,0 +1,36 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type SubmitCSRRequest struct {
+	CertificateID string `json:"certificate_id"`
+	CSR           string `json:"csr"` // PEM encoded
+}
+
+type SubmitCSRResponse struct {
+	Cert  string `json:"cert"`            // PEM encoded leaf
+	Chain string `json:"chain,omitempty"` // PEM encoded intermediates
+}
+
+// SubmitCSR sends a CSR generated on this host for certificateID and returns
+// the signed certificate. The private key never leaves the host.
+func (c *Client) SubmitCSR(ctx context.Context, certificateID, csrPEM string) (*SubmitCSRResponse, error) {
+	payload := SubmitCSRRequest{
+		CertificateID: certificateID,
+		CSR:           csrPEM,
+	}
+
+	var resp SubmitCSRResponse
+	if err := c.do(ctx, http.MethodPost, c.endpoint("/submit-csr"), payload, &resp, true); err != nil {
+		return nil, fmt.Errorf("submit csr: %w", err)
+	}
+	if resp.Cert == "" {
+		return nil, fmt.Errorf("submit csr: response has no certificate")
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+func TestTimeout(t *testing.T) {
+	type answer struct {
+		delay  time.Duration
+		status int
+	}
+	tests := []struct {
+		name     string
+		answers  []answer // one per attempt
+		timeout  time.Duration
+		wantErr  error
+		attempts int32
+	}{
+		{
+			name:     "slow handler",
+			answers:  []answer{{300 * time.Millisecond, http.StatusOK}},
+			timeout:  100 * time.Millisecond,
+			wantErr:  context.DeadlineExceeded,
+			attempts: 1,
+		},
+		{
+			// Together they take longer than the timeout, but each is within it.
+			name:     "fresh deadline per attempt",
+			answers:  []answer{{60 * time.Millisecond, http.StatusServiceUnavailable}, {60 * time.Millisecond, http.StatusOK}},
+			timeout:  100 * time.Millisecond,
+			attempts: 2,
+		},
+		{
+			name:     "fast handler",
+			answers:  []answer{{0, http.StatusOK}},
+			timeout:  100 * time.Millisecond,
+			attempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				a := tt.answers[min(int(attempts.Add(1)), len(tt.answers))-1]
+				select {
+				case <-time.After(a.delay):
+				case <-r.Context().Done():
+					return
+				}
+				w.WriteHeader(a.status)
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL, nil, nil)
+			c.SetTimeout(tt.timeout)
+			err := c.do(context.Background(), http.MethodGet, "/x", nil, nil, false)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("do: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("attempts = %d, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestSetTimeoutDefault(t *testing.T) {
+	c := NewClient("https://api.example", nil, nil)
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		c.SetTimeout(timeout)
+		if c.timeout != DefaultTimeout {
+			t.Errorf("SetTimeout(%s): timeout = %s, want %s", timeout, c.timeout, DefaultTimeout)
+		}
+	}
+}
+
+func TestTimeoutOption(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string // request_timeout
+		option  time.Duration
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default", want: DefaultTimeout},
+		{name: "request_timeout", config: "10s", want: 10 * time.Second},
+		{name: "--timeout", option: 5 * time.Second, want: 5 * time.Second},
+		{name: "--timeout over request_timeout", config: "10s", option: 5 * time.Second, want: 5 * time.Second},
+		{name: "invalid request_timeout", config: "soon", option: 5 * time.Second, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClientFromConfig(&config.Config{ApiBase: "https://api.example", RequestTimeout: tt.config}, Options{Timeout: tt.option})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c.timeout != tt.want {
+				t.Errorf("timeout = %s, want %s", c.timeout, tt.want)
+			}
+		})
+	}
+}
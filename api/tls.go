@@ -0,0 +1,128 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+// cipherSuites are the TLS 1.2 suites offered to the backend: ECDHE key
+// exchange with AEAD ciphers only. TLS 1.3 suites aren't configurable and
+// are all acceptable.
+var cipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// curvePreferences lists key exchange groups in order of preference, starting
+// with the post-quantum hybrid.
+var curvePreferences = []tls.CurveID{
+	tls.X25519MLKEM768,
+	tls.X25519,
+	tls.CurveP256,
+	tls.CurveP384,
+}
+
+// minTLSVersion parses min_version: "1.2" (the default) or "1.3".
+func minTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("invalid tls.min_version %q: must be 1.2 or 1.3", v)
+}
+
+// newTLSConfig builds the TLS settings for the API connection from config.
+// Whatever is configured, it requires at least TLS 1.2 with the suites and
+// curves above.
+func newTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CipherSuites:     cipherSuites,
+		CurvePreferences: curvePreferences,
+	}
+	if cfg == nil {
+		return tlsConfig, nil
+	}
+
+	minVersion, err := minTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.MinVersion = minVersion
+
+	if cfg.ServerName != "" {
+		if strings.ContainsAny(cfg.ServerName, "/: ") {
+			return nil, fmt.Errorf("invalid tls.server_name %q: must be a bare hostname", cfg.ServerName)
+		}
+		tlsConfig.ServerName = cfg.ServerName
+	}
+
+	if cfg.CABundlePath != "" {
+		pem, err := os.ReadFile(cfg.CABundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("read ca_bundle_path: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_bundle_path %s: no certificates found", cfg.CABundlePath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCertPath != "" || cfg.ClientKeyPath != "" {
+		if cfg.ClientCertPath == "" || cfg.ClientKeyPath == "" {
+			return nil, fmt.Errorf("client_cert_path and client_key_path must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertPath, cfg.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(cfg.PinnedSPKISHA256) > 0 {
+		pins := map[string]bool{}
+		for _, pin := range cfg.PinnedSPKISHA256 {
+			b, err := base64.StdEncoding.DecodeString(pin)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("invalid pinned_spki_sha256 %q: must be a base64 SHA-256 hash", pin)
+			}
+			pins[string(b)] = true
+		}
+		// Pinning is on top of the usual chain and hostname verification: a
+		// verified chain must also contain a pinned public key.
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(cs.VerifiedChains, pins)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyPins checks that one of the verified chains has a certificate (leaf,
+// intermediate or root) whose SPKI hash is pinned.
+func verifyPins(chains [][]*x509.Certificate, pins map[string]bool) error {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if pins[string(sum[:])] {
+				return nil
+			}
+		}
+	}
+	return errors.New("server certificate does not match any pinned public key")
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+)
+
+func spkiPin(c *testcerts.Cert) string {
+	sum := sha256.Sum256(c.Cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// get makes a request to srv with the TLS settings from cfg.
+func get(t *testing.T, srv *httptest.Server, cfg *config.TLSConfig) error {
+	t.Helper()
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("newTLSConfig: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func newTLSServer(t *testing.T, cert *testcerts.Cert, minVersion, maxVersion uint16) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert.TLS()},
+		MinVersion:   minVersion,
+		MaxVersion:   maxVersion,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPinnedSPKI(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	other := testcerts.NewCA(t, "other")
+	srv := newTLSServer(t, leaf, 0, 0)
+	bundle := ca.WritePEM(t)
+
+	tests := []struct {
+		name    string
+		cfg     *config.TLSConfig
+		wantErr bool
+	}{
+		{"trusted, no pins", &config.TLSConfig{CABundlePath: bundle}, false},
+		{"leaf pinned", &config.TLSConfig{CABundlePath: bundle, PinnedSPKISHA256: []string{spkiPin(leaf)}}, false},
+		{"root pinned", &config.TLSConfig{CABundlePath: bundle, PinnedSPKISHA256: []string{spkiPin(ca)}}, false},
+		{"other key pinned", &config.TLSConfig{CABundlePath: bundle, PinnedSPKISHA256: []string{spkiPin(other)}}, true},
+		// A pin doesn't make an untrusted chain acceptable.
+		{"pinned but untrusted", &config.TLSConfig{PinnedSPKISHA256: []string{spkiPin(leaf)}}, true},
+		// Nor does it skip the hostname check.
+		{"pinned, wrong server_name", &config.TLSConfig{CABundlePath: bundle, ServerName: "wrong.example", PinnedSPKISHA256: []string{spkiPin(leaf)}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := get(t, srv, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInvalidPin(t *testing.T) {
+	if _, err := newTLSConfig(&config.TLSConfig{PinnedSPKISHA256: []string{"not-a-hash"}}); err == nil {
+		t.Fatal("newTLSConfig accepted an invalid pin")
+	}
+}
+
+func TestMinVersion(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	bundle := ca.WritePEM(t)
+
+	tests := []struct {
+		name       string
+		serverMax  uint16 // the newest version the server speaks
+		minVersion string // tls.min_version
+		wantErr    bool
+	}{
+		{"TLS 1.0", tls.VersionTLS10, "", true},
+		{"TLS 1.1", tls.VersionTLS11, "", true},
+		{"TLS 1.2", tls.VersionTLS12, "", false},
+		{"TLS 1.3", tls.VersionTLS13, "", false},
+		{"TLS 1.2, min 1.3", tls.VersionTLS12, "1.3", true},
+		{"TLS 1.3, min 1.3", tls.VersionTLS13, "1.3", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTLSServer(t, leaf, tls.VersionTLS10, tt.serverMax)
+			err := get(t, srv, &config.TLSConfig{CABundlePath: bundle, MinVersion: tt.minVersion})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerName(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	// Only valid for the load balancer's name, not the IP it's dialled at.
+	lb := ca.Issue(t, testcerts.Options{CommonName: "lb", DNSNames: []string{"api.internal.example"}})
+	bundle := ca.WritePEM(t)
+
+	var sni string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{lb.TLS()},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni = hello.ServerName
+			return nil, nil
+		},
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name       string
+		serverName string
+		wantErr    string // substring of the error, if any
+	}{
+		{name: "dial host", wantErr: "127.0.0.1"},
+		{name: "server_name", serverName: "api.internal.example"},
+		{name: "server_name not in the certificate", serverName: "api.other.example", wantErr: "api.other.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sni = ""
+			err := get(t, srv, &config.TLSConfig{CABundlePath: bundle, ServerName: tt.serverName})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				if sni != tt.serverName {
+					t.Errorf("SNI = %q, want %q", sni, tt.serverName)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one naming %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInvalidServerName(t *testing.T) {
+	for _, name := range []string{"https://api.example", "api.example:443", "api example"} {
+		if _, err := newTLSConfig(&config.TLSConfig{ServerName: name}); err == nil {
+			t.Errorf("newTLSConfig accepted server_name %q", name)
+		}
+	}
+}
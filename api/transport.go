@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+// DefaultIdleConnTimeout is how long an idle keep-alive connection to the
+// backend is kept, unless idle_conn_timeout says otherwise. It should outlast
+// the poll interval, or every poll pays for a new TLS handshake.
+const DefaultIdleConnTimeout = 90 * time.Second
+
+// The agent talks to a single backend host, so a few idle connections suffice.
+const (
+	maxIdleConns        = 4
+	maxIdleConnsPerHost = 2
+)
+
+var (
+	transportMu  sync.Mutex
+	transportKey string
+	transport    *http.Transport
+)
+
+// sharedTransport returns the transport for cfg's connection settings. The
+// agent builds a Client per operation, so the transport is kept across calls
+// and only rebuilt when the settings change; that way keep-alive connections
+// and HTTP/2 streams are reused instead of renegotiating TLS on every poll.
+func sharedTransport(cfg *config.Config) (*http.Transport, error) {
+	var settings struct {
+		ProxyURL        string
+		TLS             *config.TLSConfig
+		IdleConnTimeout string
+	}
+	if cfg != nil {
+		settings.ProxyURL, settings.TLS, settings.IdleConnTimeout = cfg.ProxyURL, cfg.TLS, cfg.IdleConnTimeout
+	}
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	key := string(b)
+
+	transportMu.Lock()
+	defer transportMu.Unlock()
+
+	if transport != nil && transportKey == key {
+		return transport, nil
+	}
+	t, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		transport.CloseIdleConnections()
+	}
+	transport, transportKey = t, key
+	return t, nil
+}
+
+// ResetTransport drops the shared transport, so the next Client re-reads CA
+// bundles and client certificates even if their paths are unchanged (e.g.
+// after a SIGHUP config reload).
+func ResetTransport() {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	if transport != nil {
+		transport.CloseIdleConnections()
+	}
+	transport, transportKey = nil, ""
+}
+
+// newTransport builds a transport for cfg with keep-alives and HTTP/2.
+//
+// Proxies are taken from HTTP_PROXY/HTTPS_PROXY/NO_PROXY, unless cfg.ProxyURL
+// is set, in which case it is used for all requests regardless of the environment.
+func newTransport(cfg *config.Config) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	// A custom TLSClientConfig would otherwise turn HTTP/2 off.
+	t.ForceAttemptHTTP2 = true
+	t.MaxIdleConns = maxIdleConns
+	t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	t.IdleConnTimeout = DefaultIdleConnTimeout
+
+	if cfg == nil {
+		return t, nil
+	}
+
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy_url: %w", err)
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy_url %q: scheme and host are required", cfg.ProxyURL)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+
+	if cfg.IdleConnTimeout != "" {
+		idle, err := time.ParseDuration(cfg.IdleConnTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("parse idle_conn_timeout: %w", err)
+		}
+		t.IdleConnTimeout = idle
+	}
+
+	return t, nil
+}
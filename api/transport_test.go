@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+)
+
+func TestConnectionReuse(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	bundle := ca.WritePEM(t)
+
+	tests := []struct {
+		name      string
+		idle      []string // idle_conn_timeout of the client for each call
+		wantConns int64
+	}{
+		{name: "one call", idle: []string{""}, wantConns: 1},
+		{name: "same settings", idle: []string{"", "", ""}, wantConns: 1},
+		{name: "settings changed", idle: []string{"", "", "30s", "30s"}, wantConns: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetShared(t)
+			ResetTransport()
+			t.Cleanup(ResetTransport)
+
+			var conns atomic.Int64
+			var protos []string
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				protos = append(protos, r.Proto)
+				w.Write([]byte(`{"agent_id":"agent-1"}`))
+			}))
+			srv.EnableHTTP2 = true
+			srv.TLS = &tls.Config{Certificates: []tls.Certificate{leaf.TLS()}}
+			srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			srv.StartTLS()
+			defer srv.Close()
+
+			// A new Client per call, as the agent builds one per operation.
+			for _, idle := range tt.idle {
+				c, err := NewClientFromConfig(&config.Config{
+					ApiBase:         srv.URL,
+					TLS:             &config.TLSConfig{CABundlePath: bundle},
+					IdleConnTimeout: idle,
+				}, Options{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := c.InstallAgent(context.Background(), InstallRequest{}); err != nil {
+					t.Fatalf("InstallAgent: %v", err)
+				}
+			}
+			if got := conns.Load(); got != tt.wantConns {
+				t.Errorf("opened %d connections, want %d", got, tt.wantConns)
+			}
+			for _, proto := range protos {
+				if proto != "HTTP/2.0" {
+					t.Errorf("request used %s, want HTTP/2.0", proto)
+				}
+			}
+		})
+	}
+}
+
+func TestIdleConnTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		idle    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "default", want: DefaultIdleConnTimeout},
+		{name: "set", idle: "30s", want: 30 * time.Second},
+		{name: "invalid", idle: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := newTransport(&config.Config{IdleConnTimeout: tt.idle})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tr.IdleConnTimeout != tt.want {
+				t.Errorf("IdleConnTimeout = %v, want %v", tr.IdleConnTimeout, tt.want)
+			}
+			if !tr.ForceAttemptHTTP2 {
+				t.Error("ForceAttemptHTTP2 is off")
+			}
+		})
+	}
+}
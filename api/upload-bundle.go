@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// MaxBundleSize caps the diagnostics bundle accepted by UploadBundle.
+const MaxBundleSize = 20 << 20
+
+type uploadBundleRequest struct {
+	Filename string `json:"filename"`
+	Content  []byte `json:"content"` // base64 in JSON
+}
+
+// UploadBundleResponse identifies an uploaded bundle for a support ticket.
+type UploadBundleResponse struct {
+	BundleID string `json:"bundle_id"`
+}
+
+// UploadBundle sends a diagnostics bundle (see the bundle command) to the backend.
+func (c *Client) UploadBundle(ctx context.Context, filename string, content []byte) (*UploadBundleResponse, error) {
+	if len(content) > MaxBundleSize {
+		return nil, fmt.Errorf("upload bundle: %d bytes exceeds the %d byte limit", len(content), MaxBundleSize)
+	}
+	var resp UploadBundleResponse
+	req := uploadBundleRequest{Filename: filename, Content: content}
+	if err := c.do(ctx, http.MethodPost, c.endpoint("/support-bundle"), req, &resp, true); err != nil {
+		return nil, fmt.Errorf("upload bundle: %w", err)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+func TestWipeKey(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookup := func(string) (ed25519.PublicKey, error) { return pub, nil }
+		if _, err := auth.VerifyRequest(r, lookup, time.Now(), auth.VerifyOptions{MaxAge: auth.DefaultMaxAge}); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, nil, &auth.KeySigner{AgentID: "agent-1", Key: priv})
+	if err := c.ReportEvents(context.Background(), []Event{{Type: EventReconcileSucceeded}}); err != nil {
+		t.Fatalf("signed request before wiping: %v", err)
+	}
+
+	c.WipeKey()
+	for i, b := range priv {
+		if b != 0 {
+			t.Fatalf("key byte %d = %#x after WipeKey, want 0", i, b)
+		}
+	}
+	if err := c.ReportEvents(context.Background(), []Event{{Type: EventReconcileSucceeded}}); err == nil {
+		t.Fatal("request signed with the wiped key verified")
+	}
+}
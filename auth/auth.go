@@ -2,16 +2,25 @@ package auth
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -35,6 +44,77 @@ func ComputeBodySHA256Base64url(req *http.Request) (string, error) {
 	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
 }
 
+// StreamingHashThreshold is the body size above which SignRequest spools the
+// body to a temp file while hashing instead of reading it into memory.
+const StreamingHashThreshold = 1 << 20
+
+// ComputeBodySHA256Base64urlStreaming is like ComputeBodySHA256Base64url but
+// hashes the body while copying it to a temp file, so large bodies are never
+// held in memory. The request body (and GetBody) are replaced with readers over
+// that file so the request can still be sent and replayed on redirect.
+//
+// The file is closed and removed once the request's context is done, or, for
+// a context that never is, once the body is closed, in which case the request
+// can't be replayed.
+func ComputeBodySHA256Base64urlStreaming(req *http.Request) (_ string, err error) {
+	if req.Body == nil {
+		sum := sha256.Sum256(nil)
+		return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+	}
+	defer req.Body.Close()
+
+	f, err := os.CreateTemp("", "certkit-agent-body-*")
+	if err != nil {
+		return "", fmt.Errorf("create body spool file: %w", err)
+	}
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		})
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+	// Unlink right away where that's allowed; the open handle keeps the data
+	// alive until it's closed. (On Windows the remove fails until release.)
+	_ = os.Remove(f.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), req.Body)
+	if err != nil {
+		return "", fmt.Errorf("read request body: %w", err)
+	}
+
+	req.ContentLength = n
+	if ctx := req.Context(); ctx.Done() != nil {
+		context.AfterFunc(ctx, release)
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(f, 0, n)), nil
+		}
+		req.Body, _ = req.GetBody()
+	} else {
+		req.GetBody = nil
+		req.Body = &spoolBody{Reader: io.NewSectionReader(f, 0, n), release: release}
+	}
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// spoolBody reads a spooled request body, releasing the spool file on Close.
+type spoolBody struct {
+	io.Reader
+	release func()
+}
+
+func (b *spoolBody) Close() error {
+	b.release()
+	return nil
+}
+
 // canonicalPathAndQuery returns a stable string of "path?query".
 // We intentionally do NOT re-encode or sort query parameters; we use the request as built.
 // That means the signer and verifier must both use the exact URL as sent.
@@ -53,29 +133,94 @@ func canonicalPathAndQuery(u *url.URL) string {
 }
 
 // canonicalHost returns the host to sign. If Host header is empty, uses URL host.
+//
+// The rule, which the verifier must apply identically:
+//   - the host is lowercased
+//   - IPv6 literals are bracketed and in compressed form ("[::1]")
+//   - the port is kept only if it isn't the scheme default (443 for https, 80 for http)
+//
+// The scheme comes from the URL; if that's empty (server side), https is assumed
+// for TLS connections and http otherwise.
 func canonicalHost(req *http.Request) string {
 	h := strings.TrimSpace(req.Host)
-	if h != "" {
-		return strings.ToLower(h)
+	if h == "" && req.URL != nil {
+		h = req.URL.Host
 	}
+	if h == "" {
+		return ""
+	}
+
+	scheme := ""
 	if req.URL != nil {
-		return strings.ToLower(req.URL.Host)
+		scheme = strings.ToLower(req.URL.Scheme)
+	}
+	if scheme == "" {
+		scheme = "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+	}
+
+	return canonicalHostPort(h, scheme)
+}
+
+func canonicalHostPort(hostport, scheme string) string {
+	host, port := hostport, ""
+	if hh, pp, err := net.SplitHostPort(hostport); err == nil {
+		host, port = hh, pp
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+
+	if addr, err := netip.ParseAddr(host); err == nil && addr.Is6() && !addr.Is4In6() {
+		host = "[" + addr.String() + "]"
+	}
+
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		port = ""
 	}
-	return ""
+	if port != "" {
+		return host + ":" + port
+	}
+	return host
 }
 
+// baseSignedComponents are always signed, in this order.
+var baseSignedComponents = []string{"method", "path", "host", "ts", "body_sha256"}
+
 // buildSigningString is the exact string that gets signed.
 // Keep this stable across client/server.
-func buildSigningString(method, pathQuery, host string, ts int64, bodyHash string) string {
+//
+// Any extra signed headers follow the base components, in the order given,
+// as "<lowercased header name>: <values joined by ", ">".
+func buildSigningString(method, pathQuery, host string, ts int64, bodyHash string, headers []string, h http.Header) string {
 	// Newline-delimited "key: value" format.
 	// Avoid trailing spaces. Always use upper method and lower host.
-	return strings.Join([]string{
+	lines := []string{
 		"method: " + strings.ToUpper(method),
 		"path: " + pathQuery,
 		"host: " + strings.ToLower(host),
 		"ts: " + strconv.FormatInt(ts, 10),
 		"body_sha256: " + bodyHash,
-	}, "\n")
+	}
+	for _, name := range headers {
+		name = strings.ToLower(name)
+		lines = append(lines, name+": "+strings.TrimSpace(strings.Join(h.Values(name), ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validateSignedHeaders checks extra header names are usable in the signed set.
+func validateSignedHeaders(headers []string) error {
+	for _, name := range headers {
+		lower := strings.ToLower(name)
+		if name == "" || strings.ContainsAny(name, " \t\"=:,") {
+			return fmt.Errorf("invalid signed header name %q", name)
+		}
+		if lower == "authorization" || slices.Contains(baseSignedComponents, lower) {
+			return fmt.Errorf("header %q cannot be in the signed set", name)
+		}
+	}
+	return nil
 }
 
 // SignRequest signs the request and sets headers.
@@ -87,11 +232,32 @@ func buildSigningString(method, pathQuery, host string, ts int64, bodyHash strin
 //
 // agentID should be your server-issued ID for this agent.
 func SignRequest(req *http.Request, agentID string, priv ed25519.PrivateKey, now time.Time) error {
+	return SignRequestWithHeaders(req, agentID, priv, now, nil)
+}
+
+// SignRequestWithHeaders is SignRequest, additionally binding the named request
+// headers into the signature. The full signed set is advertised in the
+// Authorization header's signed="..." field so the verifier can rebuild it.
+// The X-Agent-* headers are set before signing, so they can be included too.
+func SignRequestWithHeaders(req *http.Request, agentID string, priv ed25519.PrivateKey, now time.Time, signedHeaders []string) error {
+	if len(priv) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid ed25519 private key length: got %d", len(priv))
+	}
+	return SignRequestWithKey(req, agentID, priv, now, signedHeaders)
+}
+
+// SignRequestWithKey is SignRequestWithHeaders for an ed25519 key held
+// behind a crypto.Signer, such as one in a PKCS#11 token (see OpenPKCS11),
+// so the key bytes never have to be in memory.
+func SignRequestWithKey(req *http.Request, agentID string, key crypto.Signer, now time.Time, signedHeaders []string) error {
 	if req == nil {
 		return fmt.Errorf("req is nil")
 	}
-	if len(priv) != ed25519.PrivateKeySize {
-		return fmt.Errorf("invalid ed25519 private key length: got %d", len(priv))
+	if key == nil {
+		return fmt.Errorf("signing key is nil")
+	}
+	if _, ok := key.Public().(ed25519.PublicKey); !ok {
+		return fmt.Errorf("signing key is %T, not ed25519", key.Public())
 	}
 	if agentID == "" {
 		return fmt.Errorf("agentID is required")
@@ -99,11 +265,18 @@ func SignRequest(req *http.Request, agentID string, priv ed25519.PrivateKey, now
 	if req.URL == nil {
 		return fmt.Errorf("req.URL is nil")
 	}
+	if err := validateSignedHeaders(signedHeaders); err != nil {
+		return err
+	}
 
 	// Timestamp (unix seconds)
 	ts := now.UTC().Unix()
 
-	bodyHash, err := ComputeBodySHA256Base64url(req)
+	hashBody := ComputeBodySHA256Base64url
+	if req.ContentLength < 0 || req.ContentLength > StreamingHashThreshold {
+		hashBody = ComputeBodySHA256Base64urlStreaming
+	}
+	bodyHash, err := hashBody(req)
 	if err != nil {
 		return err
 	}
@@ -114,20 +287,29 @@ func SignRequest(req *http.Request, agentID string, priv ed25519.PrivateKey, now
 		return fmt.Errorf("missing host (req.Host and req.URL.Host both empty)")
 	}
 
-	signingString := buildSigningString(req.Method, pathQuery, host, ts, bodyHash)
-	sig := ed25519.Sign(priv, []byte(signingString))
-	sigB64 := base64.RawURLEncoding.EncodeToString(sig)
-
 	// Attach headers
 	req.Header.Set("X-Agent-Id", agentID)
 	req.Header.Set("X-Agent-Timestamp", strconv.FormatInt(ts, 10))
 	req.Header.Set("X-Agent-Content-SHA256", bodyHash)
 
+	signingString := buildSigningString(req.Method, pathQuery, host, ts, bodyHash, signedHeaders, req.Header)
+	slog.Debug("signing request", "signing_string", signingString)
+	sig, err := key.Sign(rand.Reader, []byte(signingString), crypto.Hash(0))
+	if err != nil {
+		return fmt.Errorf("sign request: %w", err)
+	}
+	sigB64 := base64.RawURLEncoding.EncodeToString(sig)
+
+	signed := slices.Clone(baseSignedComponents)
+	for _, name := range signedHeaders {
+		signed = append(signed, strings.ToLower(name))
+	}
+
 	// Include what we signed to help debugging/forward compatibility
 	req.Header.Set("Authorization",
 		fmt.Sprintf(
-			`AgentSig keyId="%s", alg="ed25519", sig="%s", signed="method path host ts body_sha256"`,
-			agentID, sigB64,
+			`AgentSig keyId="%s", alg="ed25519", sig="%s", signed="%s"`,
+			agentID, sigB64, strings.Join(signed, " "),
 		),
 	)
 
@@ -137,8 +319,8 @@ func SignRequest(req *http.Request, agentID string, priv ed25519.PrivateKey, now
 // KeyPair represents an Ed25519 keypair in encoded form,
 // suitable for storage in config files.
 type KeyPair struct {
-	PublicKey  string `json:"public_key"`  // base64url encoded (32 bytes)
-	PrivateKey string `json:"private_key"` // base64url encoded (64 bytes)
+	PublicKey  string `json:"public_key" yaml:"public_key"`   // base64url encoded (32 bytes)
+	PrivateKey string `json:"private_key" yaml:"private_key"` // base64url encoded (64 bytes)
 }
 
 // CreateNewKeyPair generates a new Ed25519 keypair.
@@ -157,6 +339,42 @@ func CreateNewKeyPair() (*KeyPair, error) {
 	}, nil
 }
 
+// ErrKeyPairMismatch means a keypair's public key isn't the public half of its private key.
+var ErrKeyPairMismatch = errors.New("public key does not match private key")
+
+// Validate decodes both keys and checks that the public key is the one derived
+// from the private key. Requests signed with a mismatched pair fail
+// verification on the backend.
+func (kp *KeyPair) Validate() error {
+	pub, err := DecodePublicKey(kp.PublicKey)
+	if err != nil {
+		return err
+	}
+	priv, err := DecodePrivateKey(kp.PrivateKey)
+	if err != nil {
+		return err
+	}
+	if !pub.Equal(priv.Public()) {
+		return ErrKeyPairMismatch
+	}
+	return nil
+}
+
+// Fingerprint returns the SHA-256 of a base64url-encoded public key as
+// colon-separated uppercase hex, for humans to compare.
+func Fingerprint(encodedPublicKey string) (string, error) {
+	pub, err := DecodePublicKey(encodedPublicKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(pub)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":"), nil
+}
+
 func DecodePrivateKey(encoded string) (ed25519.PrivateKey, error) {
 	b, err := base64.RawURLEncoding.DecodeString(encoded)
 	if err != nil {
@@ -178,3 +396,24 @@ func DecodePublicKey(encoded string) (ed25519.PublicKey, error) {
 	}
 	return ed25519.PublicKey(b), nil
 }
+
+// KeySigner signs requests with an agent's ed25519 key: an ed25519.PrivateKey
+// decoded from the config, or a hardware-backed key (see OpenPKCS11).
+type KeySigner struct {
+	AgentID       string
+	Key           crypto.Signer
+	SignedHeaders []string // extra headers to bind into the signature
+}
+
+// Wipe overwrites an in-memory key with zeros, after which signatures s makes
+// don't verify. A hardware-backed key is left alone.
+func (s *KeySigner) Wipe() {
+	if key, ok := s.Key.(ed25519.PrivateKey); ok {
+		clear(key)
+	}
+}
+
+// SignRequest signs req as of the current time.
+func (s *KeySigner) SignRequest(req *http.Request) error {
+	return SignRequestWithKey(req, s.AgentID, s.Key, time.Now(), s.SignedHeaders)
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// openFiles returns how many files the process has open, skipping the test
+// where that can't be told.
+func openFiles(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("can't count open files:", err)
+	}
+	return len(entries)
+}
+
+// waitForOpenFiles waits briefly for the open file count to drop to want, as
+// the spool is released asynchronously when a context is done.
+func waitForOpenFiles(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for openFiles(t) > want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := openFiles(t); got > want {
+		t.Fatalf("%d files open, want %d: spool file not closed", got, want)
+	}
+}
+
+func TestStreamingBodyHash(t *testing.T) {
+	body := make([]byte, 3<<20+7)
+	rand.Read(body)
+	want := sha256.Sum256(body)
+
+	tests := []struct {
+		name   string
+		ctx    func() (context.Context, context.CancelFunc)
+		replay bool
+	}{
+		{"cancellable context", func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, true},
+		{"background context", func() (context.Context, context.CancelFunc) { return context.Background(), func() {} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := openFiles(t)
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://example.com/x", io.NopCloser(bytes.NewReader(body)))
+			req.ContentLength = -1
+
+			got, err := ComputeBodySHA256Base64urlStreaming(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != base64.RawURLEncoding.EncodeToString(want[:]) {
+				t.Fatal("wrong hash")
+			}
+			if req.ContentLength != int64(len(body)) {
+				t.Fatalf("ContentLength = %d", req.ContentLength)
+			}
+			sent, err := io.ReadAll(req.Body)
+			if err != nil || !bytes.Equal(sent, body) {
+				t.Fatalf("body differs from what was hashed (err %v)", err)
+			}
+			req.Body.Close()
+
+			if !tt.replay {
+				if req.GetBody != nil {
+					t.Fatal("replayable though closing the body releases the spool")
+				}
+				waitForOpenFiles(t, before)
+				return
+			}
+			replay, err := req.GetBody()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again, err := io.ReadAll(replay); err != nil || !bytes.Equal(again, body) {
+				t.Fatalf("replayed body differs (err %v)", err)
+			}
+			cancel()
+			waitForOpenFiles(t, before)
+		})
+	}
+}
+
+type failingBody struct{ closed bool }
+
+func (b *failingBody) Read([]byte) (int, error) { return 0, errors.New("disk on fire") }
+func (b *failingBody) Close() error             { b.closed = true; return nil }
+
+func TestStreamingBodyHashReadError(t *testing.T) {
+	before := openFiles(t)
+	body := &failingBody{}
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/x", body)
+	if _, err := ComputeBodySHA256Base64urlStreaming(req); err == nil {
+		t.Fatal("read error not returned")
+	}
+	if !body.closed {
+		t.Error("request body not closed")
+	}
+	waitForOpenFiles(t, before)
+}
+
+func TestCanonicalHost(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string // as the client sends it
+		host string // Host header, as the server sees it; "" for none
+		tls  bool
+		want string
+	}{
+		{"hostname", "https://API.Example.com/x", "", false, "api.example.com"},
+		{"hostname default port", "https://api.example.com:443/x", "", false, "api.example.com"},
+		{"hostname explicit port", "https://api.example.com:8443/x", "", false, "api.example.com:8443"},
+		{"http default port", "http://api.example.com:80/x", "", false, "api.example.com"},
+		{"https port on http", "http://api.example.com:443/x", "", false, "api.example.com:443"},
+		{"ipv4", "https://192.0.2.1/x", "", false, "192.0.2.1"},
+		{"ipv4 explicit port", "https://192.0.2.1:8443/x", "", false, "192.0.2.1:8443"},
+		{"ipv6", "https://[2001:DB8:0:0::1]/x", "", false, "[2001:db8::1]"},
+		{"ipv6 default port", "https://[2001:db8::1]:443/x", "", false, "[2001:db8::1]"},
+		{"ipv6 explicit port", "https://[2001:db8::1]:8443/x", "", false, "[2001:db8::1]:8443"},
+		{"ipv6 loopback", "http://[::1]:80/x", "", false, "[::1]"},
+		// Server side: no scheme in the URL, so it comes from the connection.
+		{"server tls", "/x", "api.example.com:443", true, "api.example.com"},
+		{"server plain", "/x", "api.example.com:80", false, "api.example.com"},
+		{"server plain https port", "/x", "api.example.com:443", false, "api.example.com:443"},
+		{"server ipv6", "/x", "[2001:db8:0::1]:443", true, "[2001:db8::1]"},
+		{"host header wins", "https://10.0.0.1/x", "API.example.com", false, "api.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = tt.host
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if got := canonicalHost(req); got != tt.want {
+				t.Errorf("canonicalHost = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCanonicalHostSignVerify checks that the host the client signs is the
+// one the server rebuilds, however the two spell it.
+func TestCanonicalHostSignVerify(t *testing.T) {
+	tests := []struct {
+		name       string
+		clientURL  string
+		serverHost string
+		tls        bool
+	}{
+		{"default port dropped by client", "https://api.example.com:443/x", "api.example.com", true},
+		{"default port sent by proxy", "https://api.example.com/x", "api.example.com:443", true},
+		{"explicit port", "https://api.example.com:8443/x", "api.example.com:8443", true},
+		{"ipv6 spelling", "https://[2001:db8:0:0::1]:8443/x", "[2001:DB8::1]:8443", true},
+		{"plain http", "http://10.0.0.1:80/x", "10.0.0.1", false},
+	}
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	lookup := func(string) (ed25519.PublicKey, error) { return pub, nil }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			client, _ := http.NewRequest(http.MethodGet, tt.clientURL, nil)
+			if err := SignRequest(client, "agent-1", priv, now); err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+
+			server, _ := http.NewRequest(http.MethodGet, "/x", nil)
+			server.Host = tt.serverHost
+			server.Header = client.Header.Clone()
+			if tt.tls {
+				server.TLS = &tls.ConnectionState{}
+			}
+			if _, err := VerifyRequest(server, lookup, now, VerifyOptions{MaxAge: DefaultMaxAge}); err != nil {
+				t.Errorf("verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyPairValidate(t *testing.T) {
+	a, err := CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		kp       KeyPair
+		wantErr  bool
+		mismatch bool
+	}{
+		{name: "matching", kp: *a},
+		{name: "mismatched", kp: KeyPair{PublicKey: b.PublicKey, PrivateKey: a.PrivateKey}, wantErr: true, mismatch: true},
+		{name: "corrupt public key", kp: KeyPair{PublicKey: "not-a-key", PrivateKey: a.PrivateKey}, wantErr: true},
+		{name: "corrupt private key", kp: KeyPair{PublicKey: a.PublicKey, PrivateKey: "not-a-key"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.kp.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got := errors.Is(err, ErrKeyPairMismatch); got != tt.mismatch {
+				t.Errorf("errors.Is(err, ErrKeyPairMismatch) = %t, want %t (err %v)", got, tt.mismatch, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package auth
+
+import "errors"
+
+// ErrKeyringKeyNotFound means the kernel keyring has no key under the
+// description, e.g. because the host rebooted since it was stored.
+var ErrKeyringKeyNotFound = errors.New("key not found in the kernel keyring")
+
+// KeyringDescription returns the description the private half of the key
+// with the given public key (base64url) is stored under in the keyring.
+func KeyringDescription(publicKey string) string {
+	return "certkit-agent:" + publicKey
+}
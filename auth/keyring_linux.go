@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Keys live in the user keyring of the user the agent runs as (root), which
+// lasts until reboot, whichever session or service stored them.
+const keySpecUserKeyring = -4
+
+const (
+	keyctlSetperm = 5
+	keyctlUnlink  = 9
+	keyctlSearch  = 10
+	keyctlRead    = 11
+)
+
+// keyPerm lets the owning user read the key even through a session keyring
+// that doesn't link the user keyring, as systemd gives each service: possessor
+// all, user view, read and search.
+const keyPerm = 0x3f000000 | 0x00010000 | 0x00020000 | 0x00080000
+
+// StoreKeyringKey adds priv to the kernel keyring under description,
+// replacing any key already there.
+func StoreKeyringKey(description string, priv ed25519.PrivateKey) error {
+	typ, desc, err := keyNames(description)
+	if err != nil {
+		return err
+	}
+	keyring := int32(keySpecUserKeyring)
+	id, _, errno := syscall.Syscall6(syscall.SYS_ADD_KEY,
+		uintptr(unsafe.Pointer(typ)), uintptr(unsafe.Pointer(desc)),
+		uintptr(unsafe.Pointer(&priv[0])), uintptr(len(priv)), uintptr(keyring), 0)
+	if errno != 0 {
+		return fmt.Errorf("store key in the kernel keyring: %w", errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_KEYCTL, keyctlSetperm, id, keyPerm); errno != 0 {
+		return fmt.Errorf("set kernel keyring key permissions: %w", errno)
+	}
+	return nil
+}
+
+// LoadKeyringKey returns the private key stored under description. It
+// returns ErrKeyringKeyNotFound if there is none.
+func LoadKeyringKey(description string) (ed25519.PrivateKey, error) {
+	id, err := searchKey(description)
+	if err != nil {
+		return nil, err
+	}
+	priv := make(ed25519.PrivateKey, ed25519.PrivateKeySize)
+	n, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlRead, id,
+		uintptr(unsafe.Pointer(&priv[0])), uintptr(len(priv)), 0, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("read key from the kernel keyring: %w", errno)
+	}
+	if n != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("kernel keyring key %s has length %d, not an ed25519 private key", description, n)
+	}
+	return priv, nil
+}
+
+// RemoveKeyringKey unlinks the key stored under description, if any.
+func RemoveKeyringKey(description string) error {
+	id, err := searchKey(description)
+	if errors.Is(err, ErrKeyringKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keyring := int32(keySpecUserKeyring)
+	if _, _, errno := syscall.Syscall(syscall.SYS_KEYCTL, keyctlUnlink, id, uintptr(keyring)); errno != 0 {
+		return fmt.Errorf("remove key from the kernel keyring: %w", errno)
+	}
+	return nil
+}
+
+// searchKey returns the id of the key stored under description.
+func searchKey(description string) (uintptr, error) {
+	typ, desc, err := keyNames(description)
+	if err != nil {
+		return 0, err
+	}
+	keyring := int32(keySpecUserKeyring)
+	id, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlSearch, uintptr(keyring),
+		uintptr(unsafe.Pointer(typ)), uintptr(unsafe.Pointer(desc)), 0, 0)
+	switch {
+	case errno == syscall.ENOKEY || errno == syscall.EKEYEXPIRED || errno == syscall.EKEYREVOKED:
+		return 0, ErrKeyringKeyNotFound
+	case errno != 0:
+		return 0, fmt.Errorf("search the kernel keyring: %w", errno)
+	}
+	return id, nil
+}
+
+// keyNames returns the key type ("user", for arbitrary data) and description
+// as C strings.
+func keyNames(description string) (typ, desc *byte, err error) {
+	if typ, err = syscall.BytePtrFromString("user"); err != nil {
+		return nil, nil, err
+	}
+	if desc, err = syscall.BytePtrFromString(description); err != nil {
+		return nil, nil, err
+	}
+	return typ, desc, nil
+}
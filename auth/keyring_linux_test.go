@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"syscall"
+	"testing"
+)
+
+// skipWithoutKeyring skips the test where the sandbox denies keyctl, as
+// container runtimes' seccomp profiles commonly do.
+func skipWithoutKeyring(t *testing.T, err error) {
+	t.Helper()
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.ENOSYS) {
+		t.Skipf("kernel keyring unavailable: %v", err)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	desc := KeyringDescription("test-" + t.Name())
+
+	if err := StoreKeyringKey(desc, priv); err != nil {
+		skipWithoutKeyring(t, err)
+		t.Fatalf("store: %v", err)
+	}
+	t.Cleanup(func() { RemoveKeyringKey(desc) })
+
+	got, err := LoadKeyringKey(desc)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !priv.Equal(got) {
+		t.Fatal("loaded key differs from the stored one")
+	}
+
+	// Storing again replaces the key.
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	if err := StoreKeyringKey(desc, other); err != nil {
+		t.Fatalf("store again: %v", err)
+	}
+	if got, err := LoadKeyringKey(desc); err != nil || !other.Equal(got) {
+		t.Fatalf("after replacing: key replaced %v, err %v", err == nil && other.Equal(got), err)
+	}
+
+	if err := RemoveKeyringKey(desc); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := LoadKeyringKey(desc); !errors.Is(err, ErrKeyringKeyNotFound) {
+		t.Fatalf("load after remove: %v, want ErrKeyringKeyNotFound", err)
+	}
+	if err := RemoveKeyringKey(desc); err != nil {
+		t.Fatalf("remove of a missing key: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package auth
+
+import (
+	"crypto/ed25519"
+	"errors"
+)
+
+var errNoKeyring = errors.New("the kernel keyring is only available on Linux")
+
+func StoreKeyringKey(description string, priv ed25519.PrivateKey) error {
+	return errNoKeyring
+}
+
+func LoadKeyringKey(description string) (ed25519.PrivateKey, error) {
+	return nil, errNoKeyring
+}
+
+func RemoveKeyringKey(description string) error {
+	return errNoKeyring
+}
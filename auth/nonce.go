@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// NonceCache remembers recently seen nonces so a verifier can reject replays.
+// Entries older than maxAge are forgotten; at most maxEntries are kept.
+// It is safe for concurrent use.
+//
+// Pruning walks every entry, so it runs once per batch of inserts rather than
+// on each: when the cache fills up, and otherwise at most once per maxAge.
+type NonceCache struct {
+	mu         sync.Mutex
+	maxAge     time.Duration
+	maxEntries int
+	entries    map[string]time.Time
+	nextPrune  time.Time
+	now        func() time.Time
+}
+
+// NewNonceCache returns a cache that remembers nonces for maxAge.
+// maxAge should match the signature timestamp window, since anything older
+// is rejected on its timestamp anyway.
+func NewNonceCache(maxAge time.Duration, maxEntries int) *NonceCache {
+	return &NonceCache{
+		maxAge:     maxAge,
+		maxEntries: maxEntries,
+		entries:    make(map[string]time.Time),
+		now:        time.Now,
+	}
+}
+
+// Seen records nonce and reports whether it had already been seen within maxAge.
+func (c *NonceCache) Seen(nonce string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if t, ok := c.entries[nonce]; ok && now.Sub(t) <= c.maxAge {
+		return true
+	}
+
+	full := c.maxEntries > 0 && len(c.entries) >= c.maxEntries
+	if full || !now.Before(c.nextPrune) {
+		c.prune(now)
+	}
+	c.entries[nonce] = now
+	return false
+}
+
+// Len returns the number of nonces currently remembered, including expired
+// ones not pruned yet.
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// prune drops expired entries. If the cache is still full, it then drops the
+// oldest quarter of it, so the next prune is that many inserts away.
+// Callers must hold c.mu.
+func (c *NonceCache) prune(now time.Time) {
+	c.nextPrune = now.Add(c.maxAge)
+	for nonce, t := range c.entries {
+		if now.Sub(t) > c.maxAge {
+			delete(c.entries, nonce)
+		}
+	}
+	if c.maxEntries <= 0 || len(c.entries) < c.maxEntries {
+		return
+	}
+
+	type entry struct {
+		nonce string
+		at    time.Time
+	}
+	byAge := make([]entry, 0, len(c.entries))
+	for nonce, t := range c.entries {
+		byAge = append(byAge, entry{nonce, t})
+	}
+	slices.SortFunc(byAge, func(a, b entry) int { return a.at.Compare(b.at) })
+	evict := len(c.entries) - c.maxEntries + max(c.maxEntries/4, 1)
+	for _, e := range byAge[:evict] {
+		delete(c.entries, e.nonce)
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for NonceCache.now.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestNonceCache(t *testing.T) {
+	type step struct {
+		advance time.Duration // before Seen
+		nonce   string
+		want    bool
+	}
+	tests := []struct {
+		name       string
+		maxEntries int
+		steps      []step
+		wantLen    int
+	}{
+		{
+			name:       "replay within the window",
+			maxEntries: 10,
+			steps:      []step{{0, "a", false}, {time.Second, "b", false}, {time.Minute, "a", true}},
+			wantLen:    2,
+		},
+		{
+			name:       "expired",
+			maxEntries: 10,
+			steps:      []step{{0, "a", false}, {5*time.Minute + time.Second, "a", false}, {0, "a", true}},
+			wantLen:    1,
+		},
+		{
+			// Filling up evicts the oldest quarter in one go.
+			name:       "full",
+			maxEntries: 4,
+			steps: []step{
+				{0, "a", false}, {time.Second, "b", false}, {time.Second, "c", false}, {time.Second, "d", false},
+				{time.Second, "e", false},
+				{0, "a", false}, // forgotten to make room
+				{0, "e", true},
+				{0, "c", true},
+			},
+			wantLen: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Unix(1700000000, 0)}
+			c := NewNonceCache(5*time.Minute, tt.maxEntries)
+			c.now = clock.now
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				if got := c.Seen(s.nonce); got != s.want {
+					t.Fatalf("step %d: Seen(%q) = %v, want %v", i, s.nonce, got, s.want)
+				}
+			}
+			if got := c.Len(); got != tt.wantLen {
+				t.Errorf("Len = %d, want %d", got, tt.wantLen)
+			}
+		})
+	}
+}
+
+// TestNonceCachePrunesInBatches checks that expired entries are swept, but
+// not on every insert.
+func TestNonceCachePrunesInBatches(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	c := NewNonceCache(time.Minute, 1000)
+	c.now = clock.now
+	c.Seen("first") // sweeps, and schedules the next sweep a minute out
+
+	for i := range 10 {
+		c.Seen(fmt.Sprint("old", i))
+	}
+	clock.advance(2 * time.Minute)
+	c.Seen("new") // sweeps the expired ones
+	if got := c.Len(); got != 1 {
+		t.Fatalf("Len after the sweep = %d, want 1", got)
+	}
+
+	clock.advance(2 * time.Minute)
+	c.Seen("newer") // sweeps "new"
+	clock.advance(30 * time.Second)
+	c.Seen("newest") // too soon to sweep again
+	if got := c.Len(); got != 2 {
+		t.Fatalf("Len = %d, want 2", got)
+	}
+}
+
+func TestNonceCacheConcurrent(t *testing.T) {
+	const workers, perWorker, maxEntries = 8, 500, 1000
+	c := NewNonceCache(time.Minute, maxEntries)
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWorker {
+				nonce := fmt.Sprintf("%d-%d", w, i)
+				if c.Seen(nonce) {
+					t.Errorf("new nonce %s reported as seen", nonce)
+					return
+				}
+				// Too recent to have been evicted yet.
+				if !c.Seen(nonce) {
+					t.Errorf("replayed nonce %s not reported", nonce)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := c.Len(); got > maxEntries {
+		t.Errorf("Len = %d, want at most %d", got, maxEntries)
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"sync"
+)
+
+// PKCS11Config locates the agent's ed25519 key in a PKCS#11 token (an HSM,
+// or a TPM through its PKCS#11 module) instead of the config file. The key
+// never leaves the token; requests are signed by it.
+type PKCS11Config struct {
+	ModulePath string `json:"module_path" yaml:"module_path"` // e.g. /usr/lib/softhsm/libsofthsm2.so
+	Slot       uint   `json:"slot" yaml:"slot"`
+	KeyLabel   string `json:"key_label" yaml:"key_label"` // CKA_LABEL of the private and public key objects
+	// PINRef points to the user PIN: "env:NAME" or "file:/path". Empty skips login.
+	PINRef string `json:"pin_ref,omitempty" yaml:"pin_ref,omitempty"`
+}
+
+var (
+	pkcs11Mu   sync.Mutex
+	pkcs11Keys = map[PKCS11Config]crypto.Signer{}
+)
+
+// OpenPKCS11 returns a signer for the key cfg points to. The agent builds a
+// signer per API client, so the session is opened once per config and reused.
+// Builds without the pkcs11 tag return an error.
+func OpenPKCS11(cfg *PKCS11Config) (crypto.Signer, error) {
+	if cfg.ModulePath == "" || cfg.KeyLabel == "" {
+		return nil, fmt.Errorf("pkcs11: module_path and key_label are required")
+	}
+
+	pkcs11Mu.Lock()
+	defer pkcs11Mu.Unlock()
+
+	if key, ok := pkcs11Keys[*cfg]; ok {
+		return key, nil
+	}
+	key, err := openPKCS11(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11 %s slot %d key %q: %w", cfg.ModulePath, cfg.Slot, cfg.KeyLabel, err)
+	}
+	pkcs11Keys[*cfg] = key
+	return key, nil
+}
+
+// EncodePublicKey returns the base64url form of key's public half, as stored
+// in KeyPair.PublicKey and registered with the backend.
+func EncodePublicKey(key crypto.Signer) (string, error) {
+	pub, ok := key.Public().(ed25519.PublicKey)
+	if !ok {
+		return "", fmt.Errorf("key is %T, not ed25519", key.Public())
+	}
+	return base64.RawURLEncoding.EncodeToString(pub), nil
+}
+
+// decodeEdwardsPoint parses a token's CKA_EC_POINT for an ed25519 key: the
+// 32-byte point, DER-wrapped in an OCTET STRING as the standard says, or raw
+// as some modules return it.
+func decodeEdwardsPoint(b []byte) (ed25519.PublicKey, error) {
+	if len(b) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(b), nil
+	}
+	var point []byte
+	if rest, err := asn1.Unmarshal(b, &point); err != nil || len(rest) > 0 || len(point) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("CKA_EC_POINT is not an ed25519 public key")
+	}
+	return ed25519.PublicKey(point), nil
+}
@@ -0,0 +1,12 @@
+//go:build !pkcs11
+
+package auth
+
+import (
+	"crypto"
+	"errors"
+)
+
+func openPKCS11(cfg *PKCS11Config) (crypto.Signer, error) {
+	return nil, errors.New("this build has no PKCS#11 support (rebuild with -tags pkcs11)")
+}
@@ -0,0 +1,146 @@
+//go:build pkcs11
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+	"github.com/miekg/pkcs11"
+)
+
+// ckmEDDSA is CKM_EDDSA from PKCS#11 3.0, which the bindings predate.
+const ckmEDDSA = 0x00001057
+
+// tokenKey is an ed25519 private key in a PKCS#11 token.
+type tokenKey struct {
+	mu      sync.Mutex // a session handles one operation at a time
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	handle  pkcs11.ObjectHandle
+	pub     ed25519.PublicKey
+}
+
+func (k *tokenKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Sign signs msg on the token. Like ed25519.PrivateKey.Sign it takes the
+// message itself, not a digest.
+func (k *tokenKey) Sign(_ io.Reader, msg []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.Hash(0) {
+		return nil, errors.New("ed25519 signs the message itself, not a digest")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(ckmEDDSA, nil)}, k.handle); err != nil {
+		return nil, fmt.Errorf("pkcs11 sign: %w", err)
+	}
+	sig, err := k.ctx.Sign(k.session, msg)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11 sign: %w", err)
+	}
+	return sig, nil
+}
+
+func openPKCS11(cfg *PKCS11Config) (crypto.Signer, error) {
+	ctx := pkcs11.New(cfg.ModulePath)
+	if ctx == nil {
+		return nil, errors.New("cannot load module")
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+
+	key, err := openTokenKey(ctx, cfg)
+	if err != nil {
+		ctx.Finalize()
+		ctx.Destroy()
+		return nil, err
+	}
+	return key, nil
+}
+
+func openTokenKey(ctx *pkcs11.Ctx, cfg *PKCS11Config) (*tokenKey, error) {
+	session, err := ctx.OpenSession(cfg.Slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("open session: %w", err)
+	}
+	if cfg.PINRef != "" {
+		pin, err := utils.ResolveSecret(cfg.PINRef)
+		if err != nil {
+			return nil, fmt.Errorf("pin_ref: %w", err)
+		}
+		if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			return nil, fmt.Errorf("login: %w", err)
+		}
+	}
+
+	priv, err := findObject(ctx, session, pkcs11.CKO_PRIVATE_KEY, cfg.KeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	pubObj, err := findObject(ctx, session, pkcs11.CKO_PUBLIC_KEY, cfg.KeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := ctx.GetAttributeValue(session, pubObj, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %w", err)
+	}
+	pub, err := decodeEdwardsPoint(attrs[0].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &tokenKey{ctx: ctx, session: session, handle: priv, pub: pub}
+
+	// Catch a public key object that isn't the private key's other half now,
+	// rather than as rejected requests later.
+	probe := make([]byte, 32)
+	if _, err := rand.Read(probe); err != nil {
+		return nil, fmt.Errorf("probe key: %w", err)
+	}
+	sig, err := key.Sign(nil, probe, crypto.Hash(0))
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pub, probe, sig) {
+		return nil, ErrKeyPairMismatch
+	}
+	return key, nil
+}
+
+// findObject returns the single object of class with label.
+func findObject(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, fmt.Errorf("find objects: %w", err)
+	}
+	objs, _, err := ctx.FindObjects(session, 2)
+	ctx.FindObjectsFinal(session)
+	if err != nil {
+		return 0, fmt.Errorf("find objects: %w", err)
+	}
+	kind := "private"
+	if class == pkcs11.CKO_PUBLIC_KEY {
+		kind = "public"
+	}
+	switch len(objs) {
+	case 0:
+		return 0, fmt.Errorf("no %s key labelled %q", kind, label)
+	case 1:
+		return objs[0], nil
+	}
+	return 0, fmt.Errorf("more than one %s key labelled %q", kind, label)
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response signatures let the agent verify that a response came from the
+// backend holding the server key it was given at enrollment, rather than
+// trusting TLS alone. The signature binds the request it answers, down to its
+// X-Request-Id, so a response can't be replayed to another request for the
+// same path.
+//
+//	X-Server-Timestamp: <unix seconds>
+//	X-Server-Content-SHA256: <base64url sha256 of the body>
+//	X-Server-Signature: AgentSig keyId="...", alg="ed25519", sig="...", signed="method path request_id status ts body_sha256"
+const responseSignedComponents = "method path request_id status ts body_sha256"
+
+func buildResponseSigningString(req *http.Request, status int, ts int64, bodyHash string) string {
+	return strings.Join([]string{
+		"method: " + strings.ToUpper(req.Method),
+		"path: " + canonicalPathAndQuery(req.URL),
+		"request_id: " + req.Header.Get("X-Request-Id"),
+		"status: " + strconv.Itoa(status),
+		"ts: " + strconv.FormatInt(ts, 10),
+		"body_sha256: " + bodyHash,
+	}, "\n")
+}
+
+func hashBytesBase64url(b []byte) string {
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// SignResponse sets the X-Server-* headers on h for a response to req.
+// It is the server-side counterpart of VerifyResponse.
+func SignResponse(h http.Header, req *http.Request, status int, body []byte, keyID string, priv ed25519.PrivateKey, now time.Time) error {
+	if len(priv) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid ed25519 private key length: got %d", len(priv))
+	}
+	if keyID == "" {
+		return fmt.Errorf("keyID is required")
+	}
+	if req.Header.Get("X-Request-Id") == "" {
+		return fmt.Errorf("request has no X-Request-Id")
+	}
+
+	ts := now.UTC().Unix()
+	bodyHash := hashBytesBase64url(body)
+	sig := ed25519.Sign(priv, []byte(buildResponseSigningString(req, status, ts, bodyHash)))
+
+	h.Set("X-Server-Timestamp", strconv.FormatInt(ts, 10))
+	h.Set("X-Server-Content-SHA256", bodyHash)
+	h.Set("X-Server-Signature", fmt.Sprintf(
+		`AgentSig keyId="%s", alg="ed25519", sig="%s", signed="%s"`,
+		keyID, base64.RawURLEncoding.EncodeToString(sig), responseSignedComponents,
+	))
+	return nil
+}
+
+// VerifyResponse checks that resp (whose body has already been read into body)
+// was signed by keyID/pub for the request that produced it.
+func VerifyResponse(resp *http.Response, body []byte, keyID string, pub ed25519.PublicKey, now time.Time, maxAge time.Duration) error {
+	if resp.Request == nil {
+		return fmt.Errorf("response has no request to bind to")
+	}
+	if resp.Request.Header.Get("X-Request-Id") == "" {
+		return fmt.Errorf("request has no X-Request-Id to bind the response to")
+	}
+	header := resp.Header.Get("X-Server-Signature")
+	if header == "" {
+		return fmt.Errorf("%w: response is not signed", ErrInvalidSignature)
+	}
+	params, err := ParseAuthorization(header)
+	if err != nil {
+		return err
+	}
+	if params["alg"] != "ed25519" {
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidSignature, params["alg"])
+	}
+	if params["signed"] != responseSignedComponents {
+		return fmt.Errorf("%w: unexpected signed set %q", ErrInvalidSignature, params["signed"])
+	}
+	if params["keyId"] != keyID {
+		return fmt.Errorf("%w: response signed by unknown key %q", ErrInvalidSignature, params["keyId"])
+	}
+
+	ts, err := strconv.ParseInt(resp.Header.Get("X-Server-Timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad X-Server-Timestamp", ErrInvalidSignature)
+	}
+	if maxAge > 0 {
+		age := now.Sub(time.Unix(ts, 0))
+		if age > maxAge || age < -maxAge {
+			return fmt.Errorf("%w: timestamp outside allowed window", ErrInvalidSignature)
+		}
+	}
+
+	bodyHash := hashBytesBase64url(body)
+	if subtle.ConstantTimeCompare([]byte(bodyHash), []byte(resp.Header.Get("X-Server-Content-SHA256"))) != 1 {
+		return fmt.Errorf("%w: body hash mismatch", ErrInvalidSignature)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(params["sig"])
+	if err != nil {
+		return fmt.Errorf("%w: bad sig encoding", ErrInvalidSignature)
+	}
+	if !ed25519.Verify(pub, []byte(buildResponseSigningString(resp.Request, resp.StatusCode, ts, bodyHash)), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
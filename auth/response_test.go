@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifyResponseBindsRequest(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	newRequest := func(id string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "https://api.example.com/api/agent/v1/desired-state", nil)
+		req.Header.Set("X-Request-Id", id)
+		return req
+	}
+	body := []byte(`{"ok":true}`)
+	now := time.Now()
+
+	signed := newRequest("req-1")
+	h := http.Header{}
+	if err := SignResponse(h, signed, http.StatusOK, body, "srv-1", priv, now); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		wantErr bool
+	}{
+		{"same request", signed, false},
+		{"other request, same path", newRequest("req-2"), true},
+		{"no request id", newRequest(""), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: http.StatusOK, Header: h, Request: tt.req}
+			err := VerifyResponse(resp, body, "srv-1", pub, now, DefaultMaxAge)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyResponse: err = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := SignResponse(http.Header{}, newRequest(""), http.StatusOK, body, "srv-1", priv, now); err == nil {
+		t.Fatal("signed a response to a request without X-Request-Id")
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockSigner is a crypto.Signer that, like a token key, never hands out its
+// private key: SignRequestWithKey only sees Public and Sign.
+type mockSigner struct {
+	priv  ed25519.PrivateKey
+	err   error // returned by Sign, if set
+	calls int
+}
+
+func (s *mockSigner) Public() crypto.PublicKey { return s.priv.Public() }
+
+func (s *mockSigner) Sign(_ io.Reader, msg []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	if opts.HashFunc() != crypto.Hash(0) {
+		return nil, errors.New("ed25519 signs the message itself, not a digest")
+	}
+	return ed25519.Sign(s.priv, msg), nil
+}
+
+func TestSignRequestWithSigner(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	errToken := errors.New("token removed")
+
+	tests := []struct {
+		name       string
+		signer     *mockSigner
+		wantErr    error // from signing
+		wantVerify bool
+	}{
+		{name: "signs", signer: &mockSigner{priv: priv}, wantVerify: true},
+		{name: "sign fails", signer: &mockSigner{priv: priv, err: errToken}, wantErr: errToken},
+		{name: "other key", signer: &mockSigner{priv: other}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			req, _ := http.NewRequest(http.MethodPost, "https://api.example.com/api/agent/v1/events", strings.NewReader(`{"events":[]}`))
+			err := SignRequestWithKey(req, "agent-1", tt.signer, now, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.signer.calls != 1 {
+				t.Errorf("Sign called %d times, want once", tt.signer.calls)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			lookup := func(string) (ed25519.PublicKey, error) { return priv.Public().(ed25519.PublicKey), nil }
+			_, err = VerifyRequest(req, lookup, now, VerifyOptions{MaxAge: DefaultMaxAge})
+			if (err == nil) != tt.wantVerify {
+				t.Errorf("verify: err = %v, want verified %v", err, tt.wantVerify)
+			}
+		})
+	}
+}
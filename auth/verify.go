@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("invalid request signature")
+
+// DefaultMaxAge is the signature timestamp window verifiers are expected to
+// allow. Clocks further apart than this will see signed requests rejected.
+const DefaultMaxAge = 5 * time.Minute
+
+// KeyLookup returns the public key for the keyId a request was signed with.
+type KeyLookup func(keyID string) (ed25519.PublicKey, error)
+
+// VerifyOptions tune VerifyRequest.
+type VerifyOptions struct {
+	// MaxAge is how far the signature timestamp may be from now, either way.
+	MaxAge time.Duration
+	// Nonces, if set, rejects a signature that has already been seen.
+	Nonces *NonceCache
+}
+
+var authParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// ParseAuthorization parses an `AgentSig k="v", ...` Authorization header into its parameters.
+func ParseAuthorization(header string) (map[string]string, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(header), "AgentSig ")
+	if !ok {
+		return nil, fmt.Errorf("%w: not an AgentSig authorization", ErrInvalidSignature)
+	}
+	params := map[string]string{}
+	for _, m := range authParamPattern.FindAllStringSubmatch(rest, -1) {
+		params[m[1]] = m[2]
+	}
+	return params, nil
+}
+
+// VerifyRequest checks a request signed by SignRequest/SignRequestWithHeaders.
+// The signing string is rebuilt from the components advertised in signed="...",
+// which must include the five base components. On success it returns the keyId.
+func VerifyRequest(req *http.Request, lookup KeyLookup, now time.Time, opts VerifyOptions) (string, error) {
+	params, err := ParseAuthorization(req.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+	if params["alg"] != "ed25519" {
+		return "", fmt.Errorf("%w: unsupported alg %q", ErrInvalidSignature, params["alg"])
+	}
+
+	signed := strings.Fields(params["signed"])
+	if len(signed) < len(baseSignedComponents) || !slices.Equal(signed[:len(baseSignedComponents)], baseSignedComponents) {
+		return "", fmt.Errorf("%w: signed set must start with %q", ErrInvalidSignature, strings.Join(baseSignedComponents, " "))
+	}
+	extraHeaders := signed[len(baseSignedComponents):]
+	if err := validateSignedHeaders(extraHeaders); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	keyID := params["keyId"]
+	if keyID == "" || req.Header.Get("X-Agent-Id") != keyID {
+		return "", fmt.Errorf("%w: keyId does not match X-Agent-Id", ErrInvalidSignature)
+	}
+
+	ts, err := strconv.ParseInt(req.Header.Get("X-Agent-Timestamp"), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad X-Agent-Timestamp", ErrInvalidSignature)
+	}
+	if opts.MaxAge > 0 {
+		age := now.Sub(time.Unix(ts, 0))
+		if age > opts.MaxAge || age < -opts.MaxAge {
+			return "", fmt.Errorf("%w: timestamp outside allowed window", ErrInvalidSignature)
+		}
+	}
+
+	bodyHash, err := ComputeBodySHA256Base64url(req)
+	if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(bodyHash), []byte(req.Header.Get("X-Agent-Content-SHA256"))) != 1 {
+		return "", fmt.Errorf("%w: body hash mismatch", ErrInvalidSignature)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(params["sig"])
+	if err != nil {
+		return "", fmt.Errorf("%w: bad sig encoding", ErrInvalidSignature)
+	}
+
+	pub, err := lookup(keyID)
+	if err != nil {
+		return "", err
+	}
+
+	signingString := buildSigningString(req.Method, canonicalPathAndQuery(req.URL), canonicalHost(req), ts, bodyHash, extraHeaders, req.Header)
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return "", ErrInvalidSignature
+	}
+
+	if opts.Nonces != nil && opts.Nonces.Seen(params["sig"]) {
+		return "", fmt.Errorf("%w: replayed request", ErrInvalidSignature)
+	}
+
+	return keyID, nil
+}
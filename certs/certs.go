@@ -0,0 +1,202 @@
+// Package certs generates keys and CSRs on the host, for certificates whose
+// private key must never leave it.
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// GenerateKeyAndCSR creates an ECDSA P-256 key and a CSR for it. Each SAN is
+// added as an IP address, email address, URI or DNS name depending on its form.
+// It returns the PEM encoded key and CSR.
+func GenerateKeyAndCSR(subject pkix.Name, sans []string) (keyPEM, csrPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	tmpl := &x509.CertificateRequest{Subject: subject}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if addr, err := mail.ParseAddress(san); err == nil && addr.Address == san {
+			tmpl.EmailAddresses = append(tmpl.EmailAddresses, san)
+		} else if u, err := url.Parse(san); err == nil && u.Scheme != "" && u.Host != "" {
+			tmpl.URIs = append(tmpl.URIs, u)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create CSR: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal key: %w", err)
+	}
+
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+	return keyPEM, csrPEM, nil
+}
+
+// KeyPath returns where the host key for certificateID is stored in dir, the
+// directory host-generated private keys are kept in, one per certificate ID.
+func KeyPath(dir, certificateID string) string {
+	return filepath.Join(dir, certificateID+".key")
+}
+
+// WriteKey stores a host-generated key for certificateID in dir, readable
+// only by the owner.
+func WriteKey(dir, certificateID string, keyPEM []byte) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(KeyPath(dir, certificateID), keyPEM, 0o600)
+}
+
+// ReadKey returns the host key for certificateID stored in dir.
+func ReadKey(dir, certificateID string) ([]byte, error) {
+	return os.ReadFile(KeyPath(dir, certificateID))
+}
+
+// PendingKeyPath returns where a new host key for certificateID is kept from
+// the CSR that requested its certificate until the certificate is deployed.
+// Only then does it replace the key at KeyPath, which the certificate still
+// deployed needs until it's replaced.
+func PendingKeyPath(dir, certificateID string) string {
+	return KeyPath(dir, certificateID) + ".pending"
+}
+
+// WritePendingKey stores a new host key for certificateID at PendingKeyPath,
+// readable only by the owner.
+func WritePendingKey(dir, certificateID string, keyPEM []byte) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(PendingKeyPath(dir, certificateID), keyPEM, 0o600)
+}
+
+// RemovePendingKey removes certificateID's pending key, if any, once it is
+// stored at KeyPath.
+func RemovePendingKey(dir, certificateID string) error {
+	if err := os.Remove(PendingKeyPath(dir, certificateID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// MatchingKey returns the host key for certificateID stored in dir that is
+// the private key for certPEM: the pending one (see PendingKeyPath) if it
+// is, else the current one.
+func MatchingKey(dir, certificateID string, certPEM []byte) ([]byte, error) {
+	var errs []error
+	current := KeyPath(dir, certificateID)
+	for _, path := range []string{PendingKeyPath(dir, certificateID), current} {
+		keyPEM, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) && path != current {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ok, err := KeyMatchesCert(keyPEM, certPEM)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		if ok {
+			return keyPEM, nil
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("read host key: %w", err)
+	}
+	return nil, fmt.Errorf("host key %s does not match the certificate; request a reissue", current)
+}
+
+// ParsePrivateKey parses a PEM private key in PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) form.
+func ParsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("key is not PEM")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("key cannot sign")
+	}
+	return signer, nil
+}
+
+// KeyMatchesCert reports whether the PEM key is the private key for the PEM certificate.
+func KeyMatchesCert(keyPEM, certPEM []byte) (bool, error) {
+	signer, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return false, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return false, errors.New("certificate is not PEM")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return false, fmt.Errorf("parse certificate: %w", err)
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey), nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the first certificate in
+// certPEM, as colon-separated uppercase hex (like auth.Fingerprint), or "".
+func Fingerprint(certPEM string) string {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return ""
+	}
+	return FingerprintDER(block.Bytes)
+}
+
+// FingerprintDER is Fingerprint for a DER-encoded certificate.
+func FingerprintDER(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,140 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+)
+
+func TestGenerateKeyAndCSR(t *testing.T) {
+	keyPEM, csrPEM, err := GenerateKeyAndCSR(pkix.Name{CommonName: "www.example.com"},
+		[]string{"www.example.com", "*.example.com", "192.0.2.1", "admin@example.com", "spiffe://example.com/web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		t.Fatalf("CSR is not PEM: %q", csrPEM)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Errorf("CSR signature: %v", err)
+	}
+
+	var ips, uris []string
+	for _, ip := range csr.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	for _, u := range csr.URIs {
+		uris = append(uris, u.String())
+	}
+	tests := []struct {
+		field     string
+		got, want any
+	}{
+		{"common name", csr.Subject.CommonName, "www.example.com"},
+		{"DNS names", csr.DNSNames, []string{"www.example.com", "*.example.com"}},
+		{"IP addresses", ips, []string{"192.0.2.1"}},
+		{"email addresses", csr.EmailAddresses, []string{"admin@example.com"}},
+		{"URIs", uris, []string{"spiffe://example.com/web"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.field, tt.got, tt.want)
+		}
+	}
+
+	signer, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(csr.PublicKey) {
+		t.Error("key is not the CSR's")
+	}
+}
+
+func TestWriteKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions")
+	}
+	dir := filepath.Join(t.TempDir(), "keys")
+	for name, write := range map[string]func(string, string, []byte) error{"current": WriteKey, "pending": WritePendingKey} {
+		if err := write(dir, "c1", []byte("key")); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	for _, path := range []string{dir, KeyPath(dir, "c1"), PendingKeyPath(dir, "c1")} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := os.FileMode(0o600)
+		if info.IsDir() {
+			want = 0o700
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("%s: mode %v, want %v", path, info.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestMatchingKey(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	current, next := ca.Leaf(t), ca.Leaf(t)
+
+	tests := []struct {
+		name             string
+		current, pending string // stored keys; "" for none
+		cert             *testcerts.Cert
+		want             string // the key returned; "" for an error
+		wantErr          string
+	}{
+		{name: "current key", current: current.KeyPEM(), cert: current, want: current.KeyPEM()},
+		{name: "pending key", current: current.KeyPEM(), pending: next.KeyPEM(), cert: next, want: next.KeyPEM()},
+		// E.g. the CSR went out, but the backend still sends the old certificate.
+		{name: "pending key for another certificate", current: current.KeyPEM(), pending: next.KeyPEM(), cert: current, want: current.KeyPEM()},
+		{name: "pending key, none current", pending: next.KeyPEM(), cert: next, want: next.KeyPEM()},
+		{name: "no match", current: current.KeyPEM(), cert: next, wantErr: "does not match"},
+		{name: "no key", cert: current, wantErr: "read host key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "keys")
+			if tt.current != "" {
+				if err := WriteKey(dir, "c1", []byte(tt.current)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.pending != "" {
+				if err := WritePendingKey(dir, "c1", []byte(tt.pending)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := MatchingKey(dir, "c1", []byte(tt.cert.PEM()))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Error("wrong key returned")
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/inventory"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+const redacted = "[REDACTED]"
+
+// bundleFile is one entry of a diagnostics bundle.
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// bundleCmd collects diagnostics into a tar.gz for a support ticket: the
+// config with every secret stripped, recent journal entries, the doctor
+// report and the certificate inventory. With --upload it is also sent to
+// the backend.
+func bundleCmd(args []string) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	var clientOpts api.Options
+	insecureHTTPFlag(fs, &clientOpts.AllowInsecureHTTP)
+	serviceName := fs.String("service-name", defaultServiceName, "systemd service name")
+	out := fs.String("out", "", "write the bundle to this file (default certkit-agent-bundle-<time>.tar.gz)")
+	journalLines := fs.Int("journal-lines", 1000, "number of recent journal entries to include")
+	upload := fs.Bool("upload", false, "also upload the bundle to the backend (requires an enrolled agent)")
+	fs.DurationVar(&clientOpts.Timeout, "timeout", 0, "per-request API timeout (default: request_timeout from config, else 30s)")
+	fs.Parse(args)
+
+	now := time.Now().UTC()
+	if *out == "" {
+		*out = "certkit-agent-bundle-" + now.Format("20060102T150405Z") + ".tar.gz"
+	}
+
+	files := collectBundle(*configPath, *serviceName, *journalLines, clientOpts, now)
+	b, err := writeBundle(files, now)
+	if err != nil {
+		log.Fatalf("build bundle: %v", err)
+	}
+	if err := utils.WriteFileAtomic(*out, b, 0o600); err != nil {
+		log.Fatalf("write bundle: %v", err)
+	}
+	log.Printf("Wrote diagnostics bundle to %s (%d bytes)", *out, len(b))
+
+	if !*upload {
+		return
+	}
+	cfg, err := config.ReadConfig(*configPath, config.Options{})
+	if err != nil {
+		log.Fatalf("upload bundle: %v", err)
+	}
+	cfg.Version = Version()
+	client, err := api.NewClientFromConfig(&cfg, clientOpts)
+	if err != nil {
+		log.Fatalf("upload bundle: %v", err)
+	}
+	resp, err := client.UploadBundle(context.Background(), filepath.Base(*out), b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Uploaded bundle %s; quote this id in your support ticket", resp.BundleID)
+}
+
+// collectBundle gathers the bundle's files. Anything that can't be collected
+// is noted in its file rather than failing the whole bundle.
+func collectBundle(configPath, serviceName string, journalLines int, opts api.Options, now time.Time) []bundleFile {
+	v := Version()
+	files := []bundleFile{{
+		name: "version.txt",
+		data: fmt.Appendf(nil, "version: %s\ncommit: %s\ndate: %s\nplatform: %s/%s\ncollected: %s\n",
+			v.Version, v.Commit, v.Date, runtime.GOOS, runtime.GOARCH, now.Format(time.RFC3339)),
+	}}
+
+	var paths []string
+	cfg, err := config.ReadConfig(configPath, config.Options{})
+	if err != nil {
+		files = append(files, bundleFile{"config.json", fmt.Appendf(nil, "error: %v\n", err)})
+	} else {
+		paths = cfg.InventoryPaths
+		b, _ := json.MarshalIndent(redactConfig(&cfg), "", "  ")
+		files = append(files, bundleFile{"config.json", append(b, '\n')})
+	}
+
+	var doctor bytes.Buffer
+	writeDoctorReport(&doctor, runDoctor(configPath, serviceName, opts))
+	files = append(files, bundleFile{"doctor.txt", doctor.Bytes()})
+
+	files = append(files, bundleFile{"journal.txt", journal(serviceName, journalLines)})
+
+	inv, _ := json.MarshalIndent(inventory.Collect(paths), "", "  ")
+	files = append(files, bundleFile{"inventory.json", append(inv, '\n')})
+
+	return files
+}
+
+// redactConfig returns a copy of cfg with every credential replaced by a marker,
+// keeping whether it was set, which is usually what support needs to know.
+func redactConfig(cfg *config.Config) *config.Config {
+	c := cfg.Clone()
+	mask := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	if c.Bootstrap != nil {
+		mask(&c.Bootstrap.AccessKey)
+		mask(&c.Bootstrap.SecretKey)
+	}
+	if c.Agent != nil {
+		mask(&c.Agent.AccessToken)
+		mask(&c.Agent.RefreshToken)
+	}
+	if c.Auth != nil {
+		if c.Auth.KeyPair != nil {
+			mask(&c.Auth.KeyPair.PrivateKey)
+		}
+		if c.Auth.PendingKeyPair != nil {
+			mask(&c.Auth.PendingKeyPair.PrivateKey)
+		}
+	}
+	// extra_headers are typically proxy credentials.
+	for name, value := range c.ExtraHeaders {
+		mask(&value)
+		c.ExtraHeaders[name] = value
+	}
+	if u, err := url.Parse(c.ProxyURL); err == nil && u.User != nil {
+		c.ProxyURL = u.Redacted()
+	}
+	return c
+}
+
+// journal returns the service's most recent journal entries, or a note saying
+// why they aren't available.
+func journal(serviceName string, lines int) []byte {
+	out, err := exec.Command("journalctl", "-u", serviceName, "-n", strconv.Itoa(lines), "--no-pager", "-o", "short-iso").CombinedOutput()
+	if isCmdNotFound(err) {
+		return []byte("journalctl not available on this host\n")
+	}
+	if err != nil {
+		return fmt.Appendf(out, "\njournalctl: %v\n", err)
+	}
+	return out
+}
+
+// writeBundle packs files into a gzipped tarball under a single top-level directory.
+func writeBundle(files []bundleFile, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	dir := "certkit-agent-bundle/"
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    dir + f.name,
+			Mode:    0o600,
+			Size:    int64(len(f.data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/inventory"
+)
+
+// Exit codes of `check`, as Nagios-compatible monitoring expects.
+const (
+	checkExitOK       = 0
+	checkExitWarning  = 1
+	checkExitCritical = 2
+	checkExitUnknown  = 3
+)
+
+// checkCmd is a monitoring plugin: it scans for certificates and exits 0/1/2
+// when the soonest to expire is outside/inside the warning/critical window,
+// or 3 if it found none, printing a one-line summary either way.
+func checkCmd(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file; its inventory_paths are scanned unless --path is given")
+	var paths stringList
+	fs.Var(&paths, "path", "file or directory to scan (repeatable; default: inventory_paths from config)")
+	warnFlag := fs.String("warn", "30d", "warn when a certificate expires within this duration (e.g. 30d, 72h)")
+	critFlag := fs.String("crit", "7d", "critical when a certificate expires within this duration")
+	fs.Parse(args)
+
+	warn, err := parseDays(*warnFlag)
+	if err != nil {
+		checkExit(checkExitUnknown, "--warn: %v", err)
+	}
+	crit, err := parseDays(*critFlag)
+	if err != nil {
+		checkExit(checkExitUnknown, "--crit: %v", err)
+	}
+
+	if len(paths) == 0 {
+		cfg, err := config.ReadConfig(*configPath, config.Options{})
+		if err != nil {
+			checkExit(checkExitUnknown, "%v (or pass --path)", err)
+		}
+		paths = cfg.InventoryPaths
+	}
+
+	soonest, count := soonestExpiry(paths)
+	if count == 0 {
+		checkExit(checkExitUnknown, "no certificates found")
+	}
+
+	left := time.Until(soonest.NotAfter)
+	summary := fmt.Sprintf("%d certificates, soonest %s (%s) expires %s", count, soonest.Subject, soonest.Path, describeExpiry(left))
+	switch {
+	case left <= crit:
+		checkExit(checkExitCritical, "%s", summary)
+	case left <= warn:
+		checkExit(checkExitWarning, "%s", summary)
+	}
+	checkExit(checkExitOK, "%s", summary)
+}
+
+// soonestExpiry scans paths like the inventory does and returns the leaf
+// certificate that expires first and how many leaf certificates were found.
+// CA certificates (e.g. a system trust store) are not ours to renew, so they
+// are skipped.
+func soonestExpiry(paths []string) (inventory.Certificate, int) {
+	var soonest inventory.Certificate
+	count := 0
+	found, _ := inventory.ScanPaths(paths)
+	for _, c := range found {
+		if c.IsCA {
+			continue
+		}
+		if count == 0 || c.NotAfter.Before(soonest.NotAfter) {
+			soonest = c
+		}
+		count++
+	}
+	return soonest, count
+}
+
+// checkExit prints the status line and exits with code.
+func checkExit(code int, format string, args ...any) {
+	label := [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}[code]
+	fmt.Printf("CERTKIT %s - %s\n", label, fmt.Sprintf(format, args...))
+	os.Exit(code)
+}
+
+func describeExpiry(left time.Duration) string {
+	days := int(left.Hours() / 24)
+	switch {
+	case left <= 0:
+		return fmt.Sprintf("expired %dd ago", -days)
+	case days == 0:
+		return fmt.Sprintf("in %s", left.Round(time.Minute))
+	}
+	return fmt.Sprintf("in %dd", days)
+}
+
+// parseDays parses a duration that may also be given in days, e.g. "30d".
+func parseDays(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+type checkStatus string
+
+const (
+	checkPass checkStatus = "PASS"
+	checkWarn checkStatus = "WARN"
+	checkFail checkStatus = "FAIL"
+)
+
+type checkResult struct {
+	Name   string
+	Status checkStatus
+	Detail string
+}
+
+func doctorCmd(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	var clientOpts api.Options
+	insecureHTTPFlag(fs, &clientOpts.AllowInsecureHTTP)
+	serviceName := fs.String("service-name", defaultServiceName, "systemd service name")
+	fs.DurationVar(&clientOpts.Timeout, "timeout", 0, "per-request API timeout (default: request_timeout from config, else 30s)")
+	fs.Parse(args)
+
+	results := runDoctor(*configPath, *serviceName, clientOpts)
+
+	if failed := writeDoctorReport(os.Stdout, results); failed {
+		os.Exit(1)
+	}
+}
+
+// writeDoctorReport prints results one per line and reports whether any failed.
+func writeDoctorReport(w io.Writer, results []checkResult) bool {
+	failed := false
+	for _, r := range results {
+		fmt.Fprintf(w, "[%s] %-12s %s\n", r.Status, r.Name, r.Detail)
+		if r.Status == checkFail {
+			failed = true
+		}
+	}
+	return failed
+}
+
+// runDoctor runs every diagnostic check. It never modifies the config.
+func runDoctor(configPath, serviceName string, opts api.Options) []checkResult {
+	var results []checkResult
+	add := func(name string, status checkStatus, format string, args ...any) {
+		results = append(results, checkResult{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	results = append(results, checkConfigPermissions(configPath))
+
+	cfg, err := config.ReadConfig(configPath, config.Options{})
+	if err != nil {
+		add("config", checkFail, "%v", err)
+		results = append(results, checkService(serviceName))
+		return results
+	}
+	cfg.Version = Version()
+
+	agentID := "(not enrolled)"
+	if cfg.Agent != nil && cfg.Agent.AgentID != "" {
+		agentID = cfg.Agent.AgentID
+	}
+	bootstrap := "absent"
+	if cfg.Bootstrap != nil {
+		bootstrap = "present (redacted)"
+	}
+	add("config", checkPass, "api_base=%s agent_id=%s bootstrap=%s", cfg.ApiBase, agentID, bootstrap)
+
+	if cfg.Auth != nil && (cfg.Auth.PKCS11 != nil || cfg.Auth.KeyStorage == config.KeyStorageKeyring) {
+		results = append(results, checkTokenKey(cfg.Auth))
+	} else if cfg.Auth == nil || cfg.Auth.KeyPair == nil || cfg.Auth.KeyPair.PublicKey == "" {
+		add("keypair", checkWarn, "no keypair yet (one is generated on first run)")
+	} else if fp, err := auth.Fingerprint(cfg.Auth.KeyPair.PublicKey); err != nil {
+		add("keypair", checkFail, "invalid public key: %v", err)
+	} else if err := cfg.Auth.KeyPair.Validate(); err != nil {
+		add("keypair", checkFail, "%v; signed requests will be rejected", err)
+	} else {
+		add("keypair", checkPass, "public key fingerprint %s", fp)
+	}
+
+	client, err := api.NewClientFromConfig(&cfg, opts)
+	if err != nil {
+		add("api", checkFail, "invalid client settings: %v", err)
+	} else if ping, err := client.Ping(context.Background()); err != nil {
+		add("api", checkFail, "%s unreachable: %v", cfg.ApiBase, err)
+	} else {
+		add("api", checkPass, "%s reachable (HTTP %d)", ping.APIBase, ping.StatusCode)
+
+		if ping.TLS != nil && len(ping.TLS.PeerCertificates) > 0 {
+			leaf := ping.TLS.PeerCertificates[0]
+			sum := sha256.Sum256(leaf.Raw)
+			detail := fmt.Sprintf("%s, subject=%q issuer=%q expires=%s chain=%d sha256=%s",
+				tls.VersionName(ping.TLS.Version), leaf.Subject.CommonName, leaf.Issuer.CommonName,
+				leaf.NotAfter.UTC().Format(time.RFC3339), len(ping.TLS.PeerCertificates), hex.EncodeToString(sum[:]))
+			if time.Until(leaf.NotAfter) < 14*24*time.Hour {
+				add("tls", checkWarn, "server certificate expires soon: %s", detail)
+			} else {
+				add("tls", checkPass, "%s", detail)
+			}
+		} else {
+			add("tls", checkWarn, "API connection is not using TLS")
+		}
+
+		if ping.ServerDate.IsZero() {
+			add("clock", checkWarn, "server sent no Date header; can't check clock skew")
+		} else {
+			skew := time.Since(ping.ServerDate).Round(time.Second)
+			if skew.Abs() > auth.DefaultMaxAge {
+				add("clock", checkFail, "local clock is off by %s vs server; signed requests will be rejected (check NTP)", skew)
+			} else {
+				add("clock", checkPass, "skew vs server %s", skew)
+			}
+		}
+	}
+
+	results = append(results, checkService(serviceName))
+
+	return results
+}
+
+// checkTokenKey opens the PKCS#11 or kernel keyring key and checks it's the
+// one auth.key_pair records.
+func checkTokenKey(a *config.AuthCreds) checkResult {
+	where := "kernel keyring key"
+	if a.PKCS11 != nil {
+		where = fmt.Sprintf("pkcs11 key %q", a.PKCS11.KeyLabel)
+	}
+	key, err := a.SigningKey()
+	if err != nil {
+		return checkResult{"keypair", checkFail, err.Error()}
+	}
+	pub, err := auth.EncodePublicKey(key)
+	if err != nil {
+		return checkResult{"keypair", checkFail, err.Error()}
+	}
+	if a.KeyPair != nil && a.KeyPair.PublicKey != "" && a.KeyPair.PublicKey != pub {
+		return checkResult{"keypair", checkFail, fmt.Sprintf("the %s is not the one in auth.key_pair; signed requests will be rejected", where)}
+	}
+	fp, _ := auth.Fingerprint(pub)
+	return checkResult{"keypair", checkPass, fmt.Sprintf("%s, public key fingerprint %s", where, fp)}
+}
+
+func checkConfigPermissions(configPath string) checkResult {
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return checkResult{"permissions", checkFail, err.Error()}
+	}
+	if mode := info.Mode().Perm(); mode&0o077 != 0 {
+		return checkResult{"permissions", checkFail, fmt.Sprintf("%s is %04o; it holds the private key and should be 0600", configPath, mode)}
+	}
+	return checkResult{"permissions", checkPass, fmt.Sprintf("%s is %04o", configPath, info.Mode().Perm())}
+}
+
+func checkService(serviceName string) checkResult {
+	out, err := exec.Command("systemctl", "is-active", serviceName+".service").Output()
+	if isCmdNotFound(err) {
+		return checkResult{"service", checkWarn, "systemctl not found"}
+	}
+	state := strings.TrimSpace(string(out))
+	if state == "" {
+		state = "unknown (is systemd running?)"
+	}
+	if state != "active" {
+		return checkResult{"service", checkWarn, fmt.Sprintf("%s.service is %s", serviceName, state)}
+	}
+	return checkResult{"service", checkPass, fmt.Sprintf("%s.service is active", serviceName)}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+func enrollCmd(args []string) {
+	fs := flag.NewFlagSet("enroll", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	var clientOpts api.Options
+	insecureHTTPFlag(fs, &clientOpts.AllowInsecureHTTP)
+	var configOpts config.Options
+	fs.StringVar(&configOpts.Hostname, "hostname", "", "hostname to register (default: hostname from config, else the kernel hostname)")
+	offline := fs.Bool("offline", false, "print a signed enrollment request to carry to the backend instead of calling it")
+	applyResponse := fs.String("apply-response", "", "apply an enrollment response file issued by the backend")
+	debug := fs.Bool("debug", false, "log API requests and responses (secrets redacted)")
+	keepBootstrap := fs.Bool("keep-bootstrap", false, "keep the bootstrap credentials in the config after enrolling (default: keep_bootstrap from config)")
+	fs.DurationVar(&clientOpts.Timeout, "timeout", 0, "per-request API timeout (default: request_timeout from config, else 30s)")
+	fs.Parse(args)
+
+	setupDebug(*debug)
+
+	if *offline && *applyResponse != "" {
+		log.Fatal("--offline and --apply-response are mutually exclusive")
+	}
+
+	// Keep stdout for the request envelope.
+	if *offline {
+		log.SetOutput(os.Stderr)
+	}
+
+	mgr, err := config.NewManager(*configPath, Version(), configOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := mgr.Snapshot()
+
+	switch {
+	case *offline:
+		key, err := cfg.Auth.SigningKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		req, err := api.NewOfflineEnrollmentRequest(api.NewInstallRequest(cfg), key, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(req)
+		log.Printf("Submit this request to CertKit, then run: certkit-agent enroll --apply-response FILE")
+
+	case *applyResponse != "":
+		b, err := os.ReadFile(*applyResponse)
+		if err != nil {
+			log.Fatal(err)
+		}
+		resp, err := api.ParseOfflineEnrollmentResponse(b, cfg.Auth.KeyPair.PublicKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = saveEnrollment(mgr, *keepBootstrap, &config.AgentCreds{
+			AgentID:         resp.AgentId,
+			AccessToken:     resp.AccessToken,
+			RefreshToken:    resp.RefreshToken,
+			KeyID:           resp.KeyID,
+			ServerKeyID:     resp.ServerKeyID,
+			ServerPublicKey: resp.ServerPublicKey,
+		})
+		if err != nil {
+			log.Fatalf("failed to save config: %v", err)
+		}
+		log.Printf("✅ Enrolled as agent %s", resp.AgentId)
+
+	default:
+		if err := enroll(context.Background(), mgr, clientOpts, *keepBootstrap); err != nil {
+			log.Fatalf("enrollment failed: %v", err)
+		}
+	}
+}
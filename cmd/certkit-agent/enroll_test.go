@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+func TestSaveEnrollment(t *testing.T) {
+	tests := []struct {
+		name          string
+		keepFlag      bool // --keep-bootstrap
+		keepSetting   bool // keep_bootstrap
+		wantBootstrap bool
+	}{
+		{name: "default"},
+		{name: "flag", keepFlag: true, wantBootstrap: true},
+		{name: "setting", keepSetting: true, wantBootstrap: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := `{"schema_version":1,"api_base":"https://api.example.com","bootstrap":{"access_key":"ak","secret_key":"bootstrap-secret"}}`
+			if tt.keepSetting {
+				raw = strings.Replace(raw, `{"schema_version":1,`, `{"schema_version":1,"keep_bootstrap":true,`, 1)
+			}
+			path := writeConfig(t, raw, "")
+			mgr, err := config.NewManager(path, config.VersionInfo{}, config.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := saveEnrollment(mgr, tt.keepFlag, &config.AgentCreds{AgentID: "agent-1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			m := mainFile(t, path)
+			if agent, _ := m["agent"].(map[string]any); agent["agent_id"] != "agent-1" {
+				t.Errorf("agent = %v, want agent-1", m["agent"])
+			}
+			if _, got := m["bootstrap"]; got != tt.wantBootstrap {
+				t.Errorf("bootstrap in config = %v, want %v", got, tt.wantBootstrap)
+			}
+			if tt.wantBootstrap {
+				return
+			}
+			backups, _ := filepath.Glob(path + ".bak*")
+			for _, p := range backups {
+				if b, err := os.ReadFile(p); err == nil && strings.Contains(string(b), "bootstrap-secret") {
+					t.Errorf("backup %s still holds the bootstrap secret", p)
+				}
+			}
+		})
+	}
+}
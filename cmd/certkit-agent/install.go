@@ -0,0 +1,824 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/deploy"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// defaultInstallBinPath is where --install-binary puts the binary.
+const defaultInstallBinPath = "/usr/local/bin/certkit-agent"
+
+type installOptions struct {
+	ServiceName   string
+	UnitDir       string
+	BinPath       string
+	ConfigPath    string
+	EnvFile       string
+	InstallBinary bool
+	SkipPreflight bool
+	Replace       bool
+	NoStart       bool
+	Hardening     string
+	AllowHome     bool
+	AllowWX       bool
+	WritablePaths stringList
+	UnitAfter     stringList
+	UnitRequires  stringList
+	Labels        stringList
+	KeyStorage    string
+	KeepBootstrap bool
+	Bootstrap     config.BootstrapSource
+	Env           string
+	Client        api.Options
+}
+
+// installResult is printed by `install --output json`.
+type installResult struct {
+	ServiceName string `json:"service_name"`
+	UnitPath    string `json:"unit_path"`
+	ConfigPath  string `json:"config_path"`
+	AgentID     string `json:"agent_id,omitempty"`
+	PublicKey   string `json:"public_key,omitempty"`
+	Started     bool   `json:"started"`
+}
+
+func installCmd(args []string) {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	var opts installOptions
+	fs.StringVar(&opts.ServiceName, "service-name", defaultServiceName, "systemd service name")
+	fs.StringVar(&opts.UnitDir, "unit-dir", defaultUnitPath, "systemd unit directory")
+	fs.StringVar(&opts.BinPath, "bin-path", "", "path to certkit-agent binary (default: current executable, or "+defaultInstallBinPath+" with --install-binary)")
+	fs.BoolVar(&opts.InstallBinary, "install-binary", false, "copy the current executable to --bin-path and run the service from there")
+	fs.StringVar(&opts.ConfigPath, "config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	insecureHTTPFlag(fs, &opts.Client.AllowInsecureHTTP)
+	fs.StringVar(&opts.EnvFile, "env-file", "", "environment file for the service, e.g. for ACCESS_KEY/SECRET_KEY (created 0600 if missing)")
+	fs.StringVar(&opts.Bootstrap.AccessKeyFile, "access-key-file", "", "read the bootstrap access key from this file instead of ACCESS_KEY")
+	fs.StringVar(&opts.Bootstrap.SecretKeyFile, "secret-key-file", "", "read the bootstrap secret key from this file instead of SECRET_KEY")
+	fs.BoolVar(&opts.SkipPreflight, "skip-preflight", false, "skip the API connectivity check")
+	fs.BoolVar(&opts.Replace, "replace", false, "upgrade in place: stop the running service, update the unit and restart it (no-op if nothing changed)")
+	fs.BoolVar(&opts.NoStart, "no-start", false, "enable the service without starting it or generating a key, e.g. when baking an image; the agent enrolls on first boot")
+	fs.StringVar(&opts.Hardening, "hardening", "strict", "systemd hardening preset: strict, moderate or off")
+	fs.BoolVar(&opts.AllowHome, "allow-home-write", false, "drop ProtectHome= so certs can be deployed under /home, /root or /run/user")
+	fs.BoolVar(&opts.AllowWX, "allow-wx-memory", false, "drop MemoryDenyWriteExecute= (e.g. for plugins that JIT)")
+	fs.Var(&opts.WritablePaths, "writable-path", "directory the agent may write certs to (ReadWritePaths=); repeatable")
+	fs.StringVar(&opts.Env, "env", "", "backend to use when the config has no api_base: dev, staging or prod (default: CERTKIT_API_BASE, else CERTKIT_ENV, else prod)")
+	fs.Var(&opts.UnitAfter, "unit-after", "unit to start after (After=), e.g. nginx.service; repeatable")
+	fs.Var(&opts.UnitRequires, "unit-requires", "unit the agent requires and starts after (Requires=, After=); repeatable")
+	fs.Var(&opts.Labels, "label", "key=value label sent at enrollment, e.g. datacenter=us-east; repeatable")
+	fs.StringVar(&opts.KeyStorage, "key-storage", "", "where to keep the agent's private key: file (in the config) or keyring (Linux kernel keyring; default: key_storage from config, else file)")
+	fs.BoolVar(&opts.KeepBootstrap, "keep-bootstrap", false, "keep the bootstrap credentials in the config after the agent enrolls, to enroll it again later (sets keep_bootstrap)")
+	output := fs.String("output", "text", "output format: text or json")
+	fs.Parse(args)
+
+	switch *output {
+	case "text":
+	case "json":
+		// Keep stdout for the JSON result; progress logs go to stderr.
+		log.SetOutput(os.Stderr)
+	default:
+		log.Fatalf("--output must be text or json: %s", *output)
+	}
+
+	result, err := install(opts)
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err != nil {
+			enc.Encode(map[string]string{"error": err.Error()})
+			os.Exit(1)
+		}
+		enc.Encode(result)
+		return
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !result.Started {
+		log.Printf("✅ Installed and enabled %s (unit: %s); it starts and enrolls on next boot", result.ServiceName, result.UnitPath)
+		return
+	}
+	log.Printf("✅ Installed and started %s (unit: %s)", result.ServiceName, result.UnitPath)
+	log.Printf("   systemctl status %s.service", result.ServiceName)
+}
+
+func install(opts installOptions) (*installResult, error) {
+	if err := requireRoot(); err != nil {
+		return nil, err
+	}
+	if err := requireSystemd(); err != nil {
+		return nil, err
+	}
+
+	// Determine binary path (the installed binary path you want systemd to execute).
+	exe := opts.BinPath
+	switch {
+	case opts.InstallBinary:
+		if exe == "" {
+			exe = defaultInstallBinPath
+		}
+		if !filepath.IsAbs(exe) {
+			return nil, fmt.Errorf("--bin-path must be an absolute path: %s", exe)
+		}
+		if err := installBinary(exe); err != nil {
+			return nil, err
+		}
+	case exe == "":
+		var err error
+		exe, err = currentExecutable()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Basic sanity checks.
+	if _, err := os.Stat(exe); err != nil {
+		return nil, fmt.Errorf("binary path does not exist: %s (%w)", exe, err)
+	}
+	if opts.NoStart && opts.Replace {
+		return nil, fmt.Errorf("--no-start and --replace can't be combined")
+	}
+	if !strings.HasPrefix(opts.UnitDir, "/") {
+		return nil, fmt.Errorf("--unit-dir must be an absolute path: %s", opts.UnitDir)
+	}
+	if !strings.HasPrefix(opts.ConfigPath, "/") {
+		return nil, fmt.Errorf("--config must be an absolute path: %s", opts.ConfigPath)
+	}
+	if opts.EnvFile != "" && !strings.HasPrefix(opts.EnvFile, "/") {
+		return nil, fmt.Errorf("--env-file must be an absolute path: %s", opts.EnvFile)
+	}
+	for _, p := range opts.WritablePaths {
+		if !filepath.IsAbs(p) {
+			return nil, fmt.Errorf("--writable-path must be an absolute path: %s", p)
+		}
+	}
+	for _, u := range slices.Concat(opts.UnitAfter, opts.UnitRequires) {
+		if !unitNamePattern.MatchString(u) {
+			return nil, fmt.Errorf("--unit-after/--unit-requires must be a unit name like nginx.service: %q", u)
+		}
+	}
+	labels := map[string]string{}
+	for _, l := range opts.Labels {
+		k, v, err := config.ParseLabel(l)
+		if err != nil {
+			return nil, fmt.Errorf("--label: %w", err)
+		}
+		labels[k] = v
+	}
+	if err := config.ValidateLabels(labels); err != nil {
+		return nil, fmt.Errorf("--label: %w", err)
+	}
+
+	// Ensure config directory exists (config file contents are handled by your installer script).
+	if err := os.MkdirAll(filepath.Dir(opts.ConfigPath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create config dir: %w", err)
+	}
+
+	// Ensure config exists or create it
+	if _, err := os.Stat(opts.ConfigPath); os.IsNotExist(err) {
+		log.Printf("Config not found, creating %s", opts.ConfigPath)
+		// With an env file the bootstrap secrets can live there instead of in the config.
+		if err := config.CreateInitialConfig(opts.ConfigPath, opts.Bootstrap, opts.Env, opts.EnvFile == ""); err != nil {
+			return nil, fmt.Errorf("failed to create config: %w", err)
+		}
+	} else {
+		log.Printf("Config already exists at %s", opts.ConfigPath)
+		if err := resumeConfig(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(labels) > 0 {
+		if err := setLabels(opts.ConfigPath, labels); err != nil {
+			return nil, err
+		}
+	}
+	if opts.KeyStorage != "" {
+		if err := setKeyStorage(opts.ConfigPath, opts.KeyStorage); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.KeepBootstrap {
+		if err := setKeepBootstrap(opts.ConfigPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.EnvFile != "" {
+		if err := ensureEnvFile(opts.EnvFile); err != nil {
+			return nil, fmt.Errorf("failed to create env file %s: %w", opts.EnvFile, err)
+		}
+	}
+
+	hardening, err := hardeningDirectives(opts.Hardening, opts.AllowHome, opts.AllowWX)
+	if err != nil {
+		return nil, err
+	}
+
+	writable := append([]string(nil), opts.WritablePaths...)
+	for _, dir := range deployTargetDirs(opts.ConfigPath, opts.Client) {
+		if !slices.Contains(writable, dir) {
+			log.Printf("Allowing writes to deploy target directory %s", dir)
+			writable = append(writable, dir)
+		}
+	}
+
+	unitPath := filepath.Join(opts.UnitDir, opts.ServiceName+".service")
+	unitContent := renderSystemdUnit(unitOptions{
+		ExePath:       exe,
+		ConfigPath:    opts.ConfigPath,
+		EnvFile:       opts.EnvFile,
+		Hardening:     hardening,
+		WritablePaths: writable,
+		After:         opts.UnitAfter,
+		Requires:      opts.UnitRequires,
+		InsecureHTTP:  opts.Client.AllowInsecureHTTP,
+	})
+
+	if opts.Replace {
+		if err := replaceService(opts.ServiceName, unitPath, unitContent, exe); err != nil {
+			return nil, err
+		}
+	} else {
+		// An image baked with --no-start is usually built away from the
+		// network it will run on.
+		if !opts.SkipPreflight && !opts.NoStart {
+			preflight(opts.ConfigPath, opts.Client)
+		}
+		if err := installService(hostSystemctl, opts.ServiceName, unitPath, unitContent, !opts.NoStart); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &installResult{
+		ServiceName: opts.ServiceName,
+		UnitPath:    unitPath,
+		ConfigPath:  opts.ConfigPath,
+		Started:     !opts.NoStart,
+	}
+	if opts.NoStart {
+		return result, nil
+	}
+	if mgr, err := config.NewManager(opts.ConfigPath, Version(), config.Options{}); err == nil {
+		cfg := mgr.Snapshot()
+		if cfg.Agent != nil {
+			result.AgentID = cfg.Agent.AgentID
+		}
+		if cfg.Auth != nil && cfg.Auth.KeyPair != nil {
+			result.PublicKey = cfg.Auth.KeyPair.PublicKey
+		}
+	}
+
+	return result, nil
+}
+
+// currentExecutable returns the path of the running binary, with symlinks resolved.
+func currentExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine executable path: %w", err)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve executable symlinks: %w", err)
+	}
+	return exe, nil
+}
+
+// installBinary copies the running binary to dest (mode 0755), replacing any
+// file there atomically, so the unit doesn't point at a download directory.
+// A service still running the old binary keeps it until it's restarted.
+func installBinary(dest string) error {
+	src, err := currentExecutable()
+	if err != nil {
+		return err
+	}
+	if srcInfo, err := os.Stat(src); err == nil {
+		if destInfo, err := os.Stat(dest); err == nil && os.SameFile(srcInfo, destInfo) {
+			log.Printf("Already running from %s", dest)
+			return nil
+		}
+	}
+
+	binary, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", src, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(dest), err)
+	}
+	if err := utils.WriteFileAtomic(dest, binary, 0o755); err != nil {
+		return fmt.Errorf("failed to install binary to %s: %w", dest, err)
+	}
+	log.Printf("Installed %s to %s", src, dest)
+	return nil
+}
+
+// resumeConfig picks up an existing config, possibly left by an interrupted
+// install: one that isn't enrolled yet and has no bootstrap credentials gets
+// them if they're available now, so the service can enroll when it starts.
+func resumeConfig(opts installOptions) error {
+	cfg, err := config.ReadConfig(opts.ConfigPath, config.Options{})
+	if err != nil {
+		return fmt.Errorf("existing config is unusable (fix or remove it to start over): %w", err)
+	}
+	if opts.NoStart && cfg.Auth != nil && cfg.Auth.KeyPair != nil {
+		log.Printf("⚠️  %s already has an agent key; every instance of an image baked with it will share it", opts.ConfigPath)
+	}
+	if cfg.Agent != nil && cfg.Agent.AgentID != "" {
+		log.Printf("Agent already enrolled as %s, skipping enrollment", cfg.Agent.AgentID)
+		return nil
+	}
+	if cfg.Bootstrap != nil {
+		log.Printf("Agent not enrolled yet; the service will enroll when it starts")
+		return nil
+	}
+
+	bootstrap, err := config.ResolveBootstrap(opts.Bootstrap)
+	if err != nil {
+		return err
+	}
+	if bootstrap == nil {
+		if opts.EnvFile == "" {
+			log.Printf("⚠️  Agent not enrolled yet and no bootstrap credentials found; set ACCESS_KEY and SECRET_KEY and re-run install")
+		}
+		return nil
+	}
+	log.Printf("Agent not enrolled yet; adding bootstrap credentials to %s", opts.ConfigPath)
+	return config.UpdateMainFile(opts.ConfigPath, func(cfg *config.Config) error {
+		cfg.Bootstrap = bootstrap
+		return nil
+	})
+}
+
+// setLabels adds labels to the config at path, replacing any with the same keys.
+// They're only sent at enrollment, so an enrolled agent keeps its old ones.
+func setLabels(path string, labels map[string]string) error {
+	return config.UpdateMainFile(path, func(cfg *config.Config) error {
+		if cfg.Agent != nil && cfg.Agent.AgentID != "" {
+			log.Printf("⚠️  Agent is already enrolled; --label only takes effect when it enrolls again")
+		}
+		if cfg.Labels == nil {
+			cfg.Labels = map[string]string{}
+		}
+		maps.Copy(cfg.Labels, labels)
+		if err := config.ValidateLabels(cfg.Labels); err != nil {
+			return fmt.Errorf("--label: %w", err)
+		}
+		return nil
+	})
+}
+
+// setKeyStorage sets auth.key_storage in the config at path, before a keypair
+// is generated, so with keyring the private key never touches the disk.
+func setKeyStorage(path, storage string) error {
+	if storage != config.KeyStorageFile && storage != config.KeyStorageKeyring {
+		return fmt.Errorf("--key-storage must be %s or %s", config.KeyStorageFile, config.KeyStorageKeyring)
+	}
+	return config.UpdateMainFile(path, func(cfg *config.Config) error {
+		if cfg.Auth == nil {
+			cfg.Auth = &config.AuthCreds{}
+		}
+		if cfg.Auth.KeyStorage == storage {
+			return nil
+		}
+		if storage == config.KeyStorageFile && cfg.Auth.KeyStorage == config.KeyStorageKeyring && cfg.Auth.KeyPair != nil {
+			return fmt.Errorf("--key-storage file: the agent's key is in the kernel keyring and can't be moved back; remove auth from %s to start over", path)
+		}
+		cfg.Auth.KeyStorage = storage
+		return nil
+	})
+}
+
+// setKeepBootstrap sets keep_bootstrap in the config at path, so the service
+// keeps the bootstrap credentials once it has enrolled (see saveEnrollment).
+func setKeepBootstrap(path string) error {
+	return config.UpdateMainFile(path, func(cfg *config.Config) error {
+		if cfg.KeepBootstrap {
+			return nil
+		}
+		if cfg.Agent != nil && cfg.Agent.AgentID != "" && cfg.Bootstrap == nil {
+			log.Printf("⚠️  Agent is already enrolled and its bootstrap credentials were removed; --keep-bootstrap applies to the next enrollment")
+		}
+		cfg.KeepBootstrap = true
+		return nil
+	})
+}
+
+// installService writes the unit, then reloads systemd and enables the service
+// and, if start is set, starts it, skipping each step a previous (possibly
+// interrupted) install already completed. A running service is left alone;
+// --replace restarts it.
+func installService(sc systemctl, serviceName, unitPath, unitContent string, start bool) error {
+	unit := serviceName + ".service"
+
+	old, err := os.ReadFile(unitPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read unit file %s: %w", unitPath, err)
+	}
+	unitChanged := string(old) != unitContent
+
+	if unitChanged {
+		if err := utils.WriteFileAtomic(unitPath, []byte(unitContent), 0o644); err != nil {
+			return fmt.Errorf("failed to write unit file %s: %w", unitPath, err)
+		}
+	} else {
+		log.Printf("%s is already up to date, skipping", unitPath)
+	}
+
+	if unitChanged || sc.property(unit, "NeedDaemonReload") == "yes" {
+		if err := sc.run("daemon-reload"); err != nil {
+			return fmt.Errorf("systemctl daemon-reload failed: %w", err)
+		}
+	} else {
+		log.Printf("systemd already has the current unit, skipping daemon-reload")
+	}
+
+	if sc.state("is-enabled", unit) == "enabled" {
+		log.Printf("%s is already enabled, skipping", unit)
+	} else if err := sc.run("enable", unit); err != nil {
+		return fmt.Errorf("systemctl enable failed: %w", err)
+	}
+
+	if !start {
+		log.Printf("Not starting %s (--no-start)", unit)
+		return nil
+	}
+	if sc.state("is-active", unit) == "active" {
+		if unitChanged {
+			log.Printf("⚠️  %s is already running the previous unit; use install --replace to restart it", unit)
+		} else {
+			log.Printf("%s is already running, skipping start", unit)
+		}
+		return nil
+	}
+	if err := sc.run("start", unit); err != nil {
+		return fmt.Errorf("systemctl start failed: %w", err)
+	}
+	return nil
+}
+
+// systemctl runs systemctl for installService: run for a step that changes
+// something, output for a query, returning what it printed.
+type systemctl struct {
+	run    func(args ...string) error
+	output func(args ...string) (string, error)
+}
+
+// hostSystemctl runs the host's systemctl.
+var hostSystemctl = systemctl{
+	run: func(args ...string) error {
+		return utils.RunCmdLogged("systemctl", args...)
+	},
+	output: func(args ...string) (string, error) {
+		out, err := exec.Command("systemctl", args...).Output()
+		return strings.TrimSpace(string(out)), err
+	},
+}
+
+// state returns the output of `systemctl <verb> unit` (e.g. is-active), which
+// is meaningful even when the command exits nonzero.
+func (sc systemctl) state(verb, unit string) string {
+	out, _ := sc.output(verb, unit)
+	return out
+}
+
+// property returns a property of unit, or "" if it can't be read.
+func (sc systemctl) property(unit, property string) string {
+	out, err := sc.output("show", "--property", property, "--value", unit)
+	if err != nil {
+		return ""
+	}
+	return out
+}
+
+// replaceService upgrades an installed service in place: it stops it, writes the
+// new unit, reloads systemd and starts it again. If the unit is unchanged and the
+// running process is already the binary at exe, nothing is touched.
+func replaceService(serviceName, unitPath, unitContent, exe string) error {
+	unit := serviceName + ".service"
+
+	old, err := os.ReadFile(unitPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read unit file %s: %w", unitPath, err)
+	}
+	unitChanged := string(old) != unitContent
+
+	if oldExe := unitExecPath(string(old)); oldExe != "" && oldExe != exe {
+		log.Printf("Binary path changed: %s -> %s", oldExe, exe)
+	}
+
+	if !unitChanged && serviceRunsBinary(unit, exe) {
+		log.Printf("%s is unchanged and already running %s; nothing to restart", unit, exe)
+		return nil
+	}
+
+	if err := utils.RunCmdLogged("systemctl", "stop", unit); err != nil {
+		log.Printf("⚠️  systemctl stop %s failed: %v", unit, err)
+	}
+	if unitChanged {
+		if err := utils.WriteFileAtomic(unitPath, []byte(unitContent), 0o644); err != nil {
+			return fmt.Errorf("failed to write unit file %s: %w", unitPath, err)
+		}
+		log.Printf("Updated %s", unitPath)
+	}
+	if err := utils.RunCmdLogged("systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("systemctl daemon-reload failed: %w", err)
+	}
+	if err := utils.RunCmdLogged("systemctl", "enable", unit); err != nil {
+		return fmt.Errorf("systemctl enable failed: %w", err)
+	}
+	if err := utils.RunCmdLogged("systemctl", "restart", unit); err != nil {
+		return fmt.Errorf("systemctl restart failed: %w", err)
+	}
+	log.Printf("Restarted %s", unit)
+	return nil
+}
+
+// unitExecPath returns the binary path from a unit's ExecStart line, or "".
+// Paths containing quotes aren't supported (see shellEscape).
+func unitExecPath(unit string) string {
+	for _, line := range strings.Split(unit, "\n") {
+		rest, ok := strings.CutPrefix(line, "ExecStart=")
+		if !ok {
+			continue
+		}
+		if path, ok := strings.CutPrefix(rest, `"`); ok {
+			path, _, _ = strings.Cut(path, `"`)
+			return strings.ReplaceAll(path, `\\`, `\`)
+		}
+		path, _, _ := strings.Cut(rest, " ")
+		return path
+	}
+	return ""
+}
+
+// serviceRunsBinary reports whether the unit's main process is running the file at exe.
+// A binary replaced on disk since it started no longer counts.
+func serviceRunsBinary(unit, exe string) bool {
+	pid, err := serviceMainPID(unit)
+	if err != nil {
+		return false
+	}
+	running, err := os.Stat(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(exe)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(running, current)
+}
+
+// deployTargetDirs returns the directories the current desired state deploys into,
+// so they can be made writable in the unit. This only works for an agent that is
+// already enrolled (e.g. on reinstall); any error just yields no directories.
+func deployTargetDirs(configPath string, opts api.Options) []string {
+	cfg, err := config.ReadConfig(configPath, config.Options{})
+	if err != nil || cfg.Agent == nil || cfg.Agent.AgentID == "" {
+		return nil
+	}
+	client, err := api.NewClientFromConfig(&cfg, opts)
+	if err != nil {
+		return nil
+	}
+	raw, err := client.FetchDesiredState(context.Background())
+	if err != nil {
+		log.Printf("Could not fetch desired state to derive writable paths: %v", err)
+		return nil
+	}
+	ds, err := state.Parse(raw)
+	if err != nil {
+		return nil
+	}
+	dirs := ds.TargetDirs()
+	for _, t := range ds.Targets {
+		if t.Format == state.FormatCABundle {
+			// The trust store update command writes the extracted store.
+			dirs = append(dirs, deploy.TrustStoreDirs()...)
+			break
+		}
+	}
+	return dirs
+}
+
+// preflight warns loudly if the API isn't reachable with the installed config.
+// It never fails the install: the network may simply not be up yet. The ping
+// is unsigned, so the config is only read: the agent's key is left for the
+// service to generate or open.
+func preflight(configPath string, opts api.Options) {
+	cfg, err := config.ReadConfig(configPath, config.Options{})
+	if err != nil {
+		log.Printf("⚠️  Pre-flight: could not load config: %v", err)
+		return
+	}
+	apiBase := cfg.ApiBase
+
+	// Without an agent id the client has no signer, so it needs no key.
+	cfg.Agent = nil
+	client, err := api.NewClientFromConfig(&cfg, opts)
+	if err != nil {
+		log.Printf("⚠️  Pre-flight: invalid API client settings: %v", err)
+		return
+	}
+
+	result, err := client.Ping(context.Background())
+	switch {
+	case errors.Is(err, api.ErrTLS):
+		log.Printf("⚠️  Pre-flight: TLS error connecting to %s: %v", apiBase, err)
+		log.Printf("⚠️  Check api_base and the tls settings (ca_bundle_path, pins) in %s", configPath)
+	case err != nil:
+		log.Printf("⚠️  Pre-flight: %s is unreachable: %v", apiBase, err)
+		log.Printf("⚠️  The agent will keep retrying, but check api_base in %s if this persists", configPath)
+	default:
+		log.Printf("Pre-flight: %s reachable (HTTP %d)", result.APIBase, result.StatusCode)
+	}
+}
+
+func requireRoot() error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command must be run as root (try: sudo ...)")
+	}
+	return nil
+}
+
+// requireSystemd fails before anything is written if this host can't run a systemd unit.
+func requireSystemd() error {
+	if err := exec.Command("systemctl", "--version").Run(); err != nil {
+		if isCmdNotFound(err) {
+			return fmt.Errorf("systemctl not found: install requires systemd")
+		}
+		return fmt.Errorf("systemctl --version failed: %w", err)
+	}
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return fmt.Errorf("systemd is not the running init system (no /run/systemd/system): install requires systemd")
+	}
+	return nil
+}
+
+// ensureEnvFile creates an empty, root-only env file if one doesn't exist yet.
+// Existing files are left alone apart from tightening their permissions.
+func ensureEnvFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Hardening presets. strict is the default and what we ship; the others relax it:
+//
+//	strict:   everything below
+//	moderate: strict minus ProtectHome, MemoryDenyWriteExecute, LockPersonality, RestrictRealtime
+//	off:      no hardening directives
+var (
+	strictHardening = []string{
+		"NoNewPrivileges=true",
+		"PrivateTmp=true",
+		"ProtectHome=true",
+		"ProtectControlGroups=true",
+		"ProtectKernelTunables=true",
+		"ProtectKernelModules=true",
+		"LockPersonality=true",
+		"MemoryDenyWriteExecute=true",
+		"RestrictRealtime=true",
+		"RestrictSUIDSGID=true",
+	}
+	moderateRelaxed = []string{"ProtectHome", "MemoryDenyWriteExecute", "LockPersonality", "RestrictRealtime"}
+)
+
+// hardeningDirectives returns the directives for a preset, further dropping
+// ProtectHome (allowHome) and MemoryDenyWriteExecute (allowWX) if asked.
+func hardeningDirectives(preset string, allowHome, allowWX bool) ([]string, error) {
+	var drop []string
+	switch preset {
+	case "strict", "":
+	case "moderate":
+		drop = append(drop, moderateRelaxed...)
+	case "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("--hardening must be strict, moderate or off: %s", preset)
+	}
+	if allowHome {
+		drop = append(drop, "ProtectHome")
+	}
+	if allowWX {
+		drop = append(drop, "MemoryDenyWriteExecute")
+	}
+
+	var directives []string
+	for _, d := range strictHardening {
+		name, _, _ := strings.Cut(d, "=")
+		if !slices.Contains(drop, name) {
+			directives = append(directives, d)
+		}
+	}
+	return directives, nil
+}
+
+type unitOptions struct {
+	ExePath    string
+	ConfigPath string
+	EnvFile    string   // optional EnvironmentFile, loaded if present
+	Hardening  []string // see hardeningDirectives
+	// WritablePaths are emitted as ReadWritePaths= so deploys work under the sandbox.
+	WritablePaths []string
+	// After and Requires order the agent after the services it deploys
+	// for. Required units are ordered after too, since Requires= alone doesn't.
+	After    []string
+	Requires []string
+	// InsecureHTTP passes --insecure-allow-http on to run.
+	InsecureHTTP bool
+}
+
+// unitNamePattern matches a systemd unit name with its type suffix.
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9:_.\\@-]+\.(service|socket|target|mount|automount|path|timer|device|swap|slice|scope)$`)
+
+func renderSystemdUnit(opts unitOptions) string {
+	after := []string{"network-online.target"}
+	for _, u := range slices.Concat(opts.After, opts.Requires) {
+		if !slices.Contains(after, u) {
+			after = append(after, u)
+		}
+	}
+	deps := "After=" + strings.Join(after, " ") + "\nWants=network-online.target\n"
+	if len(opts.Requires) > 0 {
+		deps += "Requires=" + strings.Join(opts.Requires, " ") + "\n"
+	}
+
+	var env string
+	if opts.EnvFile != "" {
+		// The leading dash tells systemd not to fail if the file is missing.
+		env = "EnvironmentFile=-" + opts.EnvFile + "\n"
+	}
+
+	hardening := "# Hardening disabled (--hardening off)\n"
+	if len(opts.Hardening) > 0 {
+		hardening = "# Hardening\n" + strings.Join(opts.Hardening, "\n") + "\n"
+	}
+	for _, p := range opts.WritablePaths {
+		hardening += "ReadWritePaths=" + shellEscape(p) + "\n"
+	}
+
+	run := "run --config " + shellEscape(opts.ConfigPath)
+	if opts.InsecureHTTP {
+		run += " --insecure-allow-http"
+	}
+
+	// Root-running service, hardened according to opts.Hardening.
+	// You can tighten further once you know all file paths the agent needs to write.
+	return fmt.Sprintf(`[Unit]
+Description=CertKit Agent
+%s
+[Service]
+Type=simple
+ExecStart=%s %s
+%sRestart=always
+RestartSec=5
+
+%s
+StateDirectory=certkit-agent
+LogsDirectory=certkit-agent
+
+[Install]
+WantedBy=multi-user.target
+`, deps, shellEscape(opts.ExePath), run, env, hardening)
+}
+
+func shellEscape(s string) string {
+	// systemd unit files treat ExecStart as a command line; spaces matter.
+	// Easiest safe approach: wrap in quotes and escape embedded quotes/backslashes.
+	// This is conservative and works well for typical paths.
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+// writeConfig writes a main config file, and a drop-in if dropIn isn't
+// empty, and returns the main file's path.
+func writeConfig(t *testing.T, main, dropIn string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(main), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if dropIn != "" {
+		if err := os.Mkdir(filepath.Join(dir, "config.d"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "config.d", "10.json"), []byte(dropIn), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// mainFile returns the decoded contents of the config file at path.
+func mainFile(t *testing.T, path string) map[string]any {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSetLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		main    string
+		labels  map[string]string
+		want    map[string]string // in the enrollment payload
+		wantErr bool
+	}{
+		{
+			name:   "new",
+			main:   `{"schema_version":1,"auth":{"key_pair":{"public_key":"pk"}}}`,
+			labels: map[string]string{"datacenter": "us-east", "role": "web"},
+			want:   map[string]string{"datacenter": "us-east", "role": "web", "team": "infra"},
+		},
+		{
+			name:   "replaces same key",
+			main:   `{"schema_version":1,"auth":{"key_pair":{"public_key":"pk"}},"labels":{"role":"db","rack":"r1"}}`,
+			labels: map[string]string{"role": "web"},
+			want:   map[string]string{"role": "web", "rack": "r1", "team": "infra"},
+		},
+		{
+			name:    "invalid",
+			main:    `{"schema_version":1}`,
+			labels:  map[string]string{"bad key": "x"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.main, `{"proxy_url":"http://proxy:3128","labels":{"team":"infra"}}`)
+			err := setLabels(path, tt.labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			m := mainFile(t, path)
+			for _, key := range []string{"proxy_url", "api_base"} {
+				if _, ok := m[key]; ok {
+					t.Errorf("%s written to the main file", key)
+				}
+			}
+
+			cfg, err := config.ReadConfig(path, config.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := json.Marshal(api.NewInstallRequest(&cfg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload struct {
+				Labels map[string]string `json:"labels"`
+			}
+			if err := json.Unmarshal(b, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(payload.Labels, tt.want) {
+				t.Fatalf("enrollment labels = %v, want %v", payload.Labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetKeyStorage(t *testing.T) {
+	tests := []struct {
+		name    string
+		main    string
+		storage string
+		wantErr bool
+	}{
+		{"keyring", `{"schema_version":1}`, config.KeyStorageKeyring, false},
+		{"file", `{"schema_version":1,"auth":{"key_storage":"file","future":1}}`, config.KeyStorageFile, false},
+		{"keyring, keeping other auth settings", `{"schema_version":1,"auth":{"signed_headers":["X-A"],"future":1}}`, config.KeyStorageKeyring, false},
+		{"back to file", `{"schema_version":1,"auth":{"key_storage":"keyring","key_pair":{"public_key":"x"}}}`, config.KeyStorageFile, true},
+		{"unknown", `{"schema_version":1}`, "tpm", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.main, `{"proxy_url":"http://proxy:3128"}`)
+			err := setKeyStorage(path, tt.storage)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			m := mainFile(t, path)
+			auth, _ := m["auth"].(map[string]any)
+			if auth["key_storage"] != tt.storage {
+				t.Fatalf("auth = %v", auth)
+			}
+			var before map[string]any
+			json.Unmarshal([]byte(tt.main), &before)
+			if a, ok := before["auth"].(map[string]any); ok && a["future"] != nil && auth["future"] == nil {
+				t.Errorf("unknown auth setting dropped: %v", auth)
+			}
+			if _, ok := m["proxy_url"]; ok {
+				t.Errorf("drop-in setting written to the main file")
+			}
+		})
+	}
+}
+
+func TestSetKeepBootstrap(t *testing.T) {
+	path := writeConfig(t, `{"schema_version":1,"bootstrap":{"access_key":"a","secret_key":"s"}}`, `{"proxy_url":"http://proxy:3128"}`)
+	if err := setKeepBootstrap(path); err != nil {
+		t.Fatal(err)
+	}
+	m := mainFile(t, path)
+	if m["keep_bootstrap"] != true || m["bootstrap"] == nil {
+		t.Fatalf("saved %v", m)
+	}
+	if _, ok := m["proxy_url"]; ok {
+		t.Errorf("drop-in setting written to the main file")
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name string
+		auth string // the config's auth and agent settings
+	}{
+		{"no keypair yet", `"auth":{}`},
+		// The key is only needed to sign, which a ping isn't.
+		{"enrolled, key unusable here", `"agent":{"agent_id":"agent-1"},"auth":{"key_pair":{"public_key":"pk"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinged := make(chan *http.Request, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case pinged <- r:
+				default:
+				}
+			}))
+			defer srv.Close()
+
+			raw := fmt.Sprintf(`{"schema_version":1,"api_base":%q,%s}`, srv.URL, tt.auth)
+			path := writeConfig(t, raw, "")
+			preflight(path, plainHTTP)
+
+			select {
+			case r := <-pinged:
+				if r.Header.Get("Authorization") != "" {
+					t.Errorf("ping to %s was signed", r.URL.Path)
+				}
+			default:
+				t.Fatal("backend not pinged")
+			}
+			if b, _ := os.ReadFile(path); string(b) != raw {
+				t.Errorf("config changed by pre-flight:\n%s", b)
+			}
+		})
+	}
+}
+
+func TestInstallService(t *testing.T) {
+	tests := []struct {
+		name       string
+		start      bool
+		enabled    bool // the service is already enabled
+		active     bool // and running
+		unchanged  bool // the unit on disk is already current
+		needReload bool // but systemd hasn't loaded it
+		want       []string
+	}{
+		{
+			name:  "fresh",
+			start: true,
+			want:  []string{"daemon-reload", "enable certkit-agent.service", "start certkit-agent.service"},
+		},
+		{
+			name: "no start",
+			want: []string{"daemon-reload", "enable certkit-agent.service"},
+		},
+		{
+			name:    "already running",
+			start:   true,
+			enabled: true,
+			active:  true,
+			want:    []string{"daemon-reload"},
+		},
+		{
+			name:      "already installed",
+			start:     true,
+			enabled:   true,
+			active:    true,
+			unchanged: true,
+		},
+		// Interrupted installs resume at the step they stopped before.
+		{
+			name:       "interrupted before daemon-reload",
+			start:      true,
+			unchanged:  true,
+			needReload: true,
+			want:       []string{"daemon-reload", "enable certkit-agent.service", "start certkit-agent.service"},
+		},
+		{
+			name:      "interrupted before enable",
+			start:     true,
+			unchanged: true,
+			want:      []string{"enable certkit-agent.service", "start certkit-agent.service"},
+		},
+		{
+			name:      "interrupted before start",
+			start:     true,
+			enabled:   true,
+			unchanged: true,
+			want:      []string{"start certkit-agent.service"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const content = "[Service]\nExecStart=/usr/local/bin/certkit-agent run\n"
+			unitPath := filepath.Join(t.TempDir(), "certkit-agent.service")
+			if tt.unchanged {
+				if err := os.WriteFile(unitPath, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var ran []string
+			sc := systemctl{
+				run: func(args ...string) error {
+					ran = append(ran, strings.Join(args, " "))
+					return nil
+				},
+				output: func(args ...string) (string, error) {
+					switch {
+					case args[0] == "is-enabled" && tt.enabled:
+						return "enabled", nil
+					case args[0] == "is-enabled":
+						return "disabled", fmt.Errorf("exit status 1")
+					case args[0] == "is-active" && tt.active:
+						return "active", nil
+					case args[0] == "is-active":
+						return "inactive", fmt.Errorf("exit status 3")
+					case args[0] == "show" && tt.needReload:
+						return "yes", nil
+					}
+					return "no", nil
+				},
+			}
+			if err := installService(sc, "certkit-agent", unitPath, content, tt.start); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ran, tt.want) {
+				t.Errorf("ran systemctl %q, want %q", ran, tt.want)
+			}
+			if got, err := os.ReadFile(unitPath); err != nil || string(got) != content {
+				t.Errorf("unit file = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestResumeConfig(t *testing.T) {
+	const base = `"schema_version":1,"api_base":"https://api.example.com"`
+	tests := []struct {
+		name          string
+		config        string
+		env           bool // ACCESS_KEY/SECRET_KEY are set
+		wantBootstrap string
+		wantErr       bool
+	}{
+		{
+			name:   "enrolled",
+			config: `{` + base + `,"agent":{"agent_id":"agent-1"}}`,
+			env:    true,
+		},
+		{
+			name:          "not enrolled, has bootstrap",
+			config:        `{` + base + `,"bootstrap":{"access_key":"ak-old","secret_key":"sk-old"}}`,
+			env:           true,
+			wantBootstrap: "ak-old",
+		},
+		{
+			name:          "not enrolled, bootstrap now available",
+			config:        `{` + base + `}`,
+			env:           true,
+			wantBootstrap: "ak-env",
+		},
+		{
+			name:   "not enrolled, no bootstrap anywhere",
+			config: `{` + base + `}`,
+		},
+		{
+			name:    "unusable",
+			config:  `{` + base,
+			env:     true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CREDENTIALS_DIRECTORY", "")
+			t.Setenv("ACCESS_KEY", "")
+			t.Setenv("SECRET_KEY", "")
+			if tt.env {
+				t.Setenv("ACCESS_KEY", "ak-env")
+				t.Setenv("SECRET_KEY", "sk-env")
+			}
+			path := writeConfig(t, tt.config, "")
+
+			err := resumeConfig(installOptions{ConfigPath: path})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			cfg, err := config.ReadConfig(path, config.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if cfg.Bootstrap != nil {
+				got = cfg.Bootstrap.AccessKey
+			}
+			if got != tt.wantBootstrap {
+				t.Errorf("bootstrap access key = %q, want %q", got, tt.wantBootstrap)
+			}
+		})
+	}
+}
+
+func TestInstallBinary(t *testing.T) {
+	self, err := currentExecutable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		dest  func(dir string) string
+		prior []byte // written to dest first, if set
+	}{
+		{name: "new, with missing parents", dest: func(dir string) string { return filepath.Join(dir, "usr", "local", "bin", "certkit-agent") }},
+		{name: "replaces an older binary", dest: func(dir string) string { return filepath.Join(dir, "certkit-agent") }, prior: []byte("old binary")},
+		{name: "already in place", dest: func(string) string { return self }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := tt.dest(t.TempDir())
+			if tt.prior != nil {
+				if err := os.WriteFile(dest, tt.prior, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := installBinary(dest); err != nil {
+				t.Fatalf("installBinary: %v", err)
+			}
+			got, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s has %d bytes, not the %d of the running binary", dest, len(got), len(want))
+			}
+			info, err := os.Stat(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dest != self && runtime.GOOS != "windows" && info.Mode().Perm() != 0o755 {
+				t.Errorf("mode = %v, want 0755", info.Mode().Perm())
+			}
+			// The copy is renamed into place; nothing is left behind.
+			entries, err := os.ReadDir(filepath.Dir(dest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dest != self && len(entries) != 1 {
+				t.Errorf("%s has %d entries, want just the binary", filepath.Dir(dest), len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// keygenCmd generates a keypair without touching any config, for provisioning
+// flows that register the public key out of band before the agent is installed.
+// The pair can later be placed under auth.key_pair in the config.
+func keygenCmd(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("out", "", "write the keypair as JSON to this file (mode 0600) instead of stdout")
+	publicOnly := fs.Bool("public-only", false, "print only the public key to stdout (requires --out to keep the private key)")
+	force := fs.Bool("force", false, "overwrite --out if it already exists")
+	fs.Parse(args)
+
+	// Keep stdout for the key material; anything else goes to stderr.
+	log.SetOutput(os.Stderr)
+
+	if *publicOnly && *out == "" {
+		log.Fatal("--public-only needs --out, otherwise the private key would be discarded")
+	}
+
+	keyPair, err := auth.CreateNewKeyPair()
+	if err != nil {
+		log.Fatalf("generate keypair: %v", err)
+	}
+
+	if *out != "" {
+		if err := writeKeyPair(*out, keyPair, *force); err != nil {
+			log.Fatal(err)
+		}
+		fingerprint, _ := auth.Fingerprint(keyPair.PublicKey)
+		log.Printf("Wrote keypair to %s (fingerprint %s)", *out, fingerprint)
+	}
+
+	switch {
+	case *publicOnly:
+		fmt.Println(keyPair.PublicKey)
+	case *out == "":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(keyPair)
+	}
+}
+
+// writeKeyPair writes keyPair to path readable only by the owner.
+func writeKeyPair(path string, keyPair *auth.KeyPair, force bool) error {
+	if _, err := os.Lstat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(keyPair, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(path, append(b, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+const (
+	// defaultLongPollTimeout is how long the backend may hold a long-poll open
+	// before answering "no change", unless long_poll_timeout says otherwise.
+	defaultLongPollTimeout = 5 * time.Minute
+	// longPollRetryDelay is the pause after a failed long-poll, or while the
+	// agent can't make one (not enrolled yet, nothing applied yet, API circuit
+	// open).
+	longPollRetryDelay = 30 * time.Second
+)
+
+// watchDesiredState long-polls the backend for changes to the desired state
+// past the applied version, sending on changed when one arrives so the run
+// loop reconciles right away instead of at the next tick. Sends never block: a
+// pending notification already covers any that follow.
+//
+// applied is read before every long-poll, so the watch follows whatever the
+// run loop deploys, from either a notification or a tick. Until something has
+// been applied there is no version to wait past, and interval polling does the
+// work alone.
+//
+// Interval polling carries on regardless, so this returns quietly when ctx is
+// cancelled, long-polling is disabled, or the backend doesn't support it.
+func watchDesiredState(ctx context.Context, mgr *config.Manager, opts api.Options, applied func() string, changed chan<- struct{}) {
+	// The applied version when the backend last announced a change, and the
+	// version it announced.
+	var notifiedAt, announced string
+	for {
+		cfg := mgr.Snapshot()
+		wait, err := longPollTimeout(cfg)
+		if err != nil {
+			log.Printf("Long-poll disabled: %v", err)
+			return
+		}
+		if wait <= 0 {
+			return
+		}
+
+		version := applied()
+		if version == notifiedAt && announced != "" {
+			// Still waiting on the run loop to apply the announced change, or
+			// it failed to: wait past that version, so a failing change
+			// doesn't turn into a busy loop.
+			version = announced
+		}
+
+		if version != "" && cfg.Agent != nil && cfg.Agent.AgentID != "" && !api.CircuitOpen() {
+			change, err := waitForChange(ctx, cfg, opts, version, wait)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case errors.Is(err, api.ErrLongPollUnsupported):
+				log.Printf("Backend doesn't support long-polling desired state; polling every %s", pollInterval)
+				return
+			case err != nil:
+				log.Printf("Long-poll failed, retrying in %s: %v", longPollRetryDelay, err)
+				refreshOnUnauthorized(ctx, mgr, opts, err)
+			case change.Changed:
+				notifiedAt, announced = applied(), change.Version
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+				continue
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(longPollRetryDelay):
+		}
+	}
+}
+
+func waitForChange(ctx context.Context, cfg *config.Config, opts api.Options, version string, wait time.Duration) (*api.DesiredStateChange, error) {
+	client, err := api.NewClientFromConfig(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return client.WaitForChange(ctx, version, wait)
+}
+
+// longPollTimeout returns the configured long_poll_timeout; zero or less disables long-polling.
+func longPollTimeout(cfg *config.Config) (time.Duration, error) {
+	if cfg.LongPollTimeout == "" {
+		return defaultLongPollTimeout, nil
+	}
+	wait, err := time.ParseDuration(cfg.LongPollTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("parse long_poll_timeout: %w", err)
+	}
+	return wait, nil
+}
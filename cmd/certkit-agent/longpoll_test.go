@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+// longPollServer answers long-polls with answer, given the version asked
+// about, and records the versions asked about.
+type longPollServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	versions []string
+}
+
+func newLongPollServer(t *testing.T, answer func(w http.ResponseWriter, version string)) *longPollServer {
+	t.Helper()
+	s := &longPollServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != api.DefaultAPIPrefix+"/desired-state/wait" {
+			http.NotFound(w, r)
+			return
+		}
+		version := r.URL.Query().Get("version")
+		s.mu.Lock()
+		s.versions = append(s.versions, version)
+		s.mu.Unlock()
+		answer(w, version)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *longPollServer) asked() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.versions...)
+}
+
+// plainHTTP lets clients talk to the plain http test servers.
+var plainHTTP = api.Options{AllowInsecureHTTP: true}
+
+// enrolledManager returns a Manager for an enrolled agent talking to apiBase.
+func enrolledManager(t *testing.T, apiBase string) *config.Manager {
+	t.Helper()
+	path := writeConfig(t, fmt.Sprintf(`{"schema_version":1,"api_base":%q,"agent":{"agent_id":"agent-1"}}`, apiBase), "")
+	mgr, err := config.NewManager(path, config.VersionInfo{}, config.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mgr
+}
+
+// waitFor polls cond until it holds or a few seconds pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchDesiredState(t *testing.T) {
+	tests := []struct {
+		name    string
+		applied string
+		// answer returns the backend's status and body for a long-poll on version.
+		answer       func(version string) (int, string)
+		wantReturn   bool // watchDesiredState gives up on its own
+		wantNotified bool
+		wantAsked    string // the version the first long-poll asks about; "" for none
+	}{
+		{
+			name:    "changed",
+			applied: "1",
+			answer: func(version string) (int, string) {
+				if version == "1" {
+					return http.StatusOK, `{"changed":true,"version":"2"}`
+				}
+				return http.StatusOK, `{"changed":false}`
+			},
+			wantNotified: true,
+			wantAsked:    "1",
+		},
+		{
+			name:      "timeout",
+			applied:   "1",
+			answer:    func(string) (int, string) { return http.StatusOK, `{"changed":false}` },
+			wantAsked: "1",
+		},
+		{
+			name:       "unsupported",
+			applied:    "1",
+			answer:     func(string) (int, string) { return http.StatusNotFound, `{}` },
+			wantReturn: true,
+			wantAsked:  "1",
+		},
+		{
+			// Nothing to wait past: interval polling covers it.
+			name:    "nothing applied",
+			applied: "",
+			answer:  func(string) (int, string) { return http.StatusOK, `{"changed":false}` },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newLongPollServer(t, func(w http.ResponseWriter, version string) {
+				status, body := tt.answer(version)
+				w.WriteHeader(status)
+				w.Write([]byte(body))
+			})
+			mgr := enrolledManager(t, srv.URL)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			changed := make(chan struct{}, 1)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				watchDesiredState(ctx, mgr, plainHTTP, func() string { return tt.applied }, changed)
+			}()
+
+			if tt.wantAsked != "" {
+				waitFor(t, "a long-poll", func() bool { return len(srv.asked()) > 0 })
+			} else {
+				time.Sleep(200 * time.Millisecond)
+			}
+			select {
+			case <-done:
+				if !tt.wantReturn {
+					t.Fatal("watchDesiredState returned")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.wantReturn {
+					t.Fatal("watchDesiredState kept going")
+				}
+			}
+			cancel()
+			<-done
+
+			if got := len(changed) > 0; got != tt.wantNotified {
+				t.Errorf("notified = %v, want %v", got, tt.wantNotified)
+			}
+			asked := srv.asked()
+			switch {
+			case tt.wantAsked == "" && len(asked) > 0:
+				t.Errorf("long-polled %q with nothing applied", asked)
+			case tt.wantAsked != "" && asked[0] != tt.wantAsked:
+				t.Errorf("first long-poll asked about %q, want %q", asked[0], tt.wantAsked)
+			}
+		})
+	}
+}
+
+// TestWatchDesiredStateFollowsApplied checks that the watch waits past an
+// announced version until the run loop applies something, then follows the
+// applied version.
+func TestWatchDesiredStateFollowsApplied(t *testing.T) {
+	srv := newLongPollServer(t, func(w http.ResponseWriter, version string) {
+		if version == "1" {
+			w.Write([]byte(`{"changed":true,"version":"2"}`))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"changed":false}`))
+	})
+	mgr := enrolledManager(t, srv.URL)
+
+	var applied atomic.Value
+	applied.Store("1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchDesiredState(ctx, mgr, plainHTTP, func() string { return applied.Load().(string) }, changed)
+	}()
+
+	// Reconciling "2" hasn't happened (or failed): wait past "2", not "1".
+	<-changed
+	waitFor(t, "a long-poll past the announced version", func() bool {
+		asked := srv.asked()
+		return len(asked) >= 3
+	})
+	for _, v := range srv.asked()[1:] {
+		if v != "2" {
+			t.Fatalf("long-polled %q after the announcement, want 2", v)
+		}
+	}
+
+	// The run loop applied something newer on a tick.
+	applied.Store("3")
+	waitFor(t, "a long-poll past the applied version", func() bool {
+		asked := srv.asked()
+		return asked[len(asked)-1] == "3"
+	})
+	cancel()
+	<-done
+}
@@ -3,7 +3,20 @@
 // Minimal CLI with:
 //
 //	certkit-agent install   -> writes a systemd unit file and enables/starts it
-//	certkit-agent run       -> stubbed daemon loop (logs for now)
+//	certkit-agent uninstall -> deregisters the agent and removes the service and its config
+//	certkit-agent run       -> daemon loop: enroll, poll desired state, deploy certs
+//	certkit-agent plan      -> print what run would deploy and reload, without doing it
+//	certkit-agent reconcile-now -> signal the running service to reconcile immediately
+//	certkit-agent pause     -> stop the running service deploying, e.g. for maintenance
+//	certkit-agent resume    -> let a paused service deploy again
+//	certkit-agent rotate-keys -> generate a new keypair and register it with the backend
+//	certkit-agent doctor    -> run diagnostics and print a pass/warn/fail report
+//	certkit-agent check     -> monitoring plugin: exit 0/1/2/3 on certificate expiry
+//	certkit-agent enroll    -> enroll now, or offline via a request/response file pair
+//	certkit-agent keygen    -> generate a standalone keypair for out-of-band registration
+//	certkit-agent pubkey    -> print the agent's public key and its fingerprint
+//	certkit-agent status    -> print enrollment and the outcome of the last reconcile
+//	certkit-agent bundle    -> collect redacted diagnostics into a tar.gz for support
 //
 // Build:
 //
@@ -19,28 +32,32 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/exec"
-	"os/signal"
-	"path/filepath"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
-	"github.com/certkit-io/certkit-agent-alpha/api"
 	"github.com/certkit-io/certkit-agent-alpha/config"
-	"github.com/certkit-io/certkit-agent-alpha/utils"
 )
 
 const (
 	defaultServiceName = "certkit-agent"
 	defaultUnitPath    = "/etc/systemd/system"
 	defaultConfigPath  = "/etc/certkit-agent/config.json"
+	pollInterval       = 30 * time.Second
+	minPollInterval    = 5 * time.Second
+	maxPollInterval    = time.Hour
+	inventoryInterval  = 6 * time.Hour
+	eventFlushInterval = time.Minute
+	updateInterval     = 6 * time.Hour
+	// shutdownFlushTimeout bounds the last event flush on shutdown.
+	shutdownFlushTimeout = 5 * time.Second
 )
 
 var (
@@ -61,6 +78,10 @@ func Version() config.VersionInfo {
 func main() {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.LstdFlags | log.LUTC)
+	// systemd sets INVOCATION_ID for the processes of a unit.
+	if os.Getenv("INVOCATION_ID") != "" {
+		setupJournalLogging()
+	}
 
 	if len(os.Args) < 2 {
 		usageAndExit()
@@ -69,8 +90,36 @@ func main() {
 	switch os.Args[1] {
 	case "install":
 		installCmd(os.Args[2:])
+	case "uninstall":
+		uninstallCmd(os.Args[2:])
 	case "run":
 		runCmd(os.Args[2:])
+	case "plan":
+		planCmd(os.Args[2:])
+	case "rotate-keys":
+		rotateKeysCmd(os.Args[2:])
+	case "reload-config":
+		reloadConfigCmd(os.Args[2:])
+	case "reconcile-now":
+		reconcileNowCmd(os.Args[2:])
+	case "pause":
+		pauseCmd(os.Args[2:])
+	case "resume":
+		resumeCmd(os.Args[2:])
+	case "doctor":
+		doctorCmd(os.Args[2:])
+	case "check":
+		checkCmd(os.Args[2:])
+	case "enroll":
+		enrollCmd(os.Args[2:])
+	case "keygen":
+		keygenCmd(os.Args[2:])
+	case "pubkey":
+		pubkeyCmd(os.Args[2:])
+	case "status":
+		statusCmd(os.Args[2:])
+	case "bundle":
+		bundleCmd(os.Args[2:])
 	default:
 		usageAndExit()
 	}
@@ -78,201 +127,99 @@ func main() {
 
 func usageAndExit() {
 	fmt.Fprintf(os.Stderr, `Usage:
-  certkit-agent install [--service-name NAME] [--unit-dir DIR] [--bin-path PATH] [--config PATH]
-  certkit-agent run     [--config PATH]
+  certkit-agent install [--service-name NAME] [--unit-dir DIR] [--bin-path PATH] [--install-binary]
+                        [--config PATH] [--env-file PATH] [--skip-preflight] [--replace] [--no-start]
+                        [--output text|json]
+                        [--hardening strict|moderate|off] [--allow-home-write] [--allow-wx-memory]
+                        [--writable-path DIR]... [--access-key-file FILE] [--secret-key-file FILE]
+                        [--unit-after UNIT]... [--unit-requires UNIT]... [--env dev|staging|prod]
+                        [--label KEY=VALUE]... [--key-storage file|keyring] [--keep-bootstrap]
+  certkit-agent uninstall [--service-name NAME] [--unit-dir DIR] [--config PATH]
+                        [--no-deregister] [--keep-config] [--secure-delete] [--debug]
+                        [--timeout DURATION]
+  certkit-agent run     [--config PATH] [--config-dir DIR] [--debug] [--timeout DURATION]
+                        [--metrics-listen ADDR] [--enroll-jitter DURATION] [--state-dir DIR]
+                        [--systemd] [--hostname NAME] [--env dev|staging|prod]
+                        [--log-file PATH [--log-max-size MB] [--log-max-backups N]] [--paused]
+  certkit-agent plan    [--config PATH] [--config-dir DIR] [--state-dir DIR] [--debug]
+                        [--timeout DURATION] [--output text|json]
+  certkit-agent rotate-keys [--config PATH] [--debug] [--timeout DURATION] [--secure-delete]
+  certkit-agent reload-config [--service-name NAME]
+  certkit-agent reconcile-now [--service-name NAME]
+  certkit-agent pause   [--config PATH] [--service-name NAME]
+  certkit-agent resume  [--config PATH] [--service-name NAME]
+  certkit-agent doctor  [--config PATH] [--service-name NAME] [--timeout DURATION]
+  certkit-agent check   [--config PATH | --path PATH...] [--warn 30d] [--crit 7d]
+  certkit-agent enroll  [--config PATH] [--hostname NAME] [--offline | --apply-response FILE]
+                        [--keep-bootstrap]
+  certkit-agent keygen  [--out FILE [--public-only] [--force]]
+  certkit-agent pubkey  [--config PATH] [--json]
+  certkit-agent status  [--config PATH] [--state-dir DIR] [--output text|json]
+  certkit-agent bundle  [--config PATH] [--service-name NAME] [--out FILE]
+                        [--journal-lines N] [--upload] [--timeout DURATION]
 
 Examples:
   sudo ./certkit-agent install
   sudo systemctl status certkit-agent
   ./certkit-agent run --config /etc/certkit-agent/config.json
-`)
-	os.Exit(2)
-}
-
-func installCmd(args []string) {
-	fs := flag.NewFlagSet("install", flag.ExitOnError)
-	serviceName := fs.String("service-name", defaultServiceName, "systemd service name")
-	unitDir := fs.String("unit-dir", defaultUnitPath, "systemd unit directory")
-	binPath := fs.String("bin-path", "", "path to certkit-agent binary (default: current executable)")
-	configPath := fs.String("config", defaultConfigPath, "path to config.json")
-	fs.Parse(args)
-
-	mustBeRoot()
-
-	// Determine binary path (the installed binary path you want systemd to execute).
-	exe := *binPath
-	if exe == "" {
-		var err error
-		exe, err = os.Executable()
-		if err != nil {
-			log.Fatalf("failed to determine executable path: %v", err)
-		}
-		exe, err = filepath.EvalSymlinks(exe)
-		if err != nil {
-			log.Fatalf("failed to resolve executable symlinks: %v", err)
-		}
-	}
-
-	// Basic sanity checks.
-	if _, err := os.Stat(exe); err != nil {
-		log.Fatalf("binary path does not exist: %s (%v)", exe, err)
-	}
-	if !strings.HasPrefix(*unitDir, "/") {
-		log.Fatalf("--unit-dir must be an absolute path: %s", *unitDir)
-	}
-	if !strings.HasPrefix(*configPath, "/") {
-		log.Fatalf("--config must be an absolute path: %s", *configPath)
-	}
-
-	// Ensure config directory exists (config file contents are handled by your installer script).
-	if err := os.MkdirAll(filepath.Dir(*configPath), 0o755); err != nil {
-		log.Fatalf("failed to create config dir: %v", err)
-	}
-
-	// Ensure config exists or create it
-	if _, err := os.Stat(*configPath); os.IsNotExist(err) {
-		log.Printf("Config not found, creating %s", *configPath)
-		if err := config.CreateInitialConfig(*configPath); err != nil {
-			log.Fatalf("failed to create config: %v", err)
-		}
-	} else {
-		log.Printf("Config already exists at %s", *configPath)
-	}
 
-	unitPath := filepath.Join(*unitDir, *serviceName+".service")
-	unitContent := renderSystemdUnit(exe, *configPath)
-
-	// Write unit file atomically.
-	if err := utils.WriteFileAtomic(unitPath, []byte(unitContent), 0o644); err != nil {
-		log.Fatalf("failed to write unit file %s: %v", unitPath, err)
-	}
-
-	// systemd: daemon-reload, enable, start
-	if err := runCmdLogged("systemctl", "daemon-reload"); err != nil {
-		log.Fatalf("systemctl daemon-reload failed: %v", err)
-	}
-	if err := runCmdLogged("systemctl", "enable", "--now", *serviceName+".service"); err != nil {
-		log.Fatalf("systemctl enable --now failed: %v", err)
-	}
-
-	log.Printf("✅ Installed and started %s (unit: %s)", *serviceName, unitPath)
-	log.Printf("   systemctl status %s.service", *serviceName)
-}
+Offline enrollment (no network from this host):
+  certkit-agent enroll --offline > request.json   # carry to CertKit
+  certkit-agent enroll --apply-response response.json
 
-func runCmd(args []string) {
-	fs := flag.NewFlagSet("run", flag.ExitOnError)
-	configPath := fs.String("config", defaultConfigPath, "path to config.json")
-	fs.Parse(args)
+Bootstrap secrets (ACCESS_KEY, SECRET_KEY) can be kept out of the config file
+by putting them in an env file and installing with --env-file PATH.
+To keep them out of the environment, use --access-key-file/--secret-key-file or
+systemd credentials named access_key and secret_key; files win over credentials,
+which win over the environment.
 
-	// Stubbed out for now
-	log.Printf("certkit-agent run starting (config=%s)", *configPath)
-	log.Printf("certkit-agent version: %s, commit: %s, date: %s", version, commit, date)
-
-	if _, err := config.LoadConfig(*configPath, Version()); err != nil {
-		log.Fatal(err)
-	}
-
-	log.Printf("TODO: load config, enroll if needed, inventory, poll, apply, report status")
-
-	log.Printf("API Base: %s", config.CurrentConfig.ApiBase)
-
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	// Block until systemd tells us to stop.
-	sigCh := make(chan os.Signal, 2)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-	response, err := api.InstallAgent()
-
-	if err != nil {
-		log.Printf("Error: %v", err)
-	} else {
-
-		log.Printf("Response: %v", response.AgentId)
-	}
-
-	for {
-		select {
-		case sig := <-sigCh:
-			log.Printf("received signal %s, shutting down", sig)
-			return
-		case <-ticker.C:
-			log.Printf("certkit-agent alive")
-		}
-	}
-
-	// TODO: graceful shutdown (cancel contexts, flush, etc.)
+The backend must be reached over https. For testing against a local backend only,
+commands that read the config accept --insecure-allow-http to allow a plain http api_base.
+`)
+	os.Exit(2)
 }
 
 // --- helpers ---
 
-func mustBeRoot() {
-	if os.Geteuid() != 0 {
-		log.Fatal("this command must be run as root (try: sudo ...)")
-	}
-}
+// stringList is a flag.Value that collects every occurrence of a repeated flag.
+type stringList []string
 
-func renderSystemdUnit(exePath, configPath string) string {
-	// Root-running service, with moderate hardening.
-	// You can tighten further once you know all file paths the agent needs to write.
-	return fmt.Sprintf(`[Unit]
-Description=CertKit Agent
-After=network-online.target
-Wants=network-online.target
+func (l *stringList) String() string { return strings.Join(*l, ",") }
 
-[Service]
-Type=simple
-ExecStart=%s run --config %s
-Restart=always
-RestartSec=5
-
-# Hardening
-NoNewPrivileges=true
-PrivateTmp=true
-ProtectHome=true
-ProtectControlGroups=true
-ProtectKernelTunables=true
-ProtectKernelModules=true
-LockPersonality=true
-MemoryDenyWriteExecute=true
-RestrictRealtime=true
-RestrictSUIDSGID=true
-
-StateDirectory=certkit-agent
-LogsDirectory=certkit-agent
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
 
-[Install]
-WantedBy=multi-user.target
-`, shellEscape(exePath), shellEscape(configPath))
+// insecureHTTPFlag registers --insecure-allow-http on a command that talks to
+// the backend, setting allow (see config.RequireHTTPS). Setting it warns.
+func insecureHTTPFlag(fs *flag.FlagSet, allow *bool) {
+	fs.BoolFunc("insecure-allow-http", "allow a plain http api_base, for local testing only", func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		if *allow = b; b {
+			log.Printf("⚠️  INSECURE: --insecure-allow-http lets the agent use a plain http api_base; requests and secrets would be sent in cleartext. Never use this outside local testing.")
+		}
+		return nil
+	})
 }
 
-func shellEscape(s string) string {
-	// systemd unit files treat ExecStart as a command line; spaces matter.
-	// Easiest safe approach: wrap in quotes and escape embedded quotes/backslashes.
-	// This is conservative and works well for typical paths.
-	s = strings.ReplaceAll(s, `\`, `\\`)
-	s = strings.ReplaceAll(s, `"`, `\"`)
-	return `"` + s + `"`
+// setupJournalLogging drops the timestamp prefix when the journal adds its own.
+// slog output goes through the standard logger, so it follows suit.
+func setupJournalLogging() {
+	log.SetFlags(0)
 }
 
-func runCmdLogged(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	err := cmd.Run()
-	if out.Len() > 0 {
-		log.Printf("%s %s:\n%s", name, strings.Join(args, " "), strings.TrimSpace(out.String()))
-	}
-	if err != nil {
-		// Return a cleaner error with captured output.
-		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out.String()))
+// setupDebug enables debug-level slog output, which goes through the standard logger.
+func setupDebug(debug bool) {
+	if debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
-	return nil
 }
 
-// If you want to give a nicer error when systemctl isn't present.
+// isCmdNotFound reports whether err means the command couldn't be found/executed at all.
 func isCmdNotFound(err error) bool {
 	var ee *exec.Error
 	if errors.As(err, &ee) {
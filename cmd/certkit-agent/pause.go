@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"syscall"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+// pauseCmd sets paused in the config and has the running service reload it,
+// so the agent keeps polling and reporting but deploys nothing, e.g. during
+// maintenance on the services it reloads.
+func pauseCmd(args []string) {
+	setPausedCmd("pause", args, true)
+}
+
+// resumeCmd clears paused again.
+func resumeCmd(args []string) {
+	setPausedCmd("resume", args, false)
+}
+
+func setPausedCmd(name string, args []string, paused bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	serviceName := fs.String("service-name", defaultServiceName, "systemd service name")
+	fs.Parse(args)
+
+	if err := setPaused(*configPath, paused); err != nil {
+		log.Fatalf("%s failed: %v", name, err)
+	}
+
+	if err := signalService(*serviceName, syscall.SIGHUP); err != nil {
+		log.Printf("⚠️  Saved %s, but could not signal %s to reload it: %v", *configPath, *serviceName, err)
+		return
+	}
+	if paused {
+		log.Printf("✅ %s paused; it will poll and report but deploy nothing until resumed", *serviceName)
+	} else {
+		log.Printf("✅ %s resumed", *serviceName)
+	}
+}
+
+// setPaused sets or clears paused in the main config file at configPath. A
+// drop-in that sets paused still wins, so that's checked for afterwards.
+func setPaused(configPath string, paused bool) error {
+	err := config.UpdateFile(configPath, func(m map[string]any) error {
+		if paused {
+			m["paused"] = true
+		} else {
+			delete(m, "paused")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cfg, err := config.ReadConfig(configPath, config.Options{})
+	if err != nil {
+		return err
+	}
+	if cfg.Paused != paused {
+		return fmt.Errorf("saved %s, but a drop-in config overrides paused (%v)", configPath, cfg.Paused)
+	}
+	return nil
+}
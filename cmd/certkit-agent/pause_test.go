@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+func TestSetPaused(t *testing.T) {
+	tests := []struct {
+		name    string
+		dropIn  string // config.d/10.json, if set
+		paused  bool
+		wantErr bool
+	}{
+		{name: "pause", paused: true},
+		{name: "resume", paused: false},
+		{name: "resume overridden by drop-in", dropIn: `{"paused":true}`, paused: false, wantErr: true},
+		{name: "pause with unrelated drop-in", dropIn: `{"proxy_url":"http://proxy:3128"}`, paused: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.json")
+			initial := `{"schema_version":1,"api_base":"https://certkit.example","paused":` + strconv.FormatBool(!tt.paused) + `}`
+			if err := os.WriteFile(path, []byte(initial), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if tt.dropIn != "" {
+				if err := os.Mkdir(filepath.Join(dir, "config.d"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, "config.d", "10.json"), []byte(tt.dropIn), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := setPaused(path, tt.paused)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			cfg, err := config.ReadConfig(path, config.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Paused != tt.paused || cfg.ApiBase != "https://certkit.example" {
+				t.Fatalf("paused %v, api_base %q", cfg.Paused, cfg.ApiBase)
+			}
+
+			var main map[string]any
+			b, _ := os.ReadFile(path)
+			if err := json.Unmarshal(b, &main); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := main["proxy_url"]; ok {
+				t.Fatalf("drop-in setting written to the main file:\n%s", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/reconcile"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// plannedAction is the JSON form of a state.Action.
+type plannedAction struct {
+	Type          state.ActionType `json:"type"`
+	CertificateID string           `json:"certificate_id,omitempty"`
+	TargetID      string           `json:"target_id,omitempty"`
+	CertPath      string           `json:"cert_path,omitempty"`
+	KeyPath       string           `json:"key_path,omitempty"`
+	Reload        string           `json:"reload,omitempty"`
+}
+
+// planCmd polls the backend and prints what run would do, without touching the
+// filesystem or any service.
+func planCmd(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	var clientOpts api.Options
+	insecureHTTPFlag(fs, &clientOpts.AllowInsecureHTTP)
+	var configOpts config.Options
+	fs.StringVar(&configOpts.DropInDir, "config-dir", "", "directory of drop-in config overrides (default: config.d next to --config)")
+	stateDirFlag := fs.String("state-dir", "", "directory for runtime state (default: $STATE_DIRECTORY, else "+config.DefaultStateDir+")")
+	debug := fs.Bool("debug", false, "log API requests and responses (secrets redacted)")
+	fs.DurationVar(&clientOpts.Timeout, "timeout", 0, "per-request API timeout (default: request_timeout from config, else 30s)")
+	output := fs.String("output", "text", "output format: text or json")
+	fs.Parse(args)
+
+	// Keep stdout for the plan; logs go to stderr.
+	log.SetOutput(os.Stderr)
+	setupDebug(*debug)
+
+	if *output != "text" && *output != "json" {
+		log.Fatalf("--output must be text or json: %s", *output)
+	}
+
+	actions, err := runPlan(*configPath, config.StateDir(*stateDirFlag), configOpts, clientOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *output == "json" {
+		planned := make([]plannedAction, 0, len(actions))
+		for _, a := range actions {
+			p := plannedAction{Type: a.Type}
+			if a.Type == state.ActionDeploy {
+				p.CertificateID = a.Certificate.ID
+				p.TargetID = a.Target.ID
+				p.CertPath = a.Target.CertPath
+				p.KeyPath = a.Target.KeyPath
+			} else {
+				p.Reload = a.Reload.String()
+			}
+			planned = append(planned, p)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(planned)
+		return
+	}
+
+	if len(actions) == 0 {
+		fmt.Println("No changes: this host matches the desired state.")
+		return
+	}
+	fmt.Printf("%d action(s) would be taken:\n", len(actions))
+	for _, a := range actions {
+		fmt.Printf("  - %s\n", a)
+	}
+}
+
+// runPlan reads the config and the last applied state in stateDir without
+// side effects and returns the actions a reconcile would take.
+func runPlan(configPath, stateDir string, configOpts config.Options, clientOpts api.Options) ([]state.Action, error) {
+	cfg, err := config.ReadConfig(configPath, configOpts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Agent == nil || cfg.Agent.AgentID == "" {
+		return nil, fmt.Errorf("agent is not enrolled yet; run it (or certkit-agent enroll) first")
+	}
+	cfg.Version = Version()
+
+	applied, err := config.ReadLastApplied(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	if applied == nil {
+		applied = cfg.LastApplied
+	}
+
+	client, err := api.NewClientFromConfig(&cfg, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := reconcileOptions(&cfg, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	return reconcile.Plan(context.Background(), client, applied, opts)
+}
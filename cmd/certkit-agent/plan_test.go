@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+// TestKeyDir checks the key directory plan and run look in follows the
+// state directory.
+func TestKeyDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		override string // --state-dir
+		env      string // $STATE_DIRECTORY
+		want     string
+	}{
+		{"default", "", "", filepath.Join(config.DefaultStateDir, "keys")},
+		{"systemd", "", "/run/state:/other", filepath.Join("/run/state", "keys")},
+		{"--state-dir", "/tmp/state", "/run/state", filepath.Join("/tmp/state", "keys")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("STATE_DIRECTORY", tt.env)
+
+			opts, err := reconcileOptions(&config.Config{}, config.StateDir(tt.override))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.KeyDir != tt.want {
+				t.Errorf("KeyDir = %s, want %s", opts.KeyDir, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+const (
+	// Defaults for poll_backoff: after 10 quiet polls (5 minutes), stretch
+	// the interval by half each poll, up to 5 minutes.
+	defaultPollBackoffAfter  = 10
+	defaultPollBackoffFactor = 1.5
+	defaultPollBackoffMax    = 5 * time.Minute
+)
+
+// pollBackoff is the poll_backoff config, with defaults filled in.
+type pollBackoff struct {
+	after  int
+	factor float64
+	max    time.Duration
+}
+
+// pollBackoffConfig returns the configured poll_backoff.
+func pollBackoffConfig(cfg *config.Config) (pollBackoff, error) {
+	b := pollBackoff{after: defaultPollBackoffAfter, factor: defaultPollBackoffFactor, max: defaultPollBackoffMax}
+	pb := cfg.PollBackoff
+	if pb == nil {
+		return b, nil
+	}
+	if pb.After < 0 {
+		return b, fmt.Errorf("poll_backoff.after must not be negative")
+	}
+	if pb.After > 0 {
+		b.after = pb.After
+	}
+	if pb.Factor != 0 {
+		if pb.Factor < 1 {
+			return b, fmt.Errorf("poll_backoff.factor must be at least 1")
+		}
+		b.factor = pb.Factor
+	}
+	if pb.Max != "" {
+		d, err := time.ParseDuration(pb.Max)
+		if err != nil {
+			return b, fmt.Errorf("parse poll_backoff.max: %w", err)
+		}
+		b.max = min(d, maxPollInterval)
+	}
+	return b, nil
+}
+
+// interval returns base stretched for quiet polls in a row: unchanged for the
+// first b.after, then growing by b.factor with each one, up to b.max.
+func (b pollBackoff) interval(base time.Duration, quiet int) time.Duration {
+	if b.factor <= 1 || quiet <= b.after || base >= b.max {
+		return base
+	}
+	d := float64(base) * math.Pow(b.factor, float64(quiet-b.after))
+	return time.Duration(min(d, float64(b.max))).Round(time.Second)
+}
+
+// pollSchedule is the run loop's poll timing: how many polls in a row were
+// quiet, and the delay it last chose, so that changes are logged once.
+type pollSchedule struct {
+	quiet int
+	last  time.Duration
+}
+
+func newPollSchedule() *pollSchedule {
+	return &pollSchedule{last: pollInterval}
+}
+
+// next records whether the poll just made was quiet (see runOnce) and returns
+// how long to wait before the next one: hint, the backend's next_poll_after
+// from the last desired state, clamped to [minPollInterval, maxPollInterval],
+// else pollInterval stretched by cfg's poll_backoff after a run of quiet
+// polls. The hint lets the backend slow a fleet down during an incident, or
+// speed it up for a rollout.
+func (s *pollSchedule) next(cfg *config.Config, quiet bool, hint time.Duration) time.Duration {
+	if quiet {
+		s.quiet++
+	} else {
+		s.quiet = 0
+	}
+
+	delay := pollInterval
+	if hint > 0 {
+		delay = min(max(hint, minPollInterval), maxPollInterval)
+	} else if b, err := pollBackoffConfig(cfg); err == nil {
+		delay = b.interval(pollInterval, s.quiet)
+	}
+	if delay != s.last {
+		if hint > 0 {
+			log.Printf("Backend asked for next_poll_after=%s; polling every %s", hint, delay)
+		} else if delay > pollInterval {
+			log.Printf("No changes in %d polls; polling every %s", s.quiet, delay)
+		} else {
+			log.Printf("Polling every %s", delay)
+		}
+		s.last = delay
+	}
+	return delay
+}
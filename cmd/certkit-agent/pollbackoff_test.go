@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+func TestPollSchedule(t *testing.T) {
+	type poll struct {
+		quiet bool
+		hint  time.Duration
+		want  time.Duration
+	}
+	quiet := func(n int, want ...time.Duration) []poll {
+		polls := make([]poll, n)
+		for i := range polls {
+			polls[i] = poll{quiet: true, want: want[min(i, len(want)-1)]}
+		}
+		return polls
+	}
+	backoff := &config.PollBackoffConfig{After: 2, Factor: 2, Max: "2m"}
+
+	tests := []struct {
+		name    string
+		backoff *config.PollBackoffConfig
+		polls   []poll
+	}{
+		{
+			name:    "quiet polls grow the interval",
+			backoff: backoff,
+			polls:   quiet(5, 30*time.Second, 30*time.Second, time.Minute, 2*time.Minute),
+		},
+		{
+			name:    "change resets",
+			backoff: backoff,
+			polls: append(quiet(3, 30*time.Second, 30*time.Second, time.Minute),
+				poll{quiet: false, want: 30 * time.Second},
+				poll{quiet: true, want: 30 * time.Second}),
+		},
+		{
+			name:    "defaults",
+			backoff: nil,
+			polls:   append(quiet(10, 30*time.Second), poll{quiet: true, want: 45 * time.Second}),
+		},
+		{
+			name:    "hint overrides backoff",
+			backoff: backoff,
+			polls: append(quiet(4, 30*time.Second, 30*time.Second, time.Minute, 2*time.Minute),
+				poll{quiet: true, hint: 90 * time.Second, want: 90 * time.Second},
+				poll{quiet: true, want: 2 * time.Minute}),
+		},
+		{
+			name:    "hint clamped",
+			backoff: nil,
+			polls: []poll{
+				{hint: 2 * time.Second, want: minPollInterval},
+				{hint: 2 * time.Hour, want: maxPollInterval},
+				{hint: 10 * time.Second, want: 10 * time.Second},
+				{want: pollInterval},
+			},
+		},
+		{
+			name:    "bad config falls back to the base interval",
+			backoff: &config.PollBackoffConfig{Factor: 0.5},
+			polls:   quiet(12, pollInterval),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{PollBackoff: tt.backoff}
+			s := newPollSchedule()
+			for i, p := range tt.polls {
+				if got := s.next(cfg, p.quiet, p.hint); got != p.want {
+					t.Errorf("poll %d (quiet=%t hint=%s): delay = %s, want %s", i+1, p.quiet, p.hint, got, p.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPollBackoffConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff *config.PollBackoffConfig
+		want    pollBackoff
+		wantErr bool
+	}{
+		{"unset", nil, pollBackoff{after: defaultPollBackoffAfter, factor: defaultPollBackoffFactor, max: defaultPollBackoffMax}, false},
+		{"set", &config.PollBackoffConfig{After: 3, Factor: 2, Max: "10m"}, pollBackoff{after: 3, factor: 2, max: 10 * time.Minute}, false},
+		{"max capped", &config.PollBackoffConfig{Max: "3h"}, pollBackoff{after: defaultPollBackoffAfter, factor: defaultPollBackoffFactor, max: maxPollInterval}, false},
+		{"negative after", &config.PollBackoffConfig{After: -1}, pollBackoff{}, true},
+		{"factor below 1", &config.PollBackoffConfig{Factor: 0.9}, pollBackoff{}, true},
+		{"bad max", &config.PollBackoffConfig{Max: "soon"}, pollBackoff{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pollBackoffConfig(&config.Config{PollBackoff: tt.backoff})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+// pubkeyOutput is pubkey's --json output.
+type pubkeyOutput struct {
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	AgentID     string `json:"agent_id,omitempty"`
+}
+
+// pubkeyCmd prints the agent's public key and fingerprint, for registering
+// the agent by hand. It reads the config without side effects, so unlike run
+// it never generates a keypair.
+func pubkeyCmd(args []string) {
+	fs := flag.NewFlagSet("pubkey", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	asJSON := fs.Bool("json", false, "print JSON instead of text")
+	fs.Parse(args)
+
+	// Keep stdout for the key; errors go to stderr.
+	log.SetOutput(os.Stderr)
+
+	cfg, err := config.ReadConfig(*configPath, config.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	pub, err := publicKey(cfg.Auth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fingerprint, err := auth.Fingerprint(pub)
+	if err != nil {
+		log.Fatalf("invalid public key in %s: %v", *configPath, err)
+	}
+
+	if *asJSON {
+		out := pubkeyOutput{PublicKey: pub, Fingerprint: fingerprint}
+		if cfg.Agent != nil {
+			out.AgentID = cfg.Agent.AgentID
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+		return
+	}
+	fmt.Printf("Public key:  %s\n", pub)
+	fmt.Printf("Fingerprint: %s\n", fingerprint)
+}
+
+// publicKey returns the base64url public key the agent signs with: the
+// PKCS#11 key's if one is configured, otherwise auth.key_pair's.
+func publicKey(a *config.AuthCreds) (string, error) {
+	if a != nil && a.PKCS11 != nil {
+		key, err := a.SigningKey()
+		if err != nil {
+			return "", err
+		}
+		return auth.EncodePublicKey(key)
+	}
+	if a == nil || a.KeyPair == nil || a.KeyPair.PublicKey == "" {
+		return "", errors.New("the config has no keypair yet: start the agent once to generate one, or use certkit-agent keygen")
+	}
+	return a.KeyPair.PublicKey, nil
+}
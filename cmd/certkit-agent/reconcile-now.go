@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"log"
+)
+
+// reconcileNowCmd asks the running service to reconcile without waiting for
+// its next poll, e.g. to roll out an urgent certificate change.
+func reconcileNowCmd(args []string) {
+	fs := flag.NewFlagSet("reconcile-now", flag.ExitOnError)
+	serviceName := fs.String("service-name", defaultServiceName, "systemd service name")
+	fs.Parse(args)
+
+	if reconcileNowSignal == nil {
+		log.Fatal("reconcile-now is not supported on this platform")
+	}
+	if err := signalService(*serviceName, reconcileNowSignal); err != nil {
+		log.Fatalf("reconcile-now failed: %v", err)
+	}
+
+	log.Printf("✅ Sent %s to %s; it will reconcile now", reconcileNowSignal, *serviceName)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+// tokenRefreshInterval is the least time between two token refreshes. A 401
+// within it reuses the last refresh's outcome instead of calling the backend
+// and rewriting the config again.
+const tokenRefreshInterval = time.Minute
+
+// refresher coalesces the token refreshes of every goroutine in the agent.
+var refresher = &coalescer{interval: tokenRefreshInterval}
+
+// coalescer runs a function for many concurrent callers at most once per
+// interval: callers that arrive while a run is in flight wait for it and share
+// its result, as do those arriving within interval after it finished.
+type coalescer struct {
+	interval time.Duration
+
+	mu       sync.Mutex
+	inflight *coalescedCall
+	last     *coalescedCall
+}
+
+type coalescedCall struct {
+	done     chan struct{}
+	err      error
+	finished time.Time
+}
+
+// Do runs fn, or waits for and returns the result of a recent or running call.
+func (c *coalescer) Do(fn func() error) error {
+	c.mu.Lock()
+	if call := c.inflight; call != nil {
+		c.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	if call := c.last; call != nil && time.Since(call.finished) < c.interval {
+		c.mu.Unlock()
+		return call.err
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	c.inflight = call
+	c.mu.Unlock()
+
+	call.err = fn()
+
+	c.mu.Lock()
+	call.finished = time.Now()
+	c.inflight, c.last = nil, call
+	c.mu.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// refreshOnUnauthorized refreshes the agent's tokens if err is a 401 from the
+// backend. Concurrent and repeated 401s share one refresh (see refresher).
+func refreshOnUnauthorized(ctx context.Context, mgr *config.Manager, opts api.Options, err error) {
+	if !errors.Is(err, api.ErrUnauthorized) {
+		return
+	}
+	if err := refresher.Do(func() error { return refreshTokens(ctx, mgr, opts) }); err != nil {
+		log.Printf("Token refresh failed: %v", err)
+	}
+}
+
+// refreshTokens exchanges the refresh token for new tokens and saves them.
+func refreshTokens(ctx context.Context, mgr *config.Manager, opts api.Options) error {
+	cfg := mgr.Snapshot()
+	if cfg.Agent == nil || cfg.Agent.RefreshToken == "" {
+		return fmt.Errorf("no refresh token")
+	}
+	client, err := api.NewClientFromConfig(cfg, opts)
+	if err != nil {
+		return err
+	}
+	resp, err := client.RefreshToken(ctx, cfg.Agent.RefreshToken)
+	if err != nil {
+		return err
+	}
+	err = mgr.Update(func(cfg *config.Config) error {
+		if cfg.Agent == nil {
+			return fmt.Errorf("agent was deregistered during token refresh")
+		}
+		cfg.Agent.AccessToken = resp.AccessToken
+		if resp.RefreshToken != "" {
+			cfg.Agent.RefreshToken = resp.RefreshToken
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save refreshed tokens: %w", err)
+	}
+	log.Printf("Refreshed access token")
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescer(t *testing.T) {
+	errRefresh := errors.New("refresh failed")
+	tests := []struct {
+		name     string
+		interval time.Duration
+		wait     time.Duration // between the concurrent calls and one more
+		wantRuns int32
+	}{
+		// The late caller shares the result of the run that just finished.
+		{name: "within interval", interval: time.Hour, wantRuns: 1},
+		{name: "after interval", interval: 20 * time.Millisecond, wait: 50 * time.Millisecond, wantRuns: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &coalescer{interval: tt.interval}
+			var runs atomic.Int32
+			release := make(chan struct{})
+			fn := func() error {
+				runs.Add(1)
+				<-release
+				return errRefresh
+			}
+
+			// Callers arriving while a run is in flight wait for it.
+			const callers = 20
+			errs := make([]error, callers)
+			var started, wg sync.WaitGroup
+			started.Add(callers)
+			for i := range callers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					started.Done()
+					errs[i] = c.Do(fn)
+				}()
+			}
+			started.Wait()
+			waitFor(t, "the first run", func() bool { return runs.Load() == 1 })
+			time.Sleep(20 * time.Millisecond) // let the rest reach Do
+			close(release)
+			wg.Wait()
+			if got := runs.Load(); got != 1 {
+				t.Fatalf("%d concurrent callers ran fn %d times, want once", callers, got)
+			}
+			for i, err := range errs {
+				if !errors.Is(err, errRefresh) {
+					t.Errorf("caller %d: err = %v, want the shared result", i, err)
+				}
+			}
+
+			time.Sleep(tt.wait)
+			if err := c.Do(fn); !errors.Is(err, errRefresh) {
+				t.Errorf("late caller: err = %v", err)
+			}
+			if got := runs.Load(); got != tt.wantRuns {
+				t.Errorf("fn ran %d times, want %d", got, tt.wantRuns)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func reloadConfigCmd(args []string) {
+	fs := flag.NewFlagSet("reload-config", flag.ExitOnError)
+	serviceName := fs.String("service-name", defaultServiceName, "systemd service name")
+	fs.Parse(args)
+
+	if err := signalService(*serviceName, syscall.SIGHUP); err != nil {
+		log.Fatalf("reload-config failed: %v", err)
+	}
+
+	log.Printf("✅ Sent SIGHUP to %s; it will reload its config", *serviceName)
+}
+
+// signalService sends sig to the main process of an active systemd service.
+func signalService(serviceName string, sig os.Signal) error {
+	unit := serviceName + ".service"
+
+	out, err := exec.Command("systemctl", "is-active", unit).Output()
+	if isCmdNotFound(err) {
+		return fmt.Errorf("systemctl not found")
+	}
+	if state := strings.TrimSpace(string(out)); state != "active" {
+		return fmt.Errorf("%s is not active (state: %s)", unit, state)
+	}
+
+	pid, err := serviceMainPID(unit)
+	if err != nil {
+		return err
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := proc.Signal(sig); err != nil {
+		return fmt.Errorf("signal PID %d: %w", pid, err)
+	}
+	return nil
+}
+
+// serviceMainPID returns the main PID of a running systemd unit.
+func serviceMainPID(unit string) (int, error) {
+	out, err := exec.Command("systemctl", "show", "--property", "MainPID", "--value", unit).Output()
+	if err != nil {
+		return 0, fmt.Errorf("look up main PID of %s: %w", unit, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%s has no main PID", unit)
+	}
+	return pid, nil
+}
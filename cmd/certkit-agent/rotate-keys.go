@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+)
+
+func rotateKeysCmd(args []string) {
+	fs := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	var clientOpts api.Options
+	insecureHTTPFlag(fs, &clientOpts.AllowInsecureHTTP)
+	debug := fs.Bool("debug", false, "log API requests and responses (secrets redacted)")
+	fs.DurationVar(&clientOpts.Timeout, "timeout", 0, "per-request API timeout (default: request_timeout from config, else 30s)")
+	secureDelete := fs.Bool("secure-delete", false, "overwrite the old private key in memory and in config backups (best effort)")
+	fs.Parse(args)
+
+	setupDebug(*debug)
+
+	mgr, err := config.NewManager(*configPath, Version(), config.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := rotateKeys(mgr, clientOpts, *secureDelete); err != nil {
+		log.Fatalf("key rotation failed: %v", err)
+	}
+
+	log.Printf("✅ Rotated agent keypair (public key: %s)", mgr.Snapshot().Auth.KeyPair.PublicKey)
+}
+
+// rotateKeys replaces the agent keypair in three steps, saving config after each:
+//
+//  1. generate a new keypair and store it as pending
+//  2. register the new public key, signed with the current key
+//  3. promote the pending keypair to the active one
+//
+// If interrupted, the old key stays active and a re-run reuses the pending key,
+// skipping step 2 if the backend already took it (see pendingKeyAccepted).
+// With secureDelete, the old key is then overwritten where the agent can
+// reach it: the key this rotation decoded to sign with, the config backups,
+// and the config files the saves replaced (see holdReplacedConfig).
+func rotateKeys(mgr *config.Manager, opts api.Options, secureDelete bool) error {
+	cfg := mgr.Snapshot()
+	if cfg.Agent == nil || cfg.Agent.AgentID == "" {
+		return fmt.Errorf("agent is not enrolled yet")
+	}
+	if cfg.Auth.PKCS11 != nil {
+		return fmt.Errorf("the agent key is in a PKCS#11 token; generate the new key on the token and re-enroll")
+	}
+
+	ctx := context.Background()
+	pending, accepted := cfg.Auth.PendingKeyPair, false
+	if pending == nil {
+		keyPair, err := auth.CreateNewKeyPair()
+		if err != nil {
+			return err
+		}
+		if err := cfg.Auth.StoreKey(keyPair); err != nil {
+			return fmt.Errorf("store pending key: %w", err)
+		}
+		if secureDelete {
+			holdReplacedConfig(mgr.Path())
+		}
+		err = mgr.Update(func(cfg *config.Config) error {
+			cfg.Auth.PendingKeyPair = keyPair
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("save pending keypair: %w", err)
+		}
+		pending = keyPair
+	} else {
+		log.Printf("Resuming interrupted rotation with pending key %s", pending.PublicKey)
+		var err error
+		if accepted, err = pendingKeyAccepted(ctx, cfg, opts, pending); err != nil {
+			return err
+		}
+	}
+
+	client, err := api.NewClientFromConfig(cfg, opts)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		if err := client.RotateKey(ctx, pending.PublicKey); err != nil {
+			return err
+		}
+	}
+
+	if secureDelete {
+		holdReplacedConfig(mgr.Path())
+	}
+	err = mgr.Update(func(cfg *config.Config) error {
+		cfg.Auth.KeyPair = pending
+		cfg.Auth.PendingKeyPair = nil
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save rotated keypair: %w", err)
+	}
+	if cfg.Auth.KeyStorage == config.KeyStorageKeyring {
+		if err := auth.RemoveKeyringKey(auth.KeyringDescription(cfg.Auth.KeyPair.PublicKey)); err != nil {
+			log.Printf("⚠️  Could not remove the old key from the kernel keyring: %v", err)
+		}
+	}
+
+	if secureDelete {
+		client.WipeKey()
+		if err := shredConfigBackups(mgr.Path()); err != nil {
+			return fmt.Errorf("rotated, but %w", err)
+		}
+		log.Printf("Overwrote the old private key in memory and in the config backups")
+	}
+	return nil
+}
+
+// pendingKeyAccepted reports whether the backend already knows the agent by
+// its pending key, as after a rotation interrupted between the backend taking
+// the key and the agent saving it; the backend then rejects the old key.
+// Registering the pending key again, signed with itself, only succeeds then.
+func pendingKeyAccepted(ctx context.Context, cfg *config.Config, opts api.Options, pending *auth.KeyPair) (bool, error) {
+	withPending := cfg.Clone()
+	withPending.Auth.KeyPair = pending
+	client, err := api.NewClientFromConfig(withPending, opts)
+	if err != nil {
+		return false, err
+	}
+	err = client.RotateKey(ctx, pending.PublicKey)
+	if errors.Is(err, api.ErrUnauthorized) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	log.Printf("The backend already has the pending key")
+	return true, nil
+}
+
+// holdReplacedConfig hard-links the config file and its backups to unused
+// .bak.held.N names before a save. A save replaces them by renaming new files
+// over them, which frees their old contents, old key included, without
+// overwriting them; held, they stay reachable for shredConfigBackups.
+func holdReplacedConfig(configPath string) {
+	backups, _ := filepath.Glob(configPath + ".bak*")
+	n := 0
+	for _, p := range append([]string{configPath}, backups...) {
+		if strings.HasPrefix(p, configPath+".bak.held.") {
+			continue
+		}
+		for ; ; n++ {
+			err := os.Link(p, fmt.Sprintf("%s.bak.held.%d", configPath, n))
+			if errors.Is(err, os.ErrExist) {
+				continue
+			}
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("⚠️  Can't keep %s to overwrite after saving, so it may keep the old key on disk: %v", p, err)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/internal/testserver"
+)
+
+// rotationConfig writes the config of agentID, enrolled with srv, with the
+// given keypairs.
+func rotationConfig(t *testing.T, srv *testserver.Server, agentID string, active, pending *auth.KeyPair) string {
+	t.Helper()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, srv.CABundle(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := json.Marshal(config.AuthCreds{KeyPair: active, PendingKeyPair: pending})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeConfig(t, fmt.Sprintf(
+		`{"schema_version":1,"api_base":%q,"tls":{"ca_bundle_path":%q},"agent":{"agent_id":%q},"auth":%s}`,
+		srv.URL, bundle, agentID, creds), "")
+}
+
+func newKeyPair(t *testing.T) *auth.KeyPair {
+	t.Helper()
+	kp, err := auth.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func TestRotateKeys(t *testing.T) {
+	tests := []struct {
+		name         string
+		secureDelete bool
+	}{
+		{"keep backups", false},
+		{"secure delete", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testserver.New()
+			defer srv.Close()
+			old := newKeyPair(t)
+			agentID, err := srv.RegisterAgent(old.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := rotationConfig(t, srv, agentID, old, nil)
+			// Another name for the file the rotation replaces, to see what
+			// becomes of its contents.
+			replaced := filepath.Join(t.TempDir(), "replaced.json")
+			if err := os.Link(path, replaced); err != nil {
+				t.Skipf("no hard links: %v", err)
+			}
+
+			mgr, err := config.NewManager(path, config.VersionInfo{}, config.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rotateKeys(mgr, api.Options{}, tt.secureDelete); err != nil {
+				t.Fatalf("rotateKeys: %v", err)
+			}
+
+			cfg := mgr.Snapshot()
+			if cfg.Auth.KeyPair.PublicKey == old.PublicKey || cfg.Auth.PendingKeyPair != nil {
+				t.Fatalf("after rotating: key_pair %s, pending_key_pair %v", cfg.Auth.KeyPair.PublicKey, cfg.Auth.PendingKeyPair)
+			}
+
+			var holding []string
+			files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*"))
+			files = append(files, replaced)
+			for _, f := range files {
+				if b, err := os.ReadFile(f); err == nil && strings.Contains(string(b), old.PrivateKey) {
+					holding = append(holding, filepath.Base(f))
+				}
+			}
+			if tt.secureDelete && len(holding) > 0 {
+				t.Errorf("old private key left in %v", holding)
+			}
+			if !tt.secureDelete && len(holding) == 0 {
+				t.Error("old private key not in the backups")
+			}
+		})
+	}
+}
+
+func TestRotateKeysResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// pending is whether the config has a pending key from an interrupted
+		// rotation, and taken whether the backend already switched to it.
+		pending, taken bool
+		rotateFails    bool
+		wantRotated    bool
+	}{
+		{name: "new rotation", wantRotated: true},
+		{name: "pending key not taken yet", pending: true, wantRotated: true},
+		{name: "pending key already taken", pending: true, taken: true, wantRotated: true},
+		{name: "backend fails", rotateFails: true},
+		{name: "backend fails on resume", pending: true, rotateFails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testserver.New()
+			defer srv.Close()
+			old := newKeyPair(t)
+			var pending *auth.KeyPair
+			if tt.pending {
+				pending = newKeyPair(t)
+			}
+			registered := old
+			if tt.taken {
+				registered = pending
+			}
+			agentID, err := srv.RegisterAgent(registered.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.rotateFails {
+				srv.Respond("/rotate-key", testserver.Response{Status: http.StatusInternalServerError}, testserver.Response{Status: http.StatusInternalServerError})
+			}
+
+			mgr, err := config.NewManager(rotationConfig(t, srv, agentID, old, pending), config.VersionInfo{}, config.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rotateKeys(mgr, api.Options{}, false)
+			if (err == nil) != tt.wantRotated {
+				t.Fatalf("rotateKeys: %v, want rotated %v", err, tt.wantRotated)
+			}
+
+			// The active key is one the backend accepts, and a failed
+			// rotation keeps its pending key for the next attempt.
+			cfg := mgr.Snapshot()
+			if rotated := cfg.Auth.KeyPair.PublicKey != old.PublicKey; rotated != tt.wantRotated {
+				t.Errorf("active key rotated = %v, want %v", rotated, tt.wantRotated)
+			}
+			if (cfg.Auth.PendingKeyPair != nil) == tt.wantRotated {
+				t.Errorf("pending_key_pair = %v after rotated = %v", cfg.Auth.PendingKeyPair, tt.wantRotated)
+			}
+			if pending != nil && !tt.wantRotated && cfg.Auth.PendingKeyPair.PublicKey != pending.PublicKey {
+				t.Error("the pending key was replaced")
+			}
+			client, err := api.NewClientFromConfig(cfg, api.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := client.ReportEvent(context.Background(), api.Event{Type: api.EventReconcileSucceeded}); err != nil {
+				t.Errorf("backend rejects the active key: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/deploy"
+	"github.com/certkit-io/certkit-agent-alpha/events"
+	"github.com/certkit-io/certkit-agent-alpha/inventory"
+	"github.com/certkit-io/certkit-agent-alpha/metrics"
+	"github.com/certkit-io/certkit-agent-alpha/reconcile"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/update"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+func runCmd(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	var clientOpts api.Options
+	insecureHTTPFlag(fs, &clientOpts.AllowInsecureHTTP)
+	debug := fs.Bool("debug", false, "log API requests and responses (secrets redacted)")
+	fs.DurationVar(&clientOpts.Timeout, "timeout", 0, "per-request API timeout (default: request_timeout from config, else 30s)")
+	var configOpts config.Options
+	fs.StringVar(&configOpts.DropInDir, "config-dir", "", "directory of drop-in config overrides (default: config.d next to --config)")
+	metricsAddr := fs.String("metrics-listen", "", "serve /healthz and /metrics on this address (default: metrics_listen from config)")
+	stateDirFlag := fs.String("state-dir", "", "directory for runtime state (default: $STATE_DIRECTORY, else "+config.DefaultStateDir+")")
+	jitterFlag := fs.Duration("enroll-jitter", 0, "wait a random duration up to this before the first enrollment/poll (default: enroll_jitter from config)")
+	fs.StringVar(&configOpts.Env, "env", "", "backend to use when the config has no api_base: dev, staging or prod (default: CERTKIT_API_BASE, else CERTKIT_ENV, else prod)")
+	fs.StringVar(&configOpts.Hostname, "hostname", "", "hostname to register and report (default: hostname from config, else the kernel hostname)")
+	systemd := fs.Bool("systemd", false, "log without timestamps, for the journal (default when started by systemd)")
+	logFile := fs.String("log-file", "", "log to this file instead of stdout, e.g. when there's no journal")
+	logMaxSize := fs.Int("log-max-size", 10, "rotate --log-file once it reaches this many MB (0 disables rotation)")
+	logMaxBackups := fs.Int("log-max-backups", 5, "number of rotated --log-file backups to keep")
+	pausedFlag := fs.Bool("paused", false, "poll and report, but deploy and reload nothing (default: paused from config)")
+	fs.Parse(args)
+
+	setupDebug(*debug)
+	if *systemd {
+		setupJournalLogging()
+	}
+	if *logFile != "" {
+		f, err := utils.OpenLogFile(*logFile, int64(*logMaxSize)<<20, *logMaxBackups)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+
+	log.Printf("certkit-agent run starting (config=%s)", *configPath)
+	log.Printf("certkit-agent version: %s, commit: %s, date: %s", version, commit, date)
+
+	stateDir := config.StateDir(*stateDirFlag)
+	lock, err := lockConfig(*configPath, stateDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	mgr, err := config.NewManager(*configPath, Version(), configOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := &runner{mgr: mgr, client: clientOpts, stateDir: stateDir, pausedFlag: *pausedFlag}
+
+	if err := r.loadLastApplied(); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg := mgr.Snapshot()
+	log.Printf("API Base: %s", cfg.ApiBase)
+	if len(cfg.APIBaseFallbacks) > 0 {
+		log.Printf("API Base fallbacks: %s", strings.Join(cfg.APIBaseFallbacks, ", "))
+	}
+
+	// Fail fast on bad proxy/TLS settings rather than logging them every tick.
+	if _, err := api.NewClientFromConfig(cfg, r.client); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := pollBackoffConfig(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := reconcileOptions(cfg, stateDir); err != nil {
+		log.Fatal(err)
+	}
+	r.logPaused(nil, cfg)
+	if su := cfg.SelfUpdate; su != nil && su.Enabled {
+		if _, err := auth.DecodePublicKey(su.PublicKey); err != nil {
+			log.Fatalf("self_update.public_key: %v", err)
+		}
+	}
+
+	jitter := *jitterFlag
+	if jitter == 0 && cfg.EnrollJitter != "" {
+		var err error
+		jitter, err = time.ParseDuration(cfg.EnrollJitter)
+		if err != nil {
+			log.Fatalf("parse enroll_jitter: %v", err)
+		}
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	inventoryTicker := time.NewTicker(inventoryInterval)
+	defer inventoryTicker.Stop()
+
+	eventTicker := time.NewTicker(eventFlushInterval)
+	defer eventTicker.Stop()
+
+	updateTicker := time.NewTicker(updateInterval)
+	defer updateTicker.Stop()
+
+	metricsListen := cfg.MetricsListen
+	if *metricsAddr != "" {
+		metricsListen = *metricsAddr
+	}
+	// ctx is cancelled on SIGINT/SIGTERM, aborting any API call in flight.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	defer r.flushOnShutdown()
+
+	if metricsListen != "" {
+		go func() {
+			if err := metrics.Serve(ctx, metricsListen); err != nil {
+				log.Printf("metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	// SIGUSR1 (reconcile-now) reconciles out of cycle. Any number of them
+	// during a reconcile add up to one more, as the channel holds just one.
+	nowCh := make(chan os.Signal, 1)
+	notifyReconcileNow(nowCh)
+
+	// Spread the first request of a fleet booted at once (e.g. a scale-out from
+	// one image) so they don't all hit the backend in the same second.
+	if jitter > 0 {
+		delay := rand.N(jitter)
+		log.Printf("Waiting %s before first poll (enroll jitter up to %s)", delay.Round(time.Second), jitter)
+		timer := time.NewTimer(delay)
+	wait:
+		for {
+			select {
+			case <-hupCh:
+				r.reloadConfig()
+			case <-ctx.Done():
+				log.Printf("received shutdown signal, shutting down")
+				return
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+
+	polls := newPollSchedule()
+	quiet, hint := r.runOnce(ctx)
+	ticker.Reset(polls.next(mgr.Snapshot(), quiet, hint))
+	r.reportInventory(ctx)
+	updatedTo, restart := r.selfUpdate(ctx, "")
+	if restart {
+		return
+	}
+
+	// Reconcile as soon as the backend reports a change, between ticks.
+	changed := make(chan struct{}, 1)
+	go watchDesiredState(ctx, mgr, r.client, r.appliedVersion, changed)
+
+	// Block until systemd tells us to stop.
+	for {
+		select {
+		case <-hupCh:
+			r.reloadConfig()
+		case <-ctx.Done():
+			log.Printf("received shutdown signal, shutting down")
+			return
+		case <-ticker.C:
+			quiet, hint := r.runOnce(ctx)
+			ticker.Reset(polls.next(mgr.Snapshot(), quiet, hint))
+		case <-changed:
+			log.Printf("Desired state changed, reconciling now")
+			quiet, hint := r.runOnce(ctx)
+			ticker.Reset(polls.next(mgr.Snapshot(), quiet, hint))
+		case sig := <-nowCh:
+			log.Printf("Received %s, reconciling now", sig)
+			quiet, hint := r.runOnce(ctx)
+			ticker.Reset(polls.next(mgr.Snapshot(), quiet, hint))
+		case <-inventoryTicker.C:
+			r.reportInventory(ctx)
+		case <-eventTicker.C:
+			r.flushEvents(ctx)
+		case <-updateTicker.C:
+			if updatedTo, restart = r.selfUpdate(ctx, updatedTo); restart {
+				return
+			}
+		}
+	}
+}
+
+// runner is the state of the run loop: the config, the settings from the
+// command line that every API client it builds gets, and what it last
+// deployed.
+type runner struct {
+	mgr      *config.Manager
+	client   api.Options
+	stateDir string // see config.StateDir
+	// pausedFlag is --paused, which pauses the agent whatever the config says.
+	pausedFlag bool
+	// retryNotBefore holds off API calls after the backend answers 429 with
+	// Retry-After.
+	retryNotBefore time.Time
+
+	// lastApplied is what the agent last deployed, persisted in stateDir.
+	lastApplied *state.Applied
+	// lastAppliedVersion is lastApplied's version, for the long-poll goroutine.
+	lastAppliedVersion atomic.Value // string
+}
+
+// reloadConfig re-reads the config file, keeping the current one if it's invalid.
+func (r *runner) reloadConfig() {
+	mgr := r.mgr
+	log.Printf("received SIGHUP, reloading config %s", mgr.Path())
+	prev := mgr.Snapshot()
+	// Re-read CA bundles and client certificates, even if their paths are unchanged.
+	api.ResetTransport()
+	err := mgr.Reload(func(cfg *config.Config) error {
+		if _, err := api.NewClientFromConfig(cfg, r.client); err != nil {
+			return err
+		}
+		if _, err := pollBackoffConfig(cfg); err != nil {
+			return err
+		}
+		_, err := reconcileOptions(cfg, r.stateDir)
+		return err
+	})
+	if err != nil {
+		log.Printf("config reload failed, keeping previous config: %v", err)
+		return
+	}
+	log.Printf("config reloaded")
+	r.logPaused(prev, mgr.Snapshot())
+}
+
+// reconcileOptions returns the reconcile options cfg asks for, reading
+// deploy_trust_bundle afresh, with state and host keys kept in stateDir.
+func reconcileOptions(cfg *config.Config, stateDir string) (reconcile.Options, error) {
+	roots, err := deploy.LoadTrustRoots(cfg.DeployTrustBundle)
+	if err != nil {
+		return reconcile.Options{}, err
+	}
+	return reconcile.Options{
+		Concurrency:        cfg.DeployConcurrency,
+		Paused:             cfg.Paused,
+		TrustUpdateCommand: cfg.CATrustUpdateCommand,
+		TrustRoots:         roots,
+		StateDir:           stateDir,
+		KeyDir:             config.KeyDir(stateDir),
+	}, nil
+}
+
+// reconcileOptions is reconcileOptions for the run loop, which --paused
+// pauses too.
+func (r *runner) reconcileOptions(cfg *config.Config) (reconcile.Options, error) {
+	opts, err := reconcileOptions(cfg, r.stateDir)
+	opts.Paused = r.paused(cfg)
+	return opts, err
+}
+
+// paused reports whether cfg or --paused pauses the agent.
+func (r *runner) paused(cfg *config.Config) bool {
+	return r.pausedFlag || cfg.Paused
+}
+
+// logPaused logs the agent pausing or resuming as cfg replaces prev (nil at
+// startup).
+func (r *runner) logPaused(prev, cfg *config.Config) {
+	was := prev != nil && r.paused(prev)
+	switch {
+	case r.paused(cfg) && !was:
+		log.Printf("Paused: polling and reporting only, nothing will be deployed or reloaded")
+	case !r.paused(cfg) && was:
+		log.Printf("Resumed: deploying again")
+	}
+}
+
+// setLastApplied replaces lastApplied. Only the run loop calls it.
+func (r *runner) setLastApplied(applied *state.Applied) {
+	r.lastApplied = applied
+	version := ""
+	if applied != nil {
+		version = applied.Version
+	}
+	r.lastAppliedVersion.Store(version)
+}
+
+// appliedVersion returns the version of the last applied desired state, or ""
+// if nothing has been applied yet. It is safe to call from any goroutine.
+func (r *runner) appliedVersion() string {
+	version, _ := r.lastAppliedVersion.Load().(string)
+	return version
+}
+
+// loadLastApplied restores lastApplied from the state directory. Older agents
+// kept it in the config as last_applied; that is moved over on first start.
+// The last reconcile's result is restored to /healthz too.
+func (r *runner) loadLastApplied() error {
+	mgr := r.mgr
+	applied, err := config.ReadLastApplied(r.stateDir)
+	if err != nil {
+		return err
+	}
+	cfg := mgr.Snapshot()
+	if applied == nil && cfg.LastApplied != nil {
+		log.Printf("Moving last_applied from %s to %s", mgr.Path(), r.stateDir)
+		applied = cfg.LastApplied
+		if err := config.SaveLastApplied(r.stateDir, applied); err != nil {
+			return fmt.Errorf("save last applied state: %w", err)
+		}
+	}
+	if cfg.LastApplied != nil {
+		err := mgr.Update(func(cfg *config.Config) error {
+			cfg.LastApplied = nil
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	r.setLastApplied(applied)
+
+	last, err := config.ReadLastReconcile(r.stateDir)
+	if err != nil {
+		log.Printf("Ignoring last reconcile result: %v", err)
+	} else if last != nil {
+		metrics.SetLastReconcile(last)
+	}
+	return nil
+}
+
+// lockConfig makes sure only one agent runs against configPath, e.g. not a
+// manual `run` alongside the service. The lock lives in the state directory
+// dir, named after the config's absolute path.
+func lockConfig(configPath, dir string) (*utils.FileLock, error) {
+	abs, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	name := "run-" + hex.EncodeToString(sum[:6]) + ".lock"
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		// Likely a manual run without root; still guard against a second manual run.
+		dir = os.TempDir()
+	}
+
+	lock, err := utils.Lock(filepath.Join(dir, name))
+	if errors.Is(err, utils.ErrLocked) {
+		return nil, fmt.Errorf("another certkit-agent is already running with config %s: %w (stop it first, e.g. systemctl stop %s)", abs, err, defaultServiceName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %w", filepath.Join(dir, name), err)
+	}
+	return lock, nil
+}
+
+// flushEvents sends queued events, once the agent can sign requests.
+func (r *runner) flushEvents(ctx context.Context) {
+	cfg := r.mgr.Snapshot()
+	if events.Len() == 0 || cfg.Agent == nil || cfg.Agent.AgentID == "" || api.CircuitOpen() {
+		return
+	}
+	client, err := api.NewClientFromConfig(cfg, r.client)
+	if err != nil {
+		return
+	}
+	if err := events.Flush(ctx, client); err != nil {
+		log.Printf("Failed to report events (%d queued): %v", events.Len(), err)
+		refreshOnUnauthorized(ctx, r.mgr, r.client, err)
+	}
+}
+
+// flushOnShutdown gives events still queued at shutdown a last, short chance
+// to go out. The run loop's context is cancelled by then, so it has its own.
+func (r *runner) flushOnShutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownFlushTimeout)
+	defer cancel()
+	r.flushEvents(ctx)
+}
+
+// runOnce enrolls the agent if needed, then reconciles against the desired
+// state. It reports whether the poll was quiet, i.e. succeeded without a
+// change, which is what counts towards poll_backoff, and the backend's
+// next_poll_after hint, if it fetched a desired state carrying one.
+func (r *runner) runOnce(ctx context.Context) (quiet bool, hint time.Duration) {
+	mgr := r.mgr
+	cfg := mgr.Snapshot()
+
+	if time.Now().Before(r.retryNotBefore) {
+		log.Printf("Rate limited by backend, skipping until %s", r.retryNotBefore.Format(time.RFC3339))
+		return false, 0
+	}
+	if api.CircuitOpen() {
+		// Already logged when the circuit opened; don't repeat it every tick.
+		_, until := api.CircuitState()
+		slog.Debug("API circuit open, skipping reconcile", "until", until)
+		return false, 0
+	}
+
+	if cfg.Agent == nil || cfg.Agent.AgentID == "" {
+		if err := enroll(ctx, mgr, r.client, false); err != nil {
+			log.Printf("Enrollment failed: %v", err)
+			if errors.Is(err, api.ErrRateLimited) {
+				r.retryNotBefore = time.Now().Add(api.RetryAfter(err))
+			}
+			return false, 0
+		}
+		cfg = mgr.Snapshot()
+	}
+
+	client, err := api.NewClientFromConfig(cfg, r.client)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return false, 0
+	}
+	opts, err := r.reconcileOptions(cfg)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return false, 0
+	}
+
+	start := time.Now()
+	prev := r.lastApplied
+	applied, err := reconcile.Reconcile(ctx, client, prev, opts)
+	metrics.ReconcileFinished(err)
+	r.recordReconcile(start, prev, applied, err)
+	if err != nil {
+		events.Record(api.Event{Type: api.EventReconcileFailed, Error: err.Error()})
+	} else if applied != prev {
+		events.Record(api.Event{Type: api.EventReconcileSucceeded})
+	} else {
+		quiet = true
+	}
+	if applied != prev {
+		r.setLastApplied(applied)
+		if err := config.SaveLastApplied(r.stateDir, applied); err != nil {
+			log.Printf("failed to save last applied state: %v", err)
+		}
+	}
+	if err != nil {
+		log.Printf("Error: %v", err)
+		if errors.Is(err, api.ErrRateLimited) {
+			r.retryNotBefore = time.Now().Add(api.RetryAfter(err))
+		}
+		refreshOnUnauthorized(ctx, mgr, r.client, err)
+	}
+	return quiet, client.NextPollAfter()
+}
+
+// recordReconcile publishes the result of a reconcile to /healthz and saves
+// it to the state directory for status.
+func (r *runner) recordReconcile(start time.Time, prev, next *state.Applied, err error) {
+	result := &state.ReconcileResult{
+		Time:            start,
+		DurationSeconds: time.Since(start).Seconds(),
+		TargetsApplied:  state.ChangedTargets(prev, next),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	metrics.SetLastReconcile(result)
+	if err := config.SaveLastReconcile(r.stateDir, result); err != nil {
+		log.Printf("failed to save last reconcile result: %v", err)
+	}
+}
+
+// reportInventory scans this host for certificates and services and reports them.
+func (r *runner) reportInventory(ctx context.Context) {
+	cfg := r.mgr.Snapshot()
+	if cfg.Agent == nil || cfg.Agent.AgentID == "" || api.CircuitOpen() {
+		return
+	}
+
+	client, err := api.NewClientFromConfig(cfg, r.client)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	inv := inventory.Collect(cfg.InventoryPaths)
+	inv.Hostname = cfg.ReportedHostname()
+	for _, e := range inv.Errors {
+		log.Printf("Inventory: %s", e)
+	}
+	if err := client.ReportInventory(ctx, inv); err != nil {
+		log.Printf("Error: %v", err)
+		refreshOnUnauthorized(ctx, r.mgr, r.client, err)
+		return
+	}
+	log.Printf("Reported inventory: %d certificates", len(inv.Certificates))
+}
+
+// selfUpdate installs a newer release if self_update is enabled and the backend
+// offers one. installed is the version an earlier call installed, if any:
+// until the agent is restarted it keeps running the old version, so that one
+// isn't re-downloaded. It returns the version installed now (else installed),
+// and whether the agent should exit so systemd restarts it on the new binary
+// (the unit has Restart=always).
+func (r *runner) selfUpdate(ctx context.Context, installed string) (string, bool) {
+	cfg := r.mgr.Snapshot()
+	if cfg.SelfUpdate == nil || !cfg.SelfUpdate.Enabled || cfg.Agent == nil || cfg.Agent.AgentID == "" || api.CircuitOpen() {
+		return installed, false
+	}
+	if r.paused(cfg) {
+		slog.Debug("Self-update: skipping while paused")
+		return installed, false
+	}
+	if version == "dev" {
+		slog.Debug("Self-update: skipping development build")
+		return installed, false
+	}
+
+	pub, err := auth.DecodePublicKey(cfg.SelfUpdate.PublicKey)
+	if err != nil {
+		log.Printf("Self-update: self_update.public_key: %v", err)
+		return installed, false
+	}
+	client, err := api.NewClientFromConfig(cfg, r.client)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return installed, false
+	}
+
+	info, err := client.CheckUpdate(ctx, version)
+	if err != nil {
+		log.Printf("Self-update: %v", err)
+		return installed, false
+	}
+	if !info.Available || info.Version == installed {
+		return installed, false
+	}
+	// Apply checks the signed manifest's version too; this just saves the download.
+	if newer, err := update.Newer(info.Version, version); err != nil || !newer {
+		if err != nil {
+			log.Printf("Self-update: %v", err)
+		}
+		return installed, false
+	}
+
+	log.Printf("Self-update: downloading %s (running %s)", info.Version, version)
+	if err := applyUpdate(ctx, client, info, pub); err != nil {
+		log.Printf("Self-update: %s not installed: %v", info.Version, err)
+		events.Record(api.Event{Type: api.EventUpdateFailed, Version: info.Version, Error: err.Error()})
+		return installed, false
+	}
+	events.Record(api.Event{Type: api.EventUpdated, Version: info.Version})
+
+	if os.Getenv("INVOCATION_ID") == "" {
+		log.Printf("Self-update: installed %s; not running under systemd, so restart certkit-agent to run it", info.Version)
+		return info.Version, false
+	}
+	log.Printf("Self-update: installed %s; exiting so systemd restarts the agent on it", info.Version)
+	return info.Version, true
+}
+
+// applyUpdate downloads the release in info and, once its manifest's signature
+// verifies against pub and the binary and version match it, swaps it in for
+// the running binary.
+func applyUpdate(ctx context.Context, client *api.Client, info *api.UpdateInfo, pub ed25519.PublicKey) error {
+	binary, err := client.DownloadUpdate(ctx, info)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+	if err := update.Apply(exe, binary, info.Manifest, info.Signature, pub, version); err != nil {
+		return err
+	}
+	log.Printf("Self-update: replaced %s (previous binary kept at %s)", exe, update.PrevPath(exe))
+	return nil
+}
+
+// enroll registers this agent with the backend and persists the issued AgentID.
+// keepBootstrap (enroll --keep-bootstrap) keeps the bootstrap credentials in
+// the config, as keep_bootstrap does.
+func enroll(ctx context.Context, mgr *config.Manager, opts api.Options, keepBootstrap bool) error {
+	cfg := mgr.Snapshot()
+	client, err := api.NewClientFromConfig(cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.InstallAgent(ctx, api.NewInstallRequest(cfg))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Enrolled as agent %s", response.AgentId)
+	events.Record(api.Event{Type: api.EventEnrolled})
+
+	if response.ServerKeyID != "" {
+		log.Printf("Backend signs responses with key %s", response.ServerKeyID)
+	}
+
+	return saveEnrollment(mgr, keepBootstrap, response.AgentCreds())
+}
+
+// saveEnrollment stores the agent credentials from a successful enrollment.
+// The bootstrap credentials are only needed to enroll, so they're removed from
+// the config, and from its backups, unless they're kept to enroll again: with
+// keep_bootstrap or --keep-bootstrap, or with the key in the kernel keyring,
+// which a reboot clears.
+func saveEnrollment(mgr *config.Manager, keepBootstrap bool, creds *config.AgentCreds) error {
+	dropped := false
+	err := mgr.Update(func(cfg *config.Config) error {
+		cfg.Agent = creds
+		keep := keepBootstrap || cfg.KeepBootstrap || (cfg.Auth != nil && cfg.Auth.KeyStorage == config.KeyStorageKeyring)
+		if cfg.Bootstrap != nil && !keep {
+			cfg.Bootstrap = nil
+			dropped = true
+		}
+		return nil
+	})
+	if err != nil || !dropped {
+		return err
+	}
+	if err := shredConfigBackups(mgr.Path()); err != nil {
+		log.Printf("⚠️  Removed the bootstrap credentials from %s, but its backups still hold them: %v", mgr.Path(), err)
+		return nil
+	}
+	log.Printf("Removed the bootstrap credentials from %s and its backups", mgr.Path())
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/events"
+	"github.com/certkit-io/certkit-agent-alpha/internal/testserver"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+func TestFlushOnShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		enrolled bool
+		wantSent bool
+	}{
+		{"enrolled", true, true},
+		// It can't sign requests yet; the events stay queued.
+		{"not enrolled", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := testserver.New()
+			defer srv.Close()
+			kp := newKeyPair(t)
+			agentID := ""
+			if tt.enrolled {
+				var err error
+				if agentID, err = srv.RegisterAgent(kp.PublicKey); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mgr, err := config.NewManager(rotationConfig(t, srv, agentID, kp, nil), config.VersionInfo{}, config.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Other tests may have left events queued, so look for these by name.
+			for range 3 {
+				events.Record(api.Event{Type: api.EventReconcileFailed, Error: t.Name()})
+			}
+			queued := events.Len()
+
+			(&runner{mgr: mgr}).flushOnShutdown()
+
+			sent := 0
+			for _, e := range srv.Events() {
+				if e.Error == t.Name() {
+					sent++
+				}
+			}
+			if tt.wantSent && (sent != 3 || events.Len() != 0) {
+				t.Errorf("%d events sent, %d still queued; want all 3 sent", sent, events.Len())
+			}
+			if !tt.wantSent && (sent != 0 || events.Len() != queued) {
+				t.Errorf("%d events sent, %d of %d still queued; want none sent", sent, events.Len(), queued)
+			}
+		})
+	}
+}
+
+func TestRecordReconcile(t *testing.T) {
+	r := &runner{stateDir: t.TempDir()}
+
+	applied := &state.Applied{Hash: "h1", Targets: map[string]string{"t1": "a", "t2": "b"}}
+	// Each step runs after the previous one, against the same state directory.
+	steps := []struct {
+		name        string
+		prev, next  *state.Applied
+		err         error
+		wantError   string
+		wantApplied int
+	}{
+		{name: "failure", err: errors.New("reload nginx: exit status 1"), wantError: "reload nginx: exit status 1"},
+		{name: "success clears the error", next: applied, wantApplied: 2},
+		{name: "nothing changed", prev: applied, next: applied},
+		{name: "failure keeping the applied state", prev: applied, next: applied, err: errors.New("fetch desired state: timeout"), wantError: "fetch desired state: timeout"},
+	}
+	for _, step := range steps {
+		start := time.Now()
+		r.recordReconcile(start, step.prev, step.next, step.err)
+
+		got, err := config.ReadLastReconcile(r.stateDir)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got == nil {
+			t.Fatalf("%s: no result saved", step.name)
+		}
+		if got.Error != step.wantError {
+			t.Errorf("%s: error = %q, want %q", step.name, got.Error, step.wantError)
+		}
+		if got.TargetsApplied != step.wantApplied {
+			t.Errorf("%s: targets applied = %d, want %d", step.name, got.TargetsApplied, step.wantApplied)
+		}
+		if !got.Time.Equal(start) || got.DurationSeconds < 0 {
+			t.Errorf("%s: time %v, duration %v; want %v and >= 0", step.name, got.Time, got.DurationSeconds, start)
+		}
+	}
+}
+
+func TestRunnerPaused(t *testing.T) {
+	tests := []struct {
+		name   string
+		flag   bool // --paused
+		config bool // paused in the config
+		want   bool
+	}{
+		{name: "neither"},
+		{name: "config", config: true, want: true},
+		{name: "--paused", flag: true, want: true},
+		{name: "both", flag: true, config: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &runner{stateDir: t.TempDir(), pausedFlag: tt.flag}
+			opts, err := r.reconcileOptions(&config.Config{Paused: tt.config})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.Paused != tt.want {
+				t.Errorf("Paused = %v, want %v", opts.Paused, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockConfig(t *testing.T) {
+	stateDir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	lock, err := lockConfig(path, stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lockConfig(path, stateDir)
+	if !errors.Is(err, utils.ErrLocked) || !strings.Contains(err.Error(), "another certkit-agent is already running") {
+		t.Fatalf("second lock: err = %v, want another instance running", err)
+	}
+
+	// Another config's agent may run alongside.
+	other, err := lockConfig(filepath.Join(filepath.Dir(path), "other.json"), stateDir)
+	if err != nil {
+		t.Fatalf("lock another config: %v", err)
+	}
+	other.Unlock()
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := lockConfig(path, stateDir)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	again.Unlock()
+}
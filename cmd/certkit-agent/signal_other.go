@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// reconcileNowSignal is nil where there's no SIGUSR1.
+var reconcileNowSignal os.Signal
+
+func notifyReconcileNow(c chan<- os.Signal) {}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reconcileNowSignal asks a running agent to reconcile immediately; see
+// reconcile-now.
+var reconcileNowSignal os.Signal = syscall.SIGUSR1
+
+// notifyReconcileNow relays reconcileNowSignal to c.
+func notifyReconcileNow(c chan<- os.Signal) {
+	signal.Notify(c, reconcileNowSignal)
+}
@@ -0,0 +1,56 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReconcileNowCoalesces(t *testing.T) {
+	tests := []struct {
+		name    string
+		signals int // sent while a reconcile is running
+	}{
+		{"once", 1},
+		{"repeatedly", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// As in runCmd: the loop isn't reading while it reconciles.
+			nowCh := make(chan os.Signal, 1)
+			notifyReconcileNow(nowCh)
+			defer signal.Stop(nowCh)
+
+			for range tt.signals {
+				if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Delivery is asynchronous; let every signal arrive first.
+			deadline := time.Now().Add(5 * time.Second)
+			for len(nowCh) == 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(100 * time.Millisecond)
+
+			// The reconcile finishes: the loop picks up one more, and only one.
+			select {
+			case sig := <-nowCh:
+				if sig != reconcileNowSignal {
+					t.Errorf("received %v, want %v", sig, reconcileNowSignal)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("signal not relayed")
+			}
+			select {
+			case <-nowCh:
+				t.Error("signals during one reconcile triggered more than one more")
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// agentStatus is printed by `status --output json`.
+type agentStatus struct {
+	AgentID        string                 `json:"agent_id,omitempty"`
+	APIBase        string                 `json:"api_base"`
+	AppliedTargets int                    `json:"applied_targets"`
+	LastReconcile  *state.ReconcileResult `json:"last_reconcile,omitempty"`
+}
+
+// statusCmd prints the agent's enrollment and the outcome of its last
+// reconcile, as saved in the state directory by run.
+func statusCmd(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	stateDirFlag := fs.String("state-dir", "", "directory for runtime state (default: $STATE_DIRECTORY, else "+config.DefaultStateDir+")")
+	output := fs.String("output", "text", "output format: text or json")
+	fs.Parse(args)
+
+	// Keep stdout for the status; errors go to stderr.
+	log.SetOutput(os.Stderr)
+
+	if *output != "text" && *output != "json" {
+		log.Fatalf("--output must be text or json: %s", *output)
+	}
+
+	cfg, err := config.ReadConfig(*configPath, config.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	stateDir := config.StateDir(*stateDirFlag)
+	applied, err := config.ReadLastApplied(stateDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	last, err := config.ReadLastReconcile(stateDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	status := agentStatus{APIBase: cfg.ApiBase, LastReconcile: last}
+	if cfg.Agent != nil {
+		status.AgentID = cfg.Agent.AgentID
+	}
+	if applied != nil {
+		status.AppliedTargets = len(applied.Targets)
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(status)
+		return
+	}
+
+	agentID := status.AgentID
+	if agentID == "" {
+		agentID = "(not enrolled)"
+	}
+	fmt.Printf("Agent:           %s\n", agentID)
+	fmt.Printf("API base:        %s\n", status.APIBase)
+	fmt.Printf("Applied targets: %d\n", status.AppliedTargets)
+	if last == nil {
+		fmt.Println("Last reconcile:  never")
+		return
+	}
+	fmt.Printf("Last reconcile:  %s (%s ago, took %s)\n", last.Time.UTC().Format(time.RFC3339),
+		time.Since(last.Time).Round(time.Second), time.Duration(last.DurationSeconds*float64(time.Second)).Round(time.Millisecond))
+	fmt.Printf("Targets applied: %d\n", last.TargetsApplied)
+	if last.Error != "" {
+		fmt.Printf("Last error:      %s\n", last.Error)
+	} else {
+		fmt.Println("Last error:      none")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+type uninstallOptions struct {
+	ServiceName  string
+	UnitDir      string
+	ConfigPath   string
+	NoDeregister bool
+	KeepConfig   bool
+	SecureDelete bool
+	Client       api.Options
+}
+
+func uninstallCmd(args []string) {
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	var opts uninstallOptions
+	fs.StringVar(&opts.ServiceName, "service-name", defaultServiceName, "systemd service name")
+	fs.StringVar(&opts.UnitDir, "unit-dir", defaultUnitPath, "systemd unit directory")
+	fs.StringVar(&opts.ConfigPath, "config", defaultConfigPath, "path to config file (.json, .yaml or .yml)")
+	insecureHTTPFlag(fs, &opts.Client.AllowInsecureHTTP)
+	fs.BoolVar(&opts.NoDeregister, "no-deregister", false, "don't tell the backend this agent is being decommissioned")
+	fs.BoolVar(&opts.KeepConfig, "keep-config", false, "leave the config file (and its keypair) in place")
+	fs.BoolVar(&opts.SecureDelete, "secure-delete", false, "overwrite the config file and its backups before removing them (best effort)")
+	debug := fs.Bool("debug", false, "log API requests and responses (secrets redacted)")
+	fs.DurationVar(&opts.Client.Timeout, "timeout", 0, "per-request API timeout (default: request_timeout from config, else 30s)")
+	fs.Parse(args)
+
+	setupDebug(*debug)
+
+	if err := uninstall(opts); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("✅ Uninstalled %s", opts.ServiceName)
+}
+
+// uninstall stops and removes the service, deregisters the agent and removes
+// its config. Deregistration is best-effort: if the backend can't be reached
+// the local cleanup still happens.
+func uninstall(opts uninstallOptions) error {
+	if err := requireRoot(); err != nil {
+		return err
+	}
+
+	unit := opts.ServiceName + ".service"
+	unitPath := filepath.Join(opts.UnitDir, unit)
+
+	// Stop first so the daemon doesn't keep polling with credentials we're about to retire.
+	if err := utils.RunCmdLogged("systemctl", "disable", "--now", unit); err != nil {
+		log.Printf("⚠️  systemctl disable --now %s failed: %v", unit, err)
+	}
+
+	if !opts.NoDeregister {
+		deregister(opts.ConfigPath, opts.Client)
+	}
+
+	if err := os.Remove(unitPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove unit file %s: %w", unitPath, err)
+	}
+	if err := utils.RunCmdLogged("systemctl", "daemon-reload"); err != nil {
+		log.Printf("⚠️  systemctl daemon-reload failed: %v", err)
+	}
+
+	if !opts.KeepConfig {
+		if err := removeConfig(opts.ConfigPath, opts.SecureDelete); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deregister marks the agent inactive in the backend, warning on any failure.
+func deregister(configPath string, opts api.Options) {
+	cfg, err := config.ReadConfig(configPath, config.Options{})
+	if err != nil {
+		log.Printf("⚠️  Skipping deregistration: %v", err)
+		return
+	}
+	if cfg.Agent == nil || cfg.Agent.AgentID == "" {
+		log.Printf("Agent was never enrolled; nothing to deregister")
+		return
+	}
+
+	client, err := api.NewClientFromConfig(&cfg, opts)
+	if err == nil {
+		err = client.Deregister(context.Background())
+	}
+	if err != nil {
+		log.Printf("⚠️  Could not deregister agent %s: %v", cfg.Agent.AgentID, err)
+		log.Printf("⚠️  Removing it locally anyway; deactivate it in CertKit if it still shows as active")
+		return
+	}
+
+	log.Printf("Deregistered agent %s", cfg.Agent.AgentID)
+}
+
+// removeConfig deletes the config file and its backups, overwriting them
+// first if shred is set (see utils.ShredFile).
+func removeConfig(configPath string, shred bool) error {
+	paths, _ := filepath.Glob(configPath + ".bak*")
+	paths = append([]string{configPath}, paths...)
+	for _, p := range paths {
+		remove := os.Remove
+		if shred {
+			remove = utils.ShredFile
+		}
+		if err := remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+	}
+	if err := os.Remove(configPath + ".lock"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", configPath+".lock", err)
+	}
+	log.Printf("Removed %s", configPath)
+	return nil
+}
+
+// shredConfigBackups overwrites and removes the backups of the config at
+// configPath, e.g. once they only hold a retired key.
+func shredConfigBackups(configPath string) error {
+	paths, _ := filepath.Glob(configPath + ".bak*")
+	for _, p := range paths {
+		if err := utils.ShredFile(p); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+func TestDeregister(t *testing.T) {
+	kp, err := auth.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := auth.DecodePublicKey(kp.PublicKey)
+	keyPair, _ := json.Marshal(kp)
+
+	tests := []struct {
+		name   string
+		agent  string // agent_id in the config, "" if not enrolled
+		status int    // the backend's answer
+		want   []string
+	}{
+		{"enrolled", "agent-1", http.StatusOK, []string{"POST /deregister agent-1"}},
+		// Best effort: a failure is only logged.
+		{"backend fails", "agent-1", http.StatusInternalServerError, []string{"POST /deregister agent-1"}},
+		{"not enrolled", "", http.StatusOK, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var got []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lookup := func(string) (ed25519.PublicKey, error) { return pub, nil }
+				agentID, err := auth.VerifyRequest(r, lookup, time.Now(), auth.VerifyOptions{MaxAge: auth.DefaultMaxAge})
+				if err != nil {
+					t.Errorf("%s %s: verify: %v", r.Method, r.URL.Path, err)
+				}
+				mu.Lock()
+				got = append(got, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path[len(api.DefaultAPIPrefix):], agentID))
+				mu.Unlock()
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			agent := ""
+			if tt.agent != "" {
+				agent = fmt.Sprintf(`,"agent":{"agent_id":%q}`, tt.agent)
+			}
+			path := writeConfig(t, fmt.Sprintf(`{"schema_version":1,"api_base":%q,"auth":{"key_pair":%s}%s}`, srv.URL, keyPair, agent), "")
+
+			deregister(path, plainHTTP)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("requests = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
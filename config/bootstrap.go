@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BootstrapSource says where to look for bootstrap secrets besides the environment.
+type BootstrapSource struct {
+	AccessKeyFile string
+	SecretKeyFile string
+}
+
+// Names of the systemd credentials (LoadCredential=/SetCredential=) read from
+// $CREDENTIALS_DIRECTORY.
+const (
+	accessKeyCredential = "access_key"
+	secretKeyCredential = "secret_key"
+)
+
+// ResolveBootstrap finds the bootstrap secrets, each one independently, in order:
+//
+//  1. the file given in src
+//  2. the systemd credential in $CREDENTIALS_DIRECTORY
+//  3. the ACCESS_KEY/SECRET_KEY environment variables
+//
+// It returns nil if either secret is missing from all sources.
+func ResolveBootstrap(src BootstrapSource) (*BootstrapCreds, error) {
+	access, err := resolveSecret(src.AccessKeyFile, accessKeyCredential, "ACCESS_KEY")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := resolveSecret(src.SecretKeyFile, secretKeyCredential, "SECRET_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if access == "" || secret == "" {
+		return nil, nil
+	}
+	return &BootstrapCreds{
+		AccessKey: access,
+		SecretKey: secret,
+	}, nil
+}
+
+func resolveSecret(file, credential, env string) (string, error) {
+	if file != "" {
+		return readSecretFile(file)
+	}
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		v, err := readSecretFile(filepath.Join(dir, credential))
+		if err == nil {
+			return v, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return os.Getenv(env), nil
+}
+
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret: %w", err)
+	}
+	v := strings.TrimSpace(string(b))
+	if v == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return v, nil
+}
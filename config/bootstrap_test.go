@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveBootstrap(t *testing.T) {
+	type sources struct {
+		file, credential, env string // "" if not set; "-" for an empty file or credential
+	}
+	tests := []struct {
+		name           string
+		access, secret sources
+		want           *BootstrapCreds
+		wantErr        bool
+	}{
+		{
+			name:   "env",
+			access: sources{env: "ak-env"},
+			secret: sources{env: "sk-env"},
+			want:   &BootstrapCreds{AccessKey: "ak-env", SecretKey: "sk-env"},
+		},
+		{
+			name:   "credential over env",
+			access: sources{credential: "ak-cred", env: "ak-env"},
+			secret: sources{credential: "sk-cred", env: "sk-env"},
+			want:   &BootstrapCreds{AccessKey: "ak-cred", SecretKey: "sk-cred"},
+		},
+		{
+			name:   "file over credential and env",
+			access: sources{file: "ak-file", credential: "ak-cred", env: "ak-env"},
+			secret: sources{file: "sk-file", credential: "sk-cred", env: "sk-env"},
+			want:   &BootstrapCreds{AccessKey: "ak-file", SecretKey: "sk-file"},
+		},
+		{
+			name:   "each secret resolved independently",
+			access: sources{file: "ak-file", env: "ak-env"},
+			secret: sources{credential: "sk-cred", env: "sk-env"},
+			want:   &BootstrapCreds{AccessKey: "ak-file", SecretKey: "sk-cred"},
+		},
+		{
+			name:   "missing credential falls through to env",
+			access: sources{env: "ak-env"},
+			secret: sources{credential: "sk-cred"},
+			want:   &BootstrapCreds{AccessKey: "ak-env", SecretKey: "sk-cred"},
+		},
+		{
+			name:   "whitespace trimmed",
+			access: sources{file: " ak-file\n"},
+			secret: sources{credential: "sk-cred\n"},
+			want:   &BootstrapCreds{AccessKey: "ak-file", SecretKey: "sk-cred"},
+		},
+		{
+			name:   "secret missing",
+			access: sources{env: "ak-env"},
+			want:   nil,
+		},
+		{
+			name:    "empty file",
+			access:  sources{file: "-", env: "ak-env"},
+			secret:  sources{env: "sk-env"},
+			wantErr: true,
+		},
+		{
+			name:    "empty credential",
+			access:  sources{env: "ak-env"},
+			secret:  sources{credential: "-", env: "sk-env"},
+			wantErr: true,
+		},
+	}
+
+	write := func(t *testing.T, path, content string) {
+		t.Helper()
+		if content == "-" {
+			content = ""
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			credDir := filepath.Join(dir, "credentials")
+			if err := os.Mkdir(credDir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("CREDENTIALS_DIRECTORY", credDir)
+
+			var src BootstrapSource
+			for _, s := range []struct {
+				sources
+				name, env string
+				dst       *string
+			}{
+				{tt.access, accessKeyCredential, "ACCESS_KEY", &src.AccessKeyFile},
+				{tt.secret, secretKeyCredential, "SECRET_KEY", &src.SecretKeyFile},
+			} {
+				if s.file != "" {
+					*s.dst = filepath.Join(dir, s.name+".txt")
+					write(t, *s.dst, s.file)
+				}
+				if s.credential != "" {
+					write(t, filepath.Join(credDir, s.name), s.credential)
+				}
+				t.Setenv(s.env, s.sources.env)
+			}
+
+			got, err := ResolveBootstrap(src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveBootstrapMissingFile(t *testing.T) {
+	t.Setenv("ACCESS_KEY", "ak-env")
+	t.Setenv("SECRET_KEY", "sk-env")
+	// A file that was asked for must exist; the env isn't a fallback for it.
+	if _, err := ResolveBootstrap(BootstrapSource{AccessKeyFile: filepath.Join(t.TempDir(), "nope")}); err == nil {
+		t.Fatal("ResolveBootstrap succeeded with a missing --access-key-file")
+	}
+}
@@ -2,41 +2,161 @@ package config
 
 import (
 	"bytes"
+	"crypto"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/state"
 	"github.com/certkit-io/certkit-agent-alpha/utils"
+	"gopkg.in/yaml.v3"
 )
 
-var CurrentConfig Config
-
 type Config struct {
-	ApiBase      string          `json:"api_base"`
-	Bootstrap    *BootstrapCreds `json:"bootstrap,omitempty"`
-	Agent        *AgentCreds     `json:"agent,omitempty"`
-	DesiredState json.RawMessage `json:"desired_state,omitempty"`
-	Auth         *AuthCreds      `json:"auth,omitempty"`
-	Version      VersionInfo     `json:"omit"`
+	ApiBase   string          `json:"api_base" yaml:"api_base"`
+	Bootstrap *BootstrapCreds `json:"bootstrap,omitempty" yaml:"bootstrap,omitempty"`
+	Agent     *AgentCreds     `json:"agent,omitempty" yaml:"agent,omitempty"`
+	// LastApplied is only read, to migrate it to the state directory (see SaveLastApplied).
+	LastApplied          *state.Applied     `json:"last_applied,omitempty" yaml:"last_applied,omitempty"`
+	Auth                 *AuthCreds         `json:"auth,omitempty" yaml:"auth,omitempty"`
+	ProxyURL             string             `json:"proxy_url,omitempty" yaml:"proxy_url,omitempty"`
+	InventoryPaths       []string           `json:"inventory_paths,omitempty" yaml:"inventory_paths,omitempty"`
+	RequestTimeout       string             `json:"request_timeout,omitempty" yaml:"request_timeout,omitempty"`
+	TLS                  *TLSConfig         `json:"tls,omitempty" yaml:"tls,omitempty"`
+	MetricsListen        string             `json:"metrics_listen,omitempty" yaml:"metrics_listen,omitempty"`
+	EnrollJitter         string             `json:"enroll_jitter,omitempty" yaml:"enroll_jitter,omitempty"`
+	Hostname             string             `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	APIPrefix            string             `json:"api_prefix,omitempty" yaml:"api_prefix,omitempty"`
+	SelfUpdate           *SelfUpdateConfig  `json:"self_update,omitempty" yaml:"self_update,omitempty"`
+	IdleConnTimeout      string             `json:"idle_conn_timeout,omitempty" yaml:"idle_conn_timeout,omitempty"`
+	LongPollTimeout      string             `json:"long_poll_timeout,omitempty" yaml:"long_poll_timeout,omitempty"`
+	DeployTrustBundle    string             `json:"deploy_trust_bundle,omitempty" yaml:"deploy_trust_bundle,omitempty"`
+	APIBaseFallbacks     []string           `json:"api_base_fallbacks,omitempty" yaml:"api_base_fallbacks,omitempty"`
+	Labels               map[string]string  `json:"labels,omitempty" yaml:"labels,omitempty"`
+	SchemaVersion        int                `json:"schema_version" yaml:"schema_version"`
+	DeployConcurrency    int                `json:"deploy_concurrency,omitempty" yaml:"deploy_concurrency,omitempty"`
+	Paused               bool               `json:"paused,omitempty" yaml:"paused,omitempty"`
+	PollBackoff          *PollBackoffConfig `json:"poll_backoff,omitempty" yaml:"poll_backoff,omitempty"`
+	ExtraHeaders         map[string]string  `json:"extra_headers,omitempty" yaml:"extra_headers,omitempty"`
+	KeepBootstrap        bool               `json:"keep_bootstrap,omitempty" yaml:"keep_bootstrap,omitempty"`
+	CATrustUpdateCommand []string           `json:"ca_trust_update_command,omitempty" yaml:"ca_trust_update_command,omitempty"`
+	Version              VersionInfo        `json:"-" yaml:"-"` // the running binary's, never persisted
 }
 
 type BootstrapCreds struct {
-	AccessKey string `json:"access_key"`
-	SecretKey string `json:"secret_key"`
+	AccessKey string `json:"access_key" yaml:"access_key"`
+	SecretKey string `json:"secret_key" yaml:"secret_key"`
 }
 
 type AgentCreds struct {
-	AgentID      string `json:"agent_id"`
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AgentID      string `json:"agent_id" yaml:"agent_id"`
+	AccessToken  string `json:"access_token" yaml:"access_token"`
+	RefreshToken string `json:"refresh_token" yaml:"refresh_token"`
+	// KeyID is the id the backend assigned to our public key.
+	KeyID string `json:"key_id,omitempty" yaml:"key_id,omitempty"`
+	// ServerKeyID and ServerPublicKey identify the key the backend signs responses
+	// with. When set, unsigned or mis-signed responses are rejected.
+	ServerKeyID     string `json:"server_key_id,omitempty" yaml:"server_key_id,omitempty"`
+	ServerPublicKey string `json:"server_public_key,omitempty" yaml:"server_public_key,omitempty"`
 }
 
 type AuthCreds struct {
-	KeyPair *auth.KeyPair `json:"key_pair"`
+	KeyPair *auth.KeyPair `json:"key_pair" yaml:"key_pair"`
+	// PendingKeyPair is a rotated key that has not yet been confirmed by the backend.
+	PendingKeyPair *auth.KeyPair `json:"pending_key_pair,omitempty" yaml:"pending_key_pair,omitempty"`
+	// SignedHeaders are extra request headers bound into every signature.
+	SignedHeaders []string `json:"signed_headers,omitempty" yaml:"signed_headers,omitempty"`
+	// PKCS11, if set, keeps the signing key in a token rather than in KeyPair,
+	// which then only records the token key's public half.
+	PKCS11 *auth.PKCS11Config `json:"pkcs11,omitempty" yaml:"pkcs11,omitempty"`
+	// KeyStorage is where the private half of KeyPair is kept: KeyStorageFile
+	// (the default) or KeyStorageKeyring.
+	KeyStorage string `json:"key_storage,omitempty" yaml:"key_storage,omitempty"`
+}
+
+const (
+	// KeyStorageFile keeps the private key in the config file.
+	KeyStorageFile = "file"
+	// KeyStorageKeyring keeps the private key in the Linux kernel keyring,
+	// and only the public half in the config. The keyring is lost on reboot,
+	// after which the agent generates a new key and enrolls again.
+	KeyStorageKeyring = "keyring"
+)
+
+// SigningKey returns the key requests are signed with: the PKCS#11 key if
+// configured, else the private half of KeyPair, from the kernel keyring if
+// that's where it's kept.
+func (a *AuthCreds) SigningKey() (crypto.Signer, error) {
+	if a.PKCS11 != nil {
+		return auth.OpenPKCS11(a.PKCS11)
+	}
+	if a.KeyPair == nil {
+		return nil, fmt.Errorf("no keypair configured")
+	}
+	if a.KeyStorage == KeyStorageKeyring {
+		return auth.LoadKeyringKey(auth.KeyringDescription(a.KeyPair.PublicKey))
+	}
+	return auth.DecodePrivateKey(a.KeyPair.PrivateKey)
+}
+
+// StoreKey moves the private half of kp into the kernel keyring if that's
+// where a keeps it, leaving only the public half in kp.
+func (a *AuthCreds) StoreKey(kp *auth.KeyPair) error {
+	if a.KeyStorage != KeyStorageKeyring || kp.PrivateKey == "" {
+		return nil
+	}
+	if err := kp.Validate(); err != nil {
+		return err
+	}
+	priv, err := auth.DecodePrivateKey(kp.PrivateKey)
+	if err != nil {
+		return err
+	}
+	if err := auth.StoreKeyringKey(auth.KeyringDescription(kp.PublicKey), priv); err != nil {
+		return err
+	}
+	kp.PrivateKey = ""
+	return nil
+}
+
+// TLSConfig controls how the API connection is secured.
+type TLSConfig struct {
+	CABundlePath   string `json:"ca_bundle_path,omitempty" yaml:"ca_bundle_path,omitempty"`
+	ClientCertPath string `json:"client_cert_path,omitempty" yaml:"client_cert_path,omitempty"`
+	ClientKeyPath  string `json:"client_key_path,omitempty" yaml:"client_key_path,omitempty"`
+	// PinnedSPKISHA256 are base64 SHA-256 hashes of trusted server public keys.
+	// When set, the verified server chain must also contain one of them.
+	PinnedSPKISHA256 []string `json:"pinned_spki_sha256,omitempty" yaml:"pinned_spki_sha256,omitempty"`
+	// MinVersion is the oldest TLS version accepted from the backend: "1.2"
+	// (the default) or "1.3".
+	MinVersion string `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+	// ServerName, if set, is sent as SNI and must be named by the server's
+	// certificate instead of api_base's host, e.g. when api_base is an IP.
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+}
+
+// SelfUpdateConfig lets the agent replace its binary with releases offered by
+// the backend. It is off unless Enabled, and every download must carry a valid
+// signature by PublicKey (base64url ed25519), the release signing key.
+type SelfUpdateConfig struct {
+	Enabled   bool   `json:"enabled" yaml:"enabled"`
+	PublicKey string `json:"public_key" yaml:"public_key"`
+}
+
+// PollBackoffConfig stretches the poll interval while nothing changes: after
+// After polls in a row that change nothing, each further one multiplies the
+// interval by Factor, up to Max. Any change or error resets it. Zero values
+// take the defaults, and a Factor of 1 turns it off.
+type PollBackoffConfig struct {
+	After  int     `json:"after,omitempty" yaml:"after,omitempty"`
+	Factor float64 `json:"factor,omitempty" yaml:"factor,omitempty"`
+	Max    string  `json:"max,omitempty" yaml:"max,omitempty"`
 }
 
 type VersionInfo struct {
@@ -47,29 +167,33 @@ type VersionInfo struct {
 
 const (
 	defaultAPIBase = "https://app.certkit.io"
+	configBackups  = 3
 )
 
-func CreateInitialConfig(path string) error {
-	access := os.Getenv("ACCESS_KEY")
-	secret := os.Getenv("SECRET_KEY")
-
-	if access == "" || secret == "" {
-		return fmt.Errorf("ACCESS_KEY and SECRET_KEY are required for first install")
+// CreateInitialConfig writes a fresh config with bootstrap credentials found
+// via ResolveBootstrap and the backend for env (see ResolveAPIBase). If
+// requireBootstrap is false and the credentials aren't available, the config
+// is written without them (e.g. they're provided via an EnvironmentFile).
+func CreateInitialConfig(path string, src BootstrapSource, env string, requireBootstrap bool) error {
+	bootstrap, err := ResolveBootstrap(src)
+	if err != nil {
+		return err
+	}
+	if bootstrap == nil && requireBootstrap {
+		return fmt.Errorf("ACCESS_KEY and SECRET_KEY are required for first install (env, --access-key-file/--secret-key-file or systemd credentials)")
 	}
 
-	apiBase := os.Getenv("CERTKIT_API_BASE")
-	if apiBase == "" {
-		apiBase = defaultAPIBase
+	apiBase, err := ResolveAPIBase(env)
+	if err != nil {
+		return err
 	}
 
 	cfg := &Config{
-		ApiBase: apiBase,
-		Bootstrap: &BootstrapCreds{
-			AccessKey: access,
-			SecretKey: secret,
-		},
-		Agent:        nil,
-		DesiredState: nil,
+		SchemaVersion: SchemaVersion,
+		ApiBase:       apiBase,
+		Bootstrap:     bootstrap,
+		Agent:         nil,
+		LastApplied:   nil,
 	}
 
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
@@ -79,61 +203,343 @@ func CreateInitialConfig(path string) error {
 	return SaveConfig(cfg, path)
 }
 
+// ReportedHostname returns the name this agent registers and reports under:
+// the config's hostname (which Options.Hostname overrides), else os.Hostname().
+func (cfg *Config) ReportedHostname() string {
+	if cfg.Hostname != "" {
+		return cfg.Hostname
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// isYAML reports whether path should be read and written as YAML rather than JSON.
+// JSON is the default for any other extension.
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
 func SaveConfig(cfg *Config, path string) error {
-	configBytes, err := json.MarshalIndent(cfg, "", "  ")
+	var configBytes []byte
+	var err error
+	if isYAML(path) {
+		configBytes, err = yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+	} else {
+		configBytes, err = json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return err
+		}
+		configBytes = append(configBytes, '\n')
+	}
+
+	lock, err := lockConfigFile(path)
 	if err != nil {
 		return err
 	}
-	configBytes = append(configBytes, '\n')
+	defer lock.Unlock()
+	return writeConfigFile(path, configBytes)
+}
 
-	return utils.WriteFileAtomic(path, configBytes, 0o600)
+// UpdateFile applies fn to the main config file at path as it is on disk,
+// and saves the result: unlike SaveConfig, what drop-ins or defaults (such as
+// a resolved api_base) supply isn't written into it. An older layout is
+// upgraded first (see SchemaVersion). Callers sharing the file with a running
+// agent should go through its Manager instead. The file is locked against
+// other processes updating it meanwhile.
+func UpdateFile(path string, fn func(m map[string]any) error) error {
+	lock, err := lockConfigFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	b, _, err = migrate(path, b)
+	if err != nil {
+		return err
+	}
+	m := map[string]any{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := fn(m); err != nil {
+		return err
+	}
+
+	if isYAML(path) {
+		b, err = yaml.Marshal(m)
+	} else {
+		b, err = json.MarshalIndent(m, "", "  ")
+		b = append(b, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("encode config file %s: %w", path, err)
+	}
+	return writeConfigFile(path, b)
 }
 
-func LoadConfig(path string, version VersionInfo) (Config, error) {
+// UpdateMainFile is UpdateFile with the main file decoded into a Config: fn
+// sees only what the main file itself sets, and only the settings fn changes
+// are written back.
+func UpdateMainFile(path string, fn func(cfg *Config) error) error {
+	return UpdateFile(path, func(m map[string]any) error {
+		var cfg Config
+		if err := convert(m, &cfg); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		before := cfg.Clone()
+		if err := fn(&cfg); err != nil {
+			return err
+		}
+		return patchMap(m, before, &cfg)
+	})
+}
+
+// patchMap applies the differences between prev and next to m, the decoded
+// contents of a config file: settings whose values differ are set to next's,
+// or removed if next omits them. Objects are patched key by key, so that keys
+// in m a Config doesn't have survive.
+func patchMap(m map[string]any, prev, next *Config) error {
+	var before, after map[string]any
+	if err := convert(prev, &before); err != nil {
+		return err
+	}
+	if err := convert(next, &after); err != nil {
+		return err
+	}
+	patch(m, before, after)
+	return nil
+}
+
+func patch(m, before, after map[string]any) {
+	for k, v := range after {
+		if reflect.DeepEqual(before[k], v) {
+			continue
+		}
+		b, bok := before[k].(map[string]any)
+		a, aok := v.(map[string]any)
+		cur, ok := m[k].(map[string]any)
+		if bok && aok && ok {
+			patch(cur, b, a)
+			continue
+		}
+		m[k] = v
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			delete(m, k)
+		}
+	}
+}
+
+// convert copies in to out through JSON.
+func convert(in, out any) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// configLockWait is how long lockConfigFile waits for another writer.
+const configLockWait = 10 * time.Second
+
+// lockConfigFile takes the lock that serializes writes to the config at path
+// across processes, e.g. `certkit-agent pause` and the agent saving its
+// enrollment, waiting up to configLockWait for it. It's a file next to path,
+// which itself is replaced on every write.
+func lockConfigFile(path string) (*utils.FileLock, error) {
+	deadline := time.Now().Add(configLockWait)
+	for {
+		lock, err := utils.Lock(path + ".lock")
+		if !errors.Is(err, utils.ErrLocked) || time.Now().After(deadline) {
+			if err != nil {
+				return nil, fmt.Errorf("lock config %s: %w", path, err)
+			}
+			return lock, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// writeConfigFile replaces the config at path with b, backing up what was
+// there first (see backupConfig).
+func writeConfigFile(path string, b []byte) error {
+	if err := backupConfig(path, b); err != nil {
+		return fmt.Errorf("backup config: %w", err)
+	}
+	return utils.WriteFileAtomic(path, b, 0o600)
+}
+
+// backupPath returns the path of the n-th most recent backup of path (0 is newest).
+func backupPath(path string, n int) string {
+	if n == 0 {
+		return path + ".bak"
+	}
+	return fmt.Sprintf("%s.bak.%d", path, n)
+}
+
+// backupConfig copies the current contents of path to path.bak before it's
+// overwritten, shifting older backups down and keeping at most configBackups.
+// Nothing is backed up if the file doesn't exist yet or isn't changing.
+func backupConfig(path string, next []byte) error {
+	prev, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(prev, next) {
+		return nil
+	}
+
+	for i := configBackups - 1; i > 0; i-- {
+		err := os.Rename(backupPath(path, i-1), backupPath(path, i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return utils.WriteFileAtomic(backupPath(path, 0), prev, 0o600)
+}
+
+// Restore rolls the config at path back to its most recent backup.
+func Restore(path string) error {
+	b, err := os.ReadFile(backupPath(path, 0))
+	if err != nil {
+		return fmt.Errorf("read config backup: %w", err)
+	}
+	return utils.WriteFileAtomic(path, b, 0o600)
+}
+
+// Options are settings a command supplies for reading the config, typically
+// from its flags.
+type Options struct {
+	// DropInDir is where drop-in config files are read from (--config-dir).
+	// By default it's a config.d directory next to the main config file.
+	DropInDir string
+	// Env selects the backend from Environments for a config without an
+	// api_base (--env; see ResolveAPIBase).
+	Env string
+	// Hostname, if set, is registered and reported instead of the config's
+	// hostname (--hostname). It isn't saved into the config file.
+	Hostname string
+}
+
+// ReadConfig reads and parses the config at path (merging any drop-ins)
+// without side effects: no keypair is generated (see NewManager), and an
+// older config is upgraded (see SchemaVersion) but not saved. An empty api_base is
+// filled in by ResolveAPIBase.
+func ReadConfig(path string, opts Options) (Config, error) {
+	cfg, _, err := readConfig(path, opts)
+	return cfg, err
+}
+
+// readConfig is ReadConfig, also reporting whether the config was migrated.
+func readConfig(path string, opts Options) (Config, bool, error) {
 	var cfg Config
 
 	if path == "" {
-		return cfg, fmt.Errorf("config path is empty")
+		return cfg, false, fmt.Errorf("config path is empty")
 	}
 
 	b, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return cfg, fmt.Errorf("config file does not exist: %s", path)
+			return cfg, false, fmt.Errorf("config file does not exist: %s", path)
 		}
-		return cfg, fmt.Errorf("failed to read config file %s: %w", path, err)
+		return cfg, false, fmt.Errorf("failed to read config file %s: %w", path, err)
 	}
 
 	if len(bytes.TrimSpace(b)) == 0 {
-		return cfg, fmt.Errorf("config file %s is empty", path)
+		return cfg, false, fmt.Errorf("config file %s is empty", path)
 	}
 
+	b, migrated, err := migrate(path, b)
+	if err != nil {
+		return cfg, false, err
+	}
 	if err := json.Unmarshal(b, &cfg); err != nil {
-		return cfg, fmt.Errorf("failed to parse config file %s: %w", path, err)
-	}
-
-	// // Exactly one of Bootstrap or Agent should be present
-	// if cfg.Bootstrap == nil && cfg.Agent == nil {
-	// 	return cfg, fmt.Errorf(
-	// 		"config %s: either bootstrap or agent credentials must be present",
-	// 		path,
-	// 	)
-	// }
-
-	if !hasKeyPair(&cfg) {
-		log.Print("Generating new keypair...")
-		keyPair, _ := auth.CreateNewKeyPair()
-		cfg.Auth = &AuthCreds{
-			KeyPair: keyPair,
+		return cfg, false, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	if err := applyDropIns(path, opts.DropInDir, b, &cfg); err != nil {
+		return cfg, false, err
+	}
+
+	if err := ValidateLabels(cfg.Labels); err != nil {
+		return cfg, false, fmt.Errorf("config file %s: %w", path, err)
+	}
+	if err := ValidateExtraHeaders(cfg.ExtraHeaders); err != nil {
+		return cfg, false, fmt.Errorf("config file %s: %w", path, err)
+	}
+	if a := cfg.Auth; a != nil {
+		switch a.KeyStorage {
+		case "", KeyStorageFile:
+		case KeyStorageKeyring:
+			if a.PKCS11 != nil {
+				return cfg, false, fmt.Errorf("config file %s: auth.key_storage %q can't be combined with auth.pkcs11", path, a.KeyStorage)
+			}
+		default:
+			return cfg, false, fmt.Errorf("config file %s: auth.key_storage must be %q or %q, not %q", path, KeyStorageFile, KeyStorageKeyring, a.KeyStorage)
 		}
-		SaveConfig(&cfg, path)
+	}
+	if cfg.DeployConcurrency < 0 {
+		return cfg, false, fmt.Errorf("config file %s: deploy_concurrency must not be negative", path)
+	}
+	if err := validateTrustUpdateCommand(cfg.CATrustUpdateCommand); err != nil {
+		return cfg, false, fmt.Errorf("config file %s: %w", path, err)
 	}
 
-	cfg.Version = version
+	if opts.Hostname != "" {
+		cfg.Hostname = opts.Hostname
+	}
+	if cfg.ApiBase == "" {
+		if cfg.ApiBase, err = ResolveAPIBase(opts.Env); err != nil {
+			return cfg, false, fmt.Errorf("config file %s has no api_base: %w", path, err)
+		}
+	}
+	if err := ValidateAPIBase("api_base", cfg.ApiBase); err != nil {
+		return cfg, false, fmt.Errorf("config file %s: %w", path, err)
+	}
+	for _, fallback := range cfg.APIBaseFallbacks {
+		if err := ValidateAPIBase("api_base_fallbacks", fallback); err != nil {
+			return cfg, false, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
 
-	CurrentConfig = cfg
+	return cfg, migrated, nil
+}
 
-	return cfg, nil
+// validateTrustUpdateCommand checks ca_trust_update_command: if set, it must
+// name a program by absolute path, and have no empty arguments.
+func validateTrustUpdateCommand(args []string) error {
+	if args == nil {
+		return nil
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("ca_trust_update_command is empty; leave it out to use the distribution's command")
+	}
+	if !filepath.IsAbs(args[0]) {
+		return fmt.Errorf("ca_trust_update_command must start with an absolute path, not %q", args[0])
+	}
+	for i, arg := range args {
+		if arg == "" {
+			return fmt.Errorf("ca_trust_update_command: argument %d is empty", i)
+		}
+	}
+	return nil
 }
 
 func hasKeyPair(cfg *Config) bool {
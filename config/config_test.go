@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateTrustUpdateCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"unset", nil, false},
+		{"absolute", []string{"/usr/sbin/update-ca-certificates"}, false},
+		{"with arguments", []string{"/usr/bin/update-ca-trust", "extract"}, false},
+		{"empty", []string{}, true},
+		{"relative", []string{"update-ca-certificates"}, true},
+		{"empty program", []string{""}, true},
+		{"empty argument", []string{"/usr/bin/update-ca-trust", ""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTrustUpdateCommand(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSaveConfigOmitsVersion(t *testing.T) {
+	version := VersionInfo{Version: "1.2.3", Commit: "abc123", Date: "2026-01-02"}
+	tests := []struct {
+		name string
+		file string
+		save func(t *testing.T, path string)
+	}{
+		{
+			name: "json",
+			file: "config.json",
+			save: func(t *testing.T, path string) {
+				if err := SaveConfig(&Config{SchemaVersion: SchemaVersion, ApiBase: "https://app.certkit.io", Version: version}, path); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "yaml",
+			file: "config.yaml",
+			save: func(t *testing.T, path string) {
+				if err := SaveConfig(&Config{SchemaVersion: SchemaVersion, ApiBase: "https://app.certkit.io", Version: version}, path); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "manager",
+			file: "config.json",
+			save: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte(`{"schema_version":1}`), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				// Generating the keypair saves the config.
+				if _, err := NewManager(path, version, Options{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			tt.save(t, path)
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []string{`"omit"`, `"version"`, `"Version"`, "\nomit:", "\nversion:", version.Version, version.Commit} {
+				if strings.Contains(string(b), s) {
+					t.Errorf("saved config contains %q:\n%s", s, b)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dropInDir returns where drop-in files for path are read from: dir if set
+// (--config-dir), else a config.d directory next to the main config file.
+func dropInDir(path, dir string) string {
+	if dir != "" {
+		return dir
+	}
+	return filepath.Join(filepath.Dir(path), "config.d")
+}
+
+// dropInFiles returns the drop-in files for path in the order they're applied
+// (lexical, so 10-env.json overrides 00-base.json).
+func dropInFiles(path, dir string) ([]string, error) {
+	dir = dropInDir(path, dir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config drop-in dir: %w", err)
+	}
+
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".json", ".yaml", ".yml":
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// applyDropIns deep-merges the drop-in files over the main config contents
+// and decodes the result into cfg.
+//
+// Merge semantics: objects are merged key by key, recursively; anything else
+// (strings, numbers, booleans, lists, null) in a later file replaces the
+// earlier value outright. Lists are not concatenated.
+//
+// Note that when the agent saves its config (e.g. after enrolling), merged
+// values are written back to the main file.
+func applyDropIns(path, dir string, base []byte, cfg *Config) error {
+	files, err := dropInFiles(path, dir)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	merged, err := decodeMap(path, base)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("failed to read config drop-in %s: %w", f, err)
+		}
+		overlay, err := decodeMap(f, b)
+		if err != nil {
+			return err
+		}
+		merged = mergeMaps(merged, overlay)
+	}
+
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("merge config drop-ins: %w", err)
+	}
+
+	var out Config
+	if err := json.Unmarshal(b, &out); err != nil {
+		return fmt.Errorf("merge config drop-ins: %w", err)
+	}
+	*cfg = out
+	return nil
+}
+
+func decodeMap(path string, b []byte) (map[string]any, error) {
+	m := map[string]any{}
+	var err error
+	if isYAML(path) {
+		err = yaml.Unmarshal(b, &m)
+	} else {
+		err = json.Unmarshal(b, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// mergeMaps merges src over dst (see applyDropIns for semantics).
+func mergeMaps(dst, src map[string]any) map[string]any {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]any)
+		dstMap, dstIsMap := dst[k].(map[string]any)
+		if srcIsMap && dstIsMap {
+			dst[k] = mergeMaps(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
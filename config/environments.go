@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Environments maps the names accepted by --env and CERTKIT_ENV to their backends.
+var Environments = map[string]string{
+	"prod":    defaultAPIBase,
+	"staging": "https://staging.certkit.io",
+	"dev":     "https://dev.certkit.io",
+}
+
+// ValidateAPIBase checks that apiBase, the value of field, is an http or https
+// URL. Whether plain http may be used is up to RequireHTTPS, when a request is
+// about to be sent.
+func ValidateAPIBase(field, apiBase string) error {
+	u, err := url.Parse(apiBase)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s %q is not an absolute URL", field, apiBase)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "https", "http":
+		return nil
+	default:
+		return fmt.Errorf("%s %q must be an https URL", field, apiBase)
+	}
+}
+
+// RequireHTTPS checks that apiBase, the value of field, is https, before the
+// agent talks to it. Over plain http, signed requests and the bootstrap
+// secrets sent when enrolling would cross the network in cleartext, so it's
+// only allowed with allowHTTP (--insecure-allow-http), for testing against a
+// local backend.
+func RequireHTTPS(field, apiBase string, allowHTTP bool) error {
+	u, err := url.Parse(apiBase)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return nil
+	}
+	if !allowHTTP {
+		return fmt.Errorf("%s %s uses plain http, which would send signed requests and enrollment secrets in cleartext; use https (--insecure-allow-http allows it for local testing)", field, apiBase)
+	}
+	return nil
+}
+
+// ResolveAPIBase returns the backend for a config that doesn't set api_base,
+// from the first of: env (--env), CERTKIT_API_BASE, CERTKIT_ENV, prod.
+func ResolveAPIBase(env string) (string, error) {
+	if env != "" {
+		return environmentAPIBase(env, "--env")
+	}
+	if apiBase := os.Getenv("CERTKIT_API_BASE"); apiBase != "" {
+		return apiBase, nil
+	}
+	if env := os.Getenv("CERTKIT_ENV"); env != "" {
+		return environmentAPIBase(env, "CERTKIT_ENV")
+	}
+	return defaultAPIBase, nil
+}
+
+func environmentAPIBase(name, source string) (string, error) {
+	apiBase, ok := Environments[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(Environments))
+		for n := range Environments {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return "", fmt.Errorf("%s %q is not one of %s", source, name, strings.Join(names, ", "))
+	}
+	return apiBase, nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValidateAPIBase(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiBase string
+		wantErr bool
+	}{
+		{"https", "https://app.certkit.io", false},
+		// Loading a config doesn't care; RequireHTTPS does.
+		{"http", "http://localhost:8080", false},
+		{"relative", "app.certkit.io", true},
+		{"other scheme", "ftp://app.certkit.io", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAPIBase("api_base", tt.apiBase)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiBase string
+		allow   bool // --insecure-allow-http
+		wantErr bool
+	}{
+		{name: "https", apiBase: "https://app.certkit.io"},
+		{name: "https allowed", apiBase: "https://app.certkit.io", allow: true},
+		{name: "http", apiBase: "http://localhost:8080", wantErr: true},
+		{name: "http allowed", apiBase: "http://localhost:8081", allow: true},
+		{name: "HTTPS", apiBase: "HTTPS://app.certkit.io"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RequireHTTPS("api_base", tt.apiBase, tt.allow)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveAPIBase(t *testing.T) {
+	tests := []struct {
+		name       string
+		configBase string // api_base in the config file
+		flag       string // --env
+		envBase    string // CERTKIT_API_BASE
+		envName    string // CERTKIT_ENV
+		want       string
+		wantErr    bool
+	}{
+		{name: "default", want: defaultAPIBase},
+		{name: "CERTKIT_ENV", envName: "staging", want: "https://staging.certkit.io"},
+		{name: "CERTKIT_ENV is case-insensitive", envName: "DEV", want: "https://dev.certkit.io"},
+		{name: "CERTKIT_API_BASE over CERTKIT_ENV", envBase: "https://custom.example.com", envName: "dev", want: "https://custom.example.com"},
+		{name: "--env over environment", flag: "dev", envBase: "https://custom.example.com", envName: "staging", want: "https://dev.certkit.io"},
+		{name: "api_base over everything", configBase: "https://pinned.example.com", flag: "dev", envBase: "https://custom.example.com", envName: "staging", want: "https://pinned.example.com"},
+		{name: "unknown --env", flag: "qa", wantErr: true},
+		{name: "unknown CERTKIT_ENV", envName: "qa", wantErr: true},
+		{name: "unknown name unused with api_base", configBase: "https://pinned.example.com", flag: "qa", want: "https://pinned.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CERTKIT_API_BASE", tt.envBase)
+			t.Setenv("CERTKIT_ENV", tt.envName)
+
+			raw := `{"schema_version":1}`
+			if tt.configBase != "" {
+				raw = fmt.Sprintf(`{"schema_version":1,"api_base":%q}`, tt.configBase)
+			}
+			cfg, err := ReadConfig(writeMain(t, raw), Options{Env: tt.flag})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if cfg.ApiBase != tt.want {
+				t.Errorf("api_base = %q, want %q", cfg.ApiBase, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// headerNamePattern matches an HTTP header name (an RFC 9110 token).
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// reservedHeaders are set by the agent itself on every request.
+var reservedHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Authorization",
+	"Connection",
+	"Content-Length",
+	"Content-Type",
+	"Host",
+	"Transfer-Encoding",
+	"User-Agent",
+	"X-Request-Id",
+}
+
+// ValidateExtraHeaders checks extra_headers, which are added to every request
+// to the backend (e.g. for an auth proxy in front of it): names must be
+// valid, and not ones the agent sets itself, and values must be printable.
+func ValidateExtraHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if !headerNamePattern.MatchString(name) {
+			return fmt.Errorf("extra_headers: invalid header name %q", name)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if strings.HasPrefix(canonical, "X-Agent-") {
+			return fmt.Errorf("extra_headers: %s is set by the agent", name)
+		}
+		for _, reserved := range reservedHeaders {
+			if canonical == reserved {
+				return fmt.Errorf("extra_headers: %s is set by the agent", name)
+			}
+		}
+		if strings.IndexFunc(value, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+			return fmt.Errorf("extra_headers: %s: value has non-printable characters", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import "testing"
+
+func TestValidateExtraHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"gateway token", map[string]string{"X-Gateway-Token": "abc"}, false},
+		{"cloudflare access", map[string]string{"CF-Access-Client-Id": "id.access", "CF-Access-Client-Secret": "s3cret"}, false},
+		{"space in name", map[string]string{"Bad Name": "x"}, true},
+		{"colon in name", map[string]string{"Bad:Name": "x"}, true},
+		{"empty name", map[string]string{"": "x"}, true},
+		{"authorization", map[string]string{"authorization": "Bearer x"}, true},
+		{"user agent", map[string]string{"User-Agent": "x"}, true},
+		{"agent header", map[string]string{"X-Agent-Id": "x"}, true},
+		{"accept encoding", map[string]string{"Accept-Encoding": "br"}, true},
+		{"newline in value", map[string]string{"X-Token": "a\r\nX-Injected: b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateExtraHeaders(tt.headers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
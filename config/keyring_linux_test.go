@@ -0,0 +1,154 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+// keyringKey returns a new keypair, with its private half in the kernel
+// keyring if stored. It skips the test where the sandbox denies keyctl.
+func keyringKey(t *testing.T, stored bool) *auth.KeyPair {
+	t.Helper()
+	kp, err := auth.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := auth.KeyringDescription(kp.PublicKey)
+	t.Cleanup(func() { auth.RemoveKeyringKey(desc) })
+	priv, err := auth.DecodePrivateKey(kp.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = auth.StoreKeyringKey(desc, priv)
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.ENOSYS) {
+		t.Skipf("kernel keyring unavailable: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored {
+		if err := auth.RemoveKeyringKey(desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &auth.KeyPair{PublicKey: kp.PublicKey, PrivateKey: kp.PrivateKey}
+}
+
+func publicHalf(kp *auth.KeyPair) *auth.KeyPair {
+	return &auth.KeyPair{PublicKey: kp.PublicKey}
+}
+
+func TestKeyringStorage(t *testing.T) {
+	tests := []struct {
+		name string
+		// active and pending return the keypairs in the config file.
+		active, pending func(t *testing.T) *auth.KeyPair
+		wantEnrolled    bool
+		wantSameKey     bool // the active key is kept
+		wantPending     bool
+	}{
+		{
+			name:         "private key moved into the keyring",
+			active:       func(t *testing.T) *auth.KeyPair { return keyringKey(t, false) },
+			wantEnrolled: true,
+			wantSameKey:  true,
+		},
+		{
+			name:         "key in the keyring",
+			active:       func(t *testing.T) *auth.KeyPair { return publicHalf(keyringKey(t, true)) },
+			pending:      func(t *testing.T) *auth.KeyPair { return publicHalf(keyringKey(t, true)) },
+			wantEnrolled: true,
+			wantSameKey:  true,
+			wantPending:  true,
+		},
+		{
+			// Rebooted while rotating: the rotation starts over.
+			name:         "pending key lost",
+			active:       func(t *testing.T) *auth.KeyPair { return publicHalf(keyringKey(t, true)) },
+			pending:      func(t *testing.T) *auth.KeyPair { return publicHalf(keyringKey(t, false)) },
+			wantEnrolled: true,
+			wantSameKey:  true,
+		},
+		{
+			// Rebooted: the agent enrolls again with a new key.
+			name:    "active key lost",
+			active:  func(t *testing.T) *auth.KeyPair { return publicHalf(keyringKey(t, false)) },
+			pending: func(t *testing.T) *auth.KeyPair { return publicHalf(keyringKey(t, true)) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			active := tt.active(t)
+			creds := &AuthCreds{KeyStorage: KeyStorageKeyring, KeyPair: active}
+			if tt.pending != nil {
+				creds.PendingKeyPair = tt.pending(t)
+			}
+			raw, err := json.Marshal(map[string]any{
+				"schema_version": SchemaVersion,
+				"agent":          map[string]string{"agent_id": "agent-1"},
+				"auth":           creds,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.json")
+			if err := os.WriteFile(path, raw, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(dir, "config.d"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "config.d", "10.json"), []byte(`{"proxy_url":"http://dropin:3128"}`), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			mgr, err := NewManager(path, VersionInfo{}, Options{})
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+			cfg := mgr.Snapshot()
+
+			if enrolled := cfg.Agent != nil && cfg.Agent.AgentID != ""; enrolled != tt.wantEnrolled {
+				t.Errorf("enrolled = %v, want %v", enrolled, tt.wantEnrolled)
+			}
+			if same := cfg.Auth.KeyPair.PublicKey == active.PublicKey; same != tt.wantSameKey {
+				t.Errorf("kept the active key = %v, want %v", same, tt.wantSameKey)
+			}
+			if hasPending := cfg.Auth.PendingKeyPair != nil; hasPending != tt.wantPending {
+				t.Errorf("pending key kept = %v, want %v", hasPending, tt.wantPending)
+			}
+			if _, err := cfg.Auth.SigningKey(); err != nil {
+				t.Errorf("signing key: %v", err)
+			}
+
+			// The file matches, and holds no private key or drop-in setting.
+			var file Config
+			b, _ := os.ReadFile(path)
+			if err := json.Unmarshal(b, &file); err != nil {
+				t.Fatal(err)
+			}
+			if file.Auth.KeyPair.PrivateKey != "" {
+				t.Error("private key left in the config file")
+			}
+			if file.Auth.KeyPair.PublicKey != cfg.Auth.KeyPair.PublicKey {
+				t.Error("config file has a different active key")
+			}
+			if (file.Auth.PendingKeyPair != nil) != tt.wantPending {
+				t.Errorf("config file pending_key_pair = %+v, want present %v", file.Auth.PendingKeyPair, tt.wantPending)
+			}
+			if (file.Agent != nil) != tt.wantEnrolled {
+				t.Errorf("config file agent = %+v, want present %v", file.Agent, tt.wantEnrolled)
+			}
+			if file.ProxyURL != "" {
+				t.Errorf("drop-in proxy_url %q saved into the config file", file.ProxyURL)
+			}
+		})
+	}
+}
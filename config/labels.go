@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxLabels          = 64
+	maxLabelValueBytes = 256
+)
+
+// labelKeyPattern matches a label key: a letter or digit, then up to 62
+// letters, digits, '.', '_', '-' or '/', e.g. "datacenter" or "example.com/role".
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+
+// ValidateLabels checks that labels, which are sent to the backend at
+// enrollment for grouping agents, have sane keys and printable values.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("too many labels: %d (max %d)", len(labels), maxLabels)
+	}
+	for k, v := range labels {
+		if !labelKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid label key %q: use letters, digits, '.', '_', '-' or '/', at most 63 characters", k)
+		}
+		if len(v) > maxLabelValueBytes {
+			return fmt.Errorf("label %s: value is longer than %d bytes", k, maxLabelValueBytes)
+		}
+		if strings.IndexFunc(v, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+			return fmt.Errorf("label %s: value has non-printable characters", k)
+		}
+	}
+	return nil
+}
+
+// ParseLabel splits a "key=value" label, e.g. from --label.
+func ParseLabel(s string) (key, value string, err error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", "", fmt.Errorf("label %q: want key=value", s)
+	}
+	if err := ValidateLabels(map[string]string{key: value}); err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
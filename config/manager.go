@@ -0,0 +1,329 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// Manager owns the config at one path. It loads, saves and reloads it under a
+// mutex and hands out snapshots, so the run loop, a SIGHUP reload and
+// enrollment can't observe each other's half-applied changes.
+type Manager struct {
+	mu      sync.RWMutex
+	path    string
+	version VersionInfo
+	opts    Options
+	cfg     *Config
+}
+
+// NewManager loads the config at path, generating and saving a keypair if it
+// has none. An invalid keypair is replaced too, unless the agent is already
+// enrolled with it, in which case loading fails. Reloads read it with the
+// same opts.
+func NewManager(path string, version VersionInfo, opts Options) (*Manager, error) {
+	m := &Manager{path: path, version: version, opts: opts}
+	cfg, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	m.cfg = cfg
+	return m, nil
+}
+
+// Path returns the path of the managed config file.
+func (m *Manager) Path() string {
+	return m.path
+}
+
+// Snapshot returns a copy of the current config. Changing it has no effect on
+// the Manager; use Update for that.
+func (m *Manager) Snapshot() *Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cfg.Clone()
+}
+
+// Reload re-reads the config file. If reading fails or validate (if non-nil)
+// rejects the new config, the current one is kept and the error returned.
+func (m *Manager) Reload(validate func(*Config) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cfg, err := m.load()
+	if err != nil {
+		return err
+	}
+	if validate != nil {
+		if err := validate(cfg); err != nil {
+			return err
+		}
+	}
+	m.cfg = cfg
+	return nil
+}
+
+// Update applies fn to a copy of the current config and saves what it
+// changed to the main config file; settings from drop-ins and defaults (such
+// as a resolved api_base) aren't written into it. The change only takes
+// effect if fn and the save both succeed.
+func (m *Manager) Update(fn func(*Config) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cfg := m.cfg.Clone()
+	if err := fn(cfg); err != nil {
+		return err
+	}
+	err := UpdateFile(m.path, func(file map[string]any) error {
+		return patchMap(file, m.cfg, cfg)
+	})
+	if err != nil {
+		return fmt.Errorf("save config: %w", err)
+	}
+	m.cfg = cfg
+	return nil
+}
+
+// load reads the config, generating and saving a keypair if it has none, or
+// an invalid one before enrollment. A config file in an older layout is
+// upgraded to the current one (see SchemaVersion).
+func (m *Manager) load() (*Config, error) {
+	cfg, migrated, err := readConfig(m.path, m.opts)
+	if err != nil {
+		return nil, err
+	}
+	if migrated {
+		log.Printf("Upgrading %s to config schema version %d", m.path, SchemaVersion)
+		// Only the main file is upgraded, not cfg with the drop-ins merged in.
+		if err := UpdateFile(m.path, func(map[string]any) error { return nil }); err != nil {
+			return nil, fmt.Errorf("save upgraded config: %w", err)
+		}
+	}
+
+	if cfg.Auth != nil && cfg.Auth.PKCS11 != nil {
+		if err := m.syncTokenKey(&cfg); err != nil {
+			return nil, err
+		}
+		cfg.Version = m.version
+		return &cfg, nil
+	}
+
+	if cfg.Auth != nil && cfg.Auth.KeyStorage == KeyStorageKeyring {
+		if err := m.syncKeyringKey(&cfg); err != nil {
+			return nil, err
+		}
+		cfg.Version = m.version
+		return &cfg, nil
+	}
+
+	regenerate := !hasKeyPair(&cfg)
+	if !regenerate {
+		if err := cfg.Auth.KeyPair.Validate(); err != nil {
+			// An enrolled agent is registered under its public key, so a new
+			// pair wouldn't help; the config needs restoring.
+			if cfg.Agent != nil && cfg.Agent.AgentID != "" {
+				return nil, fmt.Errorf("config %s: auth.key_pair is invalid: %w (restore it from a backup, e.g. %s)", m.path, err, backupPath(m.path, 0))
+			}
+			log.Printf("auth.key_pair is invalid (%v) and the agent isn't enrolled yet; replacing it", err)
+			regenerate = true
+		}
+	}
+
+	if regenerate {
+		log.Print("Generating new keypair...")
+		keyPair, err := auth.CreateNewKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Auth == nil {
+			cfg.Auth = &AuthCreds{}
+		}
+		cfg.Auth.KeyPair = keyPair
+		if err := m.saveKeys(&cfg); err != nil {
+			return nil, fmt.Errorf("save generated keypair: %w", err)
+		}
+	}
+
+	cfg.Version = m.version
+	return &cfg, nil
+}
+
+// syncTokenKey records the public half of the PKCS#11 key in auth.key_pair,
+// where enrollment and the other commands look for it.
+func (m *Manager) syncTokenKey(cfg *Config) error {
+	key, err := auth.OpenPKCS11(cfg.Auth.PKCS11)
+	if err != nil {
+		return fmt.Errorf("config %s: %w", m.path, err)
+	}
+	pub, err := auth.EncodePublicKey(key)
+	if err != nil {
+		return fmt.Errorf("config %s: pkcs11: %w", m.path, err)
+	}
+
+	kp := cfg.Auth.KeyPair
+	if kp != nil && kp.PublicKey == pub && kp.PrivateKey == "" {
+		return nil
+	}
+	// Like an invalid keypair, a different key can't be swapped in under an
+	// enrolled agent: the backend knows it by its old public key.
+	if kp != nil && kp.PublicKey != pub && cfg.Agent != nil && cfg.Agent.AgentID != "" {
+		return fmt.Errorf("config %s: the pkcs11 key is not the key agent %s is enrolled with (re-enroll to switch keys)", m.path, cfg.Agent.AgentID)
+	}
+	cfg.Auth.KeyPair = &auth.KeyPair{PublicKey: pub}
+	return m.saveKeys(cfg)
+}
+
+// syncKeyringKey keeps the private half of the keypair in the kernel keyring
+// and only the public half in the config, moving a private key still in the
+// config into the keyring. If the keyring has lost the key, as it does on
+// reboot, a new keypair is generated and the agent's enrollment is dropped,
+// so that it enrolls again with the new key. A pending key the keyring has
+// lost is dropped, which leaves its rotation to be started over.
+func (m *Manager) syncKeyringKey(cfg *Config) error {
+	kp, pending := cfg.Auth.KeyPair, cfg.Auth.PendingKeyPair
+	switch {
+	case kp != nil && kp.PrivateKey != "" || pending != nil && pending.PrivateKey != "":
+		if kp != nil {
+			if err := cfg.Auth.StoreKey(kp); err != nil {
+				return fmt.Errorf("config %s: auth.key_pair: %w", m.path, err)
+			}
+		}
+		if pending != nil {
+			if err := cfg.Auth.StoreKey(pending); err != nil {
+				return fmt.Errorf("config %s: auth.pending_key_pair: %w", m.path, err)
+			}
+		}
+		log.Printf("Moved the agent's private key from %s into the kernel keyring", m.path)
+		if err := m.saveKeys(cfg); err != nil {
+			return err
+		}
+		// The backups still hold the private key.
+		for i := range configBackups {
+			if err := utils.ShredFile(backupPath(m.path, i)); err != nil {
+				log.Printf("⚠️  %v", err)
+			}
+		}
+		return nil
+
+	case kp != nil && kp.PublicKey != "":
+		found, err := inKeyring(kp)
+		if err != nil {
+			return fmt.Errorf("config %s: %w", m.path, err)
+		}
+		if found {
+			return m.syncPendingKeyringKey(cfg)
+		}
+		if cfg.Agent != nil && cfg.Agent.AgentID != "" {
+			log.Printf("⚠️  The private key of agent %s is no longer in the kernel keyring (was the host rebooted?); generating a new one and enrolling again", cfg.Agent.AgentID)
+			cfg.Agent = nil
+		}
+		// A rotation in flight belongs to the enrollment being dropped.
+		cfg.Auth.PendingKeyPair = nil
+	}
+
+	log.Print("Generating new keypair in the kernel keyring...")
+	keyPair, err := auth.CreateNewKeyPair()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Auth.StoreKey(keyPair); err != nil {
+		return fmt.Errorf("config %s: %w", m.path, err)
+	}
+	cfg.Auth.KeyPair = keyPair
+	return m.saveKeys(cfg)
+}
+
+// syncPendingKeyringKey drops the pending keypair if the keyring has lost its
+// private half. The backend may or may not have taken the pending key before
+// the key was lost; either way it can't be used, and rotate-keys starts over
+// with a new one.
+func (m *Manager) syncPendingKeyringKey(cfg *Config) error {
+	pending := cfg.Auth.PendingKeyPair
+	if pending == nil {
+		return nil
+	}
+	found, err := inKeyring(pending)
+	if err != nil {
+		return fmt.Errorf("config %s: auth.pending_key_pair: %w", m.path, err)
+	}
+	if found {
+		return nil
+	}
+	log.Printf("⚠️  The private key of the pending key rotation is no longer in the kernel keyring (was the host rebooted?); run rotate-keys again")
+	cfg.Auth.PendingKeyPair = nil
+	return m.saveKeys(cfg)
+}
+
+// inKeyring reports whether the kernel keyring holds the private half of kp.
+func inKeyring(kp *auth.KeyPair) (bool, error) {
+	_, err := auth.LoadKeyringKey(auth.KeyringDescription(kp.PublicKey))
+	if errors.Is(err, auth.ErrKeyringKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// saveKeys writes cfg's keypairs to the main config file, and drops the
+// enrollment from it if cfg has none. Nothing else in the file changes, so
+// settings from drop-ins and defaults aren't baked into it.
+func (m *Manager) saveKeys(cfg *Config) error {
+	return UpdateMainFile(m.path, func(main *Config) error {
+		if main.Auth == nil {
+			main.Auth = &AuthCreds{}
+		}
+		main.Auth.KeyPair = cfg.Auth.KeyPair
+		main.Auth.PendingKeyPair = cfg.Auth.PendingKeyPair
+		if cfg.Agent == nil {
+			main.Agent = nil
+		}
+		return nil
+	})
+}
+
+// Clone returns a deep copy of cfg.
+func (cfg *Config) Clone() *Config {
+	c := *cfg
+	c.Bootstrap = clonePtr(cfg.Bootstrap)
+	c.Agent = clonePtr(cfg.Agent)
+	if cfg.LastApplied != nil {
+		applied := *cfg.LastApplied
+		applied.Targets = maps.Clone(cfg.LastApplied.Targets)
+		c.LastApplied = &applied
+	}
+	if cfg.Auth != nil {
+		a := *cfg.Auth
+		a.KeyPair = clonePtr(cfg.Auth.KeyPair)
+		a.PendingKeyPair = clonePtr(cfg.Auth.PendingKeyPair)
+		a.SignedHeaders = slices.Clone(cfg.Auth.SignedHeaders)
+		a.PKCS11 = clonePtr(cfg.Auth.PKCS11)
+		c.Auth = &a
+	}
+	c.InventoryPaths = slices.Clone(cfg.InventoryPaths)
+	c.APIBaseFallbacks = slices.Clone(cfg.APIBaseFallbacks)
+	c.Labels = maps.Clone(cfg.Labels)
+	c.ExtraHeaders = maps.Clone(cfg.ExtraHeaders)
+	c.CATrustUpdateCommand = slices.Clone(cfg.CATrustUpdateCommand)
+	if cfg.TLS != nil {
+		t := *cfg.TLS
+		t.PinnedSPKISHA256 = slices.Clone(cfg.TLS.PinnedSPKISHA256)
+		c.TLS = &t
+	}
+	c.SelfUpdate = clonePtr(cfg.SelfUpdate)
+	c.PollBackoff = clonePtr(cfg.PollBackoff)
+	return &c
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
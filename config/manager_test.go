@@ -0,0 +1,276 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+// writeMain writes a main config file, with a drop-in setting proxy_url next
+// to it, and returns the main file's path.
+func writeMain(t *testing.T, raw string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "config.d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.d", "10.json"), []byte(`{"proxy_url":"http://dropin:3128"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readMain(t *testing.T, path string) map[string]any {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestManagerSavesMainFileOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		main string
+		// update is applied with Manager.Update, if set.
+		update  func(*Config) error
+		wantSet []string // top-level settings the main file must have
+	}{
+		{
+			name:    "generated keypair",
+			main:    `{"schema_version":1}`,
+			wantSet: []string{"schema_version", "auth"},
+		},
+		{
+			name: "update",
+			main: `{"schema_version":1,"auth":{"key_pair":{"public_key":"pk","private_key":"sk"}},"future_setting":{"x":1}}`,
+			update: func(cfg *Config) error {
+				cfg.Paused = true
+				cfg.Agent = &AgentCreds{AgentID: "agent-1"}
+				return nil
+			},
+			wantSet: []string{"schema_version", "auth", "future_setting", "paused", "agent"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeMain(t, tt.main)
+			mgr, err := NewManager(path, VersionInfo{}, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.update != nil {
+				if err := mgr.Update(tt.update); err != nil {
+					t.Fatalf("Update: %v", err)
+				}
+			}
+			if mgr.Snapshot().ProxyURL != "http://dropin:3128" {
+				t.Errorf("proxy_url = %q, want the drop-in's", mgr.Snapshot().ProxyURL)
+			}
+
+			m := readMain(t, path)
+			if len(m) != len(tt.wantSet) {
+				t.Errorf("main file has %v, want just %v", slices.Sorted(maps.Keys(m)), tt.wantSet)
+			}
+			for _, k := range tt.wantSet {
+				if _, ok := m[k]; !ok {
+					t.Errorf("main file lacks %s: %v", k, slices.Sorted(maps.Keys(m)))
+				}
+			}
+		})
+	}
+}
+
+func TestManagerDropInDir(t *testing.T) {
+	other := t.TempDir()
+	if err := os.WriteFile(filepath.Join(other, "10.json"), []byte(`{"proxy_url":"http://other:3128"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"default", Options{}, "http://dropin:3128"},
+		{"--config-dir", Options{DropInDir: other}, "http://other:3128"},
+		{"missing --config-dir", Options{DropInDir: filepath.Join(other, "missing")}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewManager(writeMain(t, `{"schema_version":1}`), VersionInfo{}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Snapshot().ProxyURL; got != tt.want {
+				t.Errorf("proxy_url = %q, want %q", got, tt.want)
+			}
+			// Reloads read the same drop-ins.
+			if err := m.Reload(nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Snapshot().ProxyURL; got != tt.want {
+				t.Errorf("after reload, proxy_url = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagerHostname(t *testing.T) {
+	path := writeMain(t, `{"schema_version":1,"hostname":"web-1"}`)
+	m, err := NewManager(path, VersionInfo{}, Options{Hostname: "web-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Snapshot().ReportedHostname(); got != "web-2" {
+		t.Errorf("reported hostname = %q, want web-2", got)
+	}
+
+	// Saving another change leaves the config file's hostname alone.
+	if err := m.Update(func(cfg *Config) error {
+		cfg.ProxyURL = "http://saved:3128"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMain(t, path)["hostname"]; got != "web-1" {
+		t.Errorf("saved hostname = %v, want web-1", got)
+	}
+}
+
+// TestManagerConcurrent snapshots, reloads and updates the config at once;
+// run it with -race.
+func TestManagerConcurrent(t *testing.T) {
+	path := writeMain(t, `{"schema_version":1,"labels":{"env":"prod"}}`)
+	dropIn := filepath.Join(filepath.Dir(path), "config.d", "10.json")
+	m, err := NewManager(path, VersionInfo{}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxies := []string{"http://dropin:3128", "http://a:3128", "http://b:3128"}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cfg := m.Snapshot()
+				if !slices.Contains(proxies, cfg.ProxyURL) {
+					t.Errorf("snapshot has proxy_url %q", cfg.ProxyURL)
+					return
+				}
+				// Snapshots are copies the caller may change.
+				cfg.Labels["seen"] = "yes"
+				cfg.ProxyURL = ""
+			}
+		}()
+	}
+
+	for i := range 50 {
+		proxy := proxies[1+i%2]
+		if err := os.WriteFile(dropIn, []byte(`{"proxy_url":"`+proxy+`"}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Reload(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Update(func(cfg *Config) error {
+			cfg.Labels["reload"] = fmt.Sprint(i)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	cfg := m.Snapshot()
+	if cfg.Labels["reload"] != "49" || cfg.Labels["seen"] != "" {
+		t.Errorf("labels = %v, want the last update and no snapshot's changes", cfg.Labels)
+	}
+}
+
+func TestManagerLoadSaveError(t *testing.T) {
+	path := writeMain(t, `{"schema_version":1}`)
+	// A directory where the lock file goes makes saving fail.
+	if err := os.Mkdir(path+".lock", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewManager(path, VersionInfo{}, Options{}); err == nil {
+		t.Fatal("NewManager succeeded without saving the generated keypair")
+	}
+}
+
+func TestManagerInvalidKeyPair(t *testing.T) {
+	a, err := auth.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := auth.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatched := fmt.Sprintf(`"auth":{"key_pair":{"public_key":%q,"private_key":%q}}`, b.PublicKey, a.PrivateKey)
+	tests := []struct {
+		name     string
+		main     string
+		wantErr  bool
+		wantKept bool // the configured public key is still in use, not a new one
+	}{
+		{
+			name:     "valid",
+			main:     fmt.Sprintf(`{"schema_version":1,"auth":{"key_pair":{"public_key":%q,"private_key":%q}}}`, a.PublicKey, a.PrivateKey),
+			wantKept: true,
+		},
+		{
+			name: "mismatched before enrollment",
+			main: `{"schema_version":1,` + mismatched + `}`,
+		},
+		{
+			name:    "mismatched after enrollment",
+			main:    `{"schema_version":1,"agent":{"agent_id":"agent-1"},` + mismatched + `}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeMain(t, tt.main)
+			m, err := NewManager(path, VersionInfo{}, Options{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			kp := m.Snapshot().Auth.KeyPair
+			if err := kp.Validate(); err != nil {
+				t.Errorf("loaded keypair is invalid: %v", err)
+			}
+			if kept := kp.PublicKey == a.PublicKey || kp.PublicKey == b.PublicKey; kept != tt.wantKept {
+				t.Errorf("public key kept = %t, want %t", kept, tt.wantKept)
+			}
+		})
+	}
+}
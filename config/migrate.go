@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersion is the config layout this agent reads and writes. A config
+// without schema_version is version 0, from before it was recorded.
+const SchemaVersion = 1
+
+// migrations[v] upgrades a decoded config from schema version v to v+1.
+var migrations = []func(m map[string]any){
+	migrateV0,
+}
+
+// migrateV0 drops keys early agents persisted by mistake: "omit", the build
+// info Config.Version was written under, and "desired_state", the raw desired
+// state kept in the config before last_applied replaced it.
+func migrateV0(m map[string]any) {
+	delete(m, "omit")
+	delete(m, "desired_state")
+}
+
+// migrate upgrades the contents of the config file at path to SchemaVersion
+// and returns them as JSON. A config from a newer agent is refused rather
+// than misread.
+func migrate(path string, raw []byte) ([]byte, bool, error) {
+	m, err := decodeMap(path, raw)
+	if err != nil {
+		return nil, false, err
+	}
+
+	version := 0
+	switch v := m["schema_version"].(type) {
+	case nil:
+	case float64:
+		version = int(v)
+	case int:
+		version = v
+	default:
+		return nil, false, fmt.Errorf("config file %s: schema_version must be a number", path)
+	}
+	if version > SchemaVersion {
+		return nil, false, fmt.Errorf("config file %s has schema_version %d, but this agent supports up to %d (upgrade certkit-agent)", path, version, SchemaVersion)
+	}
+
+	changed := version < SchemaVersion
+	for ; version < SchemaVersion; version++ {
+		migrations[version](m)
+	}
+	m["schema_version"] = SchemaVersion
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, false, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return b, changed, nil
+}
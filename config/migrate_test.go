@@ -0,0 +1,140 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		path, raw   string
+		wantChanged bool
+		wantErr     bool
+		wantGone    []string
+	}{
+		{
+			name:        "v0 json",
+			path:        "config.json",
+			raw:         `{"api_base":"https://a.example","omit":{"Version":"0.1"},"desired_state":{"version":"3"}}`,
+			wantChanged: true,
+			wantGone:    []string{"omit", "desired_state"},
+		},
+		{
+			name:        "v0 yaml",
+			path:        "config.yaml",
+			raw:         "api_base: https://a.example\nomit:\n  Version: \"0.1\"\n",
+			wantChanged: true,
+			wantGone:    []string{"omit"},
+		},
+		{name: "current", path: "config.json", raw: `{"schema_version":1,"api_base":"https://a.example"}`},
+		{name: "newer", path: "config.json", raw: `{"schema_version":2}`, wantErr: true},
+		{name: "not a number", path: "config.json", raw: `{"schema_version":"1"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, changed, err := migrate(tt.path, []byte(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			var m map[string]any
+			if err := json.Unmarshal(b, &m); err != nil {
+				t.Fatal(err)
+			}
+			if m["schema_version"] != float64(SchemaVersion) || m["api_base"] != "https://a.example" {
+				t.Errorf("migrated to %s", b)
+			}
+			for _, key := range tt.wantGone {
+				if _, ok := m[key]; ok {
+					t.Errorf("%s survived: %s", key, b)
+				}
+			}
+		})
+	}
+}
+
+// TestLoadUpgradesMainFile checks that upgrading a v0 config rewrites only
+// the main file's own settings, not the drop-ins or resolved defaults.
+func TestLoadUpgradesMainFile(t *testing.T) {
+	t.Setenv("CERTKIT_API_BASE", "")
+	t.Setenv("CERTKIT_ENV", "")
+	keyPair, err := auth.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"config.json", "config.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, name)
+			main := map[string]any{
+				"omit": map[string]any{"Version": "0.1"},
+				"auth": map[string]any{"key_pair": keyPair},
+			}
+			var b []byte
+			if name == "config.yaml" {
+				// Through JSON, so the keypair gets its json field names.
+				j, _ := json.Marshal(main)
+				var m map[string]any
+				json.Unmarshal(j, &m)
+				b, err = yaml.Marshal(m)
+			} else {
+				b, err = json.Marshal(main)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, b, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(dir, "config.d"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "config.d", "10-proxy.json"), []byte(`{"proxy_url":"http://proxy:3128"}`), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			mgr, err := NewManager(path, VersionInfo{}, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg := mgr.Snapshot(); cfg.ProxyURL != "http://proxy:3128" || cfg.ApiBase != defaultAPIBase {
+				t.Fatalf("loaded proxy_url %q, api_base %q", cfg.ProxyURL, cfg.ApiBase)
+			}
+
+			saved, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := decodeMap(path, saved)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"omit", "proxy_url", "api_base"} {
+				if _, ok := m[key]; ok {
+					t.Errorf("%s written to the main file:\n%s", key, saved)
+				}
+			}
+			if !strings.Contains(string(saved), keyPair.PrivateKey) {
+				t.Errorf("keypair lost:\n%s", saved)
+			}
+			if v, ok := m["schema_version"]; !ok || v != SchemaVersion && v != float64(SchemaVersion) {
+				t.Errorf("schema_version = %v, want %d", v, SchemaVersion)
+			}
+			if _, err := os.Stat(backupPath(path, 0)); err != nil {
+				t.Errorf("no backup of the v0 file: %v", err)
+			}
+		})
+	}
+}
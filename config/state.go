@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// DefaultStateDir matches StateDirectory=certkit-agent in the generated unit.
+const DefaultStateDir = "/var/lib/certkit-agent"
+
+const (
+	lastAppliedFile    = "last-applied.json"
+	lastReconcileFile  = "last-reconcile.json"
+	pendingReloadsFile = "pending-reloads.json"
+)
+
+// StateDir returns where runtime state (last applied state, host keys, locks)
+// is kept: override (--state-dir) if set, else the first entry of
+// $STATE_DIRECTORY that systemd sets for the service, else DefaultStateDir.
+func StateDir(override string) string {
+	if override != "" {
+		return override
+	}
+	if dir, _, _ := strings.Cut(os.Getenv("STATE_DIRECTORY"), ":"); dir != "" {
+		return dir
+	}
+	return DefaultStateDir
+}
+
+// KeyDir returns where keys generated on this host are kept: a keys
+// directory in stateDir.
+func KeyDir(stateDir string) string {
+	return filepath.Join(stateDir, "keys")
+}
+
+// lastApplied is the on-disk form of the last applied state. Fingerprint is
+// the hash of the desired state it came from, kept at the top level so it can
+// be compared without decoding the rest.
+type lastApplied struct {
+	Fingerprint string         `json:"fingerprint"`
+	Applied     *state.Applied `json:"applied"`
+}
+
+// ReadLastApplied returns the applied state saved in the state directory
+// dir, or nil if there is none yet.
+func ReadLastApplied(dir string) (*state.Applied, error) {
+	path := filepath.Join(dir, lastAppliedFile)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var la lastApplied
+	if err := json.Unmarshal(b, &la); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return la.Applied, nil
+}
+
+// SaveLastApplied persists applied to the state directory dir, so a restart
+// doesn't redeploy everything.
+func SaveLastApplied(dir string, applied *state.Applied) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	la := lastApplied{Applied: applied}
+	if applied != nil {
+		la.Fingerprint = applied.Hash
+	}
+	b, err := json.MarshalIndent(la, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(dir, lastAppliedFile), append(b, '\n'), 0o600)
+}
+
+// ReadPendingReloads returns the reloads saved in dir by SavePendingReloads,
+// or nil if there are none.
+func ReadPendingReloads(dir string) ([]*state.Reload, error) {
+	path := filepath.Join(dir, pendingReloadsFile)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var reloads []*state.Reload
+	if err := json.Unmarshal(b, &reloads); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return reloads, nil
+}
+
+// SavePendingReloads records reloads that are due before the files they're
+// for are written, until they've run. Had the agent stopped in between, the
+// files would already be on disk next time, and nothing else would reload
+// the services. They're kept in the state directory dir. Empty reloads
+// clears them.
+func SavePendingReloads(dir string, reloads []*state.Reload) error {
+	path := filepath.Join(dir, pendingReloadsFile)
+	if len(reloads) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(reloads, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, append(b, '\n'), 0o600)
+}
+
+// ReadLastReconcile returns the result of the last reconcile saved in the
+// state directory dir, or nil if there is none yet.
+func ReadLastReconcile(dir string) (*state.ReconcileResult, error) {
+	path := filepath.Join(dir, lastReconcileFile)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r state.ReconcileResult
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// SaveLastReconcile persists r to the state directory dir, so its error
+// survives a restart.
+func SaveLastReconcile(dir string, r *state.ReconcileResult) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(dir, lastReconcileFile), append(b, '\n'), 0o600)
+}
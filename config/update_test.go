@@ -0,0 +1,94 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+func TestUpdateFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		raw  string
+	}{
+		{"json", "config.json", `{"schema_version":1,"proxy_url":"http://main:3128","future_setting":{"x":1}}`},
+		{"yaml", "config.yaml", "schema_version: 1\nproxy_url: http://main:3128\nfuture_setting:\n  x: 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.raw), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(dir, "config.d"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "config.d", "10.json"), []byte(`{"proxy_url":"http://dropin:3128"}`), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := UpdateFile(path, func(m map[string]any) error {
+				if m["proxy_url"] != "http://main:3128" {
+					t.Errorf("fn got proxy_url %v, want the main file's", m["proxy_url"])
+				}
+				m["paused"] = true
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, _ := os.ReadFile(path)
+			m, err := decodeMap(path, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m["paused"] != true || m["proxy_url"] != "http://main:3128" || m["future_setting"] == nil {
+				t.Errorf("saved:\n%s", b)
+			}
+			if _, ok := m["api_base"]; ok {
+				t.Errorf("resolved api_base saved:\n%s", b)
+			}
+		})
+	}
+}
+
+func TestUpdateFileWaitsForLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"schema_version":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := utils.Lock(path + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lock is per open file, so this process can contend with itself.
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		close(released)
+		lock.Unlock()
+	}()
+
+	err = UpdateFile(path, func(m map[string]any) error {
+		select {
+		case <-released:
+		default:
+			t.Error("updated while another writer held the lock")
+		}
+		m["paused"] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); !strings.Contains(string(b), `"paused": true`) {
+		t.Fatalf("saved:\n%s", b)
+	}
+}
@@ -0,0 +1,59 @@
+package deploy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// trustUpdateCommands are the distributions' trust store update commands.
+var trustUpdateCommands = [][]string{
+	{"update-ca-trust", "extract"}, // RHEL, Fedora, Amazon Linux
+	{"update-ca-certificates"},     // Debian, Ubuntu, SUSE, Alpine
+	{"trust", "extract-compat"},    // Arch
+}
+
+// trustStoreDirs are where those commands write the extracted trust store.
+var trustStoreDirs = []string{
+	"/etc/pki/ca-trust/extracted",
+	"/etc/ssl/certs",
+	"/etc/ca-certificates",
+}
+
+// trustUpdateCommand returns configured, the ca_trust_update_command setting,
+// or if that's empty the first of trustUpdateCommands that's installed.
+func trustUpdateCommand(configured []string) ([]string, error) {
+	if len(configured) > 0 {
+		return configured, nil
+	}
+	for _, cmd := range trustUpdateCommands {
+		if _, err := exec.LookPath(cmd[0]); err == nil {
+			return cmd, nil
+		}
+	}
+	return nil, fmt.Errorf("no trust store update command found (update-ca-trust, update-ca-certificates or trust); set ca_trust_update_command")
+}
+
+// TrustStoreDirs returns the directories on this host the trust store update
+// commands write to, which the service must be allowed to write.
+func TrustStoreDirs() []string {
+	var dirs []string
+	for _, dir := range trustStoreDirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// caBundleFiles returns the file deploying c to a CA bundle target writes: the
+// certificate's chain alone, for the system trust store, without the leaf.
+func caBundleFiles(t *state.Target, c *state.Certificate, chain []*x509.Certificate) ([]file, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("target %s: ca_bundle needs a chain but certificate %s has none", t.ID, c.ID)
+	}
+	return []file{{target: t.ID, kind: "ca bundle", path: t.CertPath, data: []byte(encodeChain(chain)), perm: 0o644}}, nil
+}
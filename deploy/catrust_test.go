@@ -0,0 +1,112 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+func TestCABundleFiles(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	intermediate := ca.Issue(t, testcerts.Options{CommonName: "intermediate", IsCA: true})
+	leaf := intermediate.Leaf(t)
+
+	tests := []struct {
+		name    string
+		chain   string
+		wantErr bool
+	}{
+		{name: "chain", chain: leaf.ChainPEM()},
+		{name: "no chain", chain: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &state.Target{ID: "t1", Format: state.FormatCABundle, CertPath: filepath.Join(t.TempDir(), "anchor.pem")}
+			c := &state.Certificate{ID: "c1", Cert: leaf.PEM(), Chain: tt.chain}
+			files, err := targetContents(target, c, ca.Pool())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(files) != 1 || files[0].path != target.CertPath {
+				t.Fatalf("files = %+v, want just %s", files, target.CertPath)
+			}
+			got := string(files[0].data)
+			if !strings.Contains(got, intermediate.PEM()) || strings.Contains(got, leaf.PEM()) {
+				t.Errorf("bundle = %q, want the intermediate without the leaf", got)
+			}
+		})
+	}
+}
+
+func TestTrustReloader(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("trust store updates are Unix only")
+	}
+	tests := []struct {
+		name       string
+		configured []string
+		path       []string // programs installed on PATH
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "configured",
+			configured: []string{"/usr/sbin/my-update-trust", "--quiet"},
+			path:       []string{"update-ca-certificates"},
+			want:       []string{"/usr/sbin/my-update-trust", "--quiet"},
+		},
+		{
+			name: "installed default",
+			path: []string{"update-ca-certificates"},
+			want: []string{"update-ca-certificates"},
+		},
+		{
+			name: "first default wins",
+			path: []string{"update-ca-certificates", "update-ca-trust"},
+			want: []string{"update-ca-trust", "extract"},
+		},
+		{
+			name:    "none installed",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := t.TempDir()
+			for _, name := range tt.path {
+				if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("PATH", bin)
+
+			var ran []string
+			exec := func(name string, args ...string) error {
+				ran = append([]string{name}, args...)
+				return nil
+			}
+			r, err := newReloader(&state.Reload{Type: state.ReloadTrust}, tt.configured, exec, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if err := r.Reload(); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ran, tt.want) {
+				t.Errorf("ran %q, want %q", ran, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package deploy
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"slices"
+)
+
+// parseChain parses the PEM certificates in chain, in order.
+func parseChain(chain string) ([]*x509.Certificate, error) {
+	var parsed []*x509.Certificate
+	rest := []byte(chain)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return parsed, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, cert)
+	}
+}
+
+// orderChain returns chain ordered so that leaf is followed by its issuer, then
+// that certificate's issuer and so on, as TLS servers must send it. A chain
+// given out of order is reordered; one with a certificate that isn't part of
+// leaf's path is rejected.
+func orderChain(leaf *x509.Certificate, chain []*x509.Certificate) ([]*x509.Certificate, error) {
+	remaining := slices.Clone(chain)
+	ordered := make([]*x509.Certificate, 0, len(chain))
+	current := leaf
+	for len(remaining) > 0 {
+		i := slices.IndexFunc(remaining, func(c *x509.Certificate) bool { return issuedBy(current, c) })
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not an issuer above %q", remaining[0].Subject.String(), current.Subject.String())
+		}
+		current = remaining[i]
+		ordered = append(ordered, current)
+		remaining = slices.Delete(remaining, i, i+1)
+	}
+	return ordered, nil
+}
+
+// issuedBy reports whether cert was signed by issuer.
+func issuedBy(cert, issuer *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, issuer.RawSubject) && cert.CheckSignatureFrom(issuer) == nil
+}
+
+// encodeChain PEM encodes certs, in order.
+func encodeChain(certs []*x509.Certificate) string {
+	var b bytes.Buffer
+	for _, c := range certs {
+		pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package deploy
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+func TestChainOutputs(t *testing.T) {
+	root := testcerts.NewCA(t, "root")
+	mid := root.Issue(t, testcerts.Options{CommonName: "intermediate 1", IsCA: true})
+	low := mid.Issue(t, testcerts.Options{CommonName: "intermediate 2", IsCA: true})
+	leaf := low.Leaf(t)
+	stray := testcerts.NewCA(t, "other").Issue(t, testcerts.Options{CommonName: "stray", IsCA: true})
+	ordered := testcerts.EncodePEM(low.Cert, mid.Cert)
+
+	tests := []struct {
+		name      string
+		chain     []*x509.Certificate
+		fullchain bool // whether the target has fullchain_path and chain_path
+		wantCert  string
+		wantErr   bool
+	}{
+		{name: "in order", chain: []*x509.Certificate{low.Cert, mid.Cert}, fullchain: true, wantCert: leaf.PEM()},
+		{name: "out of order is reordered", chain: []*x509.Certificate{mid.Cert, low.Cert}, fullchain: true, wantCert: leaf.PEM()},
+		{name: "cert_path alone gets the chain", chain: []*x509.Certificate{mid.Cert, low.Cert}, wantCert: leaf.PEM() + ordered},
+		{name: "stray certificate rejected", chain: []*x509.Certificate{low.Cert, stray.Cert, mid.Cert}, fullchain: true, wantErr: true},
+		{name: "gap rejected", chain: []*x509.Certificate{mid.Cert}, fullchain: true, wantErr: true},
+		{name: "chain_path without a chain", fullchain: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := &state.Target{ID: "t1", CertPath: filepath.Join(dir, "cert.pem"), KeyPath: filepath.Join(dir, "key.pem")}
+			if tt.fullchain {
+				target.FullchainPath = filepath.Join(dir, "fullchain.pem")
+				target.ChainPath = filepath.Join(dir, "chain.pem")
+			}
+			cert := &state.Certificate{ID: "c1", Cert: leaf.PEM(), Chain: testcerts.EncodePEM(tt.chain...), Key: leaf.KeyPEM()}
+
+			err := WriteTarget(target, cert, root.Pool())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteTarget: err = %v, wantErr %t", err, tt.wantErr)
+			}
+			want := map[string]string{target.CertPath: tt.wantCert}
+			if tt.fullchain {
+				want[target.FullchainPath] = leaf.PEM() + ordered
+				want[target.ChainPath] = ordered
+			}
+			for path, data := range want {
+				got, err := os.ReadFile(path)
+				if tt.wantErr {
+					if err == nil {
+						t.Errorf("%s written for a rejected chain", filepath.Base(path))
+					}
+					continue
+				}
+				if string(got) != data {
+					t.Errorf("%s:\n%s\nwant:\n%s", filepath.Base(path), got, data)
+				}
+			}
+		})
+	}
+}
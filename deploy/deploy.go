@@ -0,0 +1,220 @@
+package deploy
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/certkit-io/certkit-agent-alpha/certs"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// file is a single file written by a target deploy.
+type file struct {
+	target string
+	kind   string // "cert", "fullchain", "chain", "key", "pkcs12", "ca bundle", "ocsp staple" or "host key"
+	path   string
+	data   []byte
+	perm   os.FileMode
+	owner  *owner // nil keeps the owner WriteFileAtomic leaves
+	mkdir  bool   // create the parent directory first (create_dirs)
+	remove bool   // remove the file instead (data is unused)
+}
+
+// write writes f, returning any directories it had to create (see makeDirs).
+func (f *file) write() ([]string, error) {
+	if f.remove {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("target %s: remove %s %s: %w", f.target, f.kind, f.path, err)
+		}
+		return nil, nil
+	}
+	var created []string
+	if f.mkdir {
+		var err error
+		created, err = makeDirs(filepath.Dir(f.path))
+		if err != nil {
+			return nil, fmt.Errorf("target %s: create directory for %s %s: %w%s", f.target, f.kind, f.path, err, permissionHint(err))
+		}
+	}
+	// Chown before the rename, so a key never sits at its path readable by
+	// whoever owned the file before.
+	var chownErr error
+	var prepare func(string) error
+	if f.owner != nil {
+		prepare = func(tmp string) error {
+			chownErr = chown(tmp, f.owner.uid, f.owner.gid)
+			return chownErr
+		}
+	}
+	if err := utils.WriteFileAtomicPrepared(f.path, f.data, f.perm, prepare); err != nil {
+		if chownErr != nil {
+			return created, fmt.Errorf("target %s: chown %s %s to %s: %w%s", f.target, f.kind, f.path, f.owner, err, permissionHint(err))
+		}
+		return created, fmt.Errorf("target %s: write %s %s: %w%s", f.target, f.kind, f.path, err, readOnlyHint(err, f.path))
+	}
+	return created, nil
+}
+
+// onDisk reports whether f's path already holds f's data with its mode and
+// owner, so writing it would change nothing.
+func (f *file) onDisk() bool {
+	info, err := os.Lstat(f.path)
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm() != f.perm || info.Size() != int64(len(f.data)) {
+		return false
+	}
+	if f.owner != nil {
+		uid, gid, ok := utils.FileOwner(info)
+		if ok && (f.owner.uid >= 0 && uid != f.owner.uid || f.owner.gid >= 0 && gid != f.owner.gid) {
+			return false
+		}
+	}
+	data, err := os.ReadFile(f.path)
+	return err == nil && bytes.Equal(data, f.data)
+}
+
+// targetFiles validates a target and returns the files deploying c to it writes:
+// the certificate (leaf followed by chain), and if the target has them, the
+// fullchain and chain files (in which case the certificate is just the leaf)
+// and the key. A PKCS#12 target gets a single bundle instead, and a CA bundle
+// target just the chain.
+// The certificate must chain to roots (see verifyTrust).
+// A missing directory fails with ErrMissingDir unless the target has create_dirs.
+func targetFiles(t *state.Target, c *state.Certificate, roots *x509.CertPool) ([]file, error) {
+	files, err := targetContents(t, c, roots)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyOwnership(t, files); err != nil {
+		return nil, err
+	}
+	if err := resolveSymlinks(t, files); err != nil {
+		return nil, err
+	}
+	if err := checkDirs(t, files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// targetContents returns targetFiles' files with their default permissions.
+func targetContents(t *state.Target, c *state.Certificate, roots *x509.CertPool) ([]file, error) {
+	if !filepath.IsAbs(t.CertPath) {
+		return nil, fmt.Errorf("target %s: cert_path must be absolute: %s", t.ID, t.CertPath)
+	}
+	block, _ := pem.Decode([]byte(c.Cert))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("target %s: certificate %s is not a PEM certificate", t.ID, c.ID)
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: certificate %s: %w", t.ID, c.ID, err)
+	}
+
+	chain, err := parseChain(c.Chain)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: chain for certificate %s: %w", t.ID, c.ID, err)
+	}
+	chain, err = orderChain(leaf, chain)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: chain for certificate %s: %w", t.ID, c.ID, err)
+	}
+	if err := verifyTrust(leaf, chain, roots); err != nil {
+		return nil, fmt.Errorf("target %s: certificate %s: %w", t.ID, c.ID, err)
+	}
+
+	switch t.Format {
+	case "", state.FormatPEM:
+	case state.FormatPKCS12:
+		return pkcs12Files(t, c, leaf, chain)
+	case state.FormatCABundle:
+		return caBundleFiles(t, c, chain)
+	default:
+		return nil, fmt.Errorf("target %s: unknown format %q", t.ID, t.Format)
+	}
+
+	leafPEM := strings.TrimSpace(c.Cert) + "\n"
+	chainPEM := encodeChain(chain)
+	certPEM := leafPEM + chainPEM
+	if t.FullchainPath != "" || t.ChainPath != "" {
+		certPEM = leafPEM
+	}
+	files := []file{{target: t.ID, kind: "cert", path: t.CertPath, data: []byte(certPEM), perm: 0o644}}
+	if t.FullchainPath != "" {
+		if !filepath.IsAbs(t.FullchainPath) {
+			return nil, fmt.Errorf("target %s: fullchain_path must be absolute: %s", t.ID, t.FullchainPath)
+		}
+		files = append(files, file{target: t.ID, kind: "fullchain", path: t.FullchainPath, data: []byte(leafPEM + chainPEM), perm: 0o644})
+	}
+	if t.ChainPath != "" {
+		if !filepath.IsAbs(t.ChainPath) {
+			return nil, fmt.Errorf("target %s: chain_path must be absolute: %s", t.ID, t.ChainPath)
+		}
+		if len(chain) == 0 {
+			return nil, fmt.Errorf("target %s: chain_path set but certificate %s has no chain", t.ID, c.ID)
+		}
+		files = append(files, file{target: t.ID, kind: "chain", path: t.ChainPath, data: []byte(chainPEM), perm: 0o644})
+	}
+
+	if t.KeyPath == "" {
+		return files, nil
+	}
+	if !filepath.IsAbs(t.KeyPath) {
+		return nil, fmt.Errorf("target %s: key_path must be absolute: %s", t.ID, t.KeyPath)
+	}
+	if c.Key == "" {
+		return nil, fmt.Errorf("target %s: key_path set but certificate %s has no key", t.ID, c.ID)
+	}
+	if _, err := leafKey(t, c, leaf); err != nil {
+		return nil, err
+	}
+	files = append(files, file{target: t.ID, kind: "key", path: t.KeyPath, data: []byte(strings.TrimSpace(c.Key) + "\n"), perm: 0o600})
+
+	return files, nil
+}
+
+// leafKey parses c.Key and makes sure it's the private key for leaf, so a
+// mismatched pair is refused before anything is staged rather than breaking
+// the service's next TLS handshake.
+func leafKey(t *state.Target, c *state.Certificate, leaf *x509.Certificate) (crypto.Signer, error) {
+	key, err := certs.ParsePrivateKey([]byte(c.Key))
+	if err != nil {
+		return nil, fmt.Errorf("target %s: key for certificate %s: %w", t.ID, c.ID, err)
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return nil, fmt.Errorf("target %s: key for certificate %s doesn't match the certificate", t.ID, c.ID)
+	}
+	return key, nil
+}
+
+// WriteTarget writes a certificate, its chain and its key to the target paths (see targetFiles).
+func WriteTarget(t *state.Target, c *state.Certificate, roots *x509.CertPool) error {
+	files, err := targetFiles(t, c, roots)
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		if _, err := files[i].write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readOnlyHint explains a read-only filesystem error, which under the systemd
+// sandbox usually means the directory is missing from ReadWritePaths.
+func readOnlyHint(err error, path string) string {
+	if !errors.Is(err, syscall.EROFS) {
+		return ""
+	}
+	return fmt.Sprintf(" (reinstall with --writable-path %s)", filepath.Dir(path))
+}
@@ -0,0 +1,68 @@
+package deploy
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/certkit-io/certkit-agent-alpha/certs"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// DeployedLeaf reads the leaf certificate back from t's cert_path, so what's
+// reported as deployed is what's on disk rather than what was meant to be.
+func DeployedLeaf(t *state.Target) (*x509.Certificate, error) {
+	data, err := os.ReadFile(t.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: %w", t.ID, err)
+	}
+
+	if t.Format == state.FormatPKCS12 {
+		password, err := utils.ResolveSecret(t.PasswordRef)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: password_ref: %w", t.ID, err)
+		}
+		_, leaf, _, err := pkcs12.DecodeChain(data, password)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: decode %s: %w", t.ID, t.CertPath, err)
+		}
+		return leaf, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("target %s: %s does not start with a PEM certificate", t.ID, t.CertPath)
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: %s: %w", t.ID, t.CertPath, err)
+	}
+	return leaf, nil
+}
+
+// Drifted reports whether t's files are no longer on disk as deploying c
+// would write them, e.g. because one was edited or removed by hand. A PKCS#12
+// bundle is encrypted afresh on every write, so for one only the certificate
+// it holds is compared. roots are as for Transaction.Roots.
+func Drifted(t *state.Target, c *state.Certificate, roots *x509.CertPool) (bool, error) {
+	if t.Format == state.FormatPKCS12 {
+		leaf, err := DeployedLeaf(t)
+		if err != nil {
+			return true, nil
+		}
+		return certs.FingerprintDER(leaf.Raw) != certs.Fingerprint(c.Cert), nil
+	}
+	files, err := targetFiles(t, c, roots)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range files {
+		if !f.onDisk() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,63 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// ErrMissingDir means a target writes into a directory that doesn't exist,
+// typically because the service it's for isn't installed yet, and the target
+// doesn't set create_dirs.
+var ErrMissingDir = errors.New("directory does not exist")
+
+// checkDirs makes sure every directory files are written into exists, or,
+// for a target with create_dirs, marks the files to create it on write.
+func checkDirs(t *state.Target, files []file) error {
+	for i := range files {
+		dir := filepath.Dir(files[i].path)
+		info, err := os.Stat(dir)
+		switch {
+		case err == nil && !info.IsDir():
+			return fmt.Errorf("target %s: %s is not a directory", t.ID, dir)
+		case err == nil:
+		case errors.Is(err, os.ErrNotExist) && t.CreateDirs:
+			files[i].mkdir = true
+		case errors.Is(err, os.ErrNotExist):
+			return fmt.Errorf("target %s: %w: %s (set create_dirs to create it)", t.ID, ErrMissingDir, dir)
+		default:
+			return fmt.Errorf("target %s: stat %s: %w", t.ID, dir, err)
+		}
+	}
+	return nil
+}
+
+// mkdirMu serializes makeDirs, so files written at once into the same new
+// directory don't both claim to have created it.
+var mkdirMu sync.Mutex
+
+// makeDirs creates dir and any missing parents, returning the directories it
+// created, outermost first, so they can be removed again on rollback.
+func makeDirs(dir string) ([]string, error) {
+	mkdirMu.Lock()
+	defer mkdirMu.Unlock()
+
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		missing = append([]string{d}, missing...)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
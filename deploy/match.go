@@ -0,0 +1,87 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// MatchHostname reports whether a certificate DNS SAN covers hostname.
+// A wildcard covers exactly one label: *.example.com matches a.example.com
+// but neither example.com nor a.b.example.com.
+func MatchHostname(san, hostname string) bool {
+	san = strings.ToLower(strings.TrimSuffix(san, "."))
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if san == hostname {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(san, "*.")
+	if !ok {
+		return false
+	}
+	label, rest, ok := strings.Cut(hostname, ".")
+	return ok && label != "" && rest == suffix
+}
+
+// certCovers returns how well the certificate's SANs cover every hostname:
+// 0 if some hostname isn't covered, otherwise 1 plus the number of hostnames
+// matched exactly rather than by wildcard, so exact matches win.
+func certCovers(c *state.Certificate, hostnames []string) int {
+	leaf, err := firstCertificate(c.Cert)
+	if err != nil {
+		return 0
+	}
+	score := 1
+	for _, h := range hostnames {
+		covered, exact := false, false
+		for _, san := range leaf.DNSNames {
+			if MatchHostname(san, h) {
+				covered = true
+				exact = exact || !strings.HasPrefix(san, "*.")
+			}
+		}
+		if !covered {
+			return 0
+		}
+		if exact {
+			score++
+		}
+	}
+	return score
+}
+
+// MatchTargets assigns a certificate to every target that declares Hostnames
+// instead of a CertificateID, picking the certificate whose DNS SANs cover all
+// of them (preferring exact over wildcard matches). It returns warnings for
+// targets left without a certificate and certificates no target uses.
+func MatchTargets(ds *state.DesiredState) []string {
+	var warnings []string
+	used := map[string]bool{}
+
+	for i := range ds.Targets {
+		t := &ds.Targets[i]
+		if t.CertificateID == "" && len(t.Hostnames) > 0 {
+			best := 0
+			for j := range ds.Certificates {
+				c := &ds.Certificates[j]
+				if score := certCovers(c, t.Hostnames); score > best {
+					best = score
+					t.CertificateID = c.ID
+				}
+			}
+		}
+		if t.CertificateID == "" || ds.Certificate(t.CertificateID) == nil {
+			warnings = append(warnings, fmt.Sprintf("target %s has no matching certificate (hostnames: %s)", t.ID, strings.Join(t.Hostnames, ", ")))
+			continue
+		}
+		used[t.CertificateID] = true
+	}
+
+	for _, c := range ds.Certificates {
+		if !used[c.ID] {
+			warnings = append(warnings, fmt.Sprintf("certificate %s has no matching target", c.ID))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,122 @@
+package deploy
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+func TestMatchHostname(t *testing.T) {
+	tests := []struct {
+		san, hostname string
+		want          bool
+	}{
+		{"a.example.com", "a.example.com", true},
+		{"A.Example.com", "a.example.COM", true},
+		{"a.example.com.", "a.example.com", true},
+		{"a.example.com", "b.example.com", false},
+		{"*.example.com", "a.example.com", true},
+		{"*.example.com", "A.EXAMPLE.com.", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", ".example.com", false},
+		{"*.example.com", "a.example.org", false},
+		{"*.a.example.com", "b.a.example.com", true},
+		{"a*.example.com", "ab.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := MatchHostname(tt.san, tt.hostname); got != tt.want {
+			t.Errorf("MatchHostname(%q, %q) = %t, want %t", tt.san, tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTargets(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	certs := map[string]state.Certificate{}
+	for id, sans := range map[string][]string{
+		"wildcard": {"*.example.com"},
+		"multi":    {"www.example.com", "api.example.com", "example.com"},
+		"other":    {"example.org"},
+	} {
+		certs[id] = state.Certificate{ID: id, Cert: ca.Leaf(t, sans...).PEM()}
+	}
+
+	tests := []struct {
+		name         string
+		certificates []string
+		hostnames    []string
+		certificate  string // declared by the target
+		want         string // matched
+		wantWarnings []string
+	}{
+		{
+			name:         "wildcard",
+			certificates: []string{"wildcard", "other"},
+			hostnames:    []string{"a.example.com"},
+			want:         "wildcard",
+			wantWarnings: []string{"certificate other has no matching target"},
+		},
+		{
+			name:         "exact beats wildcard",
+			certificates: []string{"wildcard", "multi"},
+			hostnames:    []string{"www.example.com"},
+			want:         "multi",
+			wantWarnings: []string{"certificate wildcard has no matching target"},
+		},
+		{
+			name:         "every hostname covered by one cert",
+			certificates: []string{"wildcard", "multi"},
+			hostnames:    []string{"api.example.com", "example.com"},
+			want:         "multi",
+			wantWarnings: []string{"certificate wildcard has no matching target"},
+		},
+		{
+			name:         "wildcard covers one label",
+			certificates: []string{"wildcard"},
+			hostnames:    []string{"a.b.example.com"},
+			wantWarnings: []string{
+				"target t1 has no matching certificate (hostnames: a.b.example.com)",
+				"certificate wildcard has no matching target",
+			},
+		},
+		{
+			name:         "no cert covers all hostnames",
+			certificates: []string{"wildcard", "other"},
+			hostnames:    []string{"a.example.com", "example.org"},
+			wantWarnings: []string{
+				"target t1 has no matching certificate (hostnames: a.example.com, example.org)",
+				"certificate wildcard has no matching target",
+				"certificate other has no matching target",
+			},
+		},
+		{
+			name:         "declared certificate kept",
+			certificates: []string{"wildcard", "other"},
+			hostnames:    []string{"a.example.com"},
+			certificate:  "other",
+			want:         "other",
+			wantWarnings: []string{"certificate wildcard has no matching target"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &state.DesiredState{
+				Targets: []state.Target{{ID: "t1", CertificateID: tt.certificate, Hostnames: tt.hostnames}},
+			}
+			for _, id := range tt.certificates {
+				ds.Certificates = append(ds.Certificates, certs[id])
+			}
+
+			warnings := MatchTargets(ds)
+			if got := ds.Targets[0].CertificateID; got != tt.want {
+				t.Errorf("matched %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(warnings, tt.wantWarnings) {
+				t.Errorf("warnings = %q, want %q", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package deploy
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// OCSPRefreshInterval is how old a stapling file may get before it is refetched.
+const OCSPRefreshInterval = 12 * time.Hour
+
+// ocspClient fetches OCSP responses. Responders are plain HTTP and usually fast.
+var ocspClient = &http.Client{Timeout: 15 * time.Second}
+
+// StaplePath returns where the OCSP response for a cert deployed to certPath is written.
+func StaplePath(certPath string) string {
+	return certPath + ".ocsp"
+}
+
+// OCSPServer returns the first OCSP responder URL from the leaf's AIA extension.
+func OCSPServer(leaf *x509.Certificate) (string, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return "", fmt.Errorf("certificate %s has no OCSP responder in its AIA extension", leaf.Subject)
+	}
+	return leaf.OCSPServer[0], nil
+}
+
+// FetchOCSP gets a DER OCSP response for c's leaf from its issuer's responder.
+// The issuer is the first certificate in c.Chain. Only a "good" response is returned.
+func FetchOCSP(c *state.Certificate) ([]byte, error) {
+	leaf, err := firstCertificate(c.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %w", c.ID, err)
+	}
+	issuer, err := firstCertificate(c.Chain)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: issuer: %w", c.ID, err)
+	}
+	server, err := OCSPServer(leaf)
+	if err != nil {
+		return nil, err
+	}
+
+	reqDER, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create OCSP request: %w", err)
+	}
+	resp, err := ocspClient.Post(server, "application/ocsp-request", bytes.NewReader(reqDER))
+	if err != nil {
+		return nil, fmt.Errorf("OCSP responder %s: %w", server, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s: HTTP %d", server, resp.StatusCode)
+	}
+	der, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("OCSP responder %s: %w", server, err)
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("OCSP responder %s: %w", server, err)
+	}
+	if parsed.Status != ocsp.Good {
+		return nil, fmt.Errorf("OCSP responder %s: certificate status is not good (%d)", server, parsed.Status)
+	}
+	return der, nil
+}
+
+func firstCertificate(pemData string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// StageStaple fetches the OCSP response for a target that opted into stapling and
+// stages it next to the cert, so it is committed and rolled back with it. If
+// the response can't be fetched, the staple on disk, which is for the
+// certificate being replaced, is staged for removal instead and the error
+// returned.
+func (tx *Transaction) StageStaple(t *state.Target, c *state.Certificate) error {
+	if prev, ok := tx.claimed[StaplePath(t.CertPath)]; ok && prev.kind == "ocsp staple" {
+		return nil // another target shares the certificate file
+	}
+	f := file{target: t.ID, kind: "ocsp staple", path: StaplePath(t.CertPath), perm: 0o644}
+	der, fetchErr := FetchOCSP(c)
+	if fetchErr != nil {
+		if _, err := os.Lstat(f.path); err != nil {
+			return fmt.Errorf("target %s: %w", t.ID, fetchErr)
+		}
+		f.remove = true
+	}
+	f.data = der
+	claimed, err := tx.claim([]file{f})
+	if err != nil {
+		return err
+	}
+	if !claimed[f.path] {
+		tx.staged = append(tx.staged, f)
+	}
+	if fetchErr != nil {
+		return fmt.Errorf("target %s: %w; removing the staple for the old certificate", t.ID, fetchErr)
+	}
+	return nil
+}
+
+// StapleStale reports whether the target's stapling file is missing or older
+// than OCSPRefreshInterval.
+func StapleStale(t *state.Target, now time.Time) bool {
+	info, err := os.Stat(StaplePath(t.CertPath))
+	return err != nil || now.Sub(info.ModTime()) > OCSPRefreshInterval
+}
+
+// RefreshStaple refetches the target's OCSP response. If the responder is
+// unavailable, the last good response is left in place and the error returned.
+func RefreshStaple(t *state.Target, c *state.Certificate) error {
+	der, err := FetchOCSP(c)
+	if err != nil {
+		if _, statErr := os.Stat(StaplePath(t.CertPath)); statErr == nil {
+			log.Printf("target %s: keeping last good OCSP response", t.ID)
+		}
+		return fmt.Errorf("target %s: %w", t.ID, err)
+	}
+	f := file{target: t.ID, kind: "ocsp staple", path: StaplePath(t.CertPath), data: der, perm: 0o644}
+	_, err = f.write()
+	return err
+}
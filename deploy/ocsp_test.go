@@ -0,0 +1,111 @@
+package deploy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// ocspResponder answers every OCSP request with a good response signed by ca,
+// or fails with HTTP 503 while down is set.
+func ocspResponder(t *testing.T, ca *testcerts.Cert, down bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read OCSP request: %v", err)
+			return
+		}
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			t.Errorf("parse OCSP request: %v", err)
+			return
+		}
+		der, err := ocsp.CreateResponse(ca.Cert, ca.Cert, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, ca.Key)
+		if err != nil {
+			t.Errorf("create OCSP response: %v", err)
+			return
+		}
+		w.Write(der)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStageStaple(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	tests := []struct {
+		name        string
+		oldStaple   bool
+		down        bool
+		wantErr     bool
+		wantStaple  bool // after Commit
+		wantUpdated bool // the staple is the new response
+	}{
+		{name: "replaced", oldStaple: true, wantStaple: true, wantUpdated: true},
+		{name: "added", wantStaple: true, wantUpdated: true},
+		// Left in place it would be served with the new certificate.
+		{name: "responder down, old staple removed", oldStaple: true, down: true, wantErr: true},
+		{name: "responder down, no staple", down: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responder := ocspResponder(t, ca, tt.down)
+			leaf := ca.Issue(t, testcerts.Options{CommonName: "leaf", DNSNames: []string{"localhost"}, OCSPServer: []string{responder.URL}})
+			target := &state.Target{ID: "t1", CertPath: filepath.Join(t.TempDir(), "cert.pem"), OCSPStaple: true}
+			staple := StaplePath(target.CertPath)
+			if tt.oldStaple {
+				if err := os.WriteFile(staple, []byte("old staple"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tx := &Transaction{}
+			err := tx.StageStaple(target, &state.Certificate{ID: "c1", Cert: leaf.PEM(), Chain: ca.PEM()})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StageStaple err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(staple)
+			if exists := err == nil; exists != tt.wantStaple {
+				t.Fatalf("staple exists = %v, want %v", exists, tt.wantStaple)
+			}
+			if tt.wantUpdated {
+				if _, err := ocsp.ParseResponseForCert(data, leaf.Cert, ca.Cert); err != nil {
+					t.Errorf("staple isn't the new response: %v", err)
+				}
+			}
+
+			if err := tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			data, err = os.ReadFile(staple)
+			switch {
+			case tt.oldStaple && string(data) != "old staple":
+				t.Errorf("old staple not restored: %q, %v", data, err)
+			case !tt.oldStaple && !os.IsNotExist(err):
+				t.Errorf("staple left after rollback: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// chown is os.Chown, swappable so ownership can be checked without root.
+var chown = os.Chown
+
+// owner is the uid/gid a target's files are chowned to; -1 leaves one unchanged.
+type owner struct {
+	uid, gid int
+	name     string // as configured, for errors
+}
+
+func (o *owner) String() string { return o.name }
+
+// equal reports whether o and p chown to the same ids; nil equals only nil.
+func (o *owner) equal(p *owner) bool {
+	if o == nil || p == nil {
+		return o == p
+	}
+	return o.uid == p.uid && o.gid == p.gid
+}
+
+// applyOwnership applies t's owner, group and modes to its files, failing if
+// the user or group doesn't exist or a mode is invalid.
+func applyOwnership(t *state.Target, files []file) error {
+	certMode, err := parseMode(t.CertMode)
+	if err != nil {
+		return fmt.Errorf("target %s: cert_mode: %w", t.ID, err)
+	}
+	keyMode, err := parseMode(t.KeyMode)
+	if err != nil {
+		return fmt.Errorf("target %s: key_mode: %w", t.ID, err)
+	}
+	o, err := lookupOwner(t.Owner, t.Group)
+	if err != nil {
+		return fmt.Errorf("target %s: %w", t.ID, err)
+	}
+
+	for i := range files {
+		f := &files[i]
+		f.owner = o
+		switch f.kind {
+		case "key", "pkcs12":
+			if keyMode != 0 {
+				f.perm = keyMode
+			}
+		default:
+			if certMode != 0 {
+				f.perm = certMode
+			}
+		}
+	}
+	return nil
+}
+
+// lookupOwner resolves a user and group, each a name or numeric id, to an
+// owner. It returns nil if both are empty.
+func lookupOwner(userName, groupName string) (*owner, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+	o := &owner{uid: -1, gid: -1, name: userName + ":" + groupName}
+
+	if userName != "" {
+		id, err := strconv.Atoi(userName)
+		if err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return nil, fmt.Errorf("owner: %w", err)
+			}
+			id, err = strconv.Atoi(u.Uid)
+			if err != nil {
+				return nil, fmt.Errorf("owner %s: uid %q is not numeric", userName, u.Uid)
+			}
+		}
+		o.uid = id
+	}
+
+	if groupName != "" {
+		id, err := strconv.Atoi(groupName)
+		if err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return nil, fmt.Errorf("group: %w", err)
+			}
+			id, err = strconv.Atoi(g.Gid)
+			if err != nil {
+				return nil, fmt.Errorf("group %s: gid %q is not numeric", groupName, g.Gid)
+			}
+		}
+		o.gid = id
+	}
+	return o, nil
+}
+
+// parseMode parses an octal file mode such as "0640". "" returns 0.
+func parseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q: want octal permissions such as 0640", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// permissionHint explains a chown failure due to missing privileges.
+func permissionHint(err error) string {
+	if !errors.Is(err, os.ErrPermission) {
+		return ""
+	}
+	return " (changing ownership requires running the agent as root)"
+}
@@ -0,0 +1,161 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+type chownCall struct {
+	path     string // the file the temporary one replaces
+	uid, gid int
+}
+
+// fakeChown replaces chown for the test, recording its calls. It fails the
+// test if a file is chowned after it has been renamed into place.
+func fakeChown(t *testing.T) func() []chownCall {
+	t.Helper()
+	var mu sync.Mutex
+	var calls []chownCall
+	orig := chown
+	chown = func(path string, uid, gid int) error {
+		dir, base := filepath.Split(path)
+		i := strings.Index(base, ".tmp.")
+		if !strings.HasPrefix(base, ".") || i < 0 {
+			t.Errorf("chown %s: not a temporary file", path)
+			return nil
+		}
+		mu.Lock()
+		calls = append(calls, chownCall{filepath.Join(dir, base[1:i]), uid, gid})
+		mu.Unlock()
+		return nil
+	}
+	t.Cleanup(func() { chown = orig })
+	return func() []chownCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]chownCall(nil), calls...)
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	cert := &state.Certificate{ID: "c1", Cert: leaf.PEM(), Key: leaf.KeyPEM()}
+
+	tests := []struct {
+		name               string
+		owner, group       string
+		certMode, keyMode  string
+		wantUID, wantGID   int // -1: unchanged
+		wantCert, wantKey  os.FileMode
+		wantChown, wantErr bool
+	}{
+		{name: "defaults", wantCert: 0o644, wantKey: 0o600},
+		{name: "owner and group", owner: "1234", group: "5678", wantUID: 1234, wantGID: 5678, wantCert: 0o644, wantKey: 0o600, wantChown: true},
+		{name: "group only", group: "5678", wantUID: -1, wantGID: 5678, wantCert: 0o644, wantKey: 0o600, wantChown: true},
+		{name: "modes", certMode: "0640", keyMode: "0640", wantCert: 0o640, wantKey: 0o640},
+		{name: "unknown user", owner: "no-such-user-certkit", wantErr: true},
+		{name: "unknown group", group: "no-such-group-certkit", wantErr: true},
+		{name: "bad mode", keyMode: "rw-------", wantErr: true},
+		{name: "mode out of range", certMode: "01777", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeChown(t)
+			dir := t.TempDir()
+			target := &state.Target{
+				ID:       "t1",
+				CertPath: filepath.Join(dir, "cert.pem"),
+				KeyPath:  filepath.Join(dir, "key.pem"),
+				Owner:    tt.owner,
+				Group:    tt.group,
+				CertMode: tt.certMode,
+				KeyMode:  tt.keyMode,
+			}
+			tx := Transaction{Roots: ca.Pool()}
+			changed, err := tx.StageAll([]state.Action{{Type: state.ActionDeploy, Target: target, Certificate: cert}})
+			if tt.wantErr {
+				if err[0] == nil {
+					t.Fatal("StageAll succeeded")
+				}
+				return
+			}
+			if err[0] != nil || !changed[0] {
+				t.Fatalf("StageAll: changed %v, err %v", changed[0], err[0])
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+
+			for path, want := range map[string]os.FileMode{target.CertPath: tt.wantCert, target.KeyPath: tt.wantKey} {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != want {
+					t.Errorf("%s: mode %o, want %o", filepath.Base(path), info.Mode().Perm(), want)
+				}
+			}
+
+			got := calls()
+			if !tt.wantChown {
+				if len(got) != 0 {
+					t.Fatalf("chowned without an owner: %v", got)
+				}
+				return
+			}
+			chowned := map[string]bool{}
+			for _, c := range got {
+				if c.uid != tt.wantUID || c.gid != tt.wantGID {
+					t.Errorf("chown %s to %d:%d, want %d:%d", c.path, c.uid, c.gid, tt.wantUID, tt.wantGID)
+				}
+				chowned[c.path] = true
+			}
+			if !chowned[target.CertPath] || !chowned[target.KeyPath] || len(got) != 2 {
+				t.Errorf("chowned %v, want the cert and key once each", got)
+			}
+		})
+	}
+}
+
+func TestOwnershipRollback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix file ownership")
+	}
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	calls := fakeChown(t)
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(certPath, []byte("old cert"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	target := &state.Target{ID: "t1", CertPath: certPath, Owner: "1234", Group: "5678"}
+	tx := Transaction{Roots: ca.Pool()}
+	if _, errs := tx.StageAll([]state.Action{{Type: state.ActionDeploy, Target: target, Certificate: &state.Certificate{ID: "c1", Cert: leaf.PEM()}}}); errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(certPath); string(data) != "old cert" {
+		t.Fatalf("after rollback: %q", data)
+	}
+	want := []chownCall{{certPath, 1234, 5678}, {certPath, os.Getuid(), os.Getgid()}}
+	got := calls()
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("chown calls %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,32 @@
+package deploy
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// pkcs12Files returns the PKCS#12 bundle deploying c to t writes: the leaf,
+// its chain and the key, encrypted with the password t.PasswordRef resolves to.
+func pkcs12Files(t *state.Target, c *state.Certificate, leaf *x509.Certificate, chain []*x509.Certificate) ([]file, error) {
+	if c.Key == "" {
+		return nil, fmt.Errorf("target %s: pkcs12 needs a key but certificate %s has none", t.ID, c.ID)
+	}
+	key, err := leafKey(t, c, leaf)
+	if err != nil {
+		return nil, err
+	}
+	password, err := utils.ResolveSecret(t.PasswordRef)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: password_ref: %w", t.ID, err)
+	}
+
+	data, err := pkcs12.Modern.Encode(key, leaf, chain, password)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: encode pkcs12: %w", t.ID, err)
+	}
+	return []file{{target: t.ID, kind: "pkcs12", path: t.CertPath, data: data, perm: 0o600}}, nil
+}
@@ -0,0 +1,56 @@
+package deploy
+
+import (
+	"crypto/ecdsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestDeployPKCS12(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	inter := ca.Issue(t, testcerts.Options{CommonName: "intermediate", IsCA: true})
+	leaf := inter.Leaf(t, "example.com")
+	t.Setenv("P12_PASSWORD", "s3cret")
+
+	target := &state.Target{
+		ID:          "t1",
+		Format:      state.FormatPKCS12,
+		CertPath:    filepath.Join(t.TempDir(), "cert.p12"),
+		PasswordRef: "env:P12_PASSWORD",
+	}
+	c := &state.Certificate{ID: "c1", Cert: leaf.PEM(), Chain: testcerts.EncodePEM(inter.Cert), Key: leaf.KeyPEM()}
+	tx := &Transaction{Roots: ca.Pool()}
+	if _, errs := tx.StageAll([]state.Action{{Type: state.ActionDeploy, Target: target, Certificate: c}}); errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(target.CertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, cert, chain, err := pkcs12.DecodeChain(data, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(leaf.Cert) {
+		t.Fatalf("leaf is %s, want %s", cert.Subject, leaf.Cert.Subject)
+	}
+	if len(chain) != 1 || !chain[0].Equal(inter.Cert) {
+		t.Fatalf("chain has %d certificates, want the intermediate", len(chain))
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); !ok || !k.PublicKey.Equal(leaf.Cert.PublicKey) {
+		t.Fatal("key doesn't match the leaf")
+	}
+
+	if _, _, _, err := pkcs12.DecodeChain(data, "wrong"); err == nil {
+		t.Fatal("decoded with the wrong password")
+	}
+}
@@ -0,0 +1,117 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// Reloader makes a service pick up newly deployed certificates.
+type Reloader interface {
+	Reload() error
+}
+
+// Executor runs a command, returning an error that includes its output on failure.
+type Executor func(name string, args ...string) error
+
+// Signaler delivers sig to the process pid.
+type Signaler func(pid int, sig os.Signal) error
+
+// SystemctlReload runs `systemctl reload Unit`.
+type SystemctlReload struct {
+	Unit string
+	Exec Executor
+}
+
+func (r *SystemctlReload) Reload() error {
+	if err := r.Exec("systemctl", "reload", r.Unit); err != nil {
+		return fmt.Errorf("reload %s: %w", r.Unit, err)
+	}
+	return nil
+}
+
+// RunCommand runs an arbitrary command, e.g. `nginx -s reload`.
+type RunCommand struct {
+	Args []string
+	Exec Executor
+}
+
+func (r *RunCommand) Reload() error {
+	if err := r.Exec(r.Args[0], r.Args[1:]...); err != nil {
+		return fmt.Errorf("reload command %s: %w", strings.Join(r.Args, " "), err)
+	}
+	return nil
+}
+
+// SignalPid sends Signal to the process whose pid is in PidFile.
+type SignalPid struct {
+	PidFile string
+	Signal  os.Signal
+	Send    Signaler
+}
+
+func (r *SignalPid) Reload() error {
+	b, err := os.ReadFile(r.PidFile)
+	if err != nil {
+		return fmt.Errorf("reload: read pid file: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("reload: pid file %s does not contain a pid", r.PidFile)
+	}
+	if err := r.Send(pid, r.Signal); err != nil {
+		return fmt.Errorf("reload: signal %s to pid %d: %w", r.Signal, pid, err)
+	}
+	return nil
+}
+
+func signalProcess(pid int, sig os.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
+
+// NewReloader returns the Reloader for r, running commands and signals for real.
+// trustUpdate is the command that updates the system trust store for a
+// state.ReloadTrust reload; nil means the installed distribution default.
+func NewReloader(r *state.Reload, trustUpdate []string) (Reloader, error) {
+	return newReloader(r, trustUpdate, utils.RunCmdLogged, signalProcess)
+}
+
+func newReloader(r *state.Reload, trustUpdate []string, exec Executor, send Signaler) (Reloader, error) {
+	switch r.Type {
+	case state.ReloadSystemctl:
+		if r.Unit == "" {
+			return nil, fmt.Errorf("systemctl reload needs a unit")
+		}
+		return &SystemctlReload{Unit: r.Unit, Exec: exec}, nil
+	case state.ReloadCommand:
+		if len(r.Command) == 0 || r.Command[0] == "" {
+			return nil, fmt.Errorf("reload command is empty")
+		}
+		return &RunCommand{Args: r.Command, Exec: exec}, nil
+	case state.ReloadTrust:
+		args, err := trustUpdateCommand(trustUpdate)
+		if err != nil {
+			return nil, err
+		}
+		return &RunCommand{Args: args, Exec: exec}, nil
+	case state.ReloadSignal:
+		if !filepath.IsAbs(r.PidFile) {
+			return nil, fmt.Errorf("reload pid_file must be absolute: %s", r.PidFile)
+		}
+		sig, err := parseSignal(r.Signal)
+		if err != nil {
+			return nil, err
+		}
+		return &SignalPid{PidFile: r.PidFile, Signal: sig, Send: send}, nil
+	}
+	return nil, fmt.Errorf("unknown reload type %q", r.Type)
+}
@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+func TestReloader(t *testing.T) {
+	errFailed := errors.New("exit status 1")
+	tests := []struct {
+		name       string
+		reload     state.Reload
+		pidFile    string // contents; "" for no pid file
+		execErr    error
+		wantRan    string // command line run, if any
+		wantSignal string // "pid signal" delivered, if any
+		wantNewErr bool   // rejected by newReloader
+		wantErr    bool   // from Reload
+		unixOnly   bool
+	}{
+		{
+			name:    "systemctl",
+			reload:  state.Reload{Type: state.ReloadSystemctl, Unit: "nginx"},
+			wantRan: "systemctl reload nginx",
+		},
+		{
+			name:    "systemctl fails",
+			reload:  state.Reload{Type: state.ReloadSystemctl, Unit: "nginx"},
+			execErr: errFailed,
+			wantRan: "systemctl reload nginx",
+			wantErr: true,
+		},
+		{
+			name:       "systemctl without unit",
+			reload:     state.Reload{Type: state.ReloadSystemctl},
+			wantNewErr: true,
+		},
+		{
+			name:    "command",
+			reload:  state.Reload{Type: state.ReloadCommand, Command: []string{"/usr/sbin/nginx", "-s", "reload"}},
+			wantRan: "/usr/sbin/nginx -s reload",
+		},
+		{
+			name:    "command fails",
+			reload:  state.Reload{Type: state.ReloadCommand, Command: []string{"/usr/sbin/nginx", "-s", "reload"}},
+			execErr: errFailed,
+			wantRan: "/usr/sbin/nginx -s reload",
+			wantErr: true,
+		},
+		{
+			name:       "empty command",
+			reload:     state.Reload{Type: state.ReloadCommand, Command: []string{""}},
+			wantNewErr: true,
+		},
+		{
+			name:       "signal",
+			reload:     state.Reload{Type: state.ReloadSignal, PidFile: "app.pid", Signal: "USR1"},
+			pidFile:    "4242\n",
+			wantSignal: "4242 user defined signal 1",
+			unixOnly:   true,
+		},
+		{
+			name:       "signal defaults to HUP",
+			reload:     state.Reload{Type: state.ReloadSignal, PidFile: "app.pid"},
+			pidFile:    "4242",
+			wantSignal: "4242 hangup",
+			unixOnly:   true,
+		},
+		{
+			name:     "signal with bad pid file",
+			reload:   state.Reload{Type: state.ReloadSignal, PidFile: "app.pid"},
+			pidFile:  "nginx",
+			wantErr:  true,
+			unixOnly: true,
+		},
+		{
+			name:     "signal with missing pid file",
+			reload:   state.Reload{Type: state.ReloadSignal, PidFile: "app.pid"},
+			wantErr:  true,
+			unixOnly: true,
+		},
+		{
+			name:       "unsupported signal",
+			reload:     state.Reload{Type: state.ReloadSignal, PidFile: "app.pid", Signal: "KILL"},
+			wantNewErr: true,
+		},
+		{
+			name:       "relative pid file",
+			reload:     state.Reload{Type: state.ReloadSignal, PidFile: "run/app.pid"},
+			wantNewErr: true,
+		},
+		{
+			name:       "unknown type",
+			reload:     state.Reload{Type: "restart"},
+			wantNewErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.unixOnly && runtime.GOOS == "windows" {
+				t.Skip("reload signals are Unix only")
+			}
+			r := tt.reload
+			if r.PidFile == "app.pid" {
+				r.PidFile = filepath.Join(t.TempDir(), r.PidFile)
+				if tt.pidFile != "" {
+					if err := os.WriteFile(r.PidFile, []byte(tt.pidFile), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			var ran, signaled string
+			exec := func(name string, args ...string) error {
+				ran = strings.Join(append([]string{name}, args...), " ")
+				return tt.execErr
+			}
+			send := func(pid int, sig os.Signal) error {
+				signaled = fmt.Sprintf("%d %s", pid, sig)
+				return nil
+			}
+			reloader, err := newReloader(&r, nil, exec, send)
+			if (err != nil) != tt.wantNewErr {
+				t.Fatalf("newReloader: err = %v, wantErr %t", err, tt.wantNewErr)
+			}
+			if tt.wantNewErr {
+				return
+			}
+			if err := reloader.Reload(); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload: err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if ran != tt.wantRan {
+				t.Errorf("ran %q, want %q", ran, tt.wantRan)
+			}
+			if signaled != tt.wantSignal {
+				t.Errorf("signaled %q, want %q", signaled, tt.wantSignal)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package deploy
+
+import (
+	"fmt"
+	"os"
+)
+
+func parseSignal(name string) (os.Signal, error) {
+	return nil, fmt.Errorf("reload signals are not supported on this platform")
+}
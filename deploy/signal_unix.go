@@ -0,0 +1,30 @@
+//go:build unix
+
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"INT":  syscall.SIGINT,
+}
+
+// parseSignal accepts names like "HUP" or "SIGHUP". Empty means SIGHUP.
+func parseSignal(name string) (os.Signal, error) {
+	if name == "" {
+		return syscall.SIGHUP, nil
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return nil, fmt.Errorf("unsupported reload signal %q", name)
+	}
+	return sig, nil
+}
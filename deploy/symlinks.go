@@ -0,0 +1,53 @@
+package deploy
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// resolveSymlinks decides what happens to files whose path is a symlink. By
+// default the link itself is replaced with a regular file, as a rename onto
+// it would anyway; that's logged, since it cuts the link. With
+// follow_symlinks the file is written where the link points instead, leaving
+// links such as a Let's Encrypt style live/ directory in place.
+func resolveSymlinks(t *state.Target, files []file) error {
+	for i := range files {
+		f := &files[i]
+		info, err := os.Lstat(f.path)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		dest, err := filepath.EvalSymlinks(f.path)
+		if !t.FollowSymlinks {
+			if err != nil {
+				dest = "nothing"
+			}
+			log.Printf("target %s: %s %s is a symlink to %s; replacing the link with a regular file (set follow_symlinks to write through it)", t.ID, f.kind, f.path, dest)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("target %s: %s %s is a symlink that can't be followed: %w", t.ID, f.kind, f.path, err)
+		}
+		f.path = dest
+	}
+	return nil
+}
+
+// restoreSymlink puts back a symlink at path pointing to link, replacing the
+// regular file written over it.
+func restoreSymlink(path, link string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".link.tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(link, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+func TestSymlinkedPaths(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	c := &state.Certificate{ID: "c1", Cert: leaf.PEM(), Key: leaf.KeyPEM()}
+
+	tests := []struct {
+		name     string
+		follow   bool
+		dangling bool // the links point nowhere
+		wantErr  bool
+	}{
+		{name: "replace the link"},
+		{name: "follow the link", follow: true},
+		{name: "replace a dangling link", dangling: true},
+		{name: "follow a dangling link", follow: true, dangling: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A Let's Encrypt style layout: live/ links into archive/.
+			dir := t.TempDir()
+			for _, d := range []string{"live", "archive"} {
+				if err := os.Mkdir(filepath.Join(dir, d), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			target := &state.Target{
+				ID:             "t1",
+				CertPath:       filepath.Join(dir, "live", "cert.pem"),
+				KeyPath:        filepath.Join(dir, "live", "privkey.pem"),
+				FollowSymlinks: tt.follow,
+			}
+			archived := map[string]string{
+				target.CertPath: filepath.Join(dir, "archive", "cert1.pem"),
+				target.KeyPath:  filepath.Join(dir, "archive", "privkey1.pem"),
+			}
+			for link, file := range archived {
+				if !tt.dangling {
+					if err := os.WriteFile(file, []byte("old\n"), 0o600); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := os.Symlink(filepath.Join("..", "archive", filepath.Base(file)), link); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tx := &Transaction{Roots: ca.Pool()}
+			_, errs := tx.StageAll([]state.Action{{Type: state.ActionDeploy, Target: target, Certificate: c}})
+			if (errs[0] != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", errs[0], tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			for link, file := range archived {
+				info, err := os.Lstat(link)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if isLink := info.Mode()&os.ModeSymlink != 0; isLink != tt.follow {
+					t.Errorf("%s is a symlink = %t, want %t", link, isLink, tt.follow)
+				}
+				got, err := os.ReadFile(file)
+				if wrote := err == nil && string(got) != "old\n"; wrote != tt.follow {
+					t.Errorf("%s written = %t, want %t", file, wrote, tt.follow)
+				}
+			}
+
+			// Rolling back puts the links and what they point to back.
+			if err := tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			for link, file := range archived {
+				if dest, err := os.Readlink(link); err != nil || filepath.Base(dest) != filepath.Base(file) {
+					t.Errorf("%s not restored as a link to %s: %q, %v", link, file, dest, err)
+				}
+				got, err := os.ReadFile(file)
+				if tt.dangling {
+					if !os.IsNotExist(err) {
+						t.Errorf("%s exists after rollback: %v", file, err)
+					}
+				} else if string(got) != "old\n" {
+					t.Errorf("%s not restored: %q, %v", file, got, err)
+				}
+			}
+		})
+	}
+}
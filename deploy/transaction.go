@@ -0,0 +1,231 @@
+package deploy
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+
+	"github.com/certkit-io/certkit-agent-alpha/certs"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// Transaction deploys several targets as a unit: every target is validated
+// before anything is written, and Rollback restores every file it wrote to its
+// prior contents (or removes it if it didn't exist), e.g. when a reload fails.
+// Directories created for create_dirs targets are removed too.
+type Transaction struct {
+	// Workers is how many targets are staged, and files written, at once.
+	// Zero or one does them one at a time.
+	Workers int
+	// Roots are the roots a certificate must chain to before it's staged
+	// (see LoadTrustRoots). nil means the system pool.
+	Roots *x509.CertPool
+	// KeyDir is where StageHostKey stores host keys (see certs.KeyPath).
+	KeyDir string
+
+	staged  []file
+	claimed map[string]file // every file of a staged target, by path (see claim)
+	priors  []prior
+	dirs    []string // created by Commit, outermost first
+}
+
+// prior is what a file looked like before the transaction wrote it.
+type prior struct {
+	path    string
+	existed bool
+	data    []byte
+	perm    os.FileMode
+	link    string // if path was a symlink (replaced by the write), its target
+	// chowned is set if the write changed the owner; uid and gid are the
+	// ones to restore.
+	chowned  bool
+	uid, gid int
+}
+
+// StageAll validates each of deploys, tx.Workers at a time, and queues their
+// files for Commit, except those already on disk as they would be written.
+// It returns the results in the same order: changed is false for a target
+// with nothing to write, so its service needn't be reloaded. A file shared by
+// several targets is written once; if they disagree on its contents, the
+// later target fails.
+func (tx *Transaction) StageAll(deploys []state.Action) (changed []bool, errs []error) {
+	files := make([][]file, len(deploys))
+	fresh := make([][]bool, len(deploys)) // not on disk yet
+	changed = make([]bool, len(deploys))
+	errs = make([]error, len(deploys))
+	utils.ForEach(len(deploys), tx.Workers, func(i int) {
+		files[i], errs[i] = targetFiles(deploys[i].Target, deploys[i].Certificate, tx.Roots)
+		for _, f := range files[i] {
+			fresh[i] = append(fresh[i], !f.onDisk())
+		}
+	})
+	for i := range files {
+		if errs[i] != nil {
+			continue
+		}
+		claimed, err := tx.claim(files[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		for j, f := range files[i] {
+			if fresh[i][j] {
+				changed[i] = true
+				if !claimed[f.path] {
+					tx.staged = append(tx.staged, f)
+				}
+			}
+		}
+	}
+	return changed, errs
+}
+
+// claim adds files to tx.claimed, so Commit never writes a path twice (at
+// once, with tx.Workers). It fails if another target already claimed a path
+// for different contents. claimed has the paths already claimed for the same.
+func (tx *Transaction) claim(files []file) (claimed map[string]bool, err error) {
+	claimed = map[string]bool{}
+	for _, f := range files {
+		prev, ok := tx.claimed[f.path]
+		if !ok {
+			continue
+		}
+		if !bytes.Equal(prev.data, f.data) || prev.perm != f.perm || prev.remove != f.remove || !prev.owner.equal(f.owner) {
+			return nil, fmt.Errorf("target %s: %s %s is also written by target %s, with different contents", f.target, f.kind, f.path, prev.target)
+		}
+		claimed[f.path] = true
+	}
+	if tx.claimed == nil {
+		tx.claimed = map[string]file{}
+	}
+	for _, f := range files {
+		if !claimed[f.path] {
+			tx.claimed[f.path] = f
+		}
+	}
+	return claimed, nil
+}
+
+// StageHostKey stages c's key, generated on this host, as the stored host key
+// for c (see certs.KeyPath), so it replaces the previous one only if the
+// deploy of t goes through. Any number of targets may stage the same key.
+func (tx *Transaction) StageHostKey(t *state.Target, c *state.Certificate) error {
+	if err := os.MkdirAll(tx.KeyDir, 0o700); err != nil {
+		return fmt.Errorf("target %s: %w", t.ID, err)
+	}
+	f := file{target: t.ID, kind: "host key", path: certs.KeyPath(tx.KeyDir, c.ID), data: []byte(c.Key), perm: 0o600}
+	if f.onDisk() {
+		return nil
+	}
+	claimed, err := tx.claim([]file{f})
+	if err != nil {
+		return err
+	}
+	if !claimed[f.path] {
+		tx.staged = append(tx.staged, f)
+	}
+	return nil
+}
+
+// Commit writes every staged file, tx.Workers at a time. If a write fails,
+// the files already written are rolled back before the error is returned.
+func (tx *Transaction) Commit() error {
+	priors := make([]*prior, len(tx.staged))
+	created := make([][]string, len(tx.staged))
+	errs := make([]error, len(tx.staged))
+	var failed atomic.Bool
+	utils.ForEach(len(tx.staged), tx.Workers, func(i int) {
+		if failed.Load() {
+			return
+		}
+		f := &tx.staged[i]
+		p, err := currentFile(f)
+		if err == nil {
+			created[i], err = f.write()
+		}
+		if err != nil {
+			errs[i] = err
+			failed.Store(true)
+			return
+		}
+		priors[i] = p
+	})
+
+	for i := range priors {
+		tx.dirs = append(tx.dirs, created[i]...)
+		if priors[i] != nil {
+			tx.priors = append(tx.priors, *priors[i])
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return tx.abort(err)
+	}
+	return nil
+}
+
+// currentFile records what's at f's path before it's written.
+func currentFile(f *file) (*prior, error) {
+	p := &prior{path: f.path}
+	if link, err := os.Readlink(f.path); err == nil {
+		p.existed, p.link = true, link
+	} else if info, err := os.Stat(f.path); err == nil {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: read current %s %s: %w", f.target, f.kind, f.path, err)
+		}
+		p.existed, p.data, p.perm = true, data, info.Mode().Perm()
+		if f.owner != nil {
+			p.uid, p.gid, p.chowned = utils.FileOwner(info)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("target %s: stat %s: %w", f.target, f.path, err)
+	}
+	return p, nil
+}
+
+func (tx *Transaction) abort(err error) error {
+	if rbErr := tx.Rollback(); rbErr != nil {
+		return errors.Join(err, rbErr)
+	}
+	return err
+}
+
+// Rollback restores every file written by Commit, newest first, then removes
+// the directories it created.
+func (tx *Transaction) Rollback() error {
+	var errs []error
+	for i := len(tx.priors) - 1; i >= 0; i-- {
+		p := tx.priors[i]
+		var err error
+		switch {
+		case p.link != "":
+			err = restoreSymlink(p.path, p.link)
+		case p.chowned:
+			err = utils.WriteFileAtomicPrepared(p.path, p.data, p.perm, func(tmp string) error {
+				return chown(tmp, p.uid, p.gid)
+			})
+		case p.existed:
+			err = utils.WriteFileAtomic(p.path, p.data, p.perm)
+		default:
+			err = os.Remove(p.path)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("roll back %s: %w", p.path, err))
+			continue
+		}
+		log.Printf("Rolled back %s", p.path)
+	}
+	tx.priors = nil
+	for i := len(tx.dirs) - 1; i >= 0; i-- {
+		if err := os.Remove(tx.dirs[i]); err != nil {
+			errs = append(errs, fmt.Errorf("roll back %s: %w", tx.dirs[i], err))
+		}
+	}
+	tx.dirs = nil
+	return errors.Join(errs...)
+}
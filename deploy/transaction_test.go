@@ -0,0 +1,247 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+func TestStageSharedPaths(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	c1 := &state.Certificate{ID: "c1", Cert: leaf.PEM(), Key: leaf.KeyPEM()}
+	other := ca.Leaf(t)
+	c2 := &state.Certificate{ID: "c2", Cert: other.PEM(), Key: other.KeyPEM()}
+
+	tests := []struct {
+		name        string
+		second      func(dir string) (*state.Target, *state.Certificate)
+		wantErr     bool
+		wantWritten int // distinct files
+	}{
+		{
+			name: "same certificate, same files",
+			second: func(dir string) (*state.Target, *state.Certificate) {
+				return &state.Target{ID: "t2", CertPath: filepath.Join(dir, "cert.pem"), KeyPath: filepath.Join(dir, "key.pem")}, c1
+			},
+			wantWritten: 2,
+		},
+		{
+			name: "same certificate, own key",
+			second: func(dir string) (*state.Target, *state.Certificate) {
+				return &state.Target{ID: "t2", CertPath: filepath.Join(dir, "cert.pem"), KeyPath: filepath.Join(dir, "key2.pem")}, c1
+			},
+			wantWritten: 3,
+		},
+		{
+			name: "other certificate, same file",
+			second: func(dir string) (*state.Target, *state.Certificate) {
+				return &state.Target{ID: "t2", CertPath: filepath.Join(dir, "cert.pem")}, c2
+			},
+			wantErr:     true,
+			wantWritten: 2,
+		},
+		{
+			name: "same file, other mode",
+			second: func(dir string) (*state.Target, *state.Certificate) {
+				return &state.Target{ID: "t2", CertPath: filepath.Join(dir, "cert.pem"), CertMode: "0640"}, c1
+			},
+			wantErr:     true,
+			wantWritten: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			first := &state.Target{ID: "t1", CertPath: filepath.Join(dir, "cert.pem"), KeyPath: filepath.Join(dir, "key.pem")}
+			second, c := tt.second(dir)
+			tx := &Transaction{Workers: 2, Roots: ca.Pool()}
+			changed, errs := tx.StageAll([]state.Action{
+				{Type: state.ActionDeploy, Target: first, Certificate: c1},
+				{Type: state.ActionDeploy, Target: second, Certificate: c},
+			})
+			if errs[0] != nil || !changed[0] {
+				t.Fatalf("first target: changed %v, err %v", changed[0], errs[0])
+			}
+			if (errs[1] != nil) != tt.wantErr {
+				t.Fatalf("second target: err %v, wantErr %v", errs[1], tt.wantErr)
+			}
+			if !tt.wantErr && !changed[1] {
+				t.Fatal("second target unchanged; its service wouldn't be reloaded")
+			}
+			if len(tx.staged) != tt.wantWritten {
+				t.Fatalf("staged %d files, want %d", len(tx.staged), tt.wantWritten)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(first.CertPath); string(got) != leaf.PEM() {
+				t.Fatalf("%s holds the wrong certificate", first.CertPath)
+			}
+		})
+	}
+}
+
+func TestCommitParallel(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	dir := t.TempDir()
+
+	var deploys []state.Action
+	for i := range 20 {
+		leaf := ca.Leaf(t)
+		target := &state.Target{
+			ID:       fmt.Sprintf("t%d", i),
+			CertPath: filepath.Join(dir, fmt.Sprintf("%d.crt", i)),
+			KeyPath:  filepath.Join(dir, fmt.Sprintf("%d.key", i)),
+		}
+		// Half the targets already exist, with other contents.
+		if i%2 == 0 {
+			if err := os.WriteFile(target.CertPath, []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		deploys = append(deploys, state.Action{Type: state.ActionDeploy, Target: target, Certificate: &state.Certificate{ID: target.ID, Cert: leaf.PEM(), Key: leaf.KeyPEM()}})
+	}
+
+	tx := &Transaction{Workers: 8, Roots: ca.Pool()}
+	_, errs := tx.StageAll(deploys)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("target %d: %v", i, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range deploys {
+		if got, _ := os.ReadFile(d.Target.CertPath); string(got) != d.Certificate.Cert {
+			t.Fatalf("%s not written", d.Target.CertPath)
+		}
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range deploys {
+		got, err := os.ReadFile(d.Target.CertPath)
+		switch {
+		case i%2 == 0 && string(got) != "old":
+			t.Errorf("%s: %q after rollback, want the old contents", d.Target.CertPath, got)
+		case i%2 == 1 && !os.IsNotExist(err):
+			t.Errorf("%s: still there after rollback", d.Target.CertPath)
+		}
+	}
+}
+
+func TestStageMissingDir(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	c := &state.Certificate{ID: "c1", Cert: leaf.PEM(), Key: leaf.KeyPEM()}
+
+	tests := []struct {
+		name        string
+		createDirs  bool
+		file        bool // a file is where the directory should be
+		wantMissing bool // fails with ErrMissingDir
+		wantErr     bool
+	}{
+		{name: "missing", wantMissing: true, wantErr: true},
+		{name: "created", createDirs: true},
+		{name: "not a directory", createDirs: true, file: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "nginx", "ssl")
+			if tt.file {
+				if err := os.WriteFile(filepath.Join(root, "nginx"), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				dir = filepath.Join(root, "nginx")
+			}
+			target := &state.Target{
+				ID:         "t1",
+				CertPath:   filepath.Join(dir, "cert.pem"),
+				KeyPath:    filepath.Join(dir, "key.pem"),
+				CreateDirs: tt.createDirs,
+			}
+			tx := &Transaction{Roots: ca.Pool()}
+			_, errs := tx.StageAll([]state.Action{{Type: state.ActionDeploy, Target: target, Certificate: c}})
+			if (errs[0] != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", errs[0], tt.wantErr)
+			}
+			if got := errors.Is(errs[0], ErrMissingDir); got != tt.wantMissing {
+				t.Errorf("errors.Is(err, ErrMissingDir) = %t, want %t (err %v)", got, tt.wantMissing, errs[0])
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range []string{target.CertPath, target.KeyPath} {
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("%s not written: %v", path, err)
+				}
+			}
+			// Rolling back removes the directories the commit created.
+			if err := tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "nginx")); !os.IsNotExist(err) {
+				t.Errorf("created directory not removed on rollback: %v", err)
+			}
+		})
+	}
+}
+
+func TestStageMismatchedKey(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	other := ca.Leaf(t)
+	c := &state.Certificate{ID: "c1", Cert: leaf.PEM(), Key: other.KeyPEM()}
+	t.Setenv("P12_PASSWORD", "s3cret")
+
+	tests := []struct {
+		name   string
+		target func(dir string) *state.Target
+	}{
+		{
+			name: "pem",
+			target: func(dir string) *state.Target {
+				return &state.Target{ID: "t1", CertPath: filepath.Join(dir, "cert.pem"), KeyPath: filepath.Join(dir, "key.pem")}
+			},
+		},
+		{
+			name: "pkcs12",
+			target: func(dir string) *state.Target {
+				return &state.Target{ID: "t1", Format: state.FormatPKCS12, CertPath: filepath.Join(dir, "cert.p12"), PasswordRef: "env:P12_PASSWORD"}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target(t.TempDir())
+			tx := &Transaction{Roots: ca.Pool()}
+			_, errs := tx.StageAll([]state.Action{{Type: state.ActionDeploy, Target: target, Certificate: c}})
+			if errs[0] == nil {
+				t.Fatal("staged a key that doesn't match the certificate")
+			}
+			if len(tx.staged) != 0 {
+				t.Fatalf("staged %d files, want none", len(tx.staged))
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(target.CertPath); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("%s written: %v", target.CertPath, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package deploy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LoadTrustRoots returns the roots a certificate must chain to before it's
+// deployed: the system pool plus the certificates in the PEM file bundlePath,
+// for issuers a private PKI doesn't put in the system store. An empty
+// bundlePath returns nil, i.e. the system pool alone. They're separate from
+// the roots trusted for the API connection (tls.ca_bundle_path).
+func LoadTrustRoots(bundlePath string) (*x509.CertPool, error) {
+	if bundlePath == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("read deploy_trust_bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("deploy_trust_bundle %s: no certificates found", bundlePath)
+	}
+	return pool, nil
+}
+
+// verifyTrust checks that leaf chains through chain to one of roots (nil for
+// the system pool) and is currently valid. Any key usage is accepted:
+// deployed certificates serve TLS, client authentication and more.
+func verifyTrust(leaf *x509.Certificate, chain []*x509.Certificate, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("not trusted: %w (for a private CA, add its root to deploy_trust_bundle)", err)
+	}
+	return nil
+}
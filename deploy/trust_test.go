@@ -0,0 +1,55 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+func TestTrustRoots(t *testing.T) {
+	custom := testcerts.NewCA(t, "private root")
+	untrusted := testcerts.NewCA(t, "untrusted root")
+	intermediate := custom.Issue(t, testcerts.Options{CommonName: "private intermediate", IsCA: true})
+	notPEM := filepath.Join(t.TempDir(), "bundle.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		bundle      string // deploy_trust_bundle
+		leaf        *testcerts.Cert
+		wantLoadErr bool // LoadTrustRoots fails
+		wantDeploy  bool
+	}{
+		{name: "custom root", bundle: custom.WritePEM(t), leaf: custom.Leaf(t), wantDeploy: true},
+		{name: "custom root via intermediate", bundle: custom.WritePEM(t), leaf: intermediate.Leaf(t), wantDeploy: true},
+		{name: "untrusted root", bundle: custom.WritePEM(t), leaf: untrusted.Leaf(t)},
+		// The system pool alone doesn't know the private root.
+		{name: "no bundle", leaf: custom.Leaf(t)},
+		{name: "missing bundle", bundle: filepath.Join(t.TempDir(), "missing.pem"), wantLoadErr: true},
+		{name: "bundle without certificates", bundle: notPEM, wantLoadErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots, err := LoadTrustRoots(tt.bundle)
+			if (err != nil) != tt.wantLoadErr {
+				t.Fatalf("LoadTrustRoots: err = %v, want failure %v", err, tt.wantLoadErr)
+			}
+			if tt.wantLoadErr {
+				return
+			}
+
+			target := &state.Target{ID: "t1", CertPath: filepath.Join(t.TempDir(), "cert.pem")}
+			c := &state.Certificate{ID: "c1", Cert: tt.leaf.PEM(), Chain: tt.leaf.ChainPEM()}
+			tx := &Transaction{Roots: roots}
+			_, errs := tx.StageAll([]state.Action{{Type: state.ActionDeploy, Target: target, Certificate: c}})
+			if (errs[0] == nil) != tt.wantDeploy {
+				t.Errorf("StageAll: err = %v, want staged %v", errs[0], tt.wantDeploy)
+			}
+		})
+	}
+}
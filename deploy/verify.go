@@ -0,0 +1,87 @@
+package deploy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/certs"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// DefaultVerifyTimeout is how long VerifyServing waits for a service to serve
+// the new certificate, unless the target's verify.timeout says otherwise.
+const DefaultVerifyTimeout = 30 * time.Second
+
+// verifyRetryDelay spaces out connection attempts while a service reloads.
+const verifyRetryDelay = time.Second
+
+// VerifyServing connects to the target's verify address until the leaf it
+// serves is c's, or the timeout passes. Services can take a moment after a
+// reload to pick up a new certificate, so a mismatch is retried until then.
+func VerifyServing(ctx context.Context, t *state.Target, c *state.Certificate) error {
+	v := t.Verify
+	timeout := DefaultVerifyTimeout
+	if v.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(v.Timeout)
+		if err != nil {
+			return fmt.Errorf("target %s: parse verify.timeout: %w", t.ID, err)
+		}
+	}
+	serverName := v.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(v.Address)
+		if err != nil {
+			return fmt.Errorf("target %s: verify.address: %w", t.ID, err)
+		}
+		serverName = host
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	want := certs.Fingerprint(c.Cert)
+	var got string
+	var err error
+	for {
+		g, e := servedFingerprint(ctx, v.Address, serverName)
+		if e == nil && g == want {
+			return nil
+		}
+		// An attempt cut short by the deadline says less than the one before it.
+		if ctx.Err() == nil || (got == "" && err == nil) {
+			got, err = g, e
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("target %s: verify %s: %w", t.ID, v.Address, err)
+			}
+			return fmt.Errorf("target %s: %s serves certificate %s, not the deployed %s", t.ID, v.Address, got, want)
+		case <-time.After(verifyRetryDelay):
+		}
+	}
+}
+
+// servedFingerprint returns the fingerprint of the leaf served at addr.
+func servedFingerprint(ctx context.Context, addr, serverName string) (string, error) {
+	d := tls.Dialer{Config: &tls.Config{
+		ServerName: serverName,
+		// The served leaf is compared byte for byte with the deployed one,
+		// which is a stronger check than chain verification.
+		InsecureSkipVerify: true,
+	}}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	peers := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(peers) == 0 {
+		return "", fmt.Errorf("no certificate served")
+	}
+	return certs.FingerprintDER(peers[0].Raw), nil
+}
@@ -0,0 +1,68 @@
+package deploy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// closedAddr returns an address nothing listens on.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestVerifyServing(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	deployed := ca.Leaf(t)
+	other := ca.Leaf(t)
+	cert := &state.Certificate{ID: "c1", Cert: deployed.PEM()}
+
+	tests := []struct {
+		name    string
+		addr    string
+		timeout string
+		wantErr bool
+	}{
+		{name: "serves the deployed certificate", addr: deployed.ServeTLS(t)},
+		{name: "serves another certificate", addr: other.ServeTLS(t), timeout: "200ms", wantErr: true},
+		{name: "nothing listening", addr: closedAddr(t), timeout: "200ms", wantErr: true},
+		{name: "bad timeout", addr: deployed.ServeTLS(t), timeout: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &state.Target{ID: "t1", Verify: &state.Verify{Address: tt.addr, ServerName: "localhost", Timeout: tt.timeout}}
+			err := VerifyServing(context.Background(), target, cert)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyServingCancel(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	cert := &state.Certificate{ID: "c1", Cert: ca.Leaf(t).PEM()}
+	// Never serves cert, so only the default 30s timeout or ctx ends the wait.
+	target := &state.Target{ID: "t1", Verify: &state.Verify{Address: ca.Leaf(t).ServeTLS(t)}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	if err := VerifyServing(ctx, target, cert); err == nil {
+		t.Fatal("VerifyServing succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("returned %s after ctx was cancelled", elapsed)
+	}
+}
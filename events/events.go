@@ -0,0 +1,78 @@
+// Package events queues status events in memory and sends them to the
+// backend in batches.
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+)
+
+const (
+	// MaxQueued bounds the queue; when full, the oldest events are dropped.
+	MaxQueued = 1000
+	// MaxBatch is the most events sent in one request.
+	MaxBatch = 100
+)
+
+var (
+	mu      sync.Mutex
+	queue   []api.Event
+	dropped int
+)
+
+// Record queues an event, stamping its time if unset.
+func Record(event api.Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	queue = append(queue, event)
+	if over := len(queue) - MaxQueued; over > 0 {
+		queue = queue[over:]
+		dropped += over
+	}
+}
+
+// Len returns the number of queued events.
+func Len() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(queue)
+}
+
+// Flush sends all queued events in batches of MaxBatch. A batch that fails is
+// put back at the front of the queue (subject to MaxQueued) for the next flush.
+func Flush(ctx context.Context, client *api.Client) error {
+	for {
+		mu.Lock()
+		n := min(len(queue), MaxBatch)
+		batch := append([]api.Event(nil), queue[:n]...)
+		queue = queue[n:]
+		if dropped > 0 {
+			log.Printf("Event queue was full; dropped %d oldest event(s)", dropped)
+			dropped = 0
+		}
+		mu.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := client.ReportEvents(ctx, batch); err != nil {
+			mu.Lock()
+			queue = append(batch, queue...)
+			if over := len(queue) - MaxQueued; over > 0 {
+				queue = queue[over:]
+				dropped += over
+			}
+			mu.Unlock()
+			return err
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package events
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+)
+
+// reset empties the queue, before and after the test.
+func reset(t *testing.T) {
+	t.Helper()
+	empty := func() {
+		mu.Lock()
+		queue, dropped = nil, 0
+		mu.Unlock()
+	}
+	empty()
+	t.Cleanup(empty)
+}
+
+// eventsServer accepts event batches, except for the failBatch'th (1-based),
+// and records them.
+type eventsServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	batches [][]api.Event // accepted
+}
+
+func newEventsServer(t *testing.T, failBatch int) *eventsServer {
+	t.Helper()
+	s := &eventsServer{}
+	requests := 0
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req api.ReportEventsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode events: %v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		requests++
+		if requests == failBatch {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.batches = append(s.batches, req.Events)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// sizes returns the sizes of the accepted batches, and the events in them in order.
+func (s *eventsServer) sizes() (sizes []int, sent []api.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.batches {
+		sizes = append(sizes, len(b))
+		sent = append(sent, b...)
+	}
+	return sizes, sent
+}
+
+// record queues n events, numbered in their Error field.
+func record(from, n int) {
+	for i := from; i < from+n; i++ {
+		Record(api.Event{Type: api.EventReconcileSucceeded, Error: strconv.Itoa(i)})
+	}
+}
+
+func TestFlush(t *testing.T) {
+	tests := []struct {
+		name        string
+		record      int
+		failBatch   int   // 1-based batch the backend rejects, 0 for none
+		wantBatches []int // sizes of the batches the backend accepted
+		wantFirst   int   // number of the first event sent
+		wantQueued  int
+		wantErr     bool
+	}{
+		{name: "empty", record: 0},
+		{name: "one", record: 1, wantBatches: []int{1}},
+		{name: "batched", record: 2*MaxBatch + 50, wantBatches: []int{MaxBatch, MaxBatch, 50}},
+		{
+			name:        "full queue drops oldest",
+			record:      MaxQueued + 5,
+			wantBatches: slices.Repeat([]int{MaxBatch}, MaxQueued/MaxBatch),
+			wantFirst:   5,
+		},
+		{
+			name:        "failed batch requeued",
+			record:      2*MaxBatch + 50,
+			failBatch:   2,
+			wantBatches: []int{MaxBatch},
+			wantQueued:  MaxBatch + 50,
+			wantErr:     true,
+		},
+	}
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reset(t)
+			srv := newEventsServer(t, tt.failBatch)
+			client := api.NewClient(srv.URL, nil, &auth.KeySigner{AgentID: "agent-1", Key: priv})
+
+			record(0, tt.record)
+			err := Flush(context.Background(), client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Flush: err = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			sizes, sent := srv.sizes()
+			if !slices.Equal(sizes, tt.wantBatches) {
+				t.Errorf("batches of %v, want %v", sizes, tt.wantBatches)
+			}
+			for i, e := range sent {
+				if e.Error != strconv.Itoa(tt.wantFirst+i) {
+					t.Fatalf("event %d sent is #%s, want #%d", i, e.Error, tt.wantFirst+i)
+				}
+			}
+			if got := Len(); got != tt.wantQueued {
+				t.Errorf("%d events still queued, want %d", got, tt.wantQueued)
+			}
+		})
+	}
+}
+
+// TestFlushRequeuedFirst checks that a batch the backend rejected goes out
+// ahead of events recorded since, on the next flush.
+func TestFlushRequeuedFirst(t *testing.T) {
+	reset(t)
+	srv := newEventsServer(t, 1)
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	client := api.NewClient(srv.URL, nil, &auth.KeySigner{AgentID: "agent-1", Key: priv})
+
+	record(0, 3)
+	if err := Flush(context.Background(), client); err == nil {
+		t.Fatal("first flush succeeded")
+	}
+	record(3, 2)
+	if err := Flush(context.Background(), client); err != nil {
+		t.Fatalf("second flush: %v", err)
+	}
+
+	_, sent := srv.sizes()
+	var got []string
+	for _, e := range sent {
+		got = append(got, e.Error)
+	}
+	if want := []string{"0", "1", "2", "3", "4"}; !slices.Equal(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
@@ -1,3 +1,10 @@
 module github.com/certkit-io/certkit-agent-alpha
 
 go 1.24.3
+
+require (
+	github.com/miekg/pkcs11 v1.1.1
+	golang.org/x/crypto v0.38.0
+	gopkg.in/yaml.v3 v3.0.1
+	software.sslmate.com/src/go-pkcs12 v0.7.3
+)
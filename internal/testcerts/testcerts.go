@@ -0,0 +1,196 @@
+// Package testcerts issues throwaway certificates for tests: a CA and the
+// leaves and intermediates it signs, with ECDSA P-256 keys.
+package testcerts
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Cert is a certificate and its key, with the chain of certificates that
+// issued it (nearest first, up to but excluding the root).
+type Cert struct {
+	Cert  *x509.Certificate
+	Key   *ecdsa.PrivateKey
+	Chain []*x509.Certificate
+}
+
+// Options describe a certificate to issue. Zero NotBefore/NotAfter mean an
+// hour ago and a day from now.
+type Options struct {
+	CommonName string
+	DNSNames   []string
+	IPs        []net.IP
+	IsCA       bool
+	OCSPServer []string // AIA OCSP responder URLs
+	NotBefore  time.Time
+	NotAfter   time.Time
+}
+
+// NewCA returns a self-signed root CA.
+func NewCA(t testing.TB, name string) *Cert {
+	t.Helper()
+	return issue(t, Options{CommonName: name, IsCA: true}, nil)
+}
+
+// Issue returns a certificate signed by c.
+func (c *Cert) Issue(t testing.TB, opts Options) *Cert {
+	t.Helper()
+	return issue(t, opts, c)
+}
+
+// Leaf is shorthand for Issue of a server certificate for localhost,
+// 127.0.0.1 and dnsNames.
+func (c *Cert) Leaf(t testing.TB, dnsNames ...string) *Cert {
+	t.Helper()
+	return c.Issue(t, Options{
+		CommonName: "leaf",
+		DNSNames:   append([]string{"localhost"}, dnsNames...),
+		IPs:        []net.IP{net.IPv4(127, 0, 0, 1)},
+	})
+}
+
+func issue(t testing.TB, opts Options, parent *Cert) *Cert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("testcerts: generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		t.Fatalf("testcerts: serial: %v", err)
+	}
+	if opts.NotBefore.IsZero() {
+		opts.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if opts.NotAfter.IsZero() {
+		opts.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: opts.CommonName},
+		DNSNames:              opts.DNSNames,
+		IPAddresses:           opts.IPs,
+		NotBefore:             opts.NotBefore,
+		NotAfter:              opts.NotAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  opts.IsCA,
+		OCSPServer:            opts.OCSPServer,
+	}
+	if opts.IsCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+
+	signerCert, signerKey := tmpl, key
+	var chain []*x509.Certificate
+	if parent != nil {
+		signerCert, signerKey = parent.Cert, parent.Key
+		if !bytes.Equal(parent.Cert.RawSubject, parent.Cert.RawIssuer) { // not a root
+			chain = append([]*x509.Certificate{parent.Cert}, parent.Chain...)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("testcerts: create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("testcerts: parse certificate: %v", err)
+	}
+	return &Cert{Cert: cert, Key: key, Chain: chain}
+}
+
+// PEM returns the certificate alone, PEM encoded.
+func (c *Cert) PEM() string {
+	return EncodePEM(c.Cert)
+}
+
+// ChainPEM returns the issuing chain, PEM encoded ("" for a root or a leaf
+// issued directly by one).
+func (c *Cert) ChainPEM() string {
+	return EncodePEM(c.Chain...)
+}
+
+// KeyPEM returns the private key, PKCS#8 PEM encoded.
+func (c *Cert) KeyPEM() string {
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		panic(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// TLS returns the certificate and its chain for a tls.Config.
+func (c *Cert) TLS() tls.Certificate {
+	tc := tls.Certificate{Certificate: [][]byte{c.Cert.Raw}, PrivateKey: c.Key, Leaf: c.Cert}
+	for _, cert := range c.Chain {
+		tc.Certificate = append(tc.Certificate, cert.Raw)
+	}
+	return tc
+}
+
+// Pool returns a cert pool holding just c.
+func (c *Cert) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Cert)
+	return pool
+}
+
+// WritePEM writes c's certificate to a file in a temporary directory and
+// returns its path, e.g. for a ca_bundle_path.
+func (c *Cert) WritePEM(t testing.TB) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(path, []byte(c.PEM()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ServeTLS listens on localhost, serving c to every TLS handshake until the
+// test ends, and returns the address.
+func (c *Cert) ServeTLS(t testing.TB) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{c.TLS()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// EncodePEM returns certs as concatenated PEM blocks.
+func EncodePEM(certs ...*x509.Certificate) string {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return string(out)
+}
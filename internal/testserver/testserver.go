@@ -0,0 +1,293 @@
+// Package testserver is a stand-in CertKit backend for tests. It serves the
+// agent API over TLS from an httptest.Server: agents register their public
+// keys with it, every signed request is verified against the registered key,
+// and responses and the desired state are signed with the server's own key,
+// as the real backend does. Tests set the desired state and read back the
+// events and inventories agents reported.
+package testserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// KeyID is the id the server signs its responses and desired state with.
+const KeyID = "testserver"
+
+// Response is a canned answer for a path, set with Server.Respond.
+type Response struct {
+	Status int
+	Body   any // encoded as JSON; nil sends no body
+}
+
+// Server is a fake CertKit backend. Its methods are safe to call while agents
+// are talking to it.
+type Server struct {
+	*httptest.Server
+
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+
+	mu          sync.Mutex
+	agents      map[string]ed25519.PublicKey // by agent id
+	nextID      int
+	desired     []byte
+	responses   map[string][]Response // by path, below the API prefix
+	events      []api.Event
+	inventories []json.RawMessage
+	requests    []string
+}
+
+// New starts a Server. Close it when done.
+func New() *Server {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("testserver: generate server key: %v", err))
+	}
+	s := &Server{
+		priv:      priv,
+		pub:       pub,
+		agents:    map[string]ed25519.PublicKey{},
+		desired:   []byte(`{"version":"0","certificates":[],"targets":[]}`),
+		responses: map[string][]Response{},
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// PublicKey returns the server's response signing key, base64url encoded as
+// in the register-agent response.
+func (s *Server) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(s.pub)
+}
+
+// CABundle returns the PEM certificate the server's TLS is issued by, for a
+// config's tls.ca_bundle_path.
+func (s *Server) CABundle() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+}
+
+// RegisterAgent registers publicKey (base64url) as if the agent had enrolled,
+// for tests that start from an enrolled config, and returns its agent id.
+func (s *Server) RegisterAgent(publicKey string) (string, error) {
+	pub, err := auth.DecodePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.register(pub), nil
+}
+
+// register adds pub, or returns the id it's already registered under.
+func (s *Server) register(pub ed25519.PublicKey) string {
+	for id, p := range s.agents {
+		if p.Equal(pub) {
+			return id
+		}
+	}
+	s.nextID++
+	id := fmt.Sprintf("agent-%d", s.nextID)
+	s.agents[id] = pub
+	return id
+}
+
+// SetDesiredState sets the desired state served to every agent.
+func (s *Server) SetDesiredState(ds *state.DesiredState) {
+	b, err := json.Marshal(ds)
+	if err != nil {
+		panic(fmt.Sprintf("testserver: encode desired state: %v", err))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.desired = b
+}
+
+// Respond queues canned responses for path (e.g. "/desired-state"), answered
+// in order by the next requests to it, after their signatures are checked.
+// Once they're used up the path behaves normally again.
+func (s *Server) Respond(path string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[path] = append(s.responses[path], responses...)
+}
+
+// Events returns the events agents have reported, oldest first.
+func (s *Server) Events() []api.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]api.Event(nil), s.events...)
+}
+
+// Inventories returns the inventories agents have reported, oldest first.
+func (s *Server) Inventories() []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]json.RawMessage(nil), s.inventories...)
+}
+
+// Requests returns "METHOD /path" for every request served, oldest first.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path, ok := strings.CutPrefix(r.URL.Path, api.DefaultAPIPrefix)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+path)
+	s.mu.Unlock()
+
+	// Registration is the only unsigned request.
+	agentID := ""
+	if path != "/register-agent" {
+		id, err := auth.VerifyRequest(r, s.lookup, time.Now(), auth.VerifyOptions{MaxAge: auth.DefaultMaxAge})
+		if err != nil {
+			s.reply(w, r, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		agentID = id
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.reply(w, r, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if resp, ok := s.canned(path); ok {
+		s.reply(w, r, resp.Status, resp.Body)
+		return
+	}
+
+	status, out := s.handle(r.Method, path, agentID, body)
+	s.reply(w, r, status, out)
+}
+
+// handle answers a verified request with the default behaviour for path.
+func (s *Server) handle(method, path, agentID string, body []byte) (int, any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch method + " " + path {
+	case "POST /register-agent":
+		var req api.InstallRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return http.StatusBadRequest, map[string]string{"error": err.Error()}
+		}
+		pub, err := auth.DecodePublicKey(req.PublicKey)
+		if err != nil {
+			return http.StatusBadRequest, map[string]string{"error": err.Error()}
+		}
+		id := s.register(pub)
+		return http.StatusOK, api.InstallResponse{
+			AgentId:         id,
+			KeyID:           "key-" + id,
+			ServerKeyID:     KeyID,
+			ServerPublicKey: base64.RawURLEncoding.EncodeToString(s.pub),
+		}
+
+	case "POST /refresh-token":
+		return http.StatusOK, api.RefreshTokenResponse{AccessToken: "access-" + agentID, RefreshToken: "refresh-" + agentID}
+
+	case "GET /desired-state":
+		return http.StatusOK, api.SignedDesiredState{
+			Payload:   json.RawMessage(s.desired),
+			Signature: base64.RawURLEncoding.EncodeToString(ed25519.Sign(s.priv, s.desired)),
+			KeyID:     KeyID,
+		}
+
+	case "POST /events":
+		var req api.ReportEventsRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return http.StatusBadRequest, map[string]string{"error": err.Error()}
+		}
+		s.events = append(s.events, req.Events...)
+		return http.StatusOK, nil
+
+	case "POST /inventory":
+		s.inventories = append(s.inventories, json.RawMessage(body))
+		return http.StatusOK, nil
+
+	case "POST /rotate-key":
+		var req api.RotateKeyRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return http.StatusBadRequest, map[string]string{"error": err.Error()}
+		}
+		pub, err := auth.DecodePublicKey(req.PublicKey)
+		if err != nil {
+			return http.StatusBadRequest, map[string]string{"error": err.Error()}
+		}
+		s.agents[agentID] = pub
+		return http.StatusOK, nil
+
+	case "POST /deregister":
+		delete(s.agents, agentID)
+		return http.StatusOK, nil
+	}
+
+	// Includes the desired-state long-poll, so agents fall back to polling.
+	return http.StatusNotFound, map[string]string{"error": "not implemented by testserver"}
+}
+
+// canned pops the next canned response for path, if any.
+func (s *Server) canned(path string) (Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.responses[path]
+	if len(queue) == 0 {
+		return Response{}, false
+	}
+	s.responses[path] = queue[1:]
+	return queue[0], true
+}
+
+// lookup is the auth.KeyLookup for registered agents.
+func (s *Server) lookup(agentID string) (ed25519.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pub, ok := s.agents[agentID]
+	if !ok {
+		return nil, errors.New("unknown agent")
+	}
+	return pub, nil
+}
+
+// reply writes out as JSON with status, signed with the server key.
+func (s *Server) reply(w http.ResponseWriter, r *http.Request, status int, out any) {
+	var body []byte
+	if out != nil {
+		var err error
+		if body, err = json.Marshal(out); err != nil {
+			status, body = http.StatusInternalServerError, []byte(`{"error":"encode response"}`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := auth.SignResponse(w.Header(), r, status, body, KeyID, s.priv, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,86 @@
+package testserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// TestRoundTrip enrolls an api.Client, fetches the signed desired state and
+// reports an event, checking the server's signatures match what the client
+// expects, and that the client's are checked.
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		otherAgentKey bool // sign requests with a key other than the one registered
+		otherServer   bool // expect responses signed by another server key
+		wantErr       bool
+	}{
+		{name: "enrolled"},
+		{name: "unregistered agent key", otherAgentKey: true, wantErr: true},
+		{name: "other server key", otherServer: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New()
+			defer srv.Close()
+			srv.SetDesiredState(&state.DesiredState{Version: "7"})
+			ctx := context.Background()
+
+			pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+			resp, err := api.NewClient(srv.URL, srv.Client(), nil).InstallAgent(ctx, api.InstallRequest{
+				PublicKey: base64.RawURLEncoding.EncodeToString(pub),
+				Hostname:  "host-1",
+			})
+			if err != nil {
+				t.Fatalf("register: %v", err)
+			}
+			if resp.AgentId == "" || resp.ServerKeyID != KeyID || resp.ServerPublicKey != srv.PublicKey() {
+				t.Fatalf("register response = %+v", resp)
+			}
+
+			if tt.otherAgentKey {
+				_, priv, _ = ed25519.GenerateKey(rand.Reader)
+			}
+			serverKey, err := auth.DecodePublicKey(resp.ServerPublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.otherServer {
+				serverKey, _, _ = ed25519.GenerateKey(rand.Reader)
+			}
+			client := api.NewClient(srv.URL, srv.Client(), &auth.KeySigner{AgentID: resp.AgentId, Key: priv})
+			client.SetServerKey(resp.ServerKeyID, serverKey)
+
+			raw, err := client.FetchDesiredState(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetch desired state: err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.otherAgentKey && !errors.Is(err, api.ErrUnauthorized) {
+				t.Errorf("fetch desired state: err = %v, want unauthorized", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			var desired state.DesiredState
+			if err := json.Unmarshal(raw, &desired); err != nil || desired.Version != "7" {
+				t.Fatalf("desired state = %s, %v", raw, err)
+			}
+
+			if err := client.ReportEvents(ctx, []api.Event{{Type: api.EventReconcileSucceeded}}); err != nil {
+				t.Fatalf("report events: %v", err)
+			}
+			if events := srv.Events(); len(events) != 1 || events[0].Type != api.EventReconcileSucceeded {
+				t.Errorf("events = %+v", events)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package inventory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultScanPaths are scanned for certificates when none are configured.
+var DefaultScanPaths = []string{
+	"/etc/ssl",
+	"/etc/pki/tls",
+	"/etc/nginx",
+	"/etc/apache2",
+	"/etc/httpd",
+	"/etc/haproxy",
+	"/etc/letsencrypt/live",
+}
+
+// KnownServices are services whose presence is reported, since they're the usual deploy targets.
+var KnownServices = []string{"nginx", "apache2", "httpd", "haproxy", "postfix", "dovecot"}
+
+// Files bigger than this are not certificates we care about.
+const maxCertFileSize = 1 << 20
+
+// Inventory is what was found on this host.
+type Inventory struct {
+	Hostname     string        `json:"hostname,omitempty"`
+	CollectedAt  time.Time     `json:"collected_at"`
+	Certificates []Certificate `json:"certificates"`
+	Services     []Service     `json:"services"`
+	Errors       []string      `json:"errors,omitempty"`
+}
+
+// Certificate is a certificate found on disk.
+type Certificate struct {
+	Path        string    `json:"path"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	IPAddresses []string  `json:"ip_addresses,omitempty"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	IsCA        bool      `json:"is_ca"`
+	SHA256      string    `json:"sha256"`
+}
+
+// Service is a known service and whether it's installed/running.
+type Service struct {
+	Name      string `json:"name"`
+	Installed bool   `json:"installed"`
+	Active    bool   `json:"active"`
+}
+
+// Collect scans paths for certificates and checks known services.
+// Unreadable paths and files are recorded in Errors rather than failing the scan.
+func Collect(paths []string) *Inventory {
+	inv := &Inventory{
+		CollectedAt: time.Now().UTC(),
+	}
+	inv.Certificates, inv.Errors = ScanPaths(paths)
+	inv.Services = collectServices()
+	return inv
+}
+
+// scannedFile is a file ScanPaths parsed, under the name it reports it by.
+type scannedFile struct {
+	path    string
+	symlink bool
+	certs   []Certificate
+}
+
+// ScanPaths walks paths (DefaultScanPaths if empty) and parses every
+// certificate found. Symlinks to files are followed, as in a Let's Encrypt
+// live/ directory, but symlinks to directories aren't. A file reached under
+// several names is scanned once and reported under the first symlink to it,
+// the name services are usually configured with, else the first name found.
+// Errors are collected rather than stopping the walk.
+func ScanPaths(paths []string) ([]Certificate, []string) {
+	if len(paths) == 0 {
+		paths = DefaultScanPaths
+	}
+
+	var files []*scannedFile
+	byTarget := map[string]*scannedFile{} // by resolved path
+	var errs []string
+	for _, root := range paths {
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				errs = append(errs, err.Error())
+				return nil
+			}
+			symlink := d.Type()&fs.ModeSymlink != 0
+			if !symlink && !d.Type().IsRegular() {
+				return nil
+			}
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				errs = append(errs, err.Error())
+				return nil
+			}
+			if symlink {
+				info, err := os.Stat(target)
+				if err != nil {
+					errs = append(errs, err.Error())
+					return nil
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+			}
+
+			if f, ok := byTarget[target]; ok {
+				if symlink && !f.symlink {
+					f.path, f.symlink = path, true
+				}
+				return nil
+			}
+			found, err := ScanFile(path)
+			if err != nil {
+				errs = append(errs, err.Error())
+				found = nil
+			}
+			f := &scannedFile{path: path, symlink: symlink, certs: found}
+			byTarget[target] = f
+			files = append(files, f)
+			return nil
+		})
+	}
+
+	var certs []Certificate
+	for _, f := range files {
+		for _, c := range f.certs {
+			c.Path = f.path
+			certs = append(certs, c)
+		}
+	}
+	return certs, errs
+}
+
+// ScanFile parses every PEM certificate in path. Files without certificates return nil.
+func ScanFile(path string) ([]Certificate, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxCertFileSize {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(b, []byte("-----BEGIN CERTIFICATE-----")) {
+		return nil, nil
+	}
+
+	var certs []Certificate
+	rest := b
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return certs, fmt.Errorf("parse certificate in %s: %w", path, err)
+		}
+		certs = append(certs, fromX509(path, cert))
+	}
+
+	return certs, nil
+}
+
+func fromX509(path string, cert *x509.Certificate) Certificate {
+	sum := sha256.Sum256(cert.Raw)
+
+	var ips []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	return Certificate{
+		Path:        path,
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		DNSNames:    cert.DNSNames,
+		IPAddresses: ips,
+		NotBefore:   cert.NotBefore.UTC(),
+		NotAfter:    cert.NotAfter.UTC(),
+		IsCA:        cert.IsCA,
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+}
+
+func collectServices() []Service {
+	var services []Service
+	for _, name := range KnownServices {
+		svc := Service{Name: name}
+		if _, err := exec.LookPath(name); err == nil {
+			svc.Installed = true
+		}
+		out, err := exec.Command("systemctl", "is-active", name).Output()
+		if err == nil && strings.TrimSpace(string(out)) == "active" {
+			svc.Installed = true
+			svc.Active = true
+		}
+		services = append(services, svc)
+	}
+	return services
+}
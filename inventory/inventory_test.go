@@ -0,0 +1,108 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+)
+
+func TestScanPaths(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+	ca := testcerts.NewCA(t, "ca")
+	root := t.TempDir()
+	write := func(rel, contents string) {
+		t.Helper()
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, rel string) {
+		t.Helper()
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A Let's Encrypt style layout: live/ links into archive/.
+	write("letsencrypt/archive/example/cert1.pem", ca.Leaf(t).PEM())
+	link("../../archive/example/cert1.pem", "letsencrypt/live/example/cert.pem")
+	link("../../archive/example/cert1.pem", "letsencrypt/live/example/link.pem")
+	write("ssl/server.pem", ca.Leaf(t).PEM())
+	write("ssl/notes.txt", "not a certificate")
+	// Links to directories aren't followed, so this loop is harmless.
+	link("..", "ssl/loop")
+
+	tests := []struct {
+		name      string
+		paths     []string
+		wantPaths []string
+		wantErrs  int
+	}{
+		{
+			name:      "link reported under its name, once",
+			paths:     []string{filepath.Join(root, "letsencrypt")},
+			wantPaths: []string{"letsencrypt/live/example/cert.pem"},
+		},
+		{
+			name:      "live only",
+			paths:     []string{filepath.Join(root, "letsencrypt/live")},
+			wantPaths: []string{"letsencrypt/live/example/cert.pem"},
+		},
+		{
+			name:      "archive only",
+			paths:     []string{filepath.Join(root, "letsencrypt/archive")},
+			wantPaths: []string{"letsencrypt/archive/example/cert1.pem"},
+		},
+		{
+			name:      "overlapping paths",
+			paths:     []string{filepath.Join(root, "ssl"), root},
+			wantPaths: []string{"letsencrypt/live/example/cert.pem", "ssl/server.pem"},
+		},
+		{
+			name:      "missing path",
+			paths:     []string{filepath.Join(root, "nope")},
+			wantPaths: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certs, errs := ScanPaths(tt.paths)
+			var got []string
+			for _, c := range certs {
+				rel, _ := filepath.Rel(root, c.Path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.wantPaths) {
+				t.Errorf("found %v, want %v", got, tt.wantPaths)
+			}
+			if len(errs) != tt.wantErrs {
+				t.Errorf("errors %v, want %d", errs, tt.wantErrs)
+			}
+		})
+	}
+
+	t.Run("dangling link", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.Symlink(filepath.Join(dir, "gone.pem"), filepath.Join(dir, "cert.pem")); err != nil {
+			t.Fatal(err)
+		}
+		if certs, errs := ScanPaths([]string{dir}); len(certs) != 0 || len(errs) != 1 {
+			t.Errorf("found %v, errors %v; want one error", certs, errs)
+		}
+	})
+}
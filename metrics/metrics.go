@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// A deliberately tiny Prometheus text-format exporter; the agent only needs a
+// handful of counters and gauges, which doesn't justify client_golang.
+
+var (
+	mu sync.Mutex
+
+	reconcileTotal       uint64
+	reconcileErrorsTotal uint64
+	apiRequestsTotal     = map[string]uint64{} // status -> count
+	certNotAfter         = map[string]time.Time{}
+	lastSuccess          time.Time
+	apiCircuit           = "closed"
+	startedAt            = time.Now()
+	lastReconcile        *state.ReconcileResult
+)
+
+// ReconcileFinished records the outcome of a reconcile pass.
+func ReconcileFinished(err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	reconcileTotal++
+	if err != nil {
+		reconcileErrorsTotal++
+		return
+	}
+	lastSuccess = time.Now()
+}
+
+// SetLastReconcile records the result of the last reconcile, for /healthz.
+func SetLastReconcile(r *state.ReconcileResult) {
+	mu.Lock()
+	defer mu.Unlock()
+	lastReconcile = r
+}
+
+// APIRequest records an API response status (or "error" if there was no response).
+func APIRequest(status string) {
+	mu.Lock()
+	defer mu.Unlock()
+	apiRequestsTotal[status]++
+}
+
+// SetCertExpiry records when the certificate deployed at path expires.
+func SetCertExpiry(path string, notAfter time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	certNotAfter[path] = notAfter
+}
+
+// SetAPICircuit records the API circuit breaker's state: closed, open or half-open.
+func SetAPICircuit(state string) {
+	mu.Lock()
+	defer mu.Unlock()
+	apiCircuit = state
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func WriteText(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	var b strings.Builder
+
+	writeHeader(&b, "certkit_agent_reconcile_total", "counter", "Reconcile passes run.")
+	fmt.Fprintf(&b, "certkit_agent_reconcile_total %d\n", reconcileTotal)
+
+	writeHeader(&b, "certkit_agent_reconcile_errors_total", "counter", "Reconcile passes that failed.")
+	fmt.Fprintf(&b, "certkit_agent_reconcile_errors_total %d\n", reconcileErrorsTotal)
+
+	writeHeader(&b, "certkit_agent_api_requests_total", "counter", "API requests by response status.")
+	for _, status := range sortedKeys(apiRequestsTotal) {
+		fmt.Fprintf(&b, "certkit_agent_api_requests_total{status=%q} %d\n", status, apiRequestsTotal[status])
+	}
+
+	writeHeader(&b, "certkit_agent_api_circuit_state", "gauge", "API circuit breaker state (1 for the current state).")
+	for _, state := range []string{"closed", "half-open", "open"} {
+		current := 0
+		if state == apiCircuit {
+			current = 1
+		}
+		fmt.Fprintf(&b, "certkit_agent_api_circuit_state{state=%q} %d\n", state, current)
+	}
+
+	writeHeader(&b, "certkit_agent_cert_expiry_seconds", "gauge", "Seconds until each deployed certificate expires.")
+	for _, path := range sortedKeys(certNotAfter) {
+		fmt.Fprintf(&b, "certkit_agent_cert_expiry_seconds{path=%q} %.0f\n", path, certNotAfter[path].Sub(now).Seconds())
+	}
+
+	// Until the first success, count from process start so the gauge still grows.
+	since := startedAt
+	if !lastSuccess.IsZero() {
+		since = lastSuccess
+	}
+	writeHeader(&b, "certkit_agent_time_since_last_success_seconds", "gauge", "Seconds since the last successful reconcile.")
+	fmt.Fprintf(&b, "certkit_agent_time_since_last_success_seconds %.0f\n", now.Sub(since).Seconds())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Serve starts the health/metrics server on addr, serving /healthz and /metrics.
+// It runs until ctx is cancelled.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		// The agent itself is healthy either way; the breaker is informational.
+		mu.Lock()
+		circuit, last := apiCircuit, lastReconcile
+		mu.Unlock()
+		fmt.Fprintf(w, "ok\napi_circuit: %s\n", circuit)
+		if last != nil {
+			fmt.Fprintf(w, "last_reconcile: %s\nlast_reconcile_duration: %.3fs\ntargets_applied: %d\n",
+				last.Time.UTC().Format(time.RFC3339), last.DurationSeconds, last.TargetsApplied)
+			if last.Error != "" {
+				fmt.Fprintf(w, "last_error: %s\n", strings.ReplaceAll(last.Error, "\n", " "))
+			}
+		}
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteText(w)
+	})
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving /healthz and /metrics on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,621 @@
+package reconcile
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/certs"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/deploy"
+	"github.com/certkit-io/certkit-agent-alpha/events"
+	"github.com/certkit-io/certkit-agent-alpha/metrics"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// DefaultConcurrency is Options.Concurrency unless the config's
+// deploy_concurrency says otherwise.
+const DefaultConcurrency = 4
+
+// Options control how Reconcile applies the desired state.
+type Options struct {
+	// Concurrency is how many targets are staged, written and verified, and
+	// how many services reloaded, at once. Zero means DefaultConcurrency.
+	Concurrency int
+	// Paused stops Reconcile from writing files or reloading services, as
+	// does the desired state's paused flag. It still fetches the desired state.
+	Paused bool
+	// TrustUpdateCommand updates the system trust store after a CA bundle
+	// target is deployed. nil means the installed distribution default.
+	TrustUpdateCommand []string
+	// TrustRoots are the roots a certificate must chain to before it's
+	// deployed (see deploy.LoadTrustRoots). nil means the system pool.
+	TrustRoots *x509.CertPool
+	// StateDir is where reloads still due are kept across restarts (see
+	// config.SavePendingReloads).
+	StateDir string
+	// KeyDir is where keys generated on this host are kept (see
+	// certs.KeyPath).
+	KeyDir string
+}
+
+// Reconcile fetches the desired state and applies whatever changed since applied.
+// A target that can't be deployed (a bad certificate, or a directory that
+// doesn't exist; see deploy.ErrMissingDir) is skipped and the others go ahead.
+// Beyond that the apply is all or nothing: if any write or reload fails, every
+// written file is rolled back and applied is returned unchanged so the next
+// poll retries. Otherwise it returns the new applied state to persist, which
+// leaves skipped targets out so they're retried too. While paused, nothing is
+// applied and applied is returned unchanged.
+//
+// Files already on disk as they'd be written are left alone, and a target
+// with nothing to write isn't reloaded, unless an earlier run wrote its files
+// but stopped before the reload (see config.SavePendingReloads). A target
+// already applied whose files have since changed on disk is deployed again.
+// A target whose certificate isn't in the desired state is reported with a
+// deploy_skipped event. Cancelling ctx aborts the API calls and verifications
+// in flight.
+func Reconcile(ctx context.Context, client *api.Client, applied *state.Applied, opts Options) (*state.Applied, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	desired, actions, keyErrs, err := plan(ctx, client, applied, opts, !opts.Paused)
+	if err != nil {
+		return applied, err
+	}
+
+	recordExpiry(desired)
+	if applied == nil || applied.Hash != desired.Hash() {
+		reportUnmatched(desired)
+	}
+
+	if opts.Paused || desired.Paused {
+		log.Printf("Reconcile: paused, %d action(s) skipped", len(actions))
+		return applied, nil
+	}
+
+	pending, err := config.ReadPendingReloads(opts.StateDir)
+	if err != nil {
+		log.Printf("Reconcile: ⚠️  ignoring pending reloads: %v", err)
+	}
+
+	if len(actions) == 0 && len(pending) == 0 {
+		refreshStaples(desired, nil, opts.TrustUpdateCommand)
+		if applied != nil && applied.Hash == desired.Hash() {
+			return applied, nil
+		}
+		// Nothing to deploy, but remember the desired state as applied so
+		// unmatched targets aren't reported again on every poll.
+		return carryOver(applied, desired), nil
+	}
+
+	// Stage every deploy first so a bad target is caught before anything is written.
+	tx := &deploy.Transaction{Workers: opts.Concurrency, Roots: opts.TrustRoots, KeyDir: opts.KeyDir}
+	var deploys, keyless []state.Action
+	for _, action := range actions {
+		switch {
+		case action.Type != state.ActionDeploy:
+		case keyErrs[action.Certificate.ID] != nil:
+			keyless = append(keyless, action)
+		default:
+			deploys = append(deploys, action)
+		}
+	}
+	changes, errs := tx.StageAll(deploys)
+	// A target whose host key isn't ready fails like one that can't be staged.
+	for _, action := range keyless {
+		deploys = append(deploys, action)
+		changes = append(changes, false)
+		errs = append(errs, fmt.Errorf("target %s: %w", action.Target.ID, keyErrs[action.Certificate.ID]))
+	}
+	var stageErrs []error
+	skipped := 0
+	var staged, unchanged []state.Action
+	for i, action := range deploys {
+		changed, err := changes[i], errs[i]
+		if err == nil && !changed {
+			log.Printf("Reconcile: target %s already has certificate %s on disk", action.Target.ID, action.Certificate.ID)
+			unchanged = append(unchanged, action)
+			continue
+		}
+		if err == nil {
+			staged = append(staged, action)
+			continue
+		}
+		skipped++
+		events.Record(api.Event{Type: api.EventDeploySkipped, CertificateID: action.Certificate.ID, TargetID: action.Target.ID, Error: err.Error()})
+		if errors.Is(err, deploy.ErrMissingDir) {
+			log.Printf("Reconcile: ⚠️  skipping %v", err)
+			continue
+		}
+		stageErrs = append(stageErrs, err)
+	}
+	var stageErr error
+	if len(stageErrs) > 0 {
+		stageErr = fmt.Errorf("%d target(s) not deployed: %w", len(stageErrs), errors.Join(stageErrs...))
+	}
+	if len(staged) == 0 && len(unchanged) == 0 && len(pending) == 0 {
+		if stageErr != nil {
+			return applied, fmt.Errorf("reconcile: nothing deployed: %w", stageErr)
+		}
+		return applied, nil
+	}
+
+	var stapled map[string]bool
+	var verifyErr error
+	if len(staged) > 0 || len(pending) > 0 {
+		stapled, verifyErr, err = commit(ctx, tx, staged, withPending(withReloads(staged, actions), pending), opts)
+		if err != nil {
+			return applied, err
+		}
+	}
+
+	refreshStaples(desired, stapled, opts.TrustUpdateCommand)
+
+	next := carryOver(applied, desired)
+	if skipped > 0 {
+		// Not the whole desired state is applied, so the next poll mustn't skip it.
+		next.Hash = ""
+		log.Printf("Reconcile: deployed %d target(s), skipped %d", len(staged), skipped)
+	}
+	for _, action := range unchanged {
+		next.Targets[action.Target.ID] = state.TargetHash(action.Target, action.Certificate)
+	}
+	for _, action := range staged {
+		next.Targets[action.Target.ID] = state.TargetHash(action.Target, action.Certificate)
+		events.Record(deployedEvent(action))
+	}
+	if err := errors.Join(stageErr, verifyErr); err != nil {
+		return next, fmt.Errorf("reconcile: %w", err)
+	}
+	return next, nil
+}
+
+// carryOver returns an applied state for desired that keeps the target hashes
+// applied has for desired's targets.
+func carryOver(applied *state.Applied, desired *state.DesiredState) *state.Applied {
+	next := &state.Applied{
+		Version: desired.Version,
+		Hash:    desired.Hash(),
+		Targets: map[string]string{},
+	}
+	for i := range desired.Targets {
+		t := &desired.Targets[i]
+		if applied != nil && applied.Targets[t.ID] != "" {
+			next.Targets[t.ID] = applied.Targets[t.ID]
+		}
+	}
+	return next
+}
+
+// reportUnmatched records a deploy_skipped event for each target without a
+// certificate in desired (MatchTargets has logged them).
+func reportUnmatched(desired *state.DesiredState) {
+	for _, t := range desired.Targets {
+		if desired.Certificate(t.CertificateID) != nil {
+			continue
+		}
+		reason := fmt.Sprintf("certificate %s is not in the desired state", t.CertificateID)
+		if t.CertificateID == "" {
+			reason = "no certificate matches its hostnames"
+		}
+		events.Record(api.Event{Type: api.EventDeploySkipped, CertificateID: t.CertificateID, TargetID: t.ID, Error: reason})
+	}
+}
+
+// commit writes the staged deploys, then runs the reloads in actions and the
+// deploys' verification, opts.Concurrency at a time. If a write, a reload or a
+// verification that asks for it fails, everything is rolled back and err says
+// why; a verification failure that doesn't is returned as verifyErr.
+func commit(ctx context.Context, tx *deploy.Transaction, staged, actions []state.Action, opts Options) (stapled map[string]bool, verifyErr error, err error) {
+	workers := opts.Concurrency
+	// Staples are staged alongside the new certs. A responder being down
+	// shouldn't hold up a deploy; the next refresh will try again.
+	stapled = map[string]bool{}
+	for _, action := range actions {
+		if action.Type == state.ActionDeploy && action.Certificate.KeyOnHost {
+			if err := tx.StageHostKey(action.Target, action.Certificate); err != nil {
+				return nil, nil, fmt.Errorf("reconcile: %w", err)
+			}
+		}
+		if action.Type == state.ActionDeploy && action.Target.OCSPStaple {
+			if err := tx.StageStaple(action.Target, action.Certificate); err != nil {
+				log.Printf("Reconcile: ⚠️  OCSP staple: %v", err)
+				continue
+			}
+			stapled[action.Target.ID] = true
+		}
+	}
+
+	var pending []*state.Reload
+	for _, action := range actions {
+		switch action.Type {
+		case state.ActionDeploy:
+			log.Printf("Reconcile: %s", action)
+		case state.ActionReload:
+			pending = append(pending, action.Reload)
+		}
+	}
+	if err := config.SavePendingReloads(opts.StateDir, pending); err != nil {
+		log.Printf("Reconcile: ⚠️  recording pending reloads: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("reconcile: deploy failed, rolled back: %w", err)
+	}
+
+	// Each service is reloaded once however many of its targets changed;
+	// different services are reloaded side by side.
+	var reloads []state.Action
+	for _, action := range actions {
+		if action.Type == state.ActionReload {
+			reloads = append(reloads, action)
+		}
+	}
+	reloaders := make([]deploy.Reloader, len(reloads))
+	errs := make([]error, len(reloads))
+	utils.ForEach(len(reloads), workers, func(i int) {
+		log.Printf("Reconcile: %s", reloads[i])
+		reloader, err := deploy.NewReloader(reloads[i].Reload, opts.TrustUpdateCommand)
+		if err == nil {
+			err = reloader.Reload()
+		}
+		if err != nil {
+			errs[i] = err
+			events.Record(api.Event{Type: api.EventReloadFailed, Reload: reloads[i].Reload.String(), Error: err.Error()})
+		}
+		if err == nil || reloader != nil && reloads[i].Reload.Type == state.ReloadTrust {
+			reloaders[i] = reloader
+		}
+	})
+	var reloaded []deploy.Reloader
+	var reloadErrs []error
+	for i := range reloads {
+		if errs[i] != nil {
+			reloadErrs = append(reloadErrs, errs[i])
+		}
+		if reloaders[i] != nil {
+			reloaded = append(reloaded, reloaders[i])
+		}
+	}
+
+	// Certificates were written, but a service didn't take them: put the old
+	// files back and reload the services that did, so none is left on a mix.
+	// A trust store update that failed is run again too: it may have got part
+	// way, and the store must be rebuilt from the restored anchors.
+	if len(reloadErrs) > 0 {
+		reloadErr := fmt.Errorf("%d reload(s) failed: %w", len(reloadErrs), errors.Join(reloadErrs...))
+		return nil, nil, rollBack(tx, reloaded, reloadErr)
+	}
+	if err := config.SavePendingReloads(opts.StateDir, nil); err != nil {
+		log.Printf("Reconcile: ⚠️  clearing pending reloads: %v", err)
+	}
+
+	rollback, verifyErr := verifyDeploys(ctx, staged, workers)
+	if rollback {
+		return nil, nil, rollBack(tx, reloaded, verifyErr)
+	}
+	for _, action := range staged {
+		if action.Certificate.KeyOnHost {
+			if err := certs.RemovePendingKey(opts.KeyDir, action.Certificate.ID); err != nil {
+				log.Printf("Reconcile: ⚠️  %v", err)
+			}
+		}
+	}
+	if verifyErr != nil {
+		log.Printf("Reconcile: ❌ %v", verifyErr)
+	}
+	return stapled, verifyErr, nil
+}
+
+// rollBack restores the files tx wrote and reloads the services in reloaded
+// again, so none is left on a mix of old and new certificates, after cause.
+func rollBack(tx *deploy.Transaction, reloaded []deploy.Reloader, cause error) error {
+	if err := tx.Rollback(); err != nil {
+		return fmt.Errorf("reconcile: %w; rollback failed: %w", cause, err)
+	}
+	for _, r := range reloaded {
+		if err := r.Reload(); err != nil {
+			log.Printf("Reconcile: reload after rollback failed: %v", err)
+		}
+	}
+	return fmt.Errorf("reconcile: %w; rolled back", cause)
+}
+
+// verifyDeploys checks, workers at a time, that the services of deployed
+// targets with a verify block serve their new certificates. rollback is true
+// if a target that failed asks for the deploy to be rolled back. Verifications
+// cut short by cancelling ctx fail, but never ask for a rollback: the services
+// were reloaded fine and may yet have served the new certificates.
+func verifyDeploys(ctx context.Context, deploys []state.Action, workers int) (rollback bool, err error) {
+	results := make([]error, len(deploys))
+	utils.ForEach(len(deploys), workers, func(i int) {
+		t := deploys[i].Target
+		if t.Verify == nil {
+			return
+		}
+		if err := deploy.VerifyServing(ctx, t, deploys[i].Certificate); err != nil {
+			results[i] = err
+			events.Record(api.Event{Type: api.EventVerifyFailed, CertificateID: deploys[i].Certificate.ID, TargetID: t.ID, Error: err.Error()})
+			return
+		}
+		log.Printf("Reconcile: verified %s serves certificate %s", t.Verify.Address, deploys[i].Certificate.ID)
+	})
+	var errs []error
+	for i, err := range results {
+		if err != nil {
+			errs = append(errs, err)
+			rollback = rollback || deploys[i].Target.Verify.Rollback
+		}
+	}
+	if len(errs) == 0 {
+		return false, nil
+	}
+	if ctx.Err() != nil {
+		rollback = false
+	}
+	return rollback, fmt.Errorf("%d verification(s) failed: %w", len(errs), errors.Join(errs...))
+}
+
+// deployedEvent reports a deploy with the fingerprint and expiry of the
+// certificate read back from the target, so the backend can check what's
+// live against what it sent.
+func deployedEvent(action state.Action) api.Event {
+	event := api.Event{
+		Type:          api.EventCertDeployed,
+		CertificateID: action.Certificate.ID,
+		TargetID:      action.Target.ID,
+	}
+	// A CA bundle holds no leaf to read back.
+	if action.Target.Format == state.FormatCABundle {
+		return event
+	}
+	leaf, err := deploy.DeployedLeaf(action.Target)
+	if err != nil {
+		log.Printf("Reconcile: ⚠️  reading back deployed certificate: %v", err)
+		return event
+	}
+	event.Fingerprint = certs.FingerprintDER(leaf.Raw)
+	event.NotAfter = leaf.NotAfter.UTC()
+	if want := certs.Fingerprint(action.Certificate.Cert); event.Fingerprint != want {
+		log.Printf("Reconcile: ⚠️  target %s: %s holds %s, not certificate %s (%s)", action.Target.ID, action.Target.CertPath, event.Fingerprint, action.Certificate.ID, want)
+	}
+	return event
+}
+
+// withReloads returns the deploys followed by those reload actions in actions
+// that one of the deploys' targets needs.
+func withReloads(deploys, actions []state.Action) []state.Action {
+	needed := map[string]bool{}
+	for _, action := range deploys {
+		if r := action.Target.ReloadSpec(); r != nil {
+			needed[r.String()] = true
+		}
+	}
+	out := slices.Clone(deploys)
+	for _, action := range actions {
+		if action.Type == state.ActionReload && needed[action.Reload.String()] {
+			out = append(out, action)
+		}
+	}
+	return out
+}
+
+// withPending returns actions followed by a reload action for each of pending
+// not already among them.
+func withPending(actions []state.Action, pending []*state.Reload) []state.Action {
+	have := map[string]bool{}
+	for _, action := range actions {
+		if action.Type == state.ActionReload {
+			have[action.Reload.String()] = true
+		}
+	}
+	for _, r := range pending {
+		if !have[r.String()] {
+			have[r.String()] = true
+			actions = append(actions, state.Action{Type: state.ActionReload, Reload: r})
+		}
+	}
+	return actions
+}
+
+// Plan fetches the desired state and returns the actions Reconcile would take
+// from applied with opts, without writing files, reloading services or
+// submitting CSRs.
+func Plan(ctx context.Context, client *api.Client, applied *state.Applied, opts Options) ([]state.Action, error) {
+	_, actions, keyErrs, err := plan(ctx, client, applied, opts, false)
+	if err != nil {
+		return nil, err
+	}
+	var planned []state.Action
+	for _, action := range actions {
+		if action.Type == state.ActionDeploy && keyErrs[action.Certificate.ID] != nil {
+			log.Printf("Plan: ⚠️  target %s would be skipped: %v", action.Target.ID, keyErrs[action.Certificate.ID])
+			continue
+		}
+		planned = append(planned, action)
+	}
+	return planned, nil
+}
+
+// plan fetches and prepares the desired state and diffs it against applied.
+// keyErrs has, by certificate ID, the key-on-host certificates whose key
+// couldn't be prepared (see prepareHostKeys); their targets can't be deployed.
+// Targets are checked for drift with opts.TrustRoots (see drifted).
+// Only when execute is true, and the desired state isn't paused, may it have
+// side effects (submitting CSRs for key-on-host certificates).
+func plan(ctx context.Context, client *api.Client, applied *state.Applied, opts Options, execute bool) (desired *state.DesiredState, actions []state.Action, keyErrs map[string]error, err error) {
+	raw, err := client.FetchDesiredState(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	desired, err = state.Parse(raw)
+	if errors.Is(err, state.ErrUnsupportedSchema) {
+		// Not fatal: whatever was last applied stays on disk until we're upgraded.
+		return nil, nil, nil, fmt.Errorf("%w; keeping last applied state", err)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if desired.Paused {
+		log.Printf("Reconcile: the backend has paused this agent")
+	}
+	keyErrs = prepareHostKeys(ctx, client, desired, opts.KeyDir, execute && !desired.Paused)
+
+	for _, warning := range deploy.MatchTargets(desired) {
+		log.Printf("Reconcile: ⚠️  %s", warning)
+	}
+
+	actions = state.Diff(applied, desired)
+	if ids := drifted(applied, desired, opts.TrustRoots); len(ids) > 0 {
+		actions = state.Diff(forget(applied, ids), desired)
+	}
+	return desired, actions, keyErrs, nil
+}
+
+// drifted returns the IDs of the targets applied has as they are in desired
+// but whose files on disk no longer match (see deploy.Drifted).
+func drifted(applied *state.Applied, desired *state.DesiredState, roots *x509.CertPool) []string {
+	if applied == nil {
+		return nil
+	}
+	var ids []string
+	for i := range desired.Targets {
+		t := &desired.Targets[i]
+		c := desired.Certificate(t.CertificateID)
+		if c == nil || applied.Targets[t.ID] != state.TargetHash(t, c) {
+			continue
+		}
+		// A target that can't be staged now (e.g. its directory is gone)
+		// is left for the next change to its desired state to report.
+		if changed, err := deploy.Drifted(t, c, roots); err == nil && changed {
+			log.Printf("Reconcile: ⚠️  target %s has changed on disk; deploying it again", t.ID)
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids
+}
+
+// forget returns a copy of applied without the targets ids, nor the hash
+// that would let state.Diff skip them.
+func forget(applied *state.Applied, ids []string) *state.Applied {
+	out := &state.Applied{Version: applied.Version, Targets: maps.Clone(applied.Targets)}
+	for _, id := range ids {
+		delete(out.Targets, id)
+	}
+	return out
+}
+
+// prepareHostKeys handles certificates whose key is generated on this host.
+// Those without a Cert (newly requested, or due for renewal) get a fresh key
+// and CSR, and the signed certificate is filled in; the others get their Key
+// from the local copy. Either way they then deploy like any other certificate.
+// The new key is kept aside until the deploy stores it (see
+// certs.PendingKeyPath and deploy.Transaction.StageHostKey). Keys are kept
+// in keyDir. Without execute, certificates that would need a CSR are only
+// logged. It returns, by certificate ID, why a certificate's key couldn't be
+// prepared.
+func prepareHostKeys(ctx context.Context, client *api.Client, desired *state.DesiredState, keyDir string, execute bool) map[string]error {
+	errs := map[string]error{}
+	for i := range desired.Certificates {
+		c := &desired.Certificates[i]
+		if !c.KeyOnHost {
+			continue
+		}
+
+		if c.Cert == "" && !execute {
+			log.Printf("Plan: would generate a key and submit a CSR for certificate %s", c.ID)
+			continue
+		}
+		if c.Cert == "" {
+			keyPEM, csrPEM, err := certs.GenerateKeyAndCSR(pkix.Name{CommonName: c.Subject}, c.SANs)
+			if err != nil {
+				errs[c.ID] = fmt.Errorf("certificate %s: %w", c.ID, err)
+				continue
+			}
+			// Saved before the CSR goes out, so a certificate issued for it
+			// can't be left without its key.
+			if err := certs.WritePendingKey(keyDir, c.ID, keyPEM); err != nil {
+				errs[c.ID] = fmt.Errorf("certificate %s: save key: %w", c.ID, err)
+				continue
+			}
+			log.Printf("Reconcile: submitting CSR for certificate %s", c.ID)
+			signed, err := client.SubmitCSR(ctx, c.ID, string(csrPEM))
+			if err != nil {
+				errs[c.ID] = fmt.Errorf("certificate %s: %w", c.ID, err)
+				continue
+			}
+			c.Cert, c.Chain = signed.Cert, signed.Chain
+		}
+
+		keyPEM, err := certs.MatchingKey(keyDir, c.ID, []byte(c.Cert))
+		if err != nil {
+			errs[c.ID] = fmt.Errorf("certificate %s: %w", c.ID, err)
+			continue
+		}
+		c.Key = string(keyPEM)
+	}
+	return errs
+}
+
+// refreshStaples refetches stale OCSP staples for targets that opted in, other
+// than those just stapled by a deploy, and reloads the affected services.
+// Failures only warn: the last good response stays in place.
+func refreshStaples(desired *state.DesiredState, skip map[string]bool, trustUpdate []string) {
+	reloads := map[string]*state.Reload{}
+	for i := range desired.Targets {
+		t := &desired.Targets[i]
+		c := desired.Certificate(t.CertificateID)
+		if !t.OCSPStaple || c == nil || skip[t.ID] || !deploy.StapleStale(t, time.Now()) {
+			continue
+		}
+		if err := deploy.RefreshStaple(t, c); err != nil {
+			log.Printf("Reconcile: ⚠️  OCSP staple: %v", err)
+			continue
+		}
+		log.Printf("Reconcile: refreshed OCSP staple %s", deploy.StaplePath(t.CertPath))
+		if r := t.ReloadSpec(); r != nil {
+			reloads[r.String()] = r
+		}
+	}
+
+	for _, r := range reloads {
+		reloader, err := deploy.NewReloader(r, trustUpdate)
+		if err == nil {
+			err = reloader.Reload()
+		}
+		if err != nil {
+			log.Printf("Reconcile: reload after OCSP refresh failed: %v", err)
+		}
+	}
+}
+
+// recordExpiry exports the expiry of every targeted certificate as a metric.
+func recordExpiry(desired *state.DesiredState) {
+	for i := range desired.Targets {
+		t := &desired.Targets[i]
+		c := desired.Certificate(t.CertificateID)
+		if c == nil {
+			continue
+		}
+		block, _ := pem.Decode([]byte(c.Cert))
+		if block == nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		metrics.SetCertExpiry(t.CertPath, cert.NotAfter)
+	}
+}
@@ -0,0 +1,826 @@
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent-alpha/api"
+	"github.com/certkit-io/certkit-agent-alpha/auth"
+	"github.com/certkit-io/certkit-agent-alpha/certs"
+	"github.com/certkit-io/certkit-agent-alpha/config"
+	"github.com/certkit-io/certkit-agent-alpha/deploy"
+	"github.com/certkit-io/certkit-agent-alpha/events"
+	"github.com/certkit-io/certkit-agent-alpha/internal/testcerts"
+	"github.com/certkit-io/certkit-agent-alpha/internal/testserver"
+	"github.com/certkit-io/certkit-agent-alpha/state"
+)
+
+// env is a backend with an enrolled agent, a CA deployed certificates are
+// trusted by (see env.reconcile), a state directory and a host key directory.
+type env struct {
+	srv    *testserver.Server
+	client *api.Client
+	ca     *testcerts.Cert
+	dir    string // for targets' files
+	state  string // the state directory
+	keys   string // for host keys
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("reloads run sh")
+	}
+	srv := testserver.New()
+	t.Cleanup(srv.Close)
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	agentID, err := srv.RegisterAgent(base64.RawURLEncoding.EncodeToString(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := api.NewClient(srv.URL, srv.Client(), &auth.KeySigner{AgentID: agentID, Key: priv})
+
+	ca := testcerts.NewCA(t, "ca")
+	stateDir := t.TempDir()
+	return &env{srv: srv, client: client, ca: ca, dir: t.TempDir(), state: stateDir, keys: config.KeyDir(stateDir)}
+}
+
+// reconcile runs Reconcile against e's backend, trusting e's CA and keeping
+// state in e.state and host keys in e.keys.
+func (e *env) reconcile(ctx context.Context, applied *state.Applied, opts Options) (*state.Applied, error) {
+	opts.TrustRoots = e.ca.Pool()
+	opts.StateDir = e.state
+	opts.KeyDir = e.keys
+	return Reconcile(ctx, e.client, applied, opts)
+}
+
+// countingReload returns a reload that appends a line to a file, and a
+// function returning how many times it has run.
+func (e *env) countingReload(t *testing.T, name string) (*state.Reload, func() int) {
+	t.Helper()
+	log := filepath.Join(e.dir, name+".reloads")
+	r := &state.Reload{Type: state.ReloadCommand, Command: []string{"/bin/sh", "-c", "echo reloaded >> " + log}}
+	return r, func() int {
+		data, err := os.ReadFile(log)
+		if err != nil {
+			return 0
+		}
+		return strings.Count(string(data), "\n")
+	}
+}
+
+// target returns a target for cert in e.dir, reloaded by r.
+func (e *env) target(id, certID string, r *state.Reload) state.Target {
+	return state.Target{
+		ID:            id,
+		CertificateID: certID,
+		CertPath:      filepath.Join(e.dir, id+".crt"),
+		KeyPath:       filepath.Join(e.dir, id+".key"),
+		Reload:        r,
+	}
+}
+
+func (e *env) certificate(t *testing.T, id string) state.Certificate {
+	t.Helper()
+	leaf := e.ca.Leaf(t)
+	return state.Certificate{ID: id, Cert: leaf.PEM(), Key: leaf.KeyPEM()}
+}
+
+func TestReconcileUnchanged(t *testing.T) {
+	e := newEnv(t)
+	reload, reloads := e.countingReload(t, "svc")
+	target := e.target("t1", "c1", reload)
+	e.srv.SetDesiredState(&state.DesiredState{
+		Version:      "1",
+		Certificates: []state.Certificate{e.certificate(t, "c1")},
+		Targets:      []state.Target{target},
+	})
+
+	applied, err := e.reconcile(context.Background(), nil, Options{})
+	if err != nil {
+		t.Fatalf("first reconcile: %v", err)
+	}
+	if got := reloads(); got != 1 {
+		t.Fatalf("first reconcile: %d reloads, want 1", got)
+	}
+	before, err := os.Stat(target.CertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		applied *state.Applied
+	}{
+		{"same applied state", applied},
+		// E.g. the applied state was lost: the files on disk still match.
+		{"no applied state", nil},
+		{"other version", &state.Applied{Version: "0", Targets: map[string]string{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			time.Sleep(10 * time.Millisecond) // so a rewrite would change the mtime
+			if _, err := e.reconcile(context.Background(), tt.applied, Options{}); err != nil {
+				t.Fatalf("reconcile: %v", err)
+			}
+			if got := reloads(); got != 1 {
+				t.Errorf("%d reloads, want still 1", got)
+			}
+			after, err := os.Stat(target.CertPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) {
+				t.Errorf("%s was rewritten", target.CertPath)
+			}
+		})
+	}
+}
+
+func TestReconcilePendingReload(t *testing.T) {
+	e := newEnv(t)
+	reload, reloads := e.countingReload(t, "svc")
+	e.srv.SetDesiredState(&state.DesiredState{
+		Version:      "1",
+		Certificates: []state.Certificate{e.certificate(t, "c1")},
+		Targets:      []state.Target{e.target("t1", "c1", reload)},
+	})
+	if _, err := e.reconcile(context.Background(), nil, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := config.ReadPendingReloads(e.state); err != nil || len(pending) != 0 {
+		t.Fatalf("pending reloads after a successful reconcile: %v, %v", pending, err)
+	}
+
+	// As if the agent had stopped after writing the files but before the
+	// reload: the files are on disk, but the reload is still due.
+	if err := config.SavePendingReloads(e.state, []*state.Reload{reload}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.reconcile(context.Background(), nil, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := reloads(); got != 2 {
+		t.Fatalf("%d reloads, want 2", got)
+	}
+	if pending, err := config.ReadPendingReloads(e.state); err != nil || len(pending) != 0 {
+		t.Fatalf("pending reloads not cleared: %v, %v", pending, err)
+	}
+}
+
+func TestReconcileCoalescesReloads(t *testing.T) {
+	e := newEnv(t)
+	shared, sharedReloads := e.countingReload(t, "shared")
+	own, ownReloads := e.countingReload(t, "own")
+	e.srv.SetDesiredState(&state.DesiredState{
+		Version:      "1",
+		Certificates: []state.Certificate{e.certificate(t, "c1"), e.certificate(t, "c2")},
+		Targets: []state.Target{
+			e.target("t1", "c1", shared),
+			e.target("t2", "c2", shared),
+			e.target("t3", "c1", own),
+		},
+	})
+	if _, err := e.reconcile(context.Background(), nil, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sharedReloads(); got != 1 {
+		t.Errorf("shared service reloaded %d times, want once", got)
+	}
+	if got := ownReloads(); got != 1 {
+		t.Errorf("other service reloaded %d times, want once", got)
+	}
+}
+
+func TestReconcileConcurrency(t *testing.T) {
+	e := newEnv(t)
+	const services = 3
+	// Each reload waits (up to 5s) for all of them to have started, so they
+	// only succeed if they run at the same time.
+	barrier := t.TempDir()
+	var certificates []state.Certificate
+	var targets []state.Target
+	for i := range services {
+		id := string(rune('a' + i))
+		script := fmt.Sprintf(`touch %s; i=0; while [ "$(ls %s | wc -l)" -lt %d ]; do i=$((i+1)); [ $i -gt 250 ] && exit 1; sleep 0.02; done`,
+			filepath.Join(barrier, id), barrier, services)
+		certificates = append(certificates, e.certificate(t, "c"+id))
+		targets = append(targets, e.target("t"+id, "c"+id, &state.Reload{Type: state.ReloadCommand, Command: []string{"/bin/sh", "-c", script}}))
+	}
+	e.srv.SetDesiredState(&state.DesiredState{Version: "1", Certificates: certificates, Targets: targets})
+
+	if _, err := e.reconcile(context.Background(), nil, Options{Concurrency: services}); err != nil {
+		t.Fatalf("reloads didn't run side by side: %v", err)
+	}
+}
+
+func TestReconcilePaused(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          Options
+		backendPaused bool
+	}{
+		{name: "paused locally", opts: Options{Paused: true}},
+		{name: "paused by the backend", backendPaused: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t)
+			reload, reloads := e.countingReload(t, "svc")
+			target := e.target("t1", "c1", reload)
+			e.srv.SetDesiredState(&state.DesiredState{
+				Version:      "1",
+				Certificates: []state.Certificate{e.certificate(t, "c1")},
+				Targets:      []state.Target{target},
+				Paused:       tt.backendPaused,
+			})
+			// Left over from before the pause; still held back.
+			if err := config.SavePendingReloads(e.state, []*state.Reload{reload}); err != nil {
+				t.Fatal(err)
+			}
+
+			applied, err := e.reconcile(context.Background(), nil, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if applied != nil {
+				t.Errorf("applied state changed to %+v", applied)
+			}
+			if _, err := os.Stat(target.CertPath); !os.IsNotExist(err) {
+				t.Errorf("%s written while paused", target.CertPath)
+			}
+			if got := reloads(); got != 0 {
+				t.Errorf("%d reloads while paused", got)
+			}
+		})
+	}
+}
+
+func TestReconcileDrift(t *testing.T) {
+	tests := []struct {
+		name  string
+		drift func(target state.Target) error
+	}{
+		{"cert removed", func(target state.Target) error { return os.Remove(target.CertPath) }},
+		{"key edited", func(target state.Target) error { return os.WriteFile(target.KeyPath, []byte("edited"), 0o600) }},
+		{"mode changed", func(target state.Target) error { return os.Chmod(target.KeyPath, 0o644) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t)
+			reload, reloads := e.countingReload(t, "svc")
+			target := e.target("t1", "c1", reload)
+			c := e.certificate(t, "c1")
+			e.srv.SetDesiredState(&state.DesiredState{Version: "1", Certificates: []state.Certificate{c}, Targets: []state.Target{target}})
+			applied, err := e.reconcile(context.Background(), nil, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.drift(target); err != nil {
+				t.Fatal(err)
+			}
+			// The backend sends the same desired state again.
+			if _, err := e.reconcile(context.Background(), applied, Options{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := reloads(); got != 2 {
+				t.Errorf("%d reloads, want 2", got)
+			}
+			for path, want := range map[string]string{target.CertPath: c.Cert, target.KeyPath: c.Key} {
+				if got, err := os.ReadFile(path); err != nil || string(got) != want {
+					t.Errorf("%s not restored: %v", path, err)
+				}
+			}
+			if info, err := os.Stat(target.KeyPath); err != nil || info.Mode().Perm() != 0o600 {
+				t.Errorf("%s mode not restored: %v", target.KeyPath, err)
+			}
+		})
+	}
+}
+
+func TestReconcileUnmatched(t *testing.T) {
+	e := newEnv(t)
+	e.srv.SetDesiredState(&state.DesiredState{
+		Version:      "1",
+		Certificates: []state.Certificate{e.certificate(t, "c1")},
+		Targets: []state.Target{
+			e.target("unmatched-id", "gone", nil),
+			{ID: "unmatched-hostnames", Hostnames: []string{"nowhere.example"}, CertPath: filepath.Join(e.dir, "h.crt")},
+		},
+	})
+
+	applied, err := e.reconcile(context.Background(), nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied == nil {
+		t.Fatal("desired state with nothing to deploy not recorded as applied")
+	}
+	// Not reported again while the desired state stays the same.
+	if _, err := e.reconcile(context.Background(), applied, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := events.Flush(context.Background(), e.client); err != nil {
+		t.Fatal(err)
+	}
+	skipped := map[string]int{}
+	for _, event := range e.srv.Events() {
+		if event.Type == api.EventDeploySkipped {
+			skipped[event.TargetID]++
+		}
+	}
+	for _, id := range []string{"unmatched-id", "unmatched-hostnames"} {
+		if skipped[id] != 1 {
+			t.Errorf("target %s: %d deploy_skipped events, want 1", id, skipped[id])
+		}
+	}
+}
+
+func TestReconcileRetriesFailedReload(t *testing.T) {
+	e := newEnv(t)
+	// Fails the first time it runs, then succeeds.
+	marker := filepath.Join(e.dir, "failed-once")
+	reload := &state.Reload{Type: state.ReloadCommand, Command: []string{"/bin/sh", "-c", "[ -e " + marker + " ] || { touch " + marker + "; exit 1; }"}}
+	target := e.target("t1", "c1", reload)
+	e.srv.SetDesiredState(&state.DesiredState{
+		Version:      "1",
+		Certificates: []state.Certificate{e.certificate(t, "c1")},
+		Targets:      []state.Target{target},
+	})
+
+	applied, err := e.reconcile(context.Background(), nil, Options{})
+	if err == nil {
+		t.Fatal("reconcile with a failing reload succeeded")
+	}
+	if applied != nil {
+		t.Fatalf("applied state recorded despite the failed reload: %+v", applied)
+	}
+	if _, err := os.Stat(target.CertPath); !os.IsNotExist(err) {
+		t.Fatalf("%s not rolled back: %v", target.CertPath, err)
+	}
+
+	applied, err = e.reconcile(context.Background(), applied, Options{})
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if applied == nil || applied.Targets["t1"] == "" {
+		t.Fatalf("target not applied on retry: %+v", applied)
+	}
+	if _, err := os.Stat(target.CertPath); err != nil {
+		t.Fatalf("%s not deployed on retry: %v", target.CertPath, err)
+	}
+}
+
+func TestReconcileVerify(t *testing.T) {
+	tests := []struct {
+		name         string
+		servesNew    bool
+		rollback     bool
+		cancel       bool
+		wantErr      bool
+		wantRollback bool
+	}{
+		{name: "serves the new certificate", servesNew: true},
+		{name: "serves the old certificate", wantErr: true},
+		{name: "serves the old certificate, rollback", rollback: true, wantErr: true, wantRollback: true},
+		// Shutting down isn't a reason to undo a deploy.
+		{name: "cancelled, rollback", rollback: true, cancel: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t)
+			leaf := e.ca.Leaf(t)
+			served := e.ca.Leaf(t)
+			if tt.servesNew {
+				served = leaf
+			}
+			target := e.target("t1", "c1", nil)
+			target.Verify = &state.Verify{Address: served.ServeTLS(t), ServerName: "localhost", Timeout: "300ms", Rollback: tt.rollback}
+			e.srv.SetDesiredState(&state.DesiredState{
+				Version:      "1",
+				Certificates: []state.Certificate{{ID: "c1", Cert: leaf.PEM(), Key: leaf.KeyPEM()}},
+				Targets:      []state.Target{target},
+			})
+
+			ctx := context.Background()
+			if tt.cancel {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+			_, err := e.reconcile(ctx, nil, Options{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			_, statErr := os.Stat(target.CertPath)
+			if rolledBack := os.IsNotExist(statErr); rolledBack != tt.wantRollback {
+				t.Fatalf("rolled back = %v, want %v (%v)", rolledBack, tt.wantRollback, statErr)
+			}
+		})
+	}
+}
+
+func TestReconcileHostKeyMismatch(t *testing.T) {
+	e := newEnv(t)
+	hostLeaf := e.ca.Leaf(t)
+	// The stored key is some other certificate's.
+	if err := certs.WriteKey(e.keys, "host", []byte(e.ca.Leaf(t).KeyPEM())); err != nil {
+		t.Fatal(err)
+	}
+	hostTarget, otherTarget := e.target("t-host", "host", nil), e.target("t-other", "other", nil)
+	e.srv.SetDesiredState(&state.DesiredState{
+		Version: "1",
+		Certificates: []state.Certificate{
+			{ID: "host", Cert: hostLeaf.PEM(), KeyOnHost: true},
+			e.certificate(t, "other"),
+		},
+		Targets: []state.Target{hostTarget, otherTarget},
+	})
+
+	applied, err := e.reconcile(context.Background(), nil, Options{})
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("err = %v, want a key mismatch", err)
+	}
+	if _, err := os.Stat(otherTarget.CertPath); err != nil {
+		t.Errorf("other target not deployed: %v", err)
+	}
+	if _, err := os.Stat(hostTarget.CertPath); !os.IsNotExist(err) {
+		t.Errorf("target with the mismatched key deployed: %v", err)
+	}
+	if applied == nil || applied.Targets["t-other"] == "" || applied.Targets["t-host"] != "" || applied.Hash != "" {
+		t.Errorf("applied = %+v, want just t-other, and no hash so t-host is retried", applied)
+	}
+}
+
+func TestReconcilePendingHostKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		reloadFail bool
+	}{
+		{name: "deployed"},
+		{name: "rolled back", reloadFail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t)
+			old, issued := e.ca.Leaf(t), e.ca.Leaf(t)
+			// A CSR for issued went out; its key waits until the deploy.
+			if err := certs.WriteKey(e.keys, "c1", []byte(old.KeyPEM())); err != nil {
+				t.Fatal(err)
+			}
+			if err := certs.WritePendingKey(e.keys, "c1", []byte(issued.KeyPEM())); err != nil {
+				t.Fatal(err)
+			}
+			var reload *state.Reload
+			if tt.reloadFail {
+				reload = &state.Reload{Type: state.ReloadCommand, Command: []string{"/bin/sh", "-c", "exit 1"}}
+			}
+			target := e.target("t1", "c1", reload)
+			e.srv.SetDesiredState(&state.DesiredState{
+				Version:      "1",
+				Certificates: []state.Certificate{{ID: "c1", Cert: issued.PEM(), KeyOnHost: true}},
+				Targets:      []state.Target{target},
+			})
+
+			_, err := e.reconcile(context.Background(), nil, Options{})
+			if (err != nil) != tt.reloadFail {
+				t.Fatalf("err = %v", err)
+			}
+			wantStored, wantPending := issued.KeyPEM(), false
+			if tt.reloadFail {
+				wantStored, wantPending = old.KeyPEM(), true
+			}
+			if got, err := certs.ReadKey(e.keys, "c1"); err != nil || string(got) != wantStored {
+				t.Errorf("stored host key is the wrong one (%v)", err)
+			}
+			if _, err := os.Stat(certs.PendingKeyPath(e.keys, "c1")); (err == nil) != wantPending {
+				t.Errorf("pending key kept = %v, want %v", err == nil, wantPending)
+			}
+			if got, err := os.ReadFile(target.KeyPath); tt.reloadFail != os.IsNotExist(err) || !tt.reloadFail && string(got) != issued.KeyPEM() {
+				t.Errorf("target key wrong after deploy (%v)", err)
+			}
+		})
+	}
+}
+
+func TestReconcileCABundle(t *testing.T) {
+	tests := []struct {
+		name        string
+		trustFails  bool // the trust store update fails the first time
+		otherFails  bool // another target's reload fails
+		wantErr     bool
+		wantUpdates int
+	}{
+		{name: "deployed", wantUpdates: 1},
+		// The anchor is put back, and the store rebuilt from it.
+		{name: "other reload fails", otherFails: true, wantErr: true, wantUpdates: 2},
+		{name: "trust update fails", trustFails: true, wantErr: true, wantUpdates: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t)
+			log := filepath.Join(e.dir, "trust.updates")
+			script := "echo updated >> " + log
+			if tt.trustFails {
+				marker := filepath.Join(e.dir, "failed-once")
+				script += "; [ -e " + marker + " ] || { touch " + marker + "; exit 1; }"
+			}
+			opts := Options{TrustUpdateCommand: []string{"/bin/sh", "-c", script}}
+
+			intermediate := e.ca.Issue(t, testcerts.Options{CommonName: "intermediate", IsCA: true})
+			leaf := intermediate.Leaf(t)
+			bundle := state.Target{
+				ID:            "anchor",
+				CertificateID: "c1",
+				Format:        state.FormatCABundle,
+				CertPath:      filepath.Join(e.dir, "anchor.pem"),
+			}
+			desired := &state.DesiredState{
+				Version:      "1",
+				Certificates: []state.Certificate{{ID: "c1", Cert: leaf.PEM(), Chain: leaf.ChainPEM()}},
+				Targets:      []state.Target{bundle},
+			}
+			if tt.otherFails {
+				desired.Certificates = append(desired.Certificates, e.certificate(t, "c2"))
+				desired.Targets = append(desired.Targets, e.target("t2", "c2", &state.Reload{Type: state.ReloadCommand, Command: []string{"/bin/sh", "-c", "exit 1"}}))
+			}
+			e.srv.SetDesiredState(desired)
+
+			_, err := e.reconcile(context.Background(), nil, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, readErr := os.ReadFile(bundle.CertPath)
+			switch {
+			case tt.wantErr && !os.IsNotExist(readErr):
+				t.Errorf("%s not rolled back: %v", bundle.CertPath, readErr)
+			case !tt.wantErr && string(got) != intermediate.PEM():
+				t.Errorf("%s = %q, want the intermediate", bundle.CertPath, got)
+			}
+			data, _ := os.ReadFile(log)
+			if n := strings.Count(string(data), "\n"); n != tt.wantUpdates {
+				t.Errorf("trust store updated %d times, want %d", n, tt.wantUpdates)
+			}
+		})
+	}
+}
+
+// TestReconcileSchemaVersion runs its steps in order against one backend: a
+// desired state the agent can't read leaves the last applied one in place.
+func TestReconcileSchemaVersion(t *testing.T) {
+	e := newEnv(t)
+	reload, reloads := e.countingReload(t, "svc")
+	target := e.target("t1", "c1", reload)
+	desired := func(version string, schema int, cert state.Certificate) []byte {
+		b, err := json.Marshal(&state.DesiredState{
+			SchemaVersion: schema,
+			Version:       version,
+			Certificates:  []state.Certificate{cert},
+			Targets:       []state.Target{target},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Fields a newer backend might add, at the top level and nested.
+		b = bytes.Replace(b, []byte(`{`), []byte(`{"rollout":{"wave":2},`), 1)
+		return bytes.Replace(b, []byte(`"cert_path"`), []byte(`"owner_team":"web","cert_path"`), 1)
+	}
+	first, second := e.certificate(t, "c1"), e.certificate(t, "c1")
+
+	var applied *state.Applied
+	tests := []struct {
+		name     string
+		payload  []byte
+		wantErr  error
+		wantCert string // on disk afterwards, "" for none
+	}{
+		{"newer schema before any applied", desired("1", state.SchemaVersion+1, first), state.ErrUnsupportedSchema, ""},
+		{"unknown fields", desired("2", state.SchemaVersion, first), nil, first.Cert},
+		{"newer schema keeps last applied", desired("3", state.SchemaVersion+1, second), state.ErrUnsupportedSchema, first.Cert},
+		{"supported again", desired("4", state.SchemaVersion, second), nil, second.Cert},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e.srv.Respond("/desired-state", testserver.Response{Status: http.StatusOK, Body: json.RawMessage(tt.payload)})
+			prev := applied
+			got, err := e.reconcile(context.Background(), prev, Options{})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if got != prev {
+					t.Errorf("applied state changed to %+v", got)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			applied = got
+
+			data, err := os.ReadFile(target.CertPath)
+			if tt.wantCert == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("%s written", target.CertPath)
+				}
+				return
+			}
+			if string(data) != tt.wantCert {
+				t.Errorf("%s doesn't hold the expected certificate", target.CertPath)
+			}
+		})
+	}
+	if got := reloads(); got != 2 {
+		t.Errorf("%d reloads, want 2", got)
+	}
+}
+
+func TestReconcileRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing string // the target whose reload fails
+	}{
+		{"second target's reload fails", "t2"},
+		{"first target's reload fails", "t1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t)
+			ok, okReloads := e.countingReload(t, "ok")
+			failing := &state.Reload{Type: state.ReloadCommand, Command: []string{"/bin/sh", "-c", "exit 1"}}
+			reloads := map[string]*state.Reload{"t1": ok, "t2": ok}
+			reloads[tt.failing] = failing
+			t1 := e.target("t1", "c1", reloads["t1"])
+			t2 := e.target("t2", "c2", reloads["t2"])
+			e.srv.SetDesiredState(&state.DesiredState{
+				Version:      "1",
+				Certificates: []state.Certificate{e.certificate(t, "c1"), e.certificate(t, "c2")},
+				Targets:      []state.Target{t1, t2},
+			})
+			// t1 replaces files that are already there; t2's are new.
+			prior := map[string]string{t1.CertPath: "old cert\n", t1.KeyPath: "old key\n"}
+			for path, data := range prior {
+				if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			applied, err := e.reconcile(context.Background(), nil, Options{Concurrency: 1})
+			if err == nil {
+				t.Fatal("reconcile with a failing reload succeeded")
+			}
+			if applied != nil {
+				t.Errorf("applied state recorded despite the failed reload: %+v", applied)
+			}
+			// Once for the new files, and again once they're rolled back.
+			if got := okReloads(); got != 2 {
+				t.Errorf("the other reload ran %d times, want 2", got)
+			}
+			for path, want := range prior {
+				if got, err := os.ReadFile(path); err != nil || string(got) != want {
+					t.Errorf("%s not restored: %q, %v", path, got, err)
+				}
+			}
+			for _, path := range []string{t2.CertPath, t2.KeyPath} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s not removed: %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileMissingDir(t *testing.T) {
+	tests := []struct {
+		name        string
+		createDirs  bool
+		wantDeploy  bool // t2, whose directory is missing, is deployed
+		wantReloads int  // of t2's service
+	}{
+		{name: "skipped"},
+		{name: "created", createDirs: true, wantDeploy: true, wantReloads: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t)
+			r1, reloads1 := e.countingReload(t, "t1")
+			r2, reloads2 := e.countingReload(t, "t2")
+			t1 := e.target("t1", "c1", r1)
+			t2 := e.target("t2", "c2", r2)
+			dir := filepath.Join(e.dir, "missing", "ssl")
+			t2.CertPath, t2.KeyPath = filepath.Join(dir, "t2.crt"), filepath.Join(dir, "t2.key")
+			t2.CreateDirs = tt.createDirs
+			desired := &state.DesiredState{
+				Version:      "1",
+				Certificates: []state.Certificate{e.certificate(t, "c1"), e.certificate(t, "c2")},
+				Targets:      []state.Target{t1, t2},
+			}
+			e.srv.SetDesiredState(desired)
+
+			applied, err := e.reconcile(context.Background(), nil, Options{})
+			if err != nil {
+				t.Fatalf("reconcile: %v", err)
+			}
+			// The other target goes ahead either way.
+			if _, err := os.Stat(t1.CertPath); err != nil {
+				t.Errorf("t1 not deployed: %v", err)
+			}
+			if got := reloads1(); got != 1 {
+				t.Errorf("t1 reloaded %d times, want 1", got)
+			}
+			_, err = os.Stat(t2.CertPath)
+			if deployed := err == nil; deployed != tt.wantDeploy {
+				t.Errorf("t2 deployed = %t, want %t (%v)", deployed, tt.wantDeploy, err)
+			}
+			if got := reloads2(); got != tt.wantReloads {
+				t.Errorf("t2 reloaded %d times, want %d", got, tt.wantReloads)
+			}
+			// A skipped target leaves the desired state unapplied, so the
+			// next poll tries it again.
+			if _, ok := applied.Targets["t2"]; ok != tt.wantDeploy {
+				t.Errorf("t2 recorded as applied = %t, want %t", ok, tt.wantDeploy)
+			}
+			if hashed := applied.Hash != ""; hashed != tt.wantDeploy {
+				t.Errorf("desired state hash recorded = %t, want %t", hashed, tt.wantDeploy)
+			}
+		})
+	}
+}
+
+func TestDeployedEvent(t *testing.T) {
+	ca := testcerts.NewCA(t, "ca")
+	leaf := ca.Leaf(t)
+	other := ca.Leaf(t, "other.example")
+	t.Setenv("CERTKIT_TEST_P12_PASSWORD", "secret")
+
+	tests := []struct {
+		name   string
+		format string
+		after  func(t *testing.T, target *state.Target) // run after the deploy
+		want   *testcerts.Cert                          // the certificate reported, if any
+	}{
+		{name: "pem", want: leaf},
+		{name: "pkcs12", format: state.FormatPKCS12, want: leaf},
+		{
+			name: "replaced since",
+			after: func(t *testing.T, target *state.Target) {
+				if err := os.WriteFile(target.CertPath, []byte(other.PEM()), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: other,
+		},
+		{
+			name: "removed since",
+			after: func(t *testing.T, target *state.Target) {
+				if err := os.Remove(target.CertPath); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := &state.Target{ID: "t1", CertificateID: "c1", CertPath: filepath.Join(dir, "cert.pem"), KeyPath: filepath.Join(dir, "key.pem")}
+			if tt.format == state.FormatPKCS12 {
+				target.CertPath, target.KeyPath = filepath.Join(dir, "cert.p12"), ""
+				target.Format, target.PasswordRef = state.FormatPKCS12, "env:CERTKIT_TEST_P12_PASSWORD"
+			}
+			c := &state.Certificate{ID: "c1", Cert: leaf.PEM(), Key: leaf.KeyPEM()}
+			if err := deploy.WriteTarget(target, c, ca.Pool()); err != nil {
+				t.Fatal(err)
+			}
+			if tt.after != nil {
+				tt.after(t, target)
+			}
+
+			event := deployedEvent(state.Action{Type: state.ActionDeploy, Target: target, Certificate: c})
+			if event.Type != api.EventCertDeployed || event.TargetID != "t1" || event.CertificateID != "c1" {
+				t.Errorf("event = %+v", event)
+			}
+			wantFingerprint, wantNotAfter := "", time.Time{}
+			if tt.want != nil {
+				wantFingerprint, wantNotAfter = certs.FingerprintDER(tt.want.Cert.Raw), tt.want.Cert.NotAfter.UTC()
+			}
+			if event.Fingerprint != wantFingerprint {
+				t.Errorf("fingerprint = %q, want %q", event.Fingerprint, wantFingerprint)
+			}
+			if !event.NotAfter.Equal(wantNotAfter) {
+				t.Errorf("not_after = %v, want %v", event.NotAfter, wantNotAfter)
+			}
+		})
+	}
+}
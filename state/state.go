@@ -0,0 +1,310 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the newest desired-state schema_version this agent understands.
+// A payload without schema_version is treated as version 1.
+const SchemaVersion = 1
+
+// ErrUnsupportedSchema means the backend sent a desired state newer than this agent understands.
+var ErrUnsupportedSchema = errors.New("unsupported desired state schema_version")
+
+// DesiredState is what the backend wants deployed on this host.
+// Unknown fields are ignored, so a backend may add fields within a schema version.
+type DesiredState struct {
+	SchemaVersion int           `json:"schema_version,omitempty"`
+	Version       string        `json:"version"`
+	Certificates  []Certificate `json:"certificates"`
+	Targets       []Target      `json:"targets"`
+	// Paused holds the agent in maintenance: it keeps polling and reporting
+	// but deploys and reloads nothing until the backend clears it.
+	Paused bool `json:"paused,omitempty"`
+}
+
+// Certificate is a certificate (and optionally its key) issued by the backend.
+type Certificate struct {
+	ID    string `json:"id"`
+	Cert  string `json:"cert"`            // PEM encoded leaf
+	Chain string `json:"chain,omitempty"` // PEM encoded intermediates
+	Key   string `json:"key,omitempty"`   // PEM encoded private key
+
+	// KeyOnHost means the key is generated on this host and never sent: the
+	// agent submits a CSR for Subject/SANs while Cert is empty (new or renewing),
+	// and fills Key from its local copy when deploying.
+	KeyOnHost bool     `json:"key_on_host,omitempty"`
+	Subject   string   `json:"subject,omitempty"` // common name
+	SANs      []string `json:"sans,omitempty"`
+}
+
+// Target is a location on disk a certificate is deployed to.
+type Target struct {
+	ID            string `json:"id"`
+	CertificateID string `json:"certificate_id,omitempty"`
+	// Hostnames, when CertificateID is empty, select the certificate whose DNS
+	// SANs cover all of them (see deploy.MatchTargets).
+	Hostnames []string `json:"hostnames,omitempty"`
+	CertPath  string   `json:"cert_path"`
+	KeyPath   string   `json:"key_path,omitempty"`
+	// FullchainPath and ChainPath, if set, also write the leaf followed by its
+	// chain, and the chain alone. CertPath then gets just the leaf.
+	FullchainPath string  `json:"fullchain_path,omitempty"`
+	ChainPath     string  `json:"chain_path,omitempty"`
+	ReloadService string  `json:"reload_service,omitempty"` // shorthand for Reload{Type: "systemctl", Unit: ReloadService}
+	Reload        *Reload `json:"reload,omitempty"`
+	// OCSPStaple writes the certificate's OCSP response to CertPath + ".ocsp"
+	// and keeps it fresh.
+	OCSPStaple bool `json:"ocsp_staple,omitempty"`
+	// Format is FormatPEM (the default), FormatPKCS12 or FormatCABundle. A
+	// PKCS#12 target writes leaf, chain and key to CertPath, encrypted with
+	// the password PasswordRef points to; KeyPath is unused. A CA bundle
+	// target writes only the chain to CertPath, e.g. under
+	// /etc/pki/ca-trust/source/anchors, and its reload defaults to ReloadTrust.
+	Format      string `json:"format,omitempty"`
+	PasswordRef string `json:"password_ref,omitempty"` // "env:NAME" or "file:/path"
+	// Owner and Group (names or numeric ids) own every file written for the
+	// target, e.g. for a service that runs as its own user. CertMode and
+	// KeyMode are octal permissions ("0640") overriding 0644 and 0600; a
+	// PKCS#12 bundle holds the key, so it gets KeyMode.
+	Owner    string `json:"owner,omitempty"`
+	Group    string `json:"group,omitempty"`
+	CertMode string `json:"cert_mode,omitempty"`
+	KeyMode  string `json:"key_mode,omitempty"`
+	// CreateDirs creates missing directories for the target's files. Without
+	// it, a target whose directory is missing is skipped until it exists.
+	CreateDirs bool `json:"create_dirs,omitempty"`
+	// FollowSymlinks writes a file whose path is a symlink to where the link
+	// points. Without it the link is replaced with a regular file.
+	FollowSymlinks bool `json:"follow_symlinks,omitempty"`
+	// Verify, if set, checks after the reload that the service is serving
+	// the deployed certificate.
+	Verify *Verify `json:"verify,omitempty"`
+}
+
+// Verify is a post-deploy check that a TLS service serves the new certificate.
+type Verify struct {
+	Address    string `json:"address"`               // host:port
+	ServerName string `json:"server_name,omitempty"` // SNI; default: the host of Address
+	Timeout    string `json:"timeout,omitempty"`     // default 30s
+	// Rollback restores the previous certificates (of every target in the
+	// deploy) when the check fails. Otherwise a failure is only reported.
+	Rollback bool `json:"rollback,omitempty"`
+}
+
+// Target formats.
+const (
+	FormatPEM      = "pem"
+	FormatPKCS12   = "pkcs12"
+	FormatCABundle = "ca_bundle"
+)
+
+// Reload types.
+const (
+	ReloadSystemctl = "systemctl" // systemctl reload Unit
+	ReloadCommand   = "command"   // run Command
+	ReloadSignal    = "signal"    // send Signal to the pid in PidFile
+	ReloadTrust     = "trust"     // update the system trust store (see ca_trust_update_command)
+)
+
+// Reload says how to make a service pick up a newly deployed certificate.
+type Reload struct {
+	Type    string   `json:"type"`
+	Unit    string   `json:"unit,omitempty"`
+	Command []string `json:"command,omitempty"`
+	PidFile string   `json:"pid_file,omitempty"`
+	Signal  string   `json:"signal,omitempty"`
+}
+
+// String describes the reload; equal reloads have equal strings.
+func (r *Reload) String() string {
+	switch r.Type {
+	case ReloadSystemctl:
+		return "systemctl reload " + r.Unit
+	case ReloadCommand:
+		return "command " + strings.Join(r.Command, " ")
+	case ReloadSignal:
+		return fmt.Sprintf("signal %s to pid in %s", r.Signal, r.PidFile)
+	case ReloadTrust:
+		return "update system trust store"
+	}
+	return r.Type
+}
+
+// ReloadSpec returns the target's reload, or nil if it has none.
+func (t *Target) ReloadSpec() *Reload {
+	if t.Reload != nil {
+		return t.Reload
+	}
+	if t.ReloadService != "" {
+		return &Reload{Type: ReloadSystemctl, Unit: t.ReloadService}
+	}
+	if t.Format == FormatCABundle {
+		return &Reload{Type: ReloadTrust}
+	}
+	return nil
+}
+
+// Applied records what was last applied, so unchanged desired state can be skipped.
+type Applied struct {
+	Version string            `json:"version" yaml:"version"`
+	Hash    string            `json:"hash" yaml:"hash"`
+	Targets map[string]string `json:"targets" yaml:"targets"` // target ID -> TargetHash
+}
+
+// ReconcileResult summarizes the outcome of a reconcile pass.
+type ReconcileResult struct {
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	TargetsApplied  int       `json:"targets_applied"` // targets whose applied hash changed
+	Error           string    `json:"error,omitempty"`
+}
+
+// ChangedTargets returns how many of next's targets were not already applied
+// as they are in prev.
+func ChangedTargets(prev, next *Applied) int {
+	if next == nil {
+		return 0
+	}
+	n := 0
+	for id, hash := range next.Targets {
+		if prev == nil || prev.Targets[id] != hash {
+			n++
+		}
+	}
+	return n
+}
+
+type ActionType string
+
+const (
+	ActionDeploy ActionType = "deploy"
+	ActionReload ActionType = "reload"
+)
+
+// Action is a single step needed to move the host to the desired state.
+type Action struct {
+	Type        ActionType
+	Target      *Target      // ActionDeploy
+	Certificate *Certificate // ActionDeploy
+	Reload      *Reload      // ActionReload
+}
+
+func (a Action) String() string {
+	switch a.Type {
+	case ActionDeploy:
+		return fmt.Sprintf("deploy certificate %s to %s", a.Certificate.ID, a.Target.CertPath)
+	case ActionReload:
+		return fmt.Sprintf("reload via %s", a.Reload)
+	}
+	return string(a.Type)
+}
+
+// Parse decodes a desired state payload.
+func Parse(raw []byte) (*DesiredState, error) {
+	var ds DesiredState
+	if err := json.Unmarshal(raw, &ds); err != nil {
+		return nil, fmt.Errorf("parse desired state: %w", err)
+	}
+	if ds.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("%w: got %d, this agent supports up to %d (upgrade certkit-agent)", ErrUnsupportedSchema, ds.SchemaVersion, SchemaVersion)
+	}
+	return &ds, nil
+}
+
+// Certificate returns the certificate with the given ID, or nil.
+func (ds *DesiredState) Certificate(id string) *Certificate {
+	for i := range ds.Certificates {
+		if ds.Certificates[i].ID == id {
+			return &ds.Certificates[i]
+		}
+	}
+	return nil
+}
+
+// TargetDirs returns the sorted, unique directories the targets write into.
+func (ds *DesiredState) TargetDirs() []string {
+	seen := map[string]bool{}
+	for _, t := range ds.Targets {
+		for _, p := range []string{t.CertPath, t.KeyPath, t.FullchainPath, t.ChainPath} {
+			if p != "" && filepath.IsAbs(p) {
+				seen[filepath.Dir(p)] = true
+			}
+		}
+	}
+	dirs := make([]string, 0, len(seen))
+	for d := range seen {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// Hash returns a content hash over the whole desired state.
+func (ds *DesiredState) Hash() string {
+	b, _ := json.Marshal(ds)
+	return hashBytes(b)
+}
+
+// TargetHash returns a content hash over a target and the certificate it receives.
+func TargetHash(t *Target, c *Certificate) string {
+	b, _ := json.Marshal(struct {
+		Target      *Target      `json:"target"`
+		Certificate *Certificate `json:"certificate"`
+	}{t, c})
+	return hashBytes(b)
+}
+
+// Diff returns the actions needed to go from applied to desired.
+// Targets whose content hash matches the last applied one produce no action,
+// and each distinct reload is run once after all deploys.
+func Diff(applied *Applied, desired *DesiredState) []Action {
+	if desired == nil {
+		return nil
+	}
+	if applied != nil && applied.Hash == desired.Hash() {
+		return nil
+	}
+
+	var actions []Action
+	reloads := map[string]*Reload{}
+
+	for i := range desired.Targets {
+		t := &desired.Targets[i]
+		c := desired.Certificate(t.CertificateID)
+		if c == nil {
+			continue
+		}
+		if applied != nil && applied.Targets[t.ID] == TargetHash(t, c) {
+			continue
+		}
+		actions = append(actions, Action{Type: ActionDeploy, Target: t, Certificate: c})
+		if r := t.ReloadSpec(); r != nil {
+			reloads[r.String()] = r
+		}
+	}
+
+	keys := make([]string, 0, len(reloads))
+	for k := range reloads {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		actions = append(actions, Action{Type: ActionReload, Reload: reloads[k]})
+	}
+
+	return actions
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	nginx := &Reload{Type: ReloadSystemctl, Unit: "nginx"}
+	desired := &DesiredState{
+		Version: "2",
+		Certificates: []Certificate{
+			{ID: "c1", Cert: "cert 1"},
+			{ID: "c2", Cert: "cert 2"},
+		},
+		Targets: []Target{
+			{ID: "t1", CertificateID: "c1", CertPath: "/etc/a.crt", Reload: nginx},
+			{ID: "t2", CertificateID: "c2", CertPath: "/etc/b.crt", ReloadService: "nginx"},
+			{ID: "t3", CertificateID: "c1", CertPath: "/etc/c.crt", Reload: &Reload{Type: ReloadCommand, Command: []string{"true"}}},
+			{ID: "t4", CertificateID: "missing", CertPath: "/etc/d.crt"},
+		},
+	}
+	hashes := map[string]string{}
+	for i := range desired.Targets {
+		target := &desired.Targets[i]
+		if c := desired.Certificate(target.CertificateID); c != nil {
+			hashes[target.ID] = TargetHash(target, c)
+		}
+	}
+	without := func(ids ...string) map[string]string {
+		out := map[string]string{}
+		for id, hash := range hashes {
+			out[id] = hash
+		}
+		for _, id := range ids {
+			delete(out, id)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		applied *Applied
+		want    []string
+	}{
+		{
+			name:    "unchanged",
+			applied: &Applied{Version: "2", Hash: desired.Hash(), Targets: hashes},
+		},
+		{
+			// A new version with the same targets and certificates.
+			name:    "targets unchanged",
+			applied: &Applied{Version: "1", Hash: "old", Targets: hashes},
+		},
+		{
+			name:    "one target changed",
+			applied: &Applied{Version: "1", Hash: "old", Targets: without("t3")},
+			want:    []string{"deploy certificate c1 to /etc/c.crt", "reload via command true"},
+		},
+		{
+			// t1 and t2 share a service, which is reloaded once.
+			name:    "nothing applied",
+			applied: nil,
+			want: []string{
+				"deploy certificate c1 to /etc/a.crt",
+				"deploy certificate c2 to /etc/b.crt",
+				"deploy certificate c1 to /etc/c.crt",
+				"reload via command true",
+				"reload via systemctl reload nginx",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, action := range Diff(tt.applied, desired) {
+				got = append(got, action.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+
+	if actions := Diff(nil, nil); actions != nil {
+		t.Errorf("Diff with no desired state = %v", actions)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    *DesiredState
+		wantErr error // errors.Is the error
+		anyErr  bool  // some other error
+	}{
+		{
+			name: "no schema_version",
+			raw:  `{"version":"1"}`,
+			want: &DesiredState{Version: "1"},
+		},
+		{
+			name: "current schema_version",
+			raw:  `{"schema_version":1,"version":"1"}`,
+			want: &DesiredState{SchemaVersion: 1, Version: "1"},
+		},
+		{
+			name:    "newer schema_version",
+			raw:     `{"schema_version":2,"version":"1"}`,
+			wantErr: ErrUnsupportedSchema,
+		},
+		{
+			name: "unknown fields",
+			raw: `{"schema_version":1,"version":"1","rollout":{"wave":2},
+				"certificates":[{"id":"c1","cert":"pem","issuer_hint":"x"}],
+				"targets":[{"id":"t1","certificate_id":"c1","cert_path":"/etc/a.crt","owner_team":"web"}]}`,
+			want: &DesiredState{
+				SchemaVersion: 1,
+				Version:       "1",
+				Certificates:  []Certificate{{ID: "c1", Cert: "pem"}},
+				Targets:       []Target{{ID: "t1", CertificateID: "c1", CertPath: "/etc/a.crt"}},
+			},
+		},
+		{
+			name:   "malformed",
+			raw:    `{"schema_version":`,
+			anyErr: true,
+		},
+		{
+			name:   "wrong type",
+			raw:    `{"schema_version":"1"}`,
+			anyErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.raw))
+			wantErr := tt.wantErr != nil || tt.anyErr
+			if (err != nil) != wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, wantErr)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Package update replaces the agent binary with a release signed by the
+// release key. Nothing is written unless the signature verifies and the
+// release is newer than the running agent.
+package update
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/certkit-io/certkit-agent-alpha/utils"
+)
+
+// ErrBadSignature means a release's manifest is unsigned or its signature
+// doesn't verify against the release key.
+var ErrBadSignature = errors.New("update signature does not verify")
+
+// ErrDowngrade means a release isn't newer than the running agent, e.g. an old
+// signed release replayed to roll the agent back to a vulnerable version.
+var ErrDowngrade = errors.New("update is not newer than the running agent")
+
+// Manifest describes a release. The release key signs its exact JSON bytes,
+// which bind the binary (by hash) to a version and platform.
+type Manifest struct {
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	SHA256  string `json:"sha256"` // hex, of the binary
+}
+
+// VerifyManifest checks that sig, ed25519 (base64url), is pub's signature over
+// raw, and decodes it.
+func VerifyManifest(raw []byte, sig string, pub ed25519.PublicKey) (*Manifest, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: no valid release key configured", ErrBadSignature)
+	}
+	if len(raw) == 0 || sig == "" {
+		return nil, fmt.Errorf("%w: release is not signed", ErrBadSignature)
+	}
+	rawSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrBadSignature)
+	}
+	if !ed25519.Verify(pub, raw, rawSig) {
+		return nil, ErrBadSignature
+	}
+	var m Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("decode release manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// Verify checks that manifest is signed by pub (see VerifyManifest), that it
+// is for this platform and a version newer than current, and that binary is
+// the one it describes.
+func Verify(binary, manifest []byte, sig string, pub ed25519.PublicKey, current string) (*Manifest, error) {
+	m, err := VerifyManifest(manifest, sig, pub)
+	if err != nil {
+		return nil, err
+	}
+	if m.OS != runtime.GOOS || m.Arch != runtime.GOARCH {
+		return nil, fmt.Errorf("release %s is for %s/%s, not %s/%s", m.Version, m.OS, m.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+	newer, err := Newer(m.Version, current)
+	if err != nil {
+		return nil, err
+	}
+	if !newer {
+		return nil, fmt.Errorf("%w: %s, running %s", ErrDowngrade, m.Version, current)
+	}
+	sum := sha256.Sum256(binary)
+	if hex.EncodeToString(sum[:]) != m.SHA256 {
+		return nil, fmt.Errorf("%w: binary doesn't match the manifest's sha256", ErrBadSignature)
+	}
+	return m, nil
+}
+
+// PrevPath is where Apply keeps the binary it replaced, for a manual rollback.
+func PrevPath(exe string) string {
+	return exe + ".prev"
+}
+
+// Apply verifies binary against its manifest (see Verify) and atomically
+// replaces the file at exe with it, keeping its mode and moving the previous
+// binary to PrevPath(exe). The running process is unaffected until it is
+// restarted.
+func Apply(exe string, binary, manifest []byte, sig string, pub ed25519.PublicKey, current string) error {
+	if _, err := Verify(binary, manifest, sig, pub, current); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	prev, err := os.ReadFile(exe)
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(PrevPath(exe), prev, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("keep previous binary: %w", err)
+	}
+	if err := utils.WriteFileAtomic(exe, binary, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("replace %s: %w", exe, err)
+	}
+	return nil
+}
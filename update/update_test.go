@@ -0,0 +1,169 @@
+package update
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func manifestFor(t *testing.T, m Manifest) []byte {
+	t.Helper()
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func sign(priv ed25519.PrivateKey, b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, b))
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	binary := []byte("new agent binary")
+	sum := sha256.Sum256(binary)
+	good := Manifest{Version: "1.3.0", OS: runtime.GOOS, Arch: runtime.GOARCH, SHA256: hex.EncodeToString(sum[:])}
+
+	with := func(edit func(*Manifest)) Manifest {
+		m := good
+		edit(&m)
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		manifest Manifest
+		sig      func(raw []byte) string
+		pub      ed25519.PublicKey
+		binary   []byte
+		wantErr  error // nil: success; errAny: any error
+	}{
+		{name: "valid", manifest: good},
+		{name: "unsigned", manifest: good, sig: func([]byte) string { return "" }, wantErr: ErrBadSignature},
+		{name: "bad encoding", manifest: good, sig: func([]byte) string { return "!!" }, wantErr: ErrBadSignature},
+		{name: "signed by another key", manifest: good, pub: otherPub, wantErr: ErrBadSignature},
+		{name: "no release key", manifest: good, pub: ed25519.PublicKey{}, wantErr: ErrBadSignature},
+		{name: "signature over other bytes", manifest: good, sig: func([]byte) string { return sign(priv, []byte("{}")) }, wantErr: ErrBadSignature},
+		{name: "tampered binary", manifest: good, binary: []byte("evil"), wantErr: ErrBadSignature},
+		{name: "same version", manifest: with(func(m *Manifest) { m.Version = "1.2.0" }), wantErr: ErrDowngrade},
+		{name: "older version", manifest: with(func(m *Manifest) { m.Version = "1.1.9" }), wantErr: ErrDowngrade},
+		{name: "prerelease of running version", manifest: with(func(m *Manifest) { m.Version = "1.2.0-rc.1" }), wantErr: ErrDowngrade},
+		{name: "other os", manifest: with(func(m *Manifest) { m.OS = "plan9" }), wantErr: errAny},
+		{name: "other arch", manifest: with(func(m *Manifest) { m.Arch = "mips" }), wantErr: errAny},
+		{name: "bad version", manifest: with(func(m *Manifest) { m.Version = "latest" }), wantErr: errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := manifestFor(t, tt.manifest)
+			sig := sign(priv, raw)
+			if tt.sig != nil {
+				sig = tt.sig(raw)
+			}
+			key := pub
+			if tt.pub != nil {
+				key = tt.pub
+			}
+			bin := binary
+			if tt.binary != nil {
+				bin = tt.binary
+			}
+			_, err := Verify(bin, raw, sig, key, "v1.2.0")
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Verify: %v", err)
+			case tt.wantErr == errAny && err == nil, tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("Verify err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+var errAny = errors.New("any error")
+
+func TestApply(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	exe := filepath.Join(t.TempDir(), "certkit-agent")
+	if err := os.WriteFile(exe, []byte("old binary"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	binary := []byte("new binary")
+	sum := sha256.Sum256(binary)
+	raw := manifestFor(t, Manifest{Version: "2.0.0", OS: runtime.GOOS, Arch: runtime.GOARCH, SHA256: hex.EncodeToString(sum[:])})
+
+	// A bad signature leaves everything untouched.
+	if err := Apply(exe, binary, raw, sign(priv, []byte("x")), pub, "1.0.0"); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Apply with bad signature: %v", err)
+	}
+	if got, _ := os.ReadFile(exe); string(got) != "old binary" {
+		t.Fatalf("binary replaced despite bad signature: %q", got)
+	}
+	if _, err := os.Stat(PrevPath(exe)); !os.IsNotExist(err) {
+		t.Fatalf("previous binary kept despite bad signature: %v", err)
+	}
+
+	if err := Apply(exe, binary, raw, sign(priv, raw), pub, "1.0.0"); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	got, _ := os.ReadFile(exe)
+	prev, _ := os.ReadFile(PrevPath(exe))
+	if string(got) != "new binary" || string(prev) != "old binary" {
+		t.Fatalf("after Apply: exe %q, prev %q", got, prev)
+	}
+	if info, err := os.Stat(exe); err != nil || info.Mode().Perm() != 0o755 {
+		t.Fatalf("mode not kept: %v %v", info.Mode(), err)
+	}
+
+	// Replaying the same release once it's running is a downgrade.
+	if err := Apply(exe, binary, raw, sign(priv, raw), pub, "2.0.0"); !errors.Is(err, ErrDowngrade) {
+		t.Fatalf("replayed Apply: %v", err)
+	}
+}
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		release, current string
+		want             bool
+	}{
+		{"1.2.4", "1.2.3", true},
+		{"v1.3.0", "v1.2.9", true},
+		{"2.0.0", "1.99.99", true},
+		{"1.10.0", "1.9.0", true},
+		{"1.2.3", "1.2.3", false},
+		{"1.2.3+build.5", "1.2.3", false},
+		{"1.2.2", "1.2.3", false},
+		{"1.2.3", "1.2.3-rc.1", true},
+		{"1.2.3-rc.1", "1.2.3", false},
+		{"1.2.3-rc.2", "1.2.3-rc.1", true},
+		{"1.2.3-rc.10", "1.2.3-rc.9", true},
+		{"1.2.3-beta", "1.2.3-alpha", true},
+		{"1.2.3-alpha.1", "1.2.3-alpha", true},
+		{"1.2.3-alpha", "1.2.3-1", true},
+	}
+	for _, tt := range tests {
+		got, err := Newer(tt.release, tt.current)
+		if err != nil {
+			t.Errorf("Newer(%q, %q): %v", tt.release, tt.current, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", tt.release, tt.current, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "dev", "1.2", "1.2.3.4", "01.2.3", "1.2.x", "1.2.3-"} {
+		if _, err := Newer(bad, "1.0.0"); err == nil {
+			t.Errorf("Newer(%q) accepted an invalid version", bad)
+		}
+	}
+}
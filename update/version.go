@@ -0,0 +1,100 @@
+package update
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// version is a parsed semantic version; build metadata is dropped.
+type version struct {
+	core [3]uint64
+	pre  []string // prerelease identifiers; none sorts after any
+}
+
+// parseVersion parses "1.2.3", "v1.2.3-rc.1" or "1.2.3+build".
+func parseVersion(s string) (version, error) {
+	var v version
+	rest := strings.TrimPrefix(s, "v")
+	rest, _, _ = strings.Cut(rest, "+")
+	rest, pre, hasPre := strings.Cut(rest, "-")
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid version %q: want MAJOR.MINOR.PATCH", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil || (len(p) > 1 && p[0] == '0') {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v.core[i] = n
+	}
+	if hasPre {
+		v.pre = strings.Split(pre, ".")
+		for _, id := range v.pre {
+			if id == "" {
+				return v, fmt.Errorf("invalid version %q: empty prerelease identifier", s)
+			}
+		}
+	}
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 as v is older than, the same as or newer than w,
+// by semver precedence.
+func (v version) compare(w version) int {
+	for i := range v.core {
+		if v.core[i] != w.core[i] {
+			return cmp(v.core[i] < w.core[i])
+		}
+	}
+	switch {
+	case len(v.pre) == 0 && len(w.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(w.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(w.pre); i++ {
+		a, b := v.pre[i], w.pre[i]
+		if a == b {
+			continue
+		}
+		an, aErr := strconv.ParseUint(a, 10, 64)
+		bn, bErr := strconv.ParseUint(b, 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			return cmp(an < bn)
+		case aErr == nil: // numeric identifiers sort first
+			return -1
+		case bErr == nil:
+			return 1
+		}
+		return cmp(a < b)
+	}
+	if len(v.pre) == len(w.pre) {
+		return 0
+	}
+	return cmp(len(v.pre) < len(w.pre))
+}
+
+func cmp(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// Newer reports whether release is a newer semantic version than current.
+func Newer(release, current string) (bool, error) {
+	r, err := parseVersion(release)
+	if err != nil {
+		return false, fmt.Errorf("release: %w", err)
+	}
+	c, err := parseVersion(current)
+	if err != nil {
+		return false, fmt.Errorf("running agent: %w", err)
+	}
+	return r.compare(c) > 0, nil
+}
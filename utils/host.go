@@ -0,0 +1,16 @@
+package utils
+
+import (
+	"os"
+	"strings"
+)
+
+// KernelVersion returns the running kernel release (as `uname -r` would print it).
+// Returns an empty string if it can't be determined, e.g. on non-Linux hosts.
+func KernelVersion() string {
+	b, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -1,11 +1,28 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"syscall"
 )
 
+// WriteFileAtomic replaces path with contents so readers see either the old or
+// the new file, never a partial one.
+//
+// The data is fsynced before the rename and the parent directory is fsynced
+// after it, so once this returns the new file survives a crash or power loss.
+// Without the directory sync the rename itself could be lost, leaving no file.
 func WriteFileAtomic(path string, contents []byte, perm os.FileMode) error {
+	return WriteFileAtomicPrepared(path, contents, perm, nil)
+}
+
+// WriteFileAtomicPrepared is WriteFileAtomic, calling prepare (if non-nil) on
+// the temporary file's path before it's renamed into place, e.g. to chown it,
+// so path never holds the new contents without it.
+func WriteFileAtomicPrepared(path string, contents []byte, perm os.FileMode, prepare func(tmpPath string) error) error {
 	dir := filepath.Dir(path)
 	base := filepath.Base(path)
 
@@ -21,6 +38,10 @@ func WriteFileAtomic(path string, contents []byte, perm os.FileMode) error {
 		return e
 	}
 
+	// Keep the existing owner, so e.g. root rewriting a service user's config
+	// doesn't lock that user out of it.
+	copyOwner(tmp, path)
+
 	if err := tmp.Chmod(perm); err != nil {
 		return cleanup(err)
 	}
@@ -33,6 +54,65 @@ func WriteFileAtomic(path string, contents []byte, perm os.FileMode) error {
 	if err := tmp.Close(); err != nil {
 		return cleanup(err)
 	}
+	if prepare != nil {
+		if err := prepare(tmpName); err != nil {
+			_ = os.Remove(tmpName)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+
+	return syncDir(dir)
+}
 
-	return os.Rename(tmpName, path)
+// syncDir fsyncs a directory so renames within it are durable.
+// Filesystems and platforms that can't sync directories are silently skipped.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTSUP) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// ShredFile overwrites path with zeros and syncs it before removing it, so a
+// secret in it doesn't linger in the freed blocks. This is best effort:
+// copy-on-write and journaling filesystems, SSD wear levelling, snapshots and
+// backups can all keep the old bytes elsewhere. A missing file is not an error.
+func ShredFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		_, err = f.Write(make([]byte, info.Size()))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("overwrite %s: %w", path, err)
+	}
+	return os.Remove(path)
 }
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked means another process holds the lock.
+var ErrLocked = errors.New("lock is held by another process")
+
+// FileLock is an exclusive, process-wide lock on a file. The lock is released
+// by Unlock or when the process exits, so a crash never leaves it stale.
+type FileLock struct {
+	f *os.File
+}
+
+// Lock takes an exclusive lock on path without blocking, creating the file if
+// needed and writing our pid to it. If another process holds it, the returned
+// error wraps ErrLocked and names that process's pid.
+func Lock(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, ErrLocked) {
+			b, _ := os.ReadFile(path)
+			if pid := strings.TrimSpace(string(b)); pid != "" {
+				return nil, fmt.Errorf("%w (pid %s)", ErrLocked, pid)
+			}
+		}
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &FileLock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *FileLock) Unlock() error {
+	l.f.Truncate(0)
+	return l.f.Close()
+}
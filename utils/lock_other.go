@@ -0,0 +1,8 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// lockFile is a no-op where flock isn't available.
+func lockFile(f *os.File) error { return nil }
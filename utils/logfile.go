@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LogFile is an io.Writer appending to a file that's rotated once it grows
+// past MaxSize: path becomes path.1, path.1 becomes path.2 and so on, keeping
+// at most MaxBackups old files. A single write is never split across files.
+type LogFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu           sync.Mutex
+	f            *os.File // nil if reopening it failed
+	size         int64
+	rotateFailed bool // the last rotation failed (and was reported)
+}
+
+// OpenLogFile opens path for appending, creating it (mode 0640) and its
+// directory (mode 0750) if needed. maxSize <= 0 disables rotation.
+func OpenLogFile(path string, maxSize int64, maxBackups int) (*LogFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("create log directory: %w", err)
+	}
+	l := &LogFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open log file: %w", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		l.rotate()
+	}
+	if l.f == nil {
+		if err := l.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups along, dropping the oldest, and starts a new file.
+// If that fails, logging carries on in the current file, which the next write
+// tries to rotate again; the failure is reported on stderr (it can't go to
+// the log) the first time.
+func (l *LogFile) rotate() {
+	err := l.f.Close()
+	l.f = nil
+	if err == nil {
+		err = l.shift()
+	}
+	// A new file if the shift worked, else the current one again.
+	if openErr := l.open(); openErr != nil {
+		err = errors.Join(err, openErr)
+	}
+	if err != nil && !l.rotateFailed {
+		fmt.Fprintf(os.Stderr, "rotate log file %s: %v\n", l.path, err)
+	}
+	l.rotateFailed = err != nil
+}
+
+// shift renames path to path.1, path.1 to path.2 and so on, dropping the
+// oldest backup (or removes path if no backups are kept).
+func (l *LogFile) shift() error {
+	if l.maxBackups <= 0 {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	os.Remove(backupName(l.path, l.maxBackups))
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		os.Rename(backupName(l.path, i), backupName(l.path, i+1))
+	}
+	return os.Rename(l.path, backupName(l.path, 1))
+}
+
+// Close closes the current file.
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogFileRotate(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBackups int
+		writes     []string
+		want       []string // contents of path, path.1, path.2, ...
+	}{
+		{
+			name:       "within max size",
+			maxBackups: 2,
+			writes:     []string{"aaaa\n", "bbbb\n"},
+			want:       []string{"aaaa\nbbbb\n"},
+		},
+		{
+			name:       "rotated",
+			maxBackups: 2,
+			writes:     []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			want:       []string{"cccc\n", "aaaa\nbbbb\n"},
+		},
+		{
+			name:       "oldest dropped",
+			maxBackups: 2,
+			writes:     []string{"aaaa\nbbbb\n", "cccc\ndddd\n", "eeee\nffff\n", "gggg\n"},
+			want:       []string{"gggg\n", "eeee\nffff\n", "cccc\ndddd\n"},
+		},
+		{
+			name:       "no backups",
+			maxBackups: 0,
+			writes:     []string{"aaaa\nbbbb\n", "cccc\n"},
+			want:       []string{"cccc\n"},
+		},
+		{
+			// Not split, even though it's bigger than the max size.
+			name:       "long write",
+			maxBackups: 1,
+			writes:     []string{"aaaa\n", strings.Repeat("x", 20) + "\n"},
+			want:       []string{strings.Repeat("x", 20) + "\n", "aaaa\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "logs", "agent.log")
+			l, err := OpenLogFile(path, 10, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			for _, w := range tt.writes {
+				if _, err := l.Write([]byte(w)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, want := range tt.want {
+				name := path
+				if i > 0 {
+					name = backupName(path, i)
+				}
+				if got, err := os.ReadFile(name); err != nil || string(got) != want {
+					t.Errorf("%s = %q (%v), want %q", filepath.Base(name), got, err, want)
+				}
+			}
+			if _, err := os.Stat(backupName(path, len(tt.want))); !os.IsNotExist(err) {
+				t.Errorf("%s exists", filepath.Base(backupName(path, len(tt.want))))
+			}
+		})
+	}
+}
+
+func TestLogFileRotateFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	l, err := OpenLogFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A non-empty directory where the backup goes can't be removed or
+	// replaced, so rotation fails.
+	if err := os.MkdirAll(filepath.Join(backupName(path, 1), "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{"aaaa\n", "bbbb\n", "cccc\n"} {
+		if _, err := l.Write([]byte(w)); err != nil {
+			t.Fatalf("write after failed rotation: %v", err)
+		}
+	}
+	if got, _ := os.ReadFile(path); string(got) != "aaaa\nbbbb\ncccc\n" {
+		t.Fatalf("log = %q", got)
+	}
+
+	// Once the obstacle is gone, the next write rotates.
+	if err := os.RemoveAll(backupName(path, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Write([]byte("dddd\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "dddd\n" {
+		t.Errorf("log after rotation = %q", got)
+	}
+	if got, _ := os.ReadFile(backupName(path, 1)); string(got) != "aaaa\nbbbb\ncccc\n" {
+		t.Errorf("backup = %q", got)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// copyOwner is a no-op where files don't have unix ownership.
+func copyOwner(f *os.File, path string) {}
+
+// FileOwner always reports !ok where files don't have unix ownership.
+func FileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner chowns f to match the owner of the existing file at path, if any.
+// Failures (typically EPERM when not running as root) are ignored.
+func copyOwner(f *os.File, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	if int(st.Uid) == os.Geteuid() && int(st.Gid) == os.Getegid() {
+		return
+	}
+	_ = f.Chown(int(st.Uid), int(st.Gid))
+}
+
+// FileOwner returns the uid and gid owning the file described by info.
+// ok is false where files don't have unix ownership.
+func FileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,28 @@
+package utils
+
+import "sync"
+
+// ForEach calls fn(i) for every i in [0, n), up to workers calls at a time
+// (one at a time if workers < 2), and returns once they all have.
+func ForEach(n, workers int, fn func(i int)) {
+	if workers < 2 || n < 2 {
+		for i := range n {
+			fn(i)
+		}
+		return
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range n {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ResolveSecret returns the secret ref points to: "env:NAME" reads the
+// environment variable NAME, "file:/path" the file's contents (without a
+// trailing newline), so the secret itself never has to be stored alongside
+// the setting that uses it.
+func ResolveSecret(ref string) (string, error) {
+	kind, name, ok := strings.Cut(ref, ":")
+	if !ok || name == "" {
+		return "", fmt.Errorf("%q must be env:NAME or file:/path", ref)
+	}
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case "file":
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return "", fmt.Errorf("%q must be env:NAME or file:/path", ref)
+}